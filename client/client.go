@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -128,6 +129,25 @@ func NewClient(c *Config) (*Client, error) {
 			return nil, fmt.Errorf("Client cannot listen on %s", r.String())
 		}
 		client.computed.Remotes = append(client.computed.Remotes, r)
+		//capture policy requested for this remote
+		policy, ok := c.Capture[s]
+		if !ok {
+			policy, ok = c.Capture["*"]
+		}
+		if ok {
+			if err := policy.Validate(); err != nil {
+				return nil, fmt.Errorf("Invalid capture policy for remote '%s': %s", s, err)
+			}
+			if client.computed.Capture == nil {
+				client.computed.Capture = map[string]settings.CapturePolicy{}
+			}
+			client.computed.Capture[r.Encode()] = policy
+		}
+	}
+	for s := range c.Capture {
+		if s != "*" && !slices.Contains(c.Remotes, s) {
+			return nil, fmt.Errorf("Capture policy for unknown remote '%s'", s)
+		}
 	}
 	//outbound proxy
 	if p := c.Proxy; p != "" {
@@ -10,6 +10,8 @@ import (
 	"os"
 	"path"
 	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
 )
 
 // Config represents a client configuration
@@ -26,6 +28,9 @@ type Config struct {
 	TLS              TLSConfig     `yaml:"tls,omitempty"`
 	DialContext      func(ctx context.Context, network, addr string) (net.Conn, error)
 	Verbose          bool `yaml:"verbose,omitempty"`
+	// Capture requests server-side capture policies, keyed by remote as
+	// written in Remotes or "*" for every remote
+	Capture map[string]settings.CapturePolicy `yaml:"capture,omitempty"`
 }
 
 // TLSConfig for a Client
@@ -1,15 +1,13 @@
 package capture
 
 import (
-	"bufio"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/NextChapterSoftware/chissl/share/settings"
@@ -26,10 +24,16 @@ const (
 	ResHeaders EventType = "res_headers"
 	ResBody    EventType = "res_body"
 	Metric     EventType = "metric"
+	// WSMessage is a websocket message or control frame decoded after an upgrade
+	WSMessage EventType = "ws_message"
+	// Timing carries the latency breakdown of an exchange once it completes
+	Timing EventType = "timing"
 )
 
 // Event represents a captured event for a tunnel/connection.
 type Event struct {
+	// ID increases monotonically within an entity's capture stream
+	ID        uint64    `json:"id,omitempty"`
 	Time      time.Time `json:"time"`
 	TunnelID  string    `json:"tunnel_id"`
 	User      string    `json:"user"`
@@ -46,6 +50,8 @@ type Ring struct {
 	buf   []Event
 	start int
 	len   int
+	// lastID is the highest event ID of the stream
+	lastID uint64
 }
 
 func NewRing(cap int) *Ring { return &Ring{buf: make([]Event, cap)} }
@@ -53,6 +59,29 @@ func NewRing(cap int) *Ring { return &Ring{buf: make([]Event, cap)} }
 func (r *Ring) Add(e Event) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.add(e)
+}
+
+// append assigns the stream's next ID to e and adds it, calling publish
+// before any later event is appended so that IDs are published in order
+func (r *Ring) append(e Event, publish func(Event)) Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.ID = r.lastID + 1
+	r.add(e)
+	publish(e)
+	return e
+}
+
+// LastID returns the highest event ID of the stream
+func (r *Ring) LastID() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastID
+}
+
+func (r *Ring) add(e Event) {
+	r.lastID = max(r.lastID, e.ID)
 	if len(r.buf) == 0 {
 		return
 	}
@@ -80,78 +109,217 @@ type Service struct {
 	mu            sync.RWMutex
 	buffers       map[string]*Ring // tunnelID -> ring
 	maxBytes      int              // per event body cap
-	persist       bool
-	dir           string
-	rotateBytes   int64
+	storeKind     string
+	store         atomic.Pointer[asyncStore]
+	storeQueue    int
 	retentionDays int
 	cleanEvery    time.Duration
+	cleanOnce     sync.Once
 	// subscribers per tunnel for live streaming
 	subs map[string]map[chan Event]struct{}
 	// optional hooks for DB updates
 	onMetric    func(tunnelID string, sent, received int64)
 	onConnDelta func(tunnelID string, delta int)
+	// redaction rules applied before fan-out and persistence
+	redactMu    sync.RWMutex
+	redaction   RedactionSettings
+	entityRules map[string]RedactionRules
+	redactors   map[string]*Redactor
+	// search index over captured exchanges
+	index *SearchIndex
+	// capture policies set through the server and requested by clients
+	policyMu        sync.RWMutex
+	policies        map[string]CapturePolicy
+	sessionPolicies map[string]CapturePolicy
+	// external consumers of capture events
+	sinkMu      sync.RWMutex
+	sinks       []*sinkRunner
+	sinkConfigs []SinkConfig
 }
 
 func NewService(maxEvents, maxBytes int) *Service {
 	s := &Service{
 		buffers:       make(map[string]*Ring),
 		maxBytes:      maxBytes,
-		dir:           settings.Env("CAPTURE_DIR"),
-		rotateBytes:   int64(settings.EnvInt("CAPTURE_ROTATE_BYTES", 10*1024*1024)),
+		storeQueue:    settings.EnvInt("CAPTURE_STORE_QUEUE", 10000),
 		retentionDays: settings.EnvInt("CAPTURE_RETENTION_DAYS", 7),
 		cleanEvery:    settings.EnvDuration("CAPTURE_CLEAN_INTERVAL", 24*time.Hour),
-	}
-	// default to persist ON unless explicitly disabled
-	if v := settings.Env("CAPTURE_PERSIST"); v == "" {
-		s.persist = true
-	} else {
-		s.persist = settings.EnvBool("CAPTURE_PERSIST")
-	}
-	if s.dir == "" {
-		s.dir = "./data/capture"
+		redaction:     DefaultRedactionSettings(),
+		redactors:     make(map[string]*Redactor),
+		index:         NewSearchIndex(settings.EnvInt("CAPTURE_INDEX_MAX_EXCHANGES", 100000)),
 	}
 	// initialize subscribers map
 	s.subs = make(map[string]map[chan Event]struct{})
-	if s.persist {
-		_ = os.MkdirAll(s.dir, 0o755)
-		go s.cleanupLoop()
-	}
-	// Load existing persisted data on startup if persistence is enabled
-	if s.persist {
-		s.loadPersistedData()
+	// default to persist ON unless explicitly disabled
+	persist := true
+	if v := settings.Env("CAPTURE_PERSIST"); v != "" {
+		persist = settings.EnvBool("CAPTURE_PERSIST")
+	}
+	if !persist {
+		return s
+	}
+	s.storeKind = strings.ToLower(settings.Env("CAPTURE_STORE"))
+	switch s.storeKind {
+	case StoreS3:
+		if st, err := NewS3Store(S3ConfigFromEnv()); err == nil {
+			s.SetStore(st)
+		}
+	case StoreDatabase:
+		// the server sets a DatabaseStore once its database is connected
+	default:
+		s.storeKind = StoreJSONL
+		dir := settings.Env("CAPTURE_DIR")
+		if dir == "" {
+			dir = "./data/capture"
+		}
+		s.SetStore(NewJSONLStore(dir, int64(settings.EnvInt("CAPTURE_ROTATE_BYTES", 10*1024*1024))))
 	}
 	return s
 }
 
+// StoreKind returns the configured CHISEL_CAPTURE_STORE kind, or "" when
+// persistence is disabled
+func (s *Service) StoreKind() string { return s.storeKind }
+
+// Persisting reports whether captured events are written to a store
+func (s *Service) Persisting() bool { return s.store.Load() != nil }
+
+// SetStore makes st the persistent store: writes go through an asynchronous
+// buffered queue, and the store's events are loaded into the rings and the
+// search index. A previously set store is flushed and closed.
+func (s *Service) SetStore(st CaptureStore) {
+	if old := s.store.Swap(newAsyncStore(st, s.storeQueue)); old != nil {
+		_ = old.Close()
+	}
+	s.loadPersistedData()
+	s.cleanOnce.Do(func() { go s.cleanupLoop() })
+}
+
+// Close flushes queued events and closes the store
+func (s *Service) Close() error {
+	s.SetSinks(nil)
+	if st := s.store.Swap(nil); st != nil {
+		return st.Close()
+	}
+	return nil
+}
+
 // SetOnMetric sets a hook to be called when a connection closes with byte counts.
 func (s *Service) SetOnMetric(fn func(tunnelID string, sent, received int64)) { s.onMetric = fn }
 
 // SetOnConnDelta sets a hook to be called when a connection opens (+1) or closes (-1).
 func (s *Service) SetOnConnDelta(fn func(tunnelID string, delta int)) { s.onConnDelta = fn }
 
+// SetRedaction replaces the global and per-entity redaction rules. Entity
+// rules also apply to the entity's per-remote IDs ({id}-r{n}).
+func (s *Service) SetRedaction(global RedactionSettings, perEntity map[string]RedactionRules) error {
+	if err := global.Rules.Validate(); err != nil {
+		return err
+	}
+	for id, rules := range perEntity {
+		if err := rules.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+	}
+	s.redactMu.Lock()
+	defer s.redactMu.Unlock()
+	s.redaction, s.entityRules = global, perEntity
+	s.redactors = make(map[string]*Redactor)
+	return nil
+}
+
+// Redaction returns the current global and per-entity redaction rules
+func (s *Service) Redaction() (RedactionSettings, map[string]RedactionRules) {
+	s.redactMu.RLock()
+	defer s.redactMu.RUnlock()
+	return s.redaction, s.entityRules
+}
+
+// redactor returns the compiled rules for an entity, or nil if none apply
+func (s *Service) redactor(tunnelID string) *Redactor {
+	s.redactMu.RLock()
+	r, ok := s.redactors[tunnelID]
+	s.redactMu.RUnlock()
+	if ok {
+		return r
+	}
+
+	s.redactMu.Lock()
+	defer s.redactMu.Unlock()
+	var sets []RedactionRules
+	if s.redaction.Enabled {
+		if s.redaction.Defaults {
+			sets = append(sets, DefaultRedactionRules())
+		}
+		sets = append(sets, s.redaction.Rules)
+	}
+	for id, rules := range s.entityRules {
+		if tunnelID == id || strings.HasPrefix(tunnelID, id+"-r") {
+			sets = append(sets, rules)
+		}
+	}
+	if len(sets) > 0 {
+		// Rules were validated by SetRedaction
+		r, _ = NewRedactor(sets...)
+	}
+	s.redactors[tunnelID] = r
+	return r
+}
+
 // AddEvent adds to ring and optionally persists
 func (s *Service) AddEvent(tunnelID string, e Event, maxEvents int) {
+	if r := s.redactor(tunnelID); r != nil {
+		if found := r.Redact(&e); len(found) > 0 {
+			e.Meta = withRedacted(e.Meta, found)
+		}
+	}
 	if len(e.Data) > s.maxBytes {
 		e.Data = e.Data[:s.maxBytes]
 		e.Truncated = true
 	}
 
-	// fan out live
-	if subs := s.subs[tunnelID]; subs != nil {
-		for ch := range subs {
+	e = s.ring(tunnelID, maxEvents).append(e, func(e Event) {
+		// fan out live; slow subscribers miss events and recover them by ID
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		for ch := range s.subs[tunnelID] {
 			select {
 			case ch <- e:
 			default:
-				// drop if subscriber is slow
 			}
 		}
+	})
+	if searchable(tunnelID) {
+		s.index.Add(tunnelID, e)
 	}
-
-	s.ring(tunnelID, maxEvents).Add(e)
-	if s.persist {
-		_ = s.appendJSONL(tunnelID, e)
+	if st := s.store.Load(); st != nil {
+		st.enqueue(tunnelID, e)
 	}
+	s.publish(tunnelID, e)
+}
+
+// redactsBodies reports whether body rules apply to an entity's captures
+func (s *Service) redactsBodies(tunnelID string) bool {
+	return s.redactor(tunnelID).hasBodyRules()
+}
 
+// withRedacted records the labels of matched rules in an event's meta. Meta
+// that is not a map is converted to one, keeping a non-object value under
+// "value", so the marker is never lost.
+func withRedacted(meta any, found []string) any {
+	switch m := meta.(type) {
+	case map[string]any:
+		m["redacted"] = found
+		return m
+	case nil:
+		return map[string]any{"redacted": found}
+	}
+	out := map[string]any{}
+	if b, err := json.Marshal(meta); err != nil || json.Unmarshal(b, &out) != nil {
+		out = map[string]any{"value": meta}
+	}
+	out["redacted"] = found
+	return out
 }
 
 // Subscribe returns a channel that will receive future events for a tunnel.
@@ -196,109 +364,46 @@ func (s *Service) ring(tunnelID string, maxEvents int) *Ring {
 	return r
 }
 
-// appendJSONL appends event as a JSON line into per-connection rotating file
-func (s *Service) appendJSONL(tunnelID string, e Event) error {
-	if e.ConnID == "" {
-		return errors.New("missing connID")
-	}
-	dir := filepath.Join(s.dir, tunnelID)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return err
-	}
-	// current file
-	path := filepath.Join(dir, fmt.Sprintf("%s.log", e.ConnID))
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	// rotate if needed
-	if st, err := f.Stat(); err == nil {
-		if st.Size() >= s.rotateBytes {
-			_ = f.Close()
-			// find next suffix
-			for i := 1; ; i++ {
-				rot := filepath.Join(dir, fmt.Sprintf("%s.log.%d", e.ConnID, i))
-				if _, err := os.Stat(rot); os.IsNotExist(err) {
-					_ = os.Rename(path, rot)
-					break
-				}
-			}
-			// reopen fresh file
-			f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-		}
+// ListConnections returns the persisted connection IDs for a tunnel
+func (s *Service) ListConnections(tunnelID string) ([]string, error) {
+	st := s.store.Load()
+	if st == nil {
+		return []string{}, nil
 	}
-	// write JSONL
-	b, _ := json.Marshal(e)
-	b = append(b, '\n')
-	_, _ = f.Write(b)
-	return nil
+	st.Flush()
+	return st.Connections(tunnelID)
 }
 
-// ListConnections returns known connection IDs for a tunnel based on persisted files
-func (s *Service) ListConnections(tunnelID string) ([]string, error) {
-	dir := filepath.Join(s.dir, tunnelID)
-	ents, err := os.ReadDir(dir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
-		}
-		return nil, err
-	}
-	m := map[string]struct{}{}
-	for _, e := range ents {
-		name := e.Name()
-		if strings.HasSuffix(name, ".log") || strings.Contains(name, ".log.") {
-			base := name
-			if i := strings.Index(base, ".log"); i >= 0 {
-				base = base[:i]
-			}
-			if base != "" {
-				m[base] = struct{}{}
-			}
-		}
+// LatestLogFile returns the latest JSONL file of a connection. Only the
+// JSONL store keeps files; other stores return os.ErrNotExist.
+func (s *Service) LatestLogFile(tunnelID, connID string) (string, error) {
+	st := s.store.Load()
+	if st == nil {
+		return "", os.ErrNotExist
 	}
-	out := make([]string, 0, len(m))
-	for k := range m {
-		out = append(out, k)
+	j, ok := st.CaptureStore.(*JSONLStore)
+	if !ok {
+		return "", os.ErrNotExist
 	}
-	return out, nil
+	st.Flush()
+	return j.LatestLogFile(tunnelID, connID)
 }
 
-// LatestLogFile returns the latest file path for a given connection (base or highest rotated)
-func (s *Service) LatestLogFile(tunnelID, connID string) (string, error) {
-	dir := filepath.Join(s.dir, tunnelID)
-	base := filepath.Join(dir, connID+".log")
-	if _, err := os.Stat(base); err == nil {
-		return base, nil
-	}
-	// find highest suffix
-	maxI := -1
-	var best string
-	ents, err := os.ReadDir(dir)
-	if err != nil {
-		return "", err
-	}
-	prefix := connID + ".log."
-	for _, e := range ents {
-		name := e.Name()
-		if strings.HasPrefix(name, prefix) {
-			var i int
-			if _, err := fmt.Sscanf(name, connID+".log.%d", &i); err == nil {
-				if i > maxI {
-					maxI, best = i, filepath.Join(dir, name)
-				}
-			}
-		}
+// Events returns every captured event for a tunnel in capture order. Persisted
+// events are read from the store when persistence is on; otherwise the
+// in-memory ring is used.
+func (s *Service) Events(tunnelID string) []Event {
+	st := s.store.Load()
+	if st == nil {
+		return s.GetRecent(tunnelID, 500)
 	}
-	if best != "" {
-		return best, nil
+	st.Flush()
+	out, err := st.Events(tunnelID)
+	if err != nil || len(out) == 0 {
+		return s.GetRecent(tunnelID, 500)
 	}
-	return base, os.ErrNotExist
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out
 }
 
 func (s *Service) cleanupLoop() {
@@ -309,107 +414,51 @@ func (s *Service) cleanupLoop() {
 	}
 }
 
+// cleanup removes captures past their entity's retention
 func (s *Service) cleanup() {
-	if s.retentionDays <= 0 {
-		return
+	now := time.Now()
+	cutoff := func(entityID string) time.Time { return s.retentionCutoff(entityID, now) }
+	if st := s.store.Load(); st != nil {
+		st.Flush()
+		_ = st.Prune(cutoff)
 	}
-	cutoff := time.Now().Add(-time.Duration(s.retentionDays) * 24 * time.Hour)
-	_ = filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info == nil || info.IsDir() {
-			return nil
-		}
-		// delete files older than cutoff
-		if info.ModTime().Before(cutoff) {
-			_ = os.Remove(path)
-		}
-		return nil
-	})
+	s.index.Prune(cutoff)
+}
+
+// Search runs a query over the indexed exchanges, see SearchIndex.Search
+func (s *Service) Search(q *SearchQuery, allow func(entityID string) bool) ([]SearchHit, int) {
+	return s.index.Search(q, allow)
 }
 
-// loadPersistedData loads existing capture files from disk into memory rings on startup
+// loadPersistedData loads the store's most recent events per entity into
+// the memory rings and indexes every stored exchange
 func (s *Service) loadPersistedData() {
-	if s.dir == "" {
+	st := s.store.Load()
+	if st == nil {
 		return
 	}
-
-	// Walk through tunnel directories and load recent events
-	tunnelDirs, err := os.ReadDir(s.dir)
+	ids, err := st.Entities()
 	if err != nil {
-		return // No existing data or can't read
+		return
 	}
-
-	for _, tunnelDir := range tunnelDirs {
-		if !tunnelDir.IsDir() {
-			continue
-		}
-
-		tunnelID := tunnelDir.Name()
-		tunnelPath := filepath.Join(s.dir, tunnelID)
-
-		// Load events from all connection files in this tunnel
-		connFiles, err := os.ReadDir(tunnelPath)
-		if err != nil {
+	for _, id := range ids {
+		events, err := st.Events(id)
+		if err != nil || len(events) == 0 {
 			continue
 		}
-
-		var allEvents []Event
-		for _, connFile := range connFiles {
-			if !strings.HasSuffix(connFile.Name(), ".log") {
-				continue
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+		if searchable(id) {
+			for _, e := range events {
+				s.index.Add(id, e)
 			}
-
-			filePath := filepath.Join(tunnelPath, connFile.Name())
-			events := s.loadEventsFromFile(filePath)
-			allEvents = append(allEvents, events...)
 		}
-
-		// Sort by time and keep most recent
-		if len(allEvents) > 0 {
-			sort.Slice(allEvents, func(i, j int) bool {
-				return allEvents[i].Time.After(allEvents[j].Time)
-			})
-
-			// Initialize ring buffer for this tunnel
-			s.mu.Lock()
-			if s.buffers[tunnelID] == nil {
-				s.buffers[tunnelID] = NewRing(500) // Use default max events
-			}
-
-			// Add events to ring (most recent first, so they appear in correct order)
-			maxLoad := 500
-			if len(allEvents) < maxLoad {
-				maxLoad = len(allEvents)
-			}
-			for i := 0; i < maxLoad; i++ {
-				s.buffers[tunnelID].Add(allEvents[i])
-			}
-			s.mu.Unlock()
+		// Initialize ring buffer for this tunnel with the most recent events
+		if len(events) > 500 {
+			events = events[len(events)-500:]
 		}
-	}
-}
-
-// loadEventsFromFile reads events from a single log file
-func (s *Service) loadEventsFromFile(filePath string) []Event {
-	var events []Event
-
-	file, err := os.Open(filePath)
-	if err != nil {
-		return events
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		var event Event
-		if err := json.Unmarshal([]byte(line), &event); err == nil {
-			events = append(events, event)
+		r := s.ring(id, 500)
+		for _, e := range events {
+			r.Add(e)
 		}
 	}
-
-	return events
 }
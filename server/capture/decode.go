@@ -0,0 +1,304 @@
+package capture
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Decoded views of captured bodies.
+//
+// The final body event of each HTTP message carries a "decoded" meta entry
+// describing the whole body: content codings from Content-Encoding are
+// reversed and the result is rendered according to Content-Type. The raw
+// bytes stay in the events' Data.
+
+// maxDecodedText bounds the text kept in a decoded view
+const maxDecodedText = 64 * 1024
+
+// maxMultipartParts bounds the parts listed for a multipart body
+const maxMultipartParts = 50
+
+// DecodedBody is a readable view of a message body
+type DecodedBody struct {
+	// Kind is one of json, form, multipart, image, text or binary
+	Kind        string `json:"kind"`
+	ContentType string `json:"content_type,omitempty"`
+	// Encoding lists the content codings that were reversed
+	Encoding string      `json:"encoding,omitempty"`
+	Size     int         `json:"size"`
+	Text     string      `json:"text,omitempty"`
+	Fields   []FormField `json:"fields,omitempty"`
+	Parts    []BodyPart  `json:"parts,omitempty"`
+	Image    *ImageInfo  `json:"image,omitempty"`
+	// Truncated is set when the captured body or the view was cut short
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// FormField is a name/value pair of a form-urlencoded body
+type FormField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// BodyPart describes one part of a multipart body
+type BodyPart struct {
+	Name        string     `json:"name,omitempty"`
+	Filename    string     `json:"filename,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Size        int        `json:"size"`
+	Kind        string     `json:"kind"`
+	Text        string     `json:"text,omitempty"`
+	Image       *ImageInfo `json:"image,omitempty"`
+}
+
+// ImageInfo is the metadata needed to present an image thumbnail
+type ImageInfo struct {
+	Format string `json:"format"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// DecodeBody builds the decoded view of a body sent with header. truncated
+// reports that the capture holds only a prefix of the body.
+func DecodeBody(header http.Header, body []byte, truncated bool) *DecodedBody {
+	d := &DecodedBody{ContentType: header.Get("Content-Type"), Truncated: truncated}
+	if enc := contentCodings(header.Get("Content-Encoding")); len(enc) > 0 {
+		decoded, err := decodeContent(header.Get("Content-Encoding"), body)
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Encoding = strings.Join(enc, ", ")
+			body = decoded
+		}
+	}
+	d.Size = len(body)
+
+	kind, text, fields, parts, img, err := describeBody(d.ContentType, body)
+	d.Kind, d.Fields, d.Parts, d.Image = kind, fields, parts, img
+	if len(text) > maxDecodedText {
+		text = text[:maxDecodedText]
+		d.Truncated = true
+	}
+	d.Text = text
+	if err != nil && d.Error == "" {
+		d.Error = err.Error()
+	}
+	return d
+}
+
+// describeBody renders body according to its media type, sniffing one when absent
+func describeBody(contentType string, body []byte) (kind, text string, fields []FormField, parts []BodyPart, img *ImageInfo, err error) {
+	if contentType == "" && len(body) > 0 {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var out bytes.Buffer
+		if err = json.Indent(&out, body, "", "  "); err != nil {
+			return "text", textOrEmpty(body), nil, nil, nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return "json", out.String(), nil, nil, nil, nil
+	case mediaType == "application/x-www-form-urlencoded":
+		fields, err = parseForm(string(body))
+		return "form", "", fields, nil, nil, err
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "":
+		parts, err = parseMultipart(body, params["boundary"])
+		return "multipart", "", nil, parts, nil, err
+	case strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml":
+		return "image", "", nil, nil, imageInfo(mediaType, body), nil
+	case isTextual(mediaType) || (mediaType == "" && utf8.Valid(body)):
+		return "text", textOrEmpty(body), nil, nil, nil, nil
+	}
+	if utf8.Valid(body) && !bytes.ContainsRune(body, 0) {
+		return "text", string(body), nil, nil, nil, nil
+	}
+	return "binary", "", nil, nil, nil, nil
+}
+
+func isTextual(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/xml", "application/javascript", "application/x-javascript", "application/graphql", "application/x-ndjson", "application/yaml":
+		return true
+	}
+	return false
+}
+
+func textOrEmpty(body []byte) string {
+	if utf8.Valid(body) {
+		return string(body)
+	}
+	return ""
+}
+
+// parseForm splits a form-urlencoded body, keeping the field order
+func parseForm(s string) ([]FormField, error) {
+	var fields []FormField
+	var firstErr error
+	for _, pair := range strings.Split(s, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		n, err := url.QueryUnescape(name)
+		if err != nil {
+			n, firstErr = name, err
+		}
+		v, err := url.QueryUnescape(value)
+		if err != nil {
+			v, firstErr = value, err
+		}
+		fields = append(fields, FormField{Name: n, Value: v})
+	}
+	return fields, firstErr
+}
+
+func parseMultipart(body []byte, boundary string) ([]BodyPart, error) {
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	var parts []BodyPart
+	for len(parts) < maxMultipartParts {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+		data, err := io.ReadAll(p)
+		part := BodyPart{Name: p.FormName(), Filename: p.FileName(), ContentType: p.Header.Get("Content-Type"), Size: len(data)}
+		if dec := contentCodings(p.Header.Get("Content-Encoding")); len(dec) > 0 {
+			if decoded, derr := decodeContent(p.Header.Get("Content-Encoding"), data); derr == nil {
+				data = decoded
+			}
+		}
+		ct := part.ContentType
+		if ct == "" && part.Filename == "" {
+			ct = "text/plain"
+		}
+		var text string
+		part.Kind, text, _, _, part.Image, _ = describeBody(ct, data)
+		if len(text) > maxDecodedText {
+			text = text[:maxDecodedText]
+		}
+		part.Text = text
+		parts = append(parts, part)
+		if err != nil {
+			return parts, err
+		}
+	}
+	return parts, nil
+}
+
+// imageInfo reads the format and dimensions of an image without decoding it
+func imageInfo(mediaType string, body []byte) *ImageInfo {
+	info := &ImageInfo{Format: strings.TrimPrefix(mediaType, "image/")}
+	if cfg, format, err := image.DecodeConfig(bytes.NewReader(body)); err == nil {
+		info.Format, info.Width, info.Height = format, cfg.Width, cfg.Height
+	}
+	return info
+}
+
+// contentCodings lists the codings of a Content-Encoding value, ignoring identity
+func contentCodings(encoding string) []string {
+	var out []string
+	for _, c := range strings.Split(encoding, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c != "" && c != "identity" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// decodeContent reverses the content codings listed in encoding (gzip,
+// deflate and br), last applied first. Unknown or broken encodings return
+// the body unchanged together with the error.
+func decodeContent(encoding string, body []byte) ([]byte, error) {
+	codings := contentCodings(encoding)
+	out := body
+	for i := len(codings) - 1; i >= 0; i-- {
+		var r io.Reader
+		var err error
+		switch codings[i] {
+		case "gzip", "x-gzip":
+			var zr *gzip.Reader
+			if zr, err = gzip.NewReader(bytes.NewReader(out)); err == nil {
+				defer zr.Close()
+				r = zr
+			}
+		case "deflate":
+			// deflate is specified as zlib-wrapped, but raw streams are common
+			var zr io.ReadCloser
+			if zr, err = zlib.NewReader(bytes.NewReader(out)); err == nil {
+				defer zr.Close()
+				r = zr
+			} else {
+				r, err = flate.NewReader(bytes.NewReader(out)), nil
+			}
+		case "br":
+			r = brotli.NewReader(bytes.NewReader(out))
+		default:
+			return body, fmt.Errorf("unsupported content encoding %q", codings[i])
+		}
+		if err != nil {
+			return body, err
+		}
+		decoded, err := io.ReadAll(r)
+		if err != nil && len(decoded) == 0 {
+			return body, err
+		}
+		out = decoded
+	}
+	return out, nil
+}
+
+// encodeContent applies the content codings listed in encoding in order, the
+// reverse of decodeContent. It is used to store redacted bodies in the
+// encoding their headers announce.
+func encodeContent(encoding string, body []byte) ([]byte, error) {
+	out := body
+	for _, c := range contentCodings(encoding) {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch c {
+		case "gzip", "x-gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "br":
+			w = brotli.NewWriter(&buf)
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", c)
+		}
+		if _, err := w.Write(out); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		out = buf.Bytes()
+	}
+	return out, nil
+}
@@ -0,0 +1,100 @@
+package capture
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"image"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestDecodeBody(t *testing.T) {
+	var gz, zl, br, img bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(`{"a":[1,2]}`))
+	zw.Close()
+	lw := zlib.NewWriter(&zl)
+	lw.Write([]byte("deflated"))
+	lw.Close()
+	bw := brotli.NewWriter(&br)
+	bw.Write([]byte(`{"b":true}`))
+	bw.Close()
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+
+	multipartBody := "--XX\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\nhello\r\n" +
+		"--XX\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.png\"\r\nContent-Type: image/png\r\n\r\n" +
+		img.String() + "\r\n--XX--\r\n"
+
+	hdr := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	t.Run("gzip json", func(t *testing.T) {
+		d := DecodeBody(hdr("Content-Type", "application/json", "Content-Encoding", "gzip"), gz.Bytes(), false)
+		if d.Kind != "json" || d.Encoding != "gzip" || d.Text != "{\n  \"a\": [\n    1,\n    2\n  ]\n}" || d.Size != 11 {
+			t.Fatalf("unexpected view: %+v", d)
+		}
+	})
+	t.Run("brotli", func(t *testing.T) {
+		d := DecodeBody(hdr("Content-Type", "application/problem+json", "Content-Encoding", "br"), br.Bytes(), false)
+		if d.Kind != "json" || d.Encoding != "br" || d.Text != "{\n  \"b\": true\n}" {
+			t.Fatalf("unexpected view: %+v", d)
+		}
+	})
+	t.Run("deflate text", func(t *testing.T) {
+		d := DecodeBody(hdr("Content-Type", "text/plain", "Content-Encoding", "deflate"), zl.Bytes(), false)
+		if d.Kind != "text" || d.Text != "deflated" {
+			t.Fatalf("unexpected view: %+v", d)
+		}
+	})
+	t.Run("form", func(t *testing.T) {
+		d := DecodeBody(hdr("Content-Type", "application/x-www-form-urlencoded"), []byte("b=2&a=x+y&a=%21"), false)
+		want := []FormField{{"b", "2"}, {"a", "x y"}, {"a", "!"}}
+		if d.Kind != "form" || len(d.Fields) != len(want) {
+			t.Fatalf("unexpected view: %+v", d)
+		}
+		for i, f := range want {
+			if d.Fields[i] != f {
+				t.Fatalf("field %d = %+v, want %+v", i, d.Fields[i], f)
+			}
+		}
+	})
+	t.Run("multipart", func(t *testing.T) {
+		d := DecodeBody(hdr("Content-Type", "multipart/form-data; boundary=XX"), []byte(multipartBody), false)
+		if d.Kind != "multipart" || len(d.Parts) != 2 {
+			t.Fatalf("unexpected view: %+v", d)
+		}
+		if p := d.Parts[0]; p.Name != "title" || p.Kind != "text" || p.Text != "hello" {
+			t.Fatalf("unexpected text part: %+v", p)
+		}
+		if p := d.Parts[1]; p.Filename != "a.png" || p.Kind != "image" || p.Image == nil || p.Image.Width != 4 || p.Image.Height != 3 {
+			t.Fatalf("unexpected image part: %+v", p)
+		}
+	})
+	t.Run("image", func(t *testing.T) {
+		d := DecodeBody(hdr("Content-Type", "image/png"), img.Bytes(), false)
+		if d.Kind != "image" || d.Image == nil || d.Image.Format != "png" || d.Image.Width != 4 {
+			t.Fatalf("unexpected view: %+v", d)
+		}
+	})
+	t.Run("broken json", func(t *testing.T) {
+		d := DecodeBody(hdr("Content-Type", "application/json"), []byte(`{"a":`), true)
+		if d.Kind != "text" || d.Error == "" || !d.Truncated || d.Text != `{"a":` {
+			t.Fatalf("unexpected view: %+v", d)
+		}
+	})
+	t.Run("binary", func(t *testing.T) {
+		d := DecodeBody(hdr("Content-Type", "application/octet-stream"), []byte{0, 1, 2, 0xff}, false)
+		if d.Kind != "binary" || d.Text != "" {
+			t.Fatalf("unexpected view: %+v", d)
+		}
+	})
+}
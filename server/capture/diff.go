@@ -0,0 +1,366 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Diff operations
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+	DiffEqual   = "equal"
+)
+
+// maxDiffLines bounds the lines compared by a line diff; the rest of the
+// bodies is reported as truncated
+const maxDiffLines = 2000
+
+// ExchangeDiff is the structured difference between two captured exchanges,
+// from A to B
+type ExchangeDiff struct {
+	Request  MessageDiff `json:"request"`
+	Response MessageDiff `json:"response"`
+	// Equal is set when neither message differs
+	Equal bool `json:"equal"`
+}
+
+// MessageDiff compares one side of two exchanges
+type MessageDiff struct {
+	// Start lists differences of the request or status line, by field
+	Start   []FieldChange  `json:"start,omitempty"`
+	Headers []HeaderChange `json:"headers,omitempty"`
+	Body    BodyDiff       `json:"body"`
+}
+
+// FieldChange is a differing request or status line field
+type FieldChange struct {
+	Field string `json:"field"`
+	A     any    `json:"a"`
+	B     any    `json:"b"`
+}
+
+// HeaderChange is an added, removed or changed header
+type HeaderChange struct {
+	Name string   `json:"name"`
+	Op   string   `json:"op"`
+	A    []string `json:"a,omitempty"`
+	B    []string `json:"b,omitempty"`
+}
+
+// BodyDiff compares two bodies as JSON trees when both are JSON, as lines
+// when they are text and by size and content otherwise
+type BodyDiff struct {
+	// Kind is json, text or binary
+	Kind  string `json:"kind"`
+	Equal bool   `json:"equal"`
+	// JSON lists changed members by dotted path, array elements by index
+	JSON  []JSONChange `json:"json,omitempty"`
+	Lines []LineChange `json:"lines,omitempty"`
+	SizeA int          `json:"size_a"`
+	SizeB int          `json:"size_b"`
+	// Truncated is set when either body was captured or compared in part
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// JSONChange is an added, removed or changed JSON member
+type JSONChange struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+	A    any    `json:"a,omitempty"`
+	B    any    `json:"b,omitempty"`
+}
+
+// LineChange is one line of a line diff
+type LineChange struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffExchanges compares exchange a with exchange b
+func DiffExchanges(a, b *Exchange) *ExchangeDiff {
+	d := &ExchangeDiff{}
+
+	reqA, _ := a.RequestHead()
+	reqB, _ := b.RequestHead()
+	var ha, hb http.Header
+	if reqA != nil {
+		ha = reqA.Header
+	} else {
+		reqA = &RequestHead{}
+	}
+	if reqB != nil {
+		hb = reqB.Header
+	} else {
+		reqB = &RequestHead{}
+	}
+	d.Request.Start = diffFields(
+		[]string{"method", "path", "proto", "host"},
+		[]any{reqA.Method, reqA.Path, reqA.Proto, reqA.Host},
+		[]any{reqB.Method, reqB.Path, reqB.Proto, reqB.Host})
+	d.Request.Headers = diffHeaders(ha, hb)
+	d.Request.Body = diffBodies(ha, a.RequestBody, a.RequestBodySize, hb, b.RequestBody, b.RequestBodySize, a.Truncated || b.Truncated)
+
+	resA, _ := a.ResponseHead()
+	resB, _ := b.ResponseHead()
+	ha, hb = nil, nil
+	if resA != nil {
+		ha = resA.Header
+	} else {
+		resA = &ResponseHead{}
+	}
+	if resB != nil {
+		hb = resB.Header
+	} else {
+		resB = &ResponseHead{}
+	}
+	d.Response.Start = diffFields(
+		[]string{"code", "status", "proto"},
+		[]any{resA.Code, resA.Status, resA.Proto},
+		[]any{resB.Code, resB.Status, resB.Proto})
+	d.Response.Headers = diffHeaders(ha, hb)
+	d.Response.Body = diffBodies(ha, a.ResponseBody, a.ResponseBodySize, hb, b.ResponseBody, b.ResponseBodySize, a.Truncated || b.Truncated)
+
+	d.Equal = len(d.Request.Start) == 0 && len(d.Request.Headers) == 0 && d.Request.Body.Equal &&
+		len(d.Response.Start) == 0 && len(d.Response.Headers) == 0 && d.Response.Body.Equal
+	return d
+}
+
+func diffFields(names []string, a, b []any) []FieldChange {
+	var out []FieldChange
+	for i, name := range names {
+		if a[i] != b[i] {
+			out = append(out, FieldChange{Field: name, A: a[i], B: b[i]})
+		}
+	}
+	return out
+}
+
+// diffHeaders compares headers by canonical name, in name order
+func diffHeaders(a, b http.Header) []HeaderChange {
+	names := map[string]bool{}
+	for k := range a {
+		names[http.CanonicalHeaderKey(k)] = true
+	}
+	for k := range b {
+		names[http.CanonicalHeaderKey(k)] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for k := range names {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var out []HeaderChange
+	for _, name := range sorted {
+		va, vb := a.Values(name), b.Values(name)
+		switch {
+		case len(va) == 0:
+			out = append(out, HeaderChange{Name: name, Op: DiffAdded, B: vb})
+		case len(vb) == 0:
+			out = append(out, HeaderChange{Name: name, Op: DiffRemoved, A: va})
+		case !reflect.DeepEqual(va, vb):
+			out = append(out, HeaderChange{Name: name, Op: DiffChanged, A: va, B: vb})
+		}
+	}
+	return out
+}
+
+// diffBodies compares two captured bodies after reversing their content codings
+func diffBodies(ha http.Header, a []byte, sizeA int, hb http.Header, b []byte, sizeB int, truncated bool) BodyDiff {
+	da := DecodeBody(ha, a, truncated)
+	db := DecodeBody(hb, b, truncated)
+	out := BodyDiff{SizeA: sizeA, SizeB: sizeB, Truncated: da.Truncated || db.Truncated}
+	if sizeA < 0 {
+		out.SizeA = len(a)
+	}
+	if sizeB < 0 {
+		out.SizeB = len(b)
+	}
+
+	if da.Kind == "json" && db.Kind == "json" {
+		va, errA := decodeJSON(da.Text)
+		vb, errB := decodeJSON(db.Text)
+		if errA == nil && errB == nil {
+			out.Kind = "json"
+			diffJSON(nil, va, vb, &out.JSON)
+			out.Equal = len(out.JSON) == 0
+			return out
+		}
+	}
+
+	ta, okA := diffText(da)
+	tb, okB := diffText(db)
+	if !okA || !okB {
+		out.Kind = "binary"
+		out.Equal = bytes.Equal(a, b) && reflect.DeepEqual(ha.Values("Content-Encoding"), hb.Values("Content-Encoding"))
+		return out
+	}
+	out.Kind = "text"
+	var cut bool
+	out.Lines, cut = diffLines(splitLines(ta), splitLines(tb))
+	out.Truncated = out.Truncated || cut
+	out.Equal = ta == tb
+	return out
+}
+
+func decodeJSON(text string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// diffText renders a decoded body as comparable text
+func diffText(d *DecodedBody) (string, bool) {
+	switch d.Kind {
+	case "form":
+		var b strings.Builder
+		for _, f := range d.Fields {
+			b.WriteString(f.Name + "=" + f.Value + "\n")
+		}
+		return b.String(), true
+	case "multipart":
+		b, _ := json.MarshalIndent(d.Parts, "", "  ")
+		return string(b), true
+	case "image":
+		b, _ := json.MarshalIndent(d.Image, "", "  ")
+		return string(b), true
+	case "binary":
+		return "", d.Size == 0
+	}
+	return d.Text, true
+}
+
+// diffJSON appends the differences between JSON values a and b at path
+func diffJSON(path []string, a, b any, out *[]JSONChange) {
+	p := strings.Join(path, ".")
+	switch ta := a.(type) {
+	case map[string]any:
+		tb, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(ta)+len(tb))
+		for k := range ta {
+			keys = append(keys, k)
+		}
+		for k := range tb {
+			if _, ok := ta[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := append(path[:len(path):len(path)], k)
+			va, inA := ta[k]
+			vb, inB := tb[k]
+			switch {
+			case !inA:
+				*out = append(*out, JSONChange{Path: strings.Join(child, "."), Op: DiffAdded, B: vb})
+			case !inB:
+				*out = append(*out, JSONChange{Path: strings.Join(child, "."), Op: DiffRemoved, A: va})
+			default:
+				diffJSON(child, va, vb, out)
+			}
+		}
+		return
+	case []any:
+		tb, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(ta), len(tb)); i++ {
+			child := append(path[:len(path):len(path)], strconv.Itoa(i))
+			switch {
+			case i >= len(ta):
+				*out = append(*out, JSONChange{Path: strings.Join(child, "."), Op: DiffAdded, B: tb[i]})
+			case i >= len(tb):
+				*out = append(*out, JSONChange{Path: strings.Join(child, "."), Op: DiffRemoved, A: ta[i]})
+			default:
+				diffJSON(child, ta[i], tb[i], out)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, JSONChange{Path: p, Op: DiffChanged, A: a, B: b})
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes a longest-common-subsequence line diff of a and b,
+// comparing at most maxDiffLines lines of each
+func diffLines(a, b []string) ([]LineChange, bool) {
+	cut := false
+	if len(a) > maxDiffLines {
+		a, cut = a[:maxDiffLines], true
+	}
+	if len(b) > maxDiffLines {
+		b, cut = b[:maxDiffLines], true
+	}
+	// Common prefix and suffix need no table
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	// lcs[i][j] is the LCS length of ma[i:] and mb[j:]
+	lcs := make([][]int32, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	out := make([]LineChange, 0, len(a)+len(b)-pre-suf)
+	for _, l := range a[:pre] {
+		out = append(out, LineChange{Op: DiffEqual, Text: l})
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			out = append(out, LineChange{Op: DiffEqual, Text: ma[i]})
+			i++
+			j++
+		case i < len(ma) && (j == len(mb) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, LineChange{Op: DiffRemoved, Text: ma[i]})
+			i++
+		default:
+			out = append(out, LineChange{Op: DiffAdded, Text: mb[j]})
+			j++
+		}
+	}
+	for _, l := range a[len(a)-suf:] {
+		out = append(out, LineChange{Op: DiffEqual, Text: l})
+	}
+	return out, cut
+}
@@ -0,0 +1,88 @@
+package capture
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NextChapterSoftware/chissl/share/tunnel"
+)
+
+func TestDiffExchanges(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+	capture := func(id, req, res string) *Exchange {
+		tap := newTap(svc, id, 500, tunnel.Meta{ConnID: "c1"}, nil)
+		tap.OnOpen()
+		tap.SrcWriter().Write([]byte(req))
+		tap.DstWriter().Write([]byte(res))
+		tap.OnClose(0, 0)
+		return FindExchange(svc.GetRecent(id, 500), "c1", 1)
+	}
+	jsonMsg := func(start, headers, body string) string {
+		return start + "\r\n" + headers + "Content-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	}
+
+	// JSON bodies are compared as trees, headers by name
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(`{"event":"paid","amount":10,"items":[1,2,3],"customer":{"id":"c1","tier":"gold"}}`))
+	zw.Close()
+	a := capture("lst-a",
+		jsonMsg("POST /hook HTTP/1.1", "Host: x\r\nX-Signature: s1\r\nX-Old: 1\r\n", `{"event":"paid","amount":10}`),
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	b := capture("tun-b",
+		jsonMsg("POST /hook/v2 HTTP/1.1", "Host: x\r\nX-Signature: s2\r\nX-New: 1\r\n", `{"event":"paid","amount":12.5,"currency":"USD"}`),
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	d := DiffExchanges(a, b)
+	if d.Equal || !d.Response.Body.Equal || len(d.Response.Headers) != 0 {
+		t.Fatalf("unexpected diff %+v", d)
+	}
+	if len(d.Request.Start) != 1 || d.Request.Start[0].Field != "path" || d.Request.Start[0].B != "/hook/v2" {
+		t.Fatalf("unexpected request line diff %+v", d.Request.Start)
+	}
+	var ops []string
+	for _, h := range d.Request.Headers {
+		ops = append(ops, h.Op+":"+h.Name)
+	}
+	if strings.Join(ops, ",") != "changed:Content-Length,added:X-New,removed:X-Old,changed:X-Signature" {
+		t.Fatalf("unexpected header diff %v", ops)
+	}
+	got, _ := json.Marshal(d.Request.Body.JSON)
+	if d.Request.Body.Kind != "json" || string(got) != `[{"path":"amount","op":"changed","a":10,"b":12.5},{"path":"currency","op":"added","b":"USD"}]` {
+		t.Fatalf("unexpected JSON diff %s (%s)", got, d.Request.Body.Kind)
+	}
+
+	// Compressed bodies are decoded first; arrays are compared by index
+	c := capture("lst-c",
+		jsonMsg("POST /hook HTTP/1.1", "Host: x\r\nContent-Encoding: gzip\r\n", gz.String()),
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	e := capture("lst-e",
+		jsonMsg("POST /hook HTTP/1.1", "Host: x\r\n", `{"event":"paid","amount":10,"items":[1,5],"customer":{"id":"c1"}}`),
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	got, _ = json.Marshal(DiffExchanges(c, e).Request.Body.JSON)
+	if string(got) != `[{"path":"customer.tier","op":"removed","a":"gold"},{"path":"items.1","op":"changed","a":2,"b":5},{"path":"items.2","op":"removed","a":3}]` {
+		t.Fatalf("unexpected JSON diff %s", got)
+	}
+
+	// Other bodies get a line diff
+	f := capture("lst-f", "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 500 Internal Server Error\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\none\ntwo\nthree")
+	g := capture("lst-g", "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 17\r\n\r\none\n2\nthree\nfour")
+	d = DiffExchanges(f, g)
+	if len(d.Request.Start) != 0 || !d.Request.Body.Equal || len(d.Response.Start) != 2 || d.Response.Start[0].A != 500 {
+		t.Fatalf("unexpected diff %+v", d)
+	}
+	var lines []string
+	for _, l := range d.Response.Body.Lines {
+		lines = append(lines, l.Op+":"+l.Text)
+	}
+	if d.Response.Body.Kind != "text" || strings.Join(lines, ",") != "equal:one,removed:two,added:2,equal:three,added:four" {
+		t.Fatalf("unexpected line diff %v", lines)
+	}
+	if d := DiffExchanges(f, f); !d.Equal {
+		t.Fatalf("expected an exchange to equal itself: %+v", d)
+	}
+}
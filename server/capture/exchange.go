@@ -0,0 +1,129 @@
+package capture
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Exchange collects the events of one captured request/response pair
+type Exchange struct {
+	ConnID string
+	Seq    int64
+	// Request is the ReqHeaders event, Response the final ResHeaders event
+	Request, Response         *Event
+	RequestBody, ResponseBody []byte
+	// wire sizes of the framed bodies, -1 while unknown
+	RequestBodySize, ResponseBodySize int
+	RequestEnd, ResponseEnd           time.Time
+	Truncated                         bool
+	// RequestRedactions lists the redaction rules that matched the request
+	RequestRedactions []string
+	// Timing is the latency breakdown, once the exchange completed
+	Timing *ExchangeTiming
+}
+
+// Exchanges groups sequenced HTTP events by connection and sequence number,
+// in the order their first event was captured
+func Exchanges(events []Event) []*Exchange {
+	type key struct {
+		conn string
+		seq  int64
+	}
+	byKey := map[key]*Exchange{}
+	var out []*Exchange
+	for i := range events {
+		e := &events[i]
+		seq, ok := metaInt(e.Meta, "seq")
+		if !ok {
+			continue
+		}
+		k := key{e.ConnID, seq}
+		x := byKey[k]
+		if x == nil {
+			x = &Exchange{ConnID: e.ConnID, Seq: seq, RequestBodySize: -1, ResponseBodySize: -1}
+			byKey[k] = x
+			out = append(out, x)
+		}
+		x.Truncated = x.Truncated || e.Truncated
+		if e.Type == ReqHeaders || e.Type == ReqBody {
+			x.RequestRedactions = append(x.RequestRedactions, metaStrings(e.Meta, "redacted")...)
+		}
+		switch e.Type {
+		case ReqHeaders:
+			x.Request, x.RequestEnd = e, e.Time
+		case ReqBody:
+			x.RequestBody = append(x.RequestBody, e.Data...)
+			x.RequestEnd = e.Time
+			if n, ok := metaInt(e.Meta, "body_bytes"); ok {
+				x.RequestBodySize = int(n)
+			}
+		case ResHeaders:
+			// Interim 1xx responses precede the final one
+			if m, _ := e.Meta.(map[string]any); m["interim"] != true {
+				x.Response, x.ResponseEnd = e, e.Time
+			}
+		case ResBody:
+			x.ResponseBody = append(x.ResponseBody, e.Data...)
+			x.ResponseEnd = e.Time
+			if n, ok := metaInt(e.Meta, "body_bytes"); ok {
+				x.ResponseBodySize = int(n)
+			}
+		case Timing:
+			x.Timing = decodeTiming(e)
+		}
+	}
+	return out
+}
+
+// metaStrings reads a string list meta value, which is a []any once events
+// were round-tripped through JSON persistence
+func metaStrings(meta any, key string) []string {
+	m, _ := meta.(map[string]any)
+	switch v := m[key].(type) {
+	case []string:
+		return v
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// FindExchange returns the exchange with the given connection and sequence number
+func FindExchange(events []Event, connID string, seq int64) *Exchange {
+	for _, x := range Exchanges(events) {
+		if x.ConnID == connID && x.Seq == seq {
+			return x
+		}
+	}
+	return nil
+}
+
+// RequestHead decodes the captured request line and headers
+func (x *Exchange) RequestHead() (*RequestHead, bool) {
+	if x.Request == nil {
+		return nil, false
+	}
+	var head RequestHead
+	if json.Unmarshal(x.Request.Data, &head) != nil {
+		return nil, false
+	}
+	return &head, true
+}
+
+// ResponseHead decodes the captured status line and headers
+func (x *Exchange) ResponseHead() (*ResponseHead, bool) {
+	if x.Response == nil {
+		return nil, false
+	}
+	var head ResponseHead
+	if json.Unmarshal(x.Response.Data, &head) != nil {
+		return nil, false
+	}
+	return &head, true
+}
@@ -0,0 +1,461 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	chshare "github.com/NextChapterSoftware/chissl/share"
+)
+
+// HAR 1.2 document types (http://www.softwareishard.com/blog/har-12-spec/).
+
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Connection      string      `json:"connection,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HAROptions filters the exchanges included in a HAR export
+type HAROptions struct {
+	Since   time.Time
+	Until   time.Time
+	ConnIDs []string
+	// Host is used to build absolute URLs when requests carry no Host header
+	Host string
+}
+
+// BuildHAR assembles captured events into HAR entries. Request and response
+// streams are reassembled per connection and parsed as HTTP/1.x exchanges;
+// connections that are not HTTP are skipped.
+func BuildHAR(events []Event, opts HAROptions) *HAR {
+	har := &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "chissl", Version: chshare.BuildVersion},
+		Entries: []HAREntry{},
+	}}
+
+	wanted := map[string]bool{}
+	for _, id := range opts.ConnIDs {
+		wanted[id] = true
+	}
+	byConn := map[string][]Event{}
+	var order []string
+	for _, e := range events {
+		if e.ConnID == "" || (len(wanted) > 0 && !wanted[e.ConnID]) {
+			continue
+		}
+		if _, ok := byConn[e.ConnID]; !ok {
+			order = append(order, e.ConnID)
+		}
+		byConn[e.ConnID] = append(byConn[e.ConnID], e)
+	}
+
+	for _, connID := range order {
+		for _, entry := range harConnectionEntries(connID, byConn[connID], opts.Host) {
+			if !opts.Since.IsZero() && entry.StartedDateTime.Before(opts.Since) {
+				continue
+			}
+			if !opts.Until.IsZero() && !entry.StartedDateTime.Before(opts.Until) {
+				continue
+			}
+			har.Log.Entries = append(har.Log.Entries, entry)
+		}
+	}
+	sort.SliceStable(har.Log.Entries, func(i, j int) bool {
+		return har.Log.Entries[i].StartedDateTime.Before(har.Log.Entries[j].StartedDateTime)
+	})
+	return har
+}
+
+// timedStream is one direction of a connection with the capture time of each chunk
+type timedStream struct {
+	data    []byte
+	offsets []int
+	times   []time.Time
+	// truncated is set when a chunk was cut by the capture size limit
+	truncated bool
+}
+
+func (s *timedStream) add(e Event) {
+	s.offsets = append(s.offsets, len(s.data))
+	s.times = append(s.times, e.Time)
+	s.data = append(s.data, e.Data...)
+	s.truncated = s.truncated || e.Truncated
+}
+
+// timeAt returns the capture time of the chunk containing byte offset off
+func (s *timedStream) timeAt(off int) time.Time {
+	i := sort.Search(len(s.offsets), func(i int) bool { return s.offsets[i] > off }) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s.times) {
+		return time.Time{}
+	}
+	return s.times[i]
+}
+
+// countingReader tracks how many bytes have been consumed from a stream
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// streamParser reads successive HTTP messages from a timed stream
+type streamParser struct {
+	cr *countingReader
+	br *bufio.Reader
+}
+
+func newStreamParser(s *timedStream) *streamParser {
+	cr := &countingReader{r: bytes.NewReader(s.data)}
+	return &streamParser{cr: cr, br: bufio.NewReader(cr)}
+}
+
+// pos is the offset of the next unread byte
+func (p *streamParser) pos() int { return p.cr.n - p.br.Buffered() }
+
+// harConnectionEntries builds the entries of one connection from the parsed
+// exchanges recorded by TapImpl, or by re-parsing the raw streams of captures
+// taken before messages carried sequence numbers.
+func harConnectionEntries(connID string, events []Event, host string) []HAREntry {
+	for _, e := range events {
+		if _, ok := metaInt(e.Meta, "seq"); ok && e.Type == ReqHeaders {
+			return harExchangeEntries(connID, events, host)
+		}
+	}
+	return harRawEntries(connID, events, host)
+}
+
+func harExchangeEntries(connID string, events []Event, host string) []HAREntry {
+	var entries []HAREntry
+	for _, x := range Exchanges(events) {
+		head, ok := x.RequestHead()
+		if !ok {
+			continue
+		}
+		u, err := url.ParseRequestURI(head.Path)
+		if err != nil {
+			u = &url.URL{Host: head.Path}
+		}
+		r := &http.Request{Method: head.Method, URL: u, Proto: head.Proto, Host: head.Host, Header: head.Header}
+		if r.Header == nil {
+			r.Header = http.Header{}
+		}
+		reqBodySize := x.RequestBodySize
+		if reqBodySize < 0 {
+			reqBodySize = len(x.RequestBody)
+		}
+		headerBytes, _ := metaInt(x.Request.Meta, "header_bytes")
+
+		entry := HAREntry{
+			StartedDateTime: x.Request.Time,
+			Connection:      connID,
+			Request:         harRequest(r, x.RequestBody, host, int(headerBytes), reqBodySize),
+			Timings:         HARTimings{Blocked: -1, DNS: -1, Connect: -1},
+		}
+		entry.Timings.Send = harMillis(x.RequestEnd.Sub(x.Request.Time))
+		if x.Truncated {
+			entry.Comment = "capture truncated"
+		}
+
+		rh, ok := x.ResponseHead()
+		if !ok {
+			entry.Response = HARResponse{Cookies: []HARCookie{}, Headers: []HARNameValue{}, HeadersSize: -1, BodySize: -1}
+			entry.Comment = "no response captured"
+			entry.Time = entry.Timings.Send
+			entries = append(entries, entry)
+			continue
+		}
+		res := &http.Response{Status: rh.Status, StatusCode: rh.Code, Proto: rh.Proto, Header: rh.Header}
+		if res.Header == nil {
+			res.Header = http.Header{}
+		}
+		resBodySize := x.ResponseBodySize
+		if resBodySize < 0 {
+			resBodySize = len(x.ResponseBody)
+		}
+		headerBytes, _ = metaInt(x.Response.Meta, "header_bytes")
+		entry.Response = harResponse(res, x.ResponseBody, int(headerBytes), resBodySize)
+		entry.Timings.Wait = harMillis(x.Response.Time.Sub(x.RequestEnd))
+		entry.Timings.Receive = harMillis(x.ResponseEnd.Sub(x.Response.Time))
+		entry.Time = entry.Timings.Send + entry.Timings.Wait + entry.Timings.Receive
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// metaInt reads an integer meta value, which is a float64 once events were
+// round-tripped through JSON persistence
+func metaInt(meta any, key string) (int64, bool) {
+	m, _ := meta.(map[string]any)
+	switch v := m[key].(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// harRawEntries re-parses the concatenated request and response streams of a connection
+func harRawEntries(connID string, events []Event, host string) []HAREntry {
+	var req, res timedStream
+	for _, e := range events {
+		switch e.Type {
+		case ReqBody:
+			req.add(e)
+		case ResBody:
+			res.add(e)
+		}
+	}
+	if len(req.data) == 0 {
+		return nil
+	}
+
+	var entries []HAREntry
+	rp, sp := newStreamParser(&req), newStreamParser(&res)
+	for {
+		start := rp.pos()
+		r, err := http.ReadRequest(rp.br)
+		if err != nil {
+			break
+		}
+		headerEnd := rp.pos()
+		reqBody, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+		end := rp.pos()
+
+		entry := HAREntry{
+			StartedDateTime: req.timeAt(start),
+			Connection:      connID,
+			Request:         harRequest(r, reqBody, host, headerEnd-start, end-headerEnd),
+			Timings:         HARTimings{Blocked: -1, DNS: -1, Connect: -1},
+		}
+		sent := req.timeAt(end - 1)
+		entry.Timings.Send = harMillis(sent.Sub(entry.StartedDateTime))
+
+		resStart := sp.pos()
+		resp, err := http.ReadResponse(sp.br, r)
+		if err != nil {
+			entry.Response = HARResponse{Cookies: []HARCookie{}, Headers: []HARNameValue{}, HeadersSize: -1, BodySize: -1}
+			entry.Comment = "no response captured"
+			entry.Time = entry.Timings.Send
+			entries = append(entries, entry)
+			break
+		}
+		resHeaderEnd := sp.pos()
+		resBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resEnd := sp.pos()
+		entry.Response = harResponse(resp, resBody, resHeaderEnd-resStart, resEnd-resHeaderEnd)
+		if res.truncated || req.truncated {
+			entry.Comment = "capture truncated"
+		}
+
+		first, last := res.timeAt(resStart), res.timeAt(resEnd-1)
+		entry.Timings.Wait = harMillis(first.Sub(sent))
+		entry.Timings.Receive = harMillis(last.Sub(first))
+		entry.Time = entry.Timings.Send + entry.Timings.Wait + entry.Timings.Receive
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func harMillis(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return float64(d.Microseconds()) / 1000
+}
+
+func harRequest(r *http.Request, body []byte, host string, headersSize, bodySize int) HARRequest {
+	u := *r.URL
+	if u.Host == "" {
+		u.Host = r.Host
+		if u.Host == "" {
+			u.Host = host
+		}
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	out := HARRequest{
+		Method:      r.Method,
+		URL:         u.String(),
+		HTTPVersion: r.Proto,
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(r.Header, r.Host),
+		QueryString: []HARNameValue{},
+		HeadersSize: headersSize,
+		BodySize:    bodySize,
+	}
+	for _, c := range r.Cookies() {
+		out.Cookies = append(out.Cookies, HARCookie{Name: c.Name, Value: c.Value})
+	}
+	for name, values := range r.URL.Query() {
+		for _, v := range values {
+			out.QueryString = append(out.QueryString, HARNameValue{Name: name, Value: v})
+		}
+	}
+	sort.Slice(out.QueryString, func(i, j int) bool { return out.QueryString[i].Name < out.QueryString[j].Name })
+	if len(body) > 0 {
+		decoded, _ := decodeContent(r.Header.Get("Content-Encoding"), body)
+		text, enc := harText(decoded)
+		out.PostData = &HARPostData{MimeType: r.Header.Get("Content-Type"), Text: text, Encoding: enc}
+	}
+	return out
+}
+
+func harResponse(res *http.Response, body []byte, headersSize, bodySize int) HARResponse {
+	out := HARResponse{
+		Status:      res.StatusCode,
+		StatusText:  http.StatusText(res.StatusCode),
+		HTTPVersion: res.Proto,
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(res.Header, ""),
+		RedirectURL: res.Header.Get("Location"),
+		HeadersSize: headersSize,
+		BodySize:    bodySize,
+	}
+	if i := strings.IndexByte(res.Status, ' '); i >= 0 {
+		out.StatusText = res.Status[i+1:]
+	}
+	for _, c := range res.Cookies() {
+		out.Cookies = append(out.Cookies, HARCookie{Name: c.Name, Value: c.Value})
+	}
+	mimeType := res.Header.Get("Content-Type")
+	if mimeType == "" && len(body) > 0 {
+		mimeType = http.DetectContentType(body)
+	}
+	decoded, err := decodeContent(res.Header.Get("Content-Encoding"), body)
+	text, enc := harText(decoded)
+	out.Content = HARContent{Size: len(decoded), MimeType: mimeType, Text: text, Encoding: enc}
+	if err != nil {
+		out.Content.Comment = "could not decode " + res.Header.Get("Content-Encoding") + " body: " + err.Error()
+	}
+	return out
+}
+
+// harHeaders flattens headers in a stable order; Go moves Host out of the header map
+func harHeaders(h http.Header, host string) []HARNameValue {
+	out := []HARNameValue{}
+	if host != "" {
+		out = append(out, HARNameValue{Name: "Host", Value: host})
+	}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range h[name] {
+			out = append(out, HARNameValue{Name: name, Value: v})
+		}
+	}
+	return out
+}
+
+// harText returns body as text when it is valid UTF-8, base64 otherwise
+func harText(body []byte) (string, string) {
+	if len(body) == 0 {
+		return "", ""
+	}
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
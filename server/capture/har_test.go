@@ -0,0 +1,114 @@
+package capture
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBuildHAR(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(`{"hello":"world"}`))
+	zw.Close()
+
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(ms int) time.Time { return t0.Add(time.Duration(ms) * time.Millisecond) }
+	res1 := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n", gz.Len())
+	events := []Event{
+		{Time: at(0), ConnID: "c1", Type: ConnOpen},
+		{Time: at(0), ConnID: "c1", Type: ReqBody, Data: []byte("GET /items?id=7 HTTP/1.1\r\nHost: example.test\r\nCookie: a=1\r\n\r\n")},
+		{Time: at(10), ConnID: "c1", Type: ResBody, Data: []byte(res1)},
+		{Time: at(15), ConnID: "c1", Type: ResBody, Data: gz.Bytes()},
+		// Second request on the same keep-alive connection, split across chunks
+		{Time: at(20), ConnID: "c1", Type: ReqBody, Data: []byte("POST /items HTTP/1.1\r\nHost: example.test\r\nTransfer-Encoding: chunked\r\n")},
+		{Time: at(22), ConnID: "c1", Type: ReqBody, Data: []byte("Content-Type: text/plain\r\n\r\n3\r\nabc\r\n0\r\n\r\n")},
+		{Time: at(30), ConnID: "c1", Type: ResBody, Data: []byte("HTTP/1.1 201 Created\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n")},
+		{Time: at(40), ConnID: "c2", Type: ReqBody, Data: []byte{0x16, 0x03, 0x01, 0x00}},
+	}
+
+	har := BuildHAR(events, HAROptions{})
+	if har.Log.Version != "1.2" {
+		t.Fatalf("version = %q", har.Log.Version)
+	}
+	if len(har.Log.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(har.Log.Entries))
+	}
+
+	get := har.Log.Entries[0]
+	if get.Request.Method != "GET" || get.Request.URL != "http://example.test/items?id=7" {
+		t.Fatalf("unexpected request: %s %s", get.Request.Method, get.Request.URL)
+	}
+	if len(get.Request.QueryString) != 1 || len(get.Request.Cookies) != 1 {
+		t.Fatalf("expected query string and cookie, got %+v %+v", get.Request.QueryString, get.Request.Cookies)
+	}
+	if get.Response.Status != 200 || get.Response.Content.Text != `{"hello":"world"}` {
+		t.Fatalf("expected decoded gzip body, got %d %q", get.Response.Status, get.Response.Content.Text)
+	}
+	if get.Response.BodySize != gz.Len() || get.Response.Content.Size != 17 {
+		t.Fatalf("unexpected sizes: body=%d content=%d", get.Response.BodySize, get.Response.Content.Size)
+	}
+	if get.Timings.Wait != 10 || get.Timings.Receive != 5 || get.Time != 15 {
+		t.Fatalf("unexpected timings: %+v time=%v", get.Timings, get.Time)
+	}
+
+	post := har.Log.Entries[1]
+	if post.Request.PostData == nil || post.Request.PostData.Text != "abc" || post.Request.PostData.MimeType != "text/plain" {
+		t.Fatalf("expected de-chunked post data, got %+v", post.Request.PostData)
+	}
+	if post.Response.Status != 201 || post.Response.Content.Text != "ok" {
+		t.Fatalf("unexpected second response: %+v", post.Response)
+	}
+	if !post.StartedDateTime.Equal(at(20)) || post.Timings.Send != 2 {
+		t.Fatalf("unexpected start %v or send %v", post.StartedDateTime, post.Timings.Send)
+	}
+
+	// Filters
+	if n := len(BuildHAR(events, HAROptions{Since: at(5)}).Log.Entries); n != 1 {
+		t.Fatalf("since filter: expected 1 entry, got %d", n)
+	}
+	if n := len(BuildHAR(events, HAROptions{ConnIDs: []string{"c2"}}).Log.Entries); n != 0 {
+		t.Fatalf("conn filter: expected 0 entries, got %d", n)
+	}
+}
+
+func TestBuildHARFromTap(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+	tap := &TapImpl{svc: svc, meta: Meta{ConnID: "c1"}, tunnelID: "t1", maxEvents: 500}
+	tap.OnOpen()
+	// Pipelined requests answered over a keep-alive connection
+	tap.SrcWriter().Write([]byte("GET /a HTTP/1.1\r\nHost: example.test\r\n\r\nPUT /b HTTP/1.1\r\nHost: example.test\r\nContent-Length: 5\r\n\r\nhel"))
+	tap.SrcWriter().Write([]byte("lo"))
+	tap.DstWriter().Write([]byte("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\naHTTP/1.1 404 Not Found\r\nTransfer-Encoding: chunked\r\n\r\n"))
+	tap.DstWriter().Write([]byte("4\r\nnope\r\n0\r\n\r\n"))
+	tap.OnClose(0, 0)
+
+	// Persisted events come back with JSON-decoded meta
+	raw, _ := json.Marshal(svc.GetRecent("t1", 500))
+	var events []Event
+	if err := json.Unmarshal(raw, &events); err != nil {
+		t.Fatalf("unmarshal events: %v", err)
+	}
+	last := events[len(events)-4]
+	if decoded, _ := last.Meta.(map[string]any)["decoded"].(map[string]any); last.Type != ResBody || decoded["text"] != "nope" {
+		t.Fatalf("expected decoded view on the final body event, got %+v", last)
+	}
+	entries := BuildHAR(events, HAROptions{}).Log.Entries
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Request.URL != "http://example.test/a" || e.Response.Status != 200 || e.Response.Content.Text != "a" {
+		t.Fatalf("unexpected first exchange: %s %d %q", e.Request.URL, e.Response.Status, e.Response.Content.Text)
+	}
+	e := entries[1]
+	if e.Request.Method != "PUT" || e.Request.PostData == nil || e.Request.PostData.Text != "hello" || e.Request.BodySize != 5 {
+		t.Fatalf("unexpected second request: %+v", e.Request)
+	}
+	if e.Response.Status != 404 || e.Response.Content.Text != "nope" || e.Response.BodySize != 14 {
+		t.Fatalf("unexpected second response: %+v", e.Response)
+	}
+}
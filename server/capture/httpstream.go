@@ -0,0 +1,383 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Incremental HTTP/1.x parsing for one direction of a captured connection.
+//
+// The parser is fed the byte stream as it is copied and reports each message
+// as a header block followed by de-chunked body pieces. Message framing
+// follows RFC 9112: chunked transfer coding, Content-Length, or (responses
+// only) read-until-close. Streams that do not look like HTTP, and the bytes
+// following a protocol upgrade, are reported as opaque data.
+
+// maxHeaderBytes bounds the header block; larger blocks switch the stream to opaque
+const maxHeaderBytes = 64 * 1024
+
+type parseState int
+
+const (
+	stateStart parseState = iota
+	stateHeaders
+	stateBodyLength
+	stateChunkSize
+	stateChunkData
+	stateChunkDataEnd
+	stateChunkTrailer
+	stateBodyUntilClose
+	stateOpaque
+)
+
+// httpMessage is a parsed header block
+type httpMessage struct {
+	Request *http.Request
+	// Response is set for status lines; its Body is never read
+	Response *http.Response
+	// Offset is the stream offset of the first byte of the message
+	Offset int64
+	// HeaderBytes is the size of the start line and header block on the wire
+	HeaderBytes int
+}
+
+// httpStreamHandler receives parse results for one direction
+type httpStreamHandler interface {
+	// onHeaders is called once a complete header block is parsed. For
+	// responses it returns the method of the request being answered, used
+	// to decide whether a body follows.
+	onHeaders(m *httpMessage) (requestMethod string)
+	// onBody is called with decoded body bytes; end is set on the final call
+	// of a message, with wireBytes holding the framed body size.
+	onBody(data []byte, end bool, wireBytes int64)
+	// onOpaque is called with bytes that are not part of an HTTP message
+	onOpaque(data []byte)
+	// onEnd is called once a message, including its body, is complete
+	onEnd()
+}
+
+type httpStream struct {
+	h     httpStreamHandler
+	state parseState
+	buf   []byte
+	// offset is the stream offset of buf[0]
+	offset int64
+
+	// current message
+	isResponse bool
+	remaining  int64
+	wireBody   int64
+	// bodySent is set once body bytes of the current message were reported
+	bodySent bool
+	// upgrade switches to opaque once the current response completes
+	upgrade bool
+}
+
+func newHTTPStream(h httpStreamHandler) *httpStream {
+	return &httpStream{h: h}
+}
+
+// write feeds p to the parser and dispatches complete units to the handler.
+// Body bytes found in p are reported in a single onBody call per message.
+func (s *httpStream) write(p []byte) {
+	if s.state == stateOpaque && len(s.buf) == 0 {
+		s.offset += int64(len(p))
+		s.h.onOpaque(p)
+		return
+	}
+	s.buf = append(s.buf, p...)
+	var body []byte
+	flush := func(end bool) {
+		if len(body) > 0 || (end && s.bodySent) {
+			s.h.onBody(body, end, s.wireBody)
+			s.bodySent = true
+			body = nil
+		}
+	}
+	for {
+		switch s.state {
+		case stateStart:
+			if len(s.buf) == 0 {
+				return
+			}
+			// Skip blank lines between messages (RFC 9112 section 2.2)
+			trimmed := bytes.TrimLeft(s.buf, "\r\n")
+			s.consume(len(s.buf) - len(trimmed))
+			if len(s.buf) == 0 {
+				return
+			}
+			switch {
+			case startsHTTP(s.buf):
+				s.state = stateHeaders
+			case couldBeHTTPPrefix(s.buf):
+				return
+			default:
+				s.state = stateOpaque
+			}
+
+		case stateHeaders:
+			end := headerEnd(s.buf)
+			if end < 0 {
+				if len(s.buf) > maxHeaderBytes {
+					s.state = stateOpaque
+					continue
+				}
+				return
+			}
+			if !s.parseHeaders(end) {
+				s.state = stateOpaque
+				continue
+			}
+
+		case stateBodyLength:
+			if s.remaining == 0 {
+				flush(true)
+				s.finishMessage()
+				continue
+			}
+			if len(s.buf) == 0 {
+				flush(false)
+				return
+			}
+			n := int64(len(s.buf))
+			if n > s.remaining {
+				n = s.remaining
+			}
+			body = append(body, s.buf[:n]...)
+			s.wireBody += n
+			s.remaining -= n
+			s.consume(int(n))
+
+		case stateChunkSize:
+			i := bytes.IndexByte(s.buf, '\n')
+			if i < 0 {
+				if len(s.buf) > 1024 {
+					s.state = stateOpaque
+					continue
+				}
+				flush(false)
+				return
+			}
+			line := strings.TrimSpace(string(s.buf[:i]))
+			if j := strings.IndexByte(line, ';'); j >= 0 {
+				line = strings.TrimSpace(line[:j])
+			}
+			size, err := strconv.ParseInt(line, 16, 64)
+			if err != nil || size < 0 {
+				flush(false)
+				s.state = stateOpaque
+				continue
+			}
+			s.wireBody += int64(i + 1)
+			s.consume(i + 1)
+			if size == 0 {
+				s.state = stateChunkTrailer
+			} else {
+				s.remaining = size
+				s.state = stateChunkData
+			}
+
+		case stateChunkData:
+			if len(s.buf) == 0 {
+				flush(false)
+				return
+			}
+			n := int64(len(s.buf))
+			if n > s.remaining {
+				n = s.remaining
+			}
+			body = append(body, s.buf[:n]...)
+			s.wireBody += n
+			s.remaining -= n
+			s.consume(int(n))
+			if s.remaining == 0 {
+				s.state = stateChunkDataEnd
+			}
+
+		case stateChunkDataEnd:
+			i := bytes.IndexByte(s.buf, '\n')
+			if i < 0 {
+				flush(false)
+				return
+			}
+			s.wireBody += int64(i + 1)
+			s.consume(i + 1)
+			s.state = stateChunkSize
+
+		case stateChunkTrailer:
+			i := bytes.IndexByte(s.buf, '\n')
+			if i < 0 {
+				flush(false)
+				return
+			}
+			line := bytes.TrimSpace(s.buf[:i])
+			s.wireBody += int64(i + 1)
+			s.consume(i + 1)
+			if len(line) == 0 {
+				flush(true)
+				s.finishMessage()
+			}
+
+		case stateBodyUntilClose:
+			body = append(body, s.buf...)
+			s.wireBody += int64(len(s.buf))
+			s.consume(len(s.buf))
+			flush(false)
+			return
+
+		case stateOpaque:
+			if len(s.buf) > 0 {
+				data := s.buf
+				s.offset += int64(len(data))
+				s.buf = nil
+				s.h.onOpaque(data)
+			}
+			return
+		}
+	}
+}
+
+// close finishes a read-until-close body when the connection ends
+func (s *httpStream) close() {
+	if s.state == stateBodyUntilClose {
+		if s.bodySent {
+			s.h.onBody(nil, true, s.wireBody)
+		}
+		s.finishMessage()
+	}
+}
+
+func (s *httpStream) consume(n int) {
+	s.buf = s.buf[n:]
+	s.offset += int64(n)
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+}
+
+// parseHeaders parses the header block ending at end and sets up body framing
+func (s *httpStream) parseHeaders(end int) bool {
+	block := s.buf[:end]
+	m := &httpMessage{Offset: s.offset, HeaderBytes: end}
+	br := bufio.NewReader(bytes.NewReader(block))
+	var header http.Header
+	var te []string
+	if bytes.HasPrefix(block, []byte("HTTP/")) {
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			return false
+		}
+		m.Response = res
+		header, te = res.Header, res.TransferEncoding
+		s.isResponse = true
+	} else {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return false
+		}
+		m.Request = req
+		header, te = req.Header, req.TransferEncoding
+		s.isResponse = false
+	}
+	// net/http moves Transfer-Encoding out of the header map; keep it visible
+	if len(te) > 0 {
+		header["Transfer-Encoding"] = te
+	}
+	s.consume(end)
+
+	reqMethod := s.h.onHeaders(m)
+	s.wireBody = 0
+	s.bodySent = false
+	s.upgrade = false
+
+	chunked := false
+	for _, v := range te {
+		if strings.EqualFold(strings.TrimSpace(v), "chunked") {
+			chunked = true
+		}
+	}
+	length := int64(-1)
+	if cl := header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(strings.TrimSpace(cl), 10, 64); err == nil && n >= 0 {
+			length = n
+		}
+	}
+
+	if s.isResponse {
+		code := m.Response.StatusCode
+		switch {
+		case code == http.StatusSwitchingProtocols:
+			s.upgrade = true
+			length, chunked = 0, false
+		case reqMethod == http.MethodConnect && code >= 200 && code < 300:
+			s.upgrade = true
+			length, chunked = 0, false
+		case code >= 100 && code < 200, code == http.StatusNoContent, code == http.StatusNotModified, reqMethod == http.MethodHead:
+			length, chunked = 0, false
+		}
+	} else if length < 0 && !chunked {
+		// Requests without framing have no body; bytes that follow an
+		// upgrade or CONNECT request fail detection and become opaque
+		length = 0
+	}
+
+	switch {
+	case chunked:
+		s.state = stateChunkSize
+	case length >= 0:
+		s.remaining = length
+		s.state = stateBodyLength
+	default:
+		s.state = stateBodyUntilClose
+	}
+	return true
+}
+
+func (s *httpStream) finishMessage() {
+	s.remaining = 0
+	s.h.onEnd()
+	if s.upgrade && s.isResponse {
+		s.state = stateOpaque
+		return
+	}
+	s.state = stateStart
+}
+
+// headerEnd returns the length of the header block including the blank line, or -1
+func headerEnd(b []byte) int {
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+		if j := bytes.Index(b, []byte("\n\n")); j >= 0 && j < i {
+			return j + 2
+		}
+		return i + 4
+	}
+	if j := bytes.Index(b, []byte("\n\n")); j >= 0 {
+		return j + 2
+	}
+	return -1
+}
+
+var httpStartTokens = []string{"GET ", "POST ", "PUT ", "PATCH ", "DELETE ", "HEAD ", "OPTIONS ", "CONNECT ", "TRACE ", "HTTP/"}
+
+// startsHTTP reports whether b begins with a request or status line token
+func startsHTTP(b []byte) bool {
+	for _, m := range httpStartTokens {
+		if bytes.HasPrefix(b, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// couldBeHTTPPrefix reports whether a short buffer may still grow into a start line
+func couldBeHTTPPrefix(b []byte) bool {
+	for _, m := range httpStartTokens {
+		if len(b) < len(m) && strings.HasPrefix(m, string(b)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package capture
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// streamRecorder records parser callbacks as readable strings
+type streamRecorder struct {
+	out    []string
+	method string
+}
+
+func (r *streamRecorder) onHeaders(m *httpMessage) string {
+	if m.Request != nil {
+		r.out = append(r.out, fmt.Sprintf("req %s %s @%d+%d", m.Request.Method, m.Request.URL, m.Offset, m.HeaderBytes))
+		return ""
+	}
+	r.out = append(r.out, fmt.Sprintf("res %d @%d+%d", m.Response.StatusCode, m.Offset, m.HeaderBytes))
+	return r.method
+}
+
+func (r *streamRecorder) onBody(data []byte, end bool, wireBytes int64) {
+	s := fmt.Sprintf("body %q", data)
+	if end {
+		s += fmt.Sprintf(" end %d", wireBytes)
+	}
+	r.out = append(r.out, s)
+}
+
+func (r *streamRecorder) onOpaque(data []byte) {
+	r.out = append(r.out, fmt.Sprintf("opaque %q", data))
+}
+
+func (r *streamRecorder) onEnd() {}
+
+func TestHTTPStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		writes []string
+		close  bool
+		want   []string
+	}{
+		{
+			name: "keep-alive with pipelined requests",
+			writes: []string{
+				"GET /a HTTP/1.1\r\nHost: x\r\n\r\nPOST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabc",
+				"GET /c HTTP/1.1\r\nHost: x\r\n\r\n",
+			},
+			want: []string{
+				"req GET /a @0+28",
+				"req POST /b @28+48",
+				`body "abc" end 3`,
+				"req GET /c @79+28",
+			},
+		},
+		{
+			name: "headers and chunks split across writes",
+			writes: []string{
+				"PO", "ST /u HTTP/1.1\r\nTransfer-Enc", "oding: chunked\r\n\r\n4\r\nab",
+				"cd\r\n3;ext=1\r\nefg\r\n0\r\nTrailer: v\r\n\r\n",
+			},
+			want: []string{
+				"req POST /u @0+48",
+				`body "ab"`,
+				`body "cdefg" end 40`,
+			},
+		},
+		{
+			name:   "HEAD and 204 responses have no body",
+			method: "HEAD",
+			writes: []string{
+				"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n",
+				"HTTP/1.1 204 No Content\r\n\r\n",
+			},
+			want: []string{"res 200 @0+39", "res 204 @39+27"},
+		},
+		{
+			name:   "body until close",
+			writes: []string{"HTTP/1.0 200 OK\r\n\r\nhello ", "world"},
+			close:  true,
+			want:   []string{"res 200 @0+19", `body "hello "`, `body "world"`, `body "" end 11`},
+		},
+		{
+			name:   "upgrade switches to opaque",
+			writes: []string{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n\x81\x02hi", "\x88\x00"},
+			want:   []string{"res 101 @0+56", `opaque "\x81\x02hi"`, `opaque "\x88\x00"`},
+		},
+		{
+			name:   "non-HTTP stream",
+			writes: []string{"\x16\x03\x01\x00", "GET / HTTP/1.1\r\n\r\n"},
+			want:   []string{`opaque "\x16\x03\x01\x00"`, `opaque "GET / HTTP/1.1\r\n\r\n"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &streamRecorder{method: tt.method}
+			s := newHTTPStream(rec)
+			for _, w := range tt.writes {
+				s.write([]byte(w))
+			}
+			if tt.close {
+				s.close()
+			}
+			if got, want := strings.Join(rec.out, "\n"), strings.Join(tt.want, "\n"); got != want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,466 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	chshare "github.com/NextChapterSoftware/chissl/share"
+)
+
+// pcapng export of captured connections.
+//
+// Every captured connection becomes a synthetic TCP flow between the public
+// peer address and the tunnel target, with a handshake at conn_open, the
+// captured bytes of both directions as data segments and a teardown at
+// conn_close, so Wireshark can dissect any protocol. Raw chunks are written
+// as captured; HTTP messages and websocket messages are re-serialized from
+// their parsed events. Addresses that were not captured are replaced with
+// documentation addresses (192.0.2.0/24).
+
+// PCAPOptions selects the connections to export
+type PCAPOptions struct {
+	Since   time.Time
+	Until   time.Time
+	ConnIDs []string
+	// Name labels the capture interface, e.g. the entity ID
+	Name string
+}
+
+const (
+	pcapLinkTypeRaw = 101
+	pcapMSS         = 1400
+
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// pcapPacket is a synthesized IP packet
+type pcapPacket struct {
+	time    time.Time
+	data    []byte
+	comment string
+}
+
+// WritePCAPNG writes the selected connections as a pcapng capture
+func WritePCAPNG(w io.Writer, events []Event, opts PCAPOptions) error {
+	wanted := map[string]bool{}
+	for _, id := range opts.ConnIDs {
+		wanted[id] = true
+	}
+	byConn := map[string][]Event{}
+	var order []string
+	for _, e := range events {
+		if e.ConnID == "" || (len(wanted) > 0 && !wanted[e.ConnID]) {
+			continue
+		}
+		if _, ok := byConn[e.ConnID]; !ok {
+			order = append(order, e.ConnID)
+		}
+		byConn[e.ConnID] = append(byConn[e.ConnID], e)
+	}
+
+	var packets []pcapPacket
+	for _, connID := range order {
+		evs := byConn[connID]
+		start := evs[0].Time
+		if (!opts.Since.IsZero() && start.Before(opts.Since)) || (!opts.Until.IsZero() && !start.Before(opts.Until)) {
+			continue
+		}
+		packets = append(packets, newTCPFlow(connID, evs).packets(evs)...)
+	}
+	sort.SliceStable(packets, func(i, j int) bool { return packets[i].time.Before(packets[j].time) })
+
+	name := opts.Name
+	if name == "" {
+		name = "chissl"
+	}
+	if err := writePCAPNGHeader(w, name); err != nil {
+		return err
+	}
+	var last time.Time
+	for _, p := range packets {
+		// Timestamps must not go backwards
+		if p.time.Before(last) {
+			p.time = last
+		}
+		last = p.time
+		if err := writePCAPNGPacket(w, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tcpFlow synthesizes the TCP segments of one connection
+type tcpFlow struct {
+	connID string
+	// endpoints indexed by direction: 0 is the client, 1 the target
+	addr [2]netip.AddrPort
+	seq  [2]uint32
+	ipID uint16
+	out  []pcapPacket
+	// chunked is set while a direction's current HTTP message is chunked
+	chunked [2]bool
+	opened  bool
+}
+
+func newTCPFlow(connID string, evs []Event) *tcpFlow {
+	f := &tcpFlow{connID: connID}
+	h := fnv.New32a()
+	h.Write([]byte(connID))
+	sum := h.Sum32()
+	f.addr[0] = netip.AddrPortFrom(netip.AddrFrom4([4]byte{192, 0, 2, 1}), uint16(49152+sum%16384))
+	f.addr[1] = netip.AddrPortFrom(netip.AddrFrom4([4]byte{192, 0, 2, 2}), 80)
+	for _, e := range evs {
+		if e.Type != ConnOpen {
+			continue
+		}
+		m, _ := e.Meta.(map[string]any)
+		if peer, _ := m["peer"].(string); peer != "" {
+			if ap, ok := resolveEndpoint(peer); ok {
+				f.addr[0] = ap
+			}
+		}
+		if target, _ := m["target"].(string); target != "" {
+			if ap, ok := resolveEndpoint(target); ok {
+				f.addr[1] = ap
+			}
+		}
+		break
+	}
+	// Both endpoints need the same address family
+	if f.addr[0].Addr().Is4() != f.addr[1].Addr().Is4() {
+		for i, ap := range f.addr {
+			if ap.Addr().Is4() {
+				f.addr[i] = netip.AddrPortFrom(netip.AddrFrom16(ap.Addr().As16()), ap.Port())
+			}
+		}
+	}
+	f.seq = [2]uint32{sum, sum*2654435761 + 1}
+	return f
+}
+
+// resolveEndpoint parses host:port; names map to loopback or a stable address in 198.18.0.0/15
+func resolveEndpoint(hostport string) (netip.AddrPort, bool) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	var p uint16
+	if _, err := fmt.Sscanf(port, "%d", &p); err != nil {
+		return netip.AddrPort{}, false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return netip.AddrPortFrom(addr.Unmap().WithZone(""), p), true
+	}
+	if host == "" || strings.EqualFold(host, "localhost") {
+		return netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), p), true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(host)))
+	sum := h.Sum32()
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte{198, 18 + byte(sum>>16&1), byte(sum >> 8), byte(sum)}), p), true
+}
+
+func (f *tcpFlow) packets(evs []Event) []pcapPacket {
+	for _, e := range evs {
+		switch e.Type {
+		case ConnOpen:
+			f.open(e.Time)
+		case ConnClose:
+			f.open(e.Time)
+			f.close(e.Time)
+		default:
+			if dir, data := f.wireBytes(e); len(data) > 0 {
+				f.open(e.Time)
+				f.send(e.Time, dir, data)
+			}
+		}
+	}
+	return f.out
+}
+
+// open writes the handshake once
+func (f *tcpFlow) open(t time.Time) {
+	if f.opened {
+		return
+	}
+	f.opened = true
+	f.segment(t, 0, tcpSYN, nil, fmt.Sprintf("conn %s", f.connID))
+	f.seq[0]++
+	f.segment(t, 1, tcpSYN|tcpACK, nil, "")
+	f.seq[1]++
+	f.segment(t, 0, tcpACK, nil, "")
+}
+
+func (f *tcpFlow) close(t time.Time) {
+	f.segment(t, 0, tcpFIN|tcpACK, nil, "")
+	f.seq[0]++
+	f.segment(t, 1, tcpFIN|tcpACK, nil, "")
+	f.seq[1]++
+	f.segment(t, 0, tcpACK, nil, "")
+}
+
+func (f *tcpFlow) send(t time.Time, dir int, data []byte) {
+	for len(data) > 0 {
+		n := min(len(data), pcapMSS)
+		f.segment(t, dir, tcpPSH|tcpACK, data[:n], "")
+		f.seq[dir] += uint32(n)
+		data = data[n:]
+	}
+}
+
+// wireBytes returns the direction (0 client to target) and bytes an event stands for
+func (f *tcpFlow) wireBytes(e Event) (int, []byte) {
+	m, _ := e.Meta.(map[string]any)
+	switch e.Type {
+	case ReqHeaders:
+		var head RequestHead
+		if json.Unmarshal(e.Data, &head) != nil {
+			return 0, nil
+		}
+		f.chunked[0] = isChunked(head.Header)
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "%s %s %s\r\n", head.Method, head.Path, head.Proto)
+		if head.Host != "" && head.Header.Get("Host") == "" {
+			fmt.Fprintf(&b, "Host: %s\r\n", head.Host)
+		}
+		writeHeaderBlock(&b, head.Header)
+		return 0, b.Bytes()
+	case ResHeaders:
+		var head ResponseHead
+		if json.Unmarshal(e.Data, &head) != nil {
+			return 1, nil
+		}
+		f.chunked[1] = isChunked(head.Header)
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "%s %s\r\n", head.Proto, head.Status)
+		writeHeaderBlock(&b, head.Header)
+		return 1, b.Bytes()
+	case ReqBody, ResBody:
+		dir := 0
+		if e.Type == ResBody {
+			dir = 1
+		}
+		if raw, _ := m["raw"].(bool); raw || !f.chunked[dir] {
+			return dir, e.Data
+		}
+		var b bytes.Buffer
+		if len(e.Data) > 0 {
+			fmt.Fprintf(&b, "%x\r\n%s\r\n", len(e.Data), e.Data)
+		}
+		if end, _ := m["end"].(bool); end {
+			b.WriteString("0\r\n\r\n")
+			f.chunked[dir] = false
+		}
+		return dir, b.Bytes()
+	case WSMessage:
+		dir := 1
+		if d, _ := m["direction"].(string); d == "client_to_server" {
+			dir = 0
+		}
+		return dir, wsFrameBytes(wsMetaOpcode(m["opcode"]), e.Data, dir == 0)
+	}
+	return 0, nil
+}
+
+func isChunked(h http.Header) bool {
+	for _, v := range h.Values("Transfer-Encoding") {
+		if strings.Contains(strings.ToLower(v), "chunked") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeHeaderBlock writes header fields in name order and the terminating blank line
+func writeHeaderBlock(b *bytes.Buffer, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range h[name] {
+			fmt.Fprintf(b, "%s: %s\r\n", name, v)
+		}
+	}
+	b.WriteString("\r\n")
+}
+
+func wsMetaOpcode(v any) byte {
+	switch n := v.(type) {
+	case byte:
+		return n
+	case int:
+		return byte(n)
+	case float64:
+		return byte(n)
+	}
+	return wsOpBinary
+}
+
+// wsFrameBytes encodes a message as one unfragmented frame; client frames
+// carry a zero mask key so the payload stays readable
+func wsFrameBytes(opcode byte, payload []byte, masked bool) []byte {
+	out := []byte{0x80 | opcode}
+	var mask byte
+	if masked {
+		mask = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		out = append(out, mask|byte(n))
+	case n <= 0xFFFF:
+		out = append(out, mask|126)
+		out = binary.BigEndian.AppendUint16(out, uint16(n))
+	default:
+		out = append(out, mask|127)
+		out = binary.BigEndian.AppendUint64(out, uint64(n))
+	}
+	if masked {
+		out = append(out, 0, 0, 0, 0)
+	}
+	return append(out, payload...)
+}
+
+// segment appends a TCP segment from dir to the other endpoint
+func (f *tcpFlow) segment(t time.Time, dir int, flags byte, payload []byte, comment string) {
+	src, dst := f.addr[dir], f.addr[1-dir]
+	var ack uint32
+	if flags&tcpACK != 0 {
+		ack = f.seq[1-dir]
+	}
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], f.seq[dir])
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	tcp = append(tcp, payload...)
+
+	var ip []byte
+	var pseudo []byte
+	if src.Addr().Is4() {
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		f.ipID++
+		binary.BigEndian.PutUint16(ip[4:], f.ipID)
+		binary.BigEndian.PutUint16(ip[6:], 0x4000)
+		ip[8] = 64
+		ip[9] = 6
+		s4, d4 := src.Addr().As4(), dst.Addr().As4()
+		copy(ip[12:], s4[:])
+		copy(ip[16:], d4[:])
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+		pseudo = append(append(append([]byte{}, s4[:]...), d4[:]...), 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+	} else {
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		ip[7] = 64
+		s16, d16 := src.Addr().As16(), dst.Addr().As16()
+		copy(ip[8:], s16[:])
+		copy(ip[24:], d16[:])
+		pseudo = append(append(append([]byte{}, s16[:]...), d16[:]...), 0, 0, byte(len(tcp)>>8), byte(len(tcp)), 0, 0, 0, 6)
+	}
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, sumWords(pseudo)))
+	f.out = append(f.out, pcapPacket{time: t, data: append(ip, tcp...), comment: comment})
+}
+
+func sumWords(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// checksum is the internet checksum of b added to an initial sum
+func checksum(b []byte, initial uint32) uint16 {
+	sum := initial + sumWords(b)
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// pcapng blocks are written little-endian
+
+func writePCAPNGHeader(w io.Writer, name string) error {
+	var opts bytes.Buffer
+	pcapngOption(&opts, 4, []byte("chissl "+chshare.BuildVersion)) // shb_userappl
+	pcapngOption(&opts, 0, nil)
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], 0x1A2B3C4D)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0)) // section length unknown
+	if err := writePCAPNGBlock(w, 0x0A0D0D0A, append(shb, opts.Bytes()...)); err != nil {
+		return err
+	}
+
+	opts.Reset()
+	pcapngOption(&opts, 2, []byte(name)) // if_name
+	pcapngOption(&opts, 9, []byte{6})    // if_tsresol: microseconds
+	pcapngOption(&opts, 0, nil)
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], pcapLinkTypeRaw)
+	return writePCAPNGBlock(w, 1, append(idb, opts.Bytes()...))
+}
+
+func writePCAPNGPacket(w io.Writer, p pcapPacket) error {
+	ts := uint64(p.time.UnixMicro())
+	body := make([]byte, 20, 20+len(p.data)+8)
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(p.data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(p.data)))
+	body = append(body, p.data...)
+	body = append(body, make([]byte, pad4(len(p.data)))...)
+	if p.comment != "" {
+		var opts bytes.Buffer
+		pcapngOption(&opts, 1, []byte(p.comment)) // opt_comment
+		pcapngOption(&opts, 0, nil)
+		body = append(body, opts.Bytes()...)
+	}
+	return writePCAPNGBlock(w, 6, body)
+}
+
+func writePCAPNGBlock(w io.Writer, typ uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	b := make([]byte, 0, total)
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	_, err := w.Write(b)
+	return err
+}
+
+func pcapngOption(b *bytes.Buffer, code uint16, value []byte) {
+	binary.Write(b, binary.LittleEndian, code)
+	binary.Write(b, binary.LittleEndian, uint16(len(value)))
+	b.Write(value)
+	b.Write(make([]byte, pad4(len(value))))
+}
+
+func pad4(n int) int { return (4 - n%4) % 4 }
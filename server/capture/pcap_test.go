@@ -0,0 +1,161 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+// pcapSegment is a TCP segment read back from a pcapng capture
+type pcapSegment struct {
+	src, dst netip.AddrPort
+	seq, ack uint32
+	flags    byte
+	payload  []byte
+}
+
+// readPCAPNG parses the blocks written by WritePCAPNG, checking lengths and checksums
+func readPCAPNG(t *testing.T, b []byte) (linkType uint16, segs []pcapSegment, comments []string) {
+	t.Helper()
+	var lastTS uint64
+	for len(b) > 0 {
+		typ := binary.LittleEndian.Uint32(b)
+		total := binary.LittleEndian.Uint32(b[4:])
+		if total%4 != 0 || int(total) > len(b) || binary.LittleEndian.Uint32(b[total-4:]) != total {
+			t.Fatalf("malformed block of type %#x", typ)
+		}
+		body := b[8 : total-4]
+		b = b[total:]
+		switch typ {
+		case 0x0A0D0D0A:
+			if binary.LittleEndian.Uint32(body) != 0x1A2B3C4D {
+				t.Fatalf("bad byte-order magic")
+			}
+		case 1:
+			linkType = binary.LittleEndian.Uint16(body)
+		case 6:
+			ts := uint64(binary.LittleEndian.Uint32(body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:]))
+			if ts < lastTS {
+				t.Fatalf("timestamps went backwards")
+			}
+			lastTS = ts
+			n := binary.LittleEndian.Uint32(body[12:])
+			pkt := body[20 : 20+n]
+			if opts := body[20+n+uint32(pad4(int(n))):]; len(opts) > 0 {
+				l := binary.LittleEndian.Uint16(opts[2:])
+				comments = append(comments, string(opts[4:4+l]))
+			}
+			var seg pcapSegment
+			var pseudo, tcp []byte
+			switch pkt[0] >> 4 {
+			case 4:
+				if checksum(pkt[:20], 0) != 0 {
+					t.Fatalf("bad IPv4 header checksum")
+				}
+				src, dst := [4]byte(pkt[12:16]), [4]byte(pkt[16:20])
+				tcp = pkt[20:]
+				seg.src, seg.dst = netip.AddrPortFrom(netip.AddrFrom4(src), 0), netip.AddrPortFrom(netip.AddrFrom4(dst), 0)
+				pseudo = append(append(append([]byte{}, src[:]...), dst[:]...), 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+			case 6:
+				src, dst := [16]byte(pkt[8:24]), [16]byte(pkt[24:40])
+				tcp = pkt[40:]
+				seg.src, seg.dst = netip.AddrPortFrom(netip.AddrFrom16(src), 0), netip.AddrPortFrom(netip.AddrFrom16(dst), 0)
+				pseudo = append(append(append([]byte{}, src[:]...), dst[:]...), 0, 0, byte(len(tcp)>>8), byte(len(tcp)), 0, 0, 0, 6)
+			}
+			if checksum(tcp, sumWords(pseudo)) != 0 {
+				t.Fatalf("bad TCP checksum")
+			}
+			seg.src = netip.AddrPortFrom(seg.src.Addr(), binary.BigEndian.Uint16(tcp))
+			seg.dst = netip.AddrPortFrom(seg.dst.Addr(), binary.BigEndian.Uint16(tcp[2:]))
+			seg.seq, seg.ack = binary.BigEndian.Uint32(tcp[4:]), binary.BigEndian.Uint32(tcp[8:])
+			seg.flags = tcp[13]
+			seg.payload = tcp[20:]
+			segs = append(segs, seg)
+		}
+	}
+	return linkType, segs, comments
+}
+
+func TestWritePCAPNG(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+
+	// A non-HTTP protocol through a tunnel
+	db := &TapImpl{svc: svc, meta: Meta{ConnID: "1", Peer: "203.0.113.9:51000"}, tunnelID: "t", maxEvents: 500}
+	db.meta.Remote.RemoteHost, db.meta.Remote.RemotePort = "localhost", "6379"
+	db.OnOpen()
+	cmd := bytes.Repeat([]byte("*1\r\n$4\r\nPING\r\n"), 200)
+	db.SrcWriter().Write(cmd)
+	db.DstWriter().Write([]byte("+PONG\r\n"))
+	db.OnClose(int64(len(cmd)), 7)
+
+	// HTTP is re-serialized from the parsed events
+	web := &TapImpl{svc: svc, meta: Meta{ConnID: "2", Peer: "[2001:db8::1]:40000"}, tunnelID: "t", maxEvents: 500}
+	web.meta.Remote.RemoteHost, web.meta.Remote.RemotePort = "127.0.0.1", "8080"
+	web.OnOpen()
+	web.SrcWriter().Write([]byte("POST /upload HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n"))
+	web.DstWriter().Write([]byte("HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok"))
+	web.OnClose(0, 0)
+
+	var out bytes.Buffer
+	if err := WritePCAPNG(&out, svc.GetRecent("t", 500), PCAPOptions{Name: "t"}); err != nil {
+		t.Fatalf("WritePCAPNG: %v", err)
+	}
+	linkType, segs, comments := readPCAPNG(t, out.Bytes())
+	if linkType != pcapLinkTypeRaw || len(comments) != 2 || comments[0] != "conn 1" {
+		t.Fatalf("unexpected link type %d or comments %v", linkType, comments)
+	}
+
+	streams := map[netip.AddrPort]*bytes.Buffer{}
+	next := map[netip.AddrPort]uint32{}
+	for _, s := range segs {
+		if s.flags&tcpSYN != 0 {
+			next[s.src] = s.seq + 1
+			streams[s.src] = &bytes.Buffer{}
+			continue
+		}
+		if s.seq != next[s.src] {
+			t.Fatalf("sequence gap from %s: %d != %d", s.src, s.seq, next[s.src])
+		}
+		next[s.src] += uint32(len(s.payload))
+		if s.flags&tcpFIN != 0 {
+			next[s.src]++
+		}
+		if len(s.payload) > pcapMSS {
+			t.Fatalf("segment exceeds MSS")
+		}
+		streams[s.src].Write(s.payload)
+	}
+
+	redisClient := netip.MustParseAddrPort("203.0.113.9:51000")
+	redisServer := netip.MustParseAddrPort("127.0.0.1:6379")
+	if !bytes.Equal(streams[redisClient].Bytes(), cmd) || streams[redisServer].String() != "+PONG\r\n" {
+		t.Fatalf("unexpected redis streams: %d bytes, %q", streams[redisClient].Len(), streams[redisServer])
+	}
+
+	// The IPv4 target is mapped into IPv6 to match the peer
+	httpClient := netip.MustParseAddrPort("[2001:db8::1]:40000")
+	httpServer := netip.AddrPortFrom(netip.AddrFrom16(netip.MustParseAddr("127.0.0.1").As16()), 8080)
+	req, err := http.ReadRequest(bufio.NewReader(streams[httpClient]))
+	if err != nil {
+		t.Fatalf("client stream is not HTTP: %v", err)
+	}
+	if body, _ := io.ReadAll(req.Body); req.URL.Path != "/upload" || string(body) != "abcde" {
+		t.Fatalf("unexpected request %s %q", req.URL, body)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(streams[httpServer]), req)
+	if err != nil || res.StatusCode != 201 {
+		t.Fatalf("server stream is not HTTP: %v", err)
+	}
+
+	// Connection filters apply
+	out.Reset()
+	WritePCAPNG(&out, svc.GetRecent("t", 500), PCAPOptions{ConnIDs: []string{"2"}})
+	if _, _, comments := readPCAPNG(t, out.Bytes()); len(comments) != 1 || comments[0] != "conn 2" {
+		t.Fatalf("expected only conn 2, got %v", comments)
+	}
+}
@@ -0,0 +1,153 @@
+package capture
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// CapturePolicy controls capture for one entity, see settings.CapturePolicy
+type CapturePolicy = settings.CapturePolicy
+
+// connPolicy is a capture policy resolved for one connection. A nil
+// *connPolicy captures everything.
+type connPolicy struct {
+	off              bool
+	headersOnly      bool
+	maxBody          int
+	include, exclude []string
+}
+
+func (p *connPolicy) disabled() bool { return p != nil && p.off }
+
+func (p *connPolicy) bodiesOmitted() bool { return p != nil && p.headersOnly }
+
+// keepPath reports whether an exchange for the request path is captured
+func (p *connPolicy) keepPath(path string) bool {
+	if p == nil {
+		return true
+	}
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if len(p.include) > 0 && !anyGlob(p.include, path) {
+		return false
+	}
+	return !anyGlob(p.exclude, path)
+}
+
+// capBody trims data so that at most maxBody bytes are kept after kept
+// bytes were already captured, reporting whether anything was cut
+func (p *connPolicy) capBody(kept int, data []byte) ([]byte, bool) {
+	if p == nil || p.maxBody <= 0 || kept+len(data) <= p.maxBody {
+		return data, false
+	}
+	return data[:max(p.maxBody-kept, 0)], true
+}
+
+func anyGlob(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if globMatch(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPolicies replaces the capture policies set through the server
+func (s *Service) SetPolicies(policies map[string]CapturePolicy) error {
+	for id, p := range policies {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+	}
+	s.policyMu.Lock()
+	defer s.policyMu.Unlock()
+	s.policies = policies
+	return nil
+}
+
+// Policies returns the capture policies set through the server
+func (s *Service) Policies() map[string]CapturePolicy {
+	s.policyMu.RLock()
+	defer s.policyMu.RUnlock()
+	return s.policies
+}
+
+// SetSessionPolicy records a policy requested by a connected client for an
+// entity, or removes it when p is nil
+func (s *Service) SetSessionPolicy(entityID string, p *CapturePolicy) {
+	s.policyMu.Lock()
+	defer s.policyMu.Unlock()
+	if p == nil {
+		delete(s.sessionPolicies, entityID)
+		return
+	}
+	if s.sessionPolicies == nil {
+		s.sessionPolicies = make(map[string]CapturePolicy)
+	}
+	s.sessionPolicies[entityID] = *p
+}
+
+var perRemoteSuffix = regexp.MustCompile(`^(.+)-r\d+$`)
+
+// Policy returns the effective capture policy of an entity and whether one
+// is set. Policies set through the server take precedence over those
+// requested by clients; per-remote IDs ({id}-r{n}) inherit their entity's.
+func (s *Service) Policy(entityID string) (CapturePolicy, bool) {
+	s.policyMu.RLock()
+	defer s.policyMu.RUnlock()
+	ids := []string{entityID}
+	if m := perRemoteSuffix.FindStringSubmatch(entityID); m != nil {
+		ids = append(ids, m[1])
+	}
+	for _, id := range ids {
+		if p, ok := s.policies[id]; ok {
+			return p, true
+		}
+		if p, ok := s.sessionPolicies[id]; ok {
+			return p, true
+		}
+	}
+	return CapturePolicy{}, false
+}
+
+// connPolicy resolves the policy of a new connection from the first of ids
+// that has one and makes its sampling decision
+func (s *Service) connPolicy(ids ...string) *connPolicy {
+	for _, id := range ids {
+		p, ok := s.Policy(id)
+		if !ok {
+			continue
+		}
+		cp := &connPolicy{
+			off:         p.Mode == settings.CaptureOff,
+			headersOnly: p.Mode == settings.CaptureHeaders,
+			maxBody:     p.MaxBodyBytes,
+			include:     p.IncludePaths,
+			exclude:     p.ExcludePaths,
+		}
+		if p.SampleRate > 0 && p.SampleRate < 1 && rand.Float64() >= p.SampleRate {
+			cp.off = true
+		}
+		return cp
+	}
+	return nil
+}
+
+// retentionCutoff returns the time before which an entity's persisted
+// captures are removed; the zero time keeps them
+func (s *Service) retentionCutoff(entityID string, now time.Time) time.Time {
+	days := s.retentionDays
+	if p, ok := s.Policy(entityID); ok && p.RetentionDays > 0 {
+		days = p.RetentionDays
+	}
+	if days <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(days) * 24 * time.Hour)
+}
@@ -0,0 +1,116 @@
+package capture
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/NextChapterSoftware/chissl/share/tunnel"
+)
+
+func TestCapturePolicy(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+	if err := svc.SetPolicies(map[string]CapturePolicy{"bad": {Mode: "some"}}); err == nil {
+		t.Fatalf("expected an invalid mode to be rejected")
+	}
+	if err := svc.SetPolicies(map[string]CapturePolicy{
+		"off":     {Mode: settings.CaptureOff},
+		"headers": {Mode: settings.CaptureHeaders},
+		"limited": {MaxBodyBytes: 4, ExcludePaths: []string{"/health"}},
+		"api":     {IncludePaths: []string{"/api/*"}},
+		"never":   {SampleRate: 0.000001},
+		"session": {MaxBodyBytes: 2},
+	}); err != nil {
+		t.Fatalf("SetPolicies: %v", err)
+	}
+	svc.SetSessionPolicy("session", &CapturePolicy{Mode: settings.CaptureOff})
+	svc.SetSessionPolicy("client", &CapturePolicy{Mode: settings.CaptureHeaders})
+
+	exchange := func(id, path string) []Event {
+		tap := newTap(svc, id, 500, tunnel.Meta{ConnID: "c-" + id + path}, svc.connPolicy(id))
+		tap.OnOpen()
+		tap.SrcWriter().Write([]byte("POST " + path + " HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\n0123456789"))
+		tap.DstWriter().Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"))
+		tap.OnClose(0, 0)
+		var out []Event
+		for _, e := range svc.GetRecent(id, 500) {
+			if e.ConnID == "c-"+id+path {
+				out = append(out, e)
+			}
+		}
+		return out
+	}
+	types := func(events []Event) string {
+		var s []string
+		for _, e := range events {
+			if e.Type != Metric {
+				s = append(s, string(e.Type))
+			}
+		}
+		return strings.Join(s, ",")
+	}
+
+	if got := exchange("off", "/"); len(got) != 0 {
+		t.Fatalf("expected nothing captured, got %s", types(got))
+	}
+	if got := exchange("never", "/"); len(got) != 0 {
+		t.Fatalf("expected the connection to be sampled out, got %s", types(got))
+	}
+	// Server policies override the client's, per-remote IDs inherit them
+	var body string
+	for _, e := range exchange("session-r0", "/") {
+		if e.Type == ReqBody {
+			body += string(e.Data)
+		}
+	}
+	if body != "01" {
+		t.Fatalf("expected the server policy to apply, got body %q", body)
+	}
+	if p, ok := svc.Policy("client-r1"); !ok || p.Mode != settings.CaptureHeaders {
+		t.Fatalf("expected the client policy for a per-remote ID, got %+v", p)
+	}
+
+	got := exchange("headers", "/")
+	if types(got) != "conn_open,req_headers,req_body,res_headers,res_body,timing,conn_close" {
+		t.Fatalf("unexpected headers-only events %s", types(got))
+	}
+	for _, e := range got {
+		if e.Type == ReqBody && (len(e.Data) != 0 || !e.Truncated || e.Meta.(map[string]any)["body_bytes"] != int64(10)) {
+			t.Fatalf("expected an omitted body with its size, got %+v", e)
+		}
+	}
+
+	got = exchange("limited", "/items")
+	var bodies []string
+	for _, e := range got {
+		if e.Type == ReqBody || e.Type == ResBody {
+			bodies = append(bodies, string(e.Data)+":"+strconv.FormatBool(e.Truncated))
+		}
+	}
+	if strings.Join(bodies, ",") != "0123:true,hell:true" {
+		t.Fatalf("unexpected capped bodies %v", bodies)
+	}
+	if got := exchange("limited", "/health"); types(got) != "conn_open,conn_close" {
+		t.Fatalf("expected the excluded exchange to be skipped, got %s", types(got))
+	}
+	if got := exchange("api", "/api/v1?x=1"); types(got) != "conn_open,req_headers,req_body,res_headers,res_body,timing,conn_close" {
+		t.Fatalf("expected the included exchange to be captured, got %s", types(got))
+	}
+	if got := exchange("api", "/web"); types(got) != "conn_open,conn_close" {
+		t.Fatalf("expected the exchange outside include_paths to be skipped, got %s", types(got))
+	}
+
+	// Retention is resolved per entity
+	now := time.Now()
+	svc.retentionDays = 7
+	svc.SetPolicies(map[string]CapturePolicy{"short": {RetentionDays: 1}})
+	if c := svc.retentionCutoff("short", now); !c.Equal(now.Add(-24 * time.Hour)) {
+		t.Fatalf("unexpected cutoff %v", c)
+	}
+	if c := svc.retentionCutoff("other", now); !c.Equal(now.Add(-7 * 24 * time.Hour)) {
+		t.Fatalf("unexpected default cutoff %v", c)
+	}
+}
@@ -0,0 +1,384 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Redaction of sensitive data in captured events.
+//
+// Rules are applied by Service.AddEvent before events are fanned out to
+// subscribers or persisted. The global rules (optionally including the
+// built-in defaults) apply to every entity; per-entity rules add to them.
+// Each redacted event lists the rules that matched in its "redacted" meta.
+
+// RedactedValue replaces redacted content
+const RedactedValue = "[REDACTED]"
+
+// cardNumberPattern matches candidate payment card numbers; matches are only
+// redacted when they pass the Luhn check
+const cardNumberPattern = `\b(?:\d[ -]?){12,18}\d\b`
+
+// RedactionRules selects the data to redact
+type RedactionRules struct {
+	// Headers are header names, matched case-insensitively
+	Headers []string `json:"headers,omitempty"`
+	// JSONPaths are object keys in JSON bodies. A single key ("password")
+	// matches at any depth; a dotted path ("user.card.number") matches from
+	// the root, with "*" matching any key or array index.
+	JSONPaths []string `json:"json_paths,omitempty"`
+	// Patterns are regular expressions whose matches are replaced in bodies
+	Patterns []string `json:"patterns,omitempty"`
+	// QueryParams are query and form-urlencoded parameter names
+	QueryParams []string `json:"query_params,omitempty"`
+}
+
+// RedactionSettings is the global redaction configuration
+type RedactionSettings struct {
+	Enabled bool `json:"enabled"`
+	// Defaults includes DefaultRedactionRules
+	Defaults bool           `json:"defaults"`
+	Rules    RedactionRules `json:"rules"`
+}
+
+// DefaultRedactionSettings enables the built-in rules
+func DefaultRedactionSettings() RedactionSettings {
+	return RedactionSettings{Enabled: true, Defaults: true}
+}
+
+// DefaultRedactionRules covers common credentials and card numbers
+func DefaultRedactionRules() RedactionRules {
+	return RedactionRules{
+		Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token", "X-Amz-Security-Token"},
+		JSONPaths: []string{"password", "passwd", "secret", "client_secret", "token", "access_token", "refresh_token", "id_token",
+			"api_key", "apikey", "private_key", "card_number", "cvv", "cvc"},
+		Patterns: []string{
+			cardNumberPattern,
+			`(?i)\bbearer\s+[a-z0-9._~+/=-]{8,}`,
+			`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`,
+			`\bAKIA[0-9A-Z]{16}\b`,
+			`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+		},
+		QueryParams: []string{"token", "access_token", "id_token", "api_key", "apikey", "key", "password", "secret", "client_secret", "sig", "signature"},
+	}
+}
+
+// Validate reports rules that cannot be compiled
+func (r RedactionRules) Validate() error {
+	_, err := NewRedactor(r)
+	return err
+}
+
+// Redactor applies a compiled set of rules
+type Redactor struct {
+	headers   map[string]bool
+	headerRaw *regexp.Regexp
+	keys      map[string]bool
+	paths     [][]string
+	patterns  []*regexp.Regexp
+	query     map[string]bool
+}
+
+// NewRedactor compiles and merges rule sets
+func NewRedactor(sets ...RedactionRules) (*Redactor, error) {
+	r := &Redactor{headers: map[string]bool{}, keys: map[string]bool{}, query: map[string]bool{}}
+	var headerNames []string
+	for _, set := range sets {
+		for _, h := range set.Headers {
+			if h = http.CanonicalHeaderKey(strings.TrimSpace(h)); h != "" && !r.headers[h] {
+				r.headers[h] = true
+				headerNames = append(headerNames, regexp.QuoteMeta(h))
+			}
+		}
+		for _, p := range set.JSONPaths {
+			p = strings.TrimPrefix(strings.TrimSpace(p), "$.")
+			switch {
+			case p == "":
+			case !strings.Contains(p, "."):
+				r.keys[p] = true
+			default:
+				r.paths = append(r.paths, strings.Split(p, "."))
+			}
+		}
+		for _, p := range set.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+			}
+			r.patterns = append(r.patterns, re)
+		}
+		for _, q := range set.QueryParams {
+			if q = strings.TrimSpace(q); q != "" {
+				r.query[q] = true
+			}
+		}
+	}
+	if len(headerNames) > 0 {
+		// Header lines inside raw, unparsed streams
+		r.headerRaw = regexp.MustCompile(`(?im)^(` + strings.Join(headerNames, "|") + `):[ \t]*[^\r\n]*`)
+	}
+	return r, nil
+}
+
+// redactions collects the labels of matched rules
+type redactions map[string]bool
+
+func (r redactions) list() []string {
+	out := make([]string, 0, len(r))
+	for k := range r {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Redact rewrites the event in place and returns the labels of matched rules
+func (r *Redactor) Redact(e *Event) []string {
+	found := redactions{}
+	meta, _ := e.Meta.(map[string]any)
+	switch e.Type {
+	case ReqHeaders:
+		var head RequestHead
+		if json.Unmarshal(e.Data, &head) == nil {
+			r.redactHeader(head.Header, found)
+			head.Path = r.redactURL(head.Path, found)
+			e.Data, _ = json.Marshal(head)
+			if p, ok := meta["path"].(string); ok {
+				meta["path"] = r.redactURL(p, found)
+			}
+		}
+	case ResHeaders:
+		var head ResponseHead
+		if json.Unmarshal(e.Data, &head) == nil {
+			r.redactHeader(head.Header, found)
+			e.Data, _ = json.Marshal(head)
+		}
+	case ReqBody, ResBody, WSMessage:
+		if raw, _ := meta["raw"].(bool); raw && r.headerRaw != nil {
+			e.Data = r.headerRaw.ReplaceAllFunc(e.Data, func(m []byte) []byte {
+				name := m[:bytes.IndexByte(m, ':')]
+				found["header:"+http.CanonicalHeaderKey(string(name))] = true
+				return append(append([]byte(nil), name...), ": "+RedactedValue...)
+			})
+		}
+		if enc, _ := meta["content_encoding"].(string); enc != "" {
+			e.Data = r.redactEncoded(enc, e.Data, found)
+		} else {
+			e.Data = r.redactBody(e.Data, found)
+		}
+		if d, ok := meta["decoded"].(*DecodedBody); ok {
+			r.redactDecoded(d, found)
+		}
+	}
+	return found.list()
+}
+
+func (r *Redactor) redactHeader(h http.Header, found redactions) {
+	for name := range h {
+		if r.headers[http.CanonicalHeaderKey(name)] {
+			for i := range h[name] {
+				h[name][i] = RedactedValue
+			}
+			found["header:"+http.CanonicalHeaderKey(name)] = true
+		}
+	}
+}
+
+// redactURL replaces the values of matching query parameters
+func (r *Redactor) redactURL(raw string, found redactions) string {
+	path, query, ok := strings.Cut(raw, "?")
+	if !ok || len(r.query) == 0 {
+		return raw
+	}
+	return path + "?" + r.redactParams(query, found)
+}
+
+// redactParams redacts an urlencoded parameter list, keeping its layout
+func (r *Redactor) redactParams(s string, found redactions) string {
+	pairs := strings.Split(s, "&")
+	for i, pair := range pairs {
+		name, _, _ := strings.Cut(pair, "=")
+		if n, err := url.QueryUnescape(name); err == nil && r.query[n] {
+			pairs[i] = name + "=" + url.QueryEscape(RedactedValue)
+			found["query:"+n] = true
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactBody applies JSON rules when data is a JSON document, then the patterns
+func (r *Redactor) redactBody(data []byte, found redactions) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	if t := bytes.TrimSpace(data); len(t) > 0 && (t[0] == '{' || t[0] == '[') && (len(r.keys) > 0 || len(r.paths) > 0) {
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if dec.Decode(&v) == nil && !dec.More() {
+			if r.redactJSON(v, nil, found) {
+				if out, err := json.Marshal(v); err == nil {
+					data = out
+				}
+			}
+		}
+	}
+	if len(r.query) > 0 && formBody.Match(data) {
+		data = []byte(r.redactParams(string(data), found))
+	}
+	return r.redactPatterns(data, found)
+}
+
+// redactEncoded applies the body rules to a compressed body, encoding the
+// result again when anything was redacted. A body that cannot be decoded is
+// dropped, since the rules cannot be checked against it.
+func (r *Redactor) redactEncoded(encoding string, data []byte, found redactions) []byte {
+	plain, err := decodeContent(encoding, data)
+	if err != nil {
+		found["encoding:"+encoding] = true
+		return nil
+	}
+	out := r.redactBody(plain, found)
+	if bytes.Equal(out, plain) {
+		return data
+	}
+	if data, err = encodeContent(encoding, out); err != nil {
+		found["encoding:"+encoding] = true
+		return nil
+	}
+	return data
+}
+
+// hasBodyRules reports whether any rule applies to body contents
+func (r *Redactor) hasBodyRules() bool {
+	return r != nil && (len(r.keys) > 0 || len(r.paths) > 0 || len(r.patterns) > 0 || len(r.query) > 0)
+}
+
+// formBody matches form-urlencoded payloads
+var formBody = regexp.MustCompile(`^[^=&\s]+=[^&\s]*(?:&[^=&\s]+=[^&\s]*)*$`)
+
+func (r *Redactor) redactPatterns(data []byte, found redactions) []byte {
+	for _, re := range r.patterns {
+		card := re.String() == cardNumberPattern
+		data = re.ReplaceAllFunc(data, func(m []byte) []byte {
+			if card && !luhnValid(m) {
+				return m
+			}
+			if card {
+				found["pattern:card_number"] = true
+			} else {
+				found["pattern:"+re.String()] = true
+			}
+			return []byte(RedactedValue)
+		})
+	}
+	return data
+}
+
+// redactJSON redacts matching members of v, reporting whether anything changed
+func (r *Redactor) redactJSON(v any, path []string, found redactions) bool {
+	changed := false
+	visit := func(key string, child any, set func(any)) {
+		p := append(path[:len(path):len(path)], key)
+		if r.keys[key] || r.matchPath(p) {
+			set(RedactedValue)
+			found["json:"+strings.Join(p, ".")] = true
+			changed = true
+			return
+		}
+		if r.redactJSON(child, p, found) {
+			changed = true
+		}
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			visit(k, child, func(nv any) { t[k] = nv })
+		}
+	case []any:
+		for i, child := range t {
+			visit(strconv.Itoa(i), child, func(nv any) { t[i] = nv })
+		}
+	}
+	return changed
+}
+
+func (r *Redactor) matchPath(p []string) bool {
+	for _, rule := range r.paths {
+		if len(rule) != len(p) {
+			continue
+		}
+		match := true
+		for i := range rule {
+			if rule[i] != "*" && rule[i] != p[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) redactDecoded(d *DecodedBody, found redactions) {
+	if d.Text != "" {
+		text := []byte(d.Text)
+		if d.Kind == "json" {
+			if out := r.redactBody(text, found); !bytes.Equal(out, text) {
+				var buf bytes.Buffer
+				if json.Indent(&buf, out, "", "  ") == nil {
+					out = buf.Bytes()
+				}
+				text = out
+			}
+		} else {
+			text = r.redactPatterns(text, found)
+		}
+		d.Text = string(text)
+	}
+	for i, f := range d.Fields {
+		if r.query[f.Name] {
+			d.Fields[i].Value = RedactedValue
+			found["query:"+f.Name] = true
+		} else {
+			d.Fields[i].Value = string(r.redactPatterns([]byte(f.Value), found))
+		}
+	}
+	for i, p := range d.Parts {
+		if r.query[p.Name] {
+			d.Parts[i].Text = RedactedValue
+			found["query:"+p.Name] = true
+		} else if p.Text != "" {
+			d.Parts[i].Text = string(r.redactBody([]byte(p.Text), found))
+		}
+	}
+}
+
+// luhnValid checks the card number checksum of the digits in b
+func luhnValid(b []byte) bool {
+	sum, n := 0, 0
+	for i := len(b) - 1; i >= 0; i-- {
+		c := b[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
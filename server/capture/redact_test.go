@@ -0,0 +1,158 @@
+package capture
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRedactEvents(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+	if err := svc.SetRedaction(DefaultRedactionSettings(), map[string]RedactionRules{
+		"t1": {JSONPaths: []string{"user.*.ssn"}, Headers: []string{"X-Session"}},
+	}); err != nil {
+		t.Fatalf("SetRedaction: %v", err)
+	}
+	if err := svc.SetRedaction(RedactionSettings{Enabled: true, Rules: RedactionRules{Patterns: []string{"("}}}, nil); err == nil {
+		t.Fatalf("expected invalid pattern to be rejected")
+	}
+
+	tap := &TapImpl{svc: svc, meta: Meta{ConnID: "c1"}, tunnelID: "t1-r0", maxEvents: 500}
+	body := `{"password":"hunter2","user":{"a":{"ssn":"123-45-6789","name":"ann"}},"note":"card 4111 1111 1111 1111, order 1234567890123"}`
+	tap.SrcWriter().Write([]byte("POST /login?token=abc&page=2 HTTP/1.1\r\nHost: x\r\nAuthorization: Bearer secret-token\r\nX-Session: s1\r\n" +
+		"Content-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+	form := "user=ann&password=pw&api_key=k1"
+	tap.SrcWriter().Write([]byte("POST /form HTTP/1.1\r\nHost: x\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: " + strconv.Itoa(len(form)) + "\r\n\r\n" + form))
+
+	raw := dumpEvents(svc.GetRecent("t1-r0", 500))
+	for _, secret := range []string{"hunter2", "123-45-6789", "4111 1111 1111 1111", "secret-token", "abc", "s1", "pw", "k1"} {
+		if strings.Contains(raw, secret) {
+			t.Fatalf("secret %q leaked into capture: %s", secret, raw)
+		}
+	}
+	for _, kept := range []string{"ann", "1234567890123", "page=2"} {
+		if !strings.Contains(raw, kept) {
+			t.Fatalf("expected %q to be kept: %s", kept, raw)
+		}
+	}
+
+	events := svc.GetRecent("t1-r0", 500)
+	hdr := events[0].Meta.(map[string]any)
+	if got := strings.Join(hdr["redacted"].([]string), ","); got != "header:Authorization,header:X-Session,query:token" {
+		t.Fatalf("unexpected header redactions: %s", got)
+	}
+	var head RequestHead
+	json.Unmarshal(events[0].Data, &head)
+	if head.Header.Get("Authorization") != RedactedValue || head.Path != "/login?token=%5BREDACTED%5D&page=2" || hdr["path"] != head.Path {
+		t.Fatalf("unexpected redacted head: %+v (meta path %v)", head, hdr["path"])
+	}
+	bodyMeta := events[1].Meta.(map[string]any)
+	if got := strings.Join(bodyMeta["redacted"].([]string), ","); got != "json:password,json:user.a.ssn,pattern:card_number" {
+		t.Fatalf("unexpected body redactions: %s", got)
+	}
+	if d := bodyMeta["decoded"].(*DecodedBody); strings.Contains(d.Text, "hunter2") || !strings.Contains(d.Text, "\n  ") {
+		t.Fatalf("expected redacted pretty view, got %s", d.Text)
+	}
+
+	// Other entities only get the global rules
+	other := &TapImpl{svc: svc, meta: Meta{ConnID: "c2"}, tunnelID: "t2", maxEvents: 500}
+	other.SrcWriter().Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nX-Session: s2\r\n\r\n"))
+	if raw := dumpEvents(svc.GetRecent("t2", 500)); !strings.Contains(raw, "s2") {
+		t.Fatalf("expected entity rules to stay scoped: %s", raw)
+	}
+
+	// Disabling redaction keeps events verbatim
+	svc.SetRedaction(RedactionSettings{}, nil)
+	e := Event{Type: ReqHeaders, Data: []byte(`{"header":{"Authorization":["x"]}}`)}
+	svc.AddEvent("t3", e, 10)
+	if got := svc.GetRecent("t3", 10)[0]; got.Meta != nil || !strings.Contains(string(got.Data), `"x"`) {
+		t.Fatalf("expected verbatim event, got %+v", got)
+	}
+}
+
+func TestRedactRawStream(t *testing.T) {
+	r, _ := NewRedactor(DefaultRedactionRules())
+	e := Event{Type: ReqBody, Meta: map[string]any{"raw": true}, Data: []byte("GET / HTTP/1.1\r\ncookie: a=b\r\nAccept: */*\r\n\r\n")}
+	found := r.Redact(&e)
+	if string(e.Data) != "GET / HTTP/1.1\r\ncookie: [REDACTED]\r\nAccept: */*\r\n\r\n" || len(found) != 1 || found[0] != "header:"+http.CanonicalHeaderKey("cookie") {
+		t.Fatalf("unexpected raw redaction: %q %v", e.Data, found)
+	}
+}
+
+func TestRedactSplitAndEncodedBodies(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+	tap := &TapImpl{svc: svc, meta: Meta{ConnID: "c1"}, tunnelID: "t1", maxEvents: 500}
+
+	// A JSON secret split across two writes
+	body := `{"password":"hunter2","user":"ann"}`
+	tap.SrcWriter().Write([]byte("POST /login HTTP/1.1\r\nHost: x\r\nContent-Type: application/json\r\nContent-Length: " +
+		strconv.Itoa(len(body)) + "\r\n\r\n" + body[:16]))
+	tap.SrcWriter().Write([]byte(body[16:]))
+
+	// A gzip-encoded JSON secret, also split
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(`{"access_token":"tok-123456","user":"ann"}`))
+	zw.Close()
+	enc := gz.Bytes()
+	tap.DstWriter().Write(append([]byte("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Encoding: gzip\r\nContent-Length: "+
+		strconv.Itoa(len(enc))+"\r\n\r\n"), enc[:10]...))
+	tap.DstWriter().Write(enc[10:])
+
+	events := svc.GetRecent("t1", 500)
+	if raw := dumpEvents(events); strings.Contains(raw, "hun") || strings.Contains(raw, "tok-123456") {
+		t.Fatalf("secret leaked into capture: %s", raw)
+	}
+	var req, res []byte
+	var resMeta map[string]any
+	for _, e := range events {
+		switch e.Type {
+		case ReqBody:
+			req = append(req, e.Data...)
+		case ResBody:
+			res = append(res, e.Data...)
+			resMeta = e.Meta.(map[string]any)
+		}
+	}
+	if string(req) != `{"password":"[REDACTED]","user":"ann"}` {
+		t.Fatalf("unexpected redacted request body %s", req)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(res))
+	if err != nil {
+		t.Fatalf("expected the redacted response to stay gzip-encoded: %v", err)
+	}
+	plain, _ := io.ReadAll(zr)
+	if string(plain) != `{"access_token":"[REDACTED]","user":"ann"}` {
+		t.Fatalf("unexpected redacted response body %s", plain)
+	}
+	if got := strings.Join(resMeta["redacted"].([]string), ","); got != "json:access_token" {
+		t.Fatalf("unexpected response redactions: %s", got)
+	}
+
+	// Meta of other types keeps the marker
+	svc.AddEvent("t2", Event{Type: ReqHeaders, Meta: struct {
+		Note string `json:"note"`
+	}{"n1"}, Data: []byte(`{"header":{"Authorization":["x"]}}`)}, 10)
+	meta, ok := svc.GetRecent("t2", 10)[0].Meta.(map[string]any)
+	if !ok || meta["note"] != "n1" || meta["redacted"] == nil {
+		t.Fatalf("expected the redaction marker on converted meta, got %#v", meta)
+	}
+}
+
+// dumpEvents renders event data and meta as text for content checks
+func dumpEvents(events []Event) string {
+	var b strings.Builder
+	for _, e := range events {
+		meta, _ := json.Marshal(e.Meta)
+		b.Write(e.Data)
+		b.Write(meta)
+	}
+	return b.String()
+}
@@ -0,0 +1,556 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Search over captured HTTP exchanges.
+//
+// Each sequenced request/response pair is indexed in memory as it is captured
+// (after redaction) and the index is rebuilt from the persisted connection
+// logs on startup. Method, path, host, status, header names and values and
+// body text are tokenized into an inverted index; structured filters are
+// checked against the indexed fields.
+//
+// Session aliases (sess-...) are not indexed since every exchange on them is
+// also captured under the canonical tunnel ID.
+
+// maxIndexedBody bounds the body text indexed per exchange
+const maxIndexedBody = 64 * 1024
+
+// maxTermLen skips tokens that are unlikely to be searched for
+const maxTermLen = 64
+
+// SearchHit is an indexed exchange
+type SearchHit struct {
+	EntityID string    `json:"entity_id"`
+	ConnID   string    `json:"conn_id"`
+	Seq      int64     `json:"seq"`
+	Time     time.Time `json:"time"`
+	User     string    `json:"user,omitempty"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Host     string    `json:"host,omitempty"`
+	Status   int       `json:"status,omitempty"`
+}
+
+type indexDoc struct {
+	SearchHit
+	key       string
+	header    http.Header
+	bodyBytes int
+	terms     map[string]struct{}
+}
+
+// SearchIndex is an inverted index of captured exchanges
+type SearchIndex struct {
+	mu       sync.RWMutex
+	maxDocs  int
+	nextID   uint64
+	docs     map[uint64]*indexDoc
+	order    []uint64
+	postings map[string]map[uint64]struct{}
+	// open maps entity/conn/seq to the latest exchange with that key
+	open map[string]uint64
+}
+
+// NewSearchIndex creates an index holding at most maxDocs exchanges
+func NewSearchIndex(maxDocs int) *SearchIndex {
+	return &SearchIndex{
+		maxDocs:  maxDocs,
+		docs:     make(map[uint64]*indexDoc),
+		postings: make(map[string]map[uint64]struct{}),
+		open:     make(map[string]uint64),
+	}
+}
+
+// searchable reports whether events captured under an ID are indexed
+func searchable(entityID string) bool {
+	return !strings.HasPrefix(entityID, "sess-")
+}
+
+// Add indexes a captured event
+func (x *SearchIndex) Add(entityID string, e Event) {
+	seq, ok := metaInt(e.Meta, "seq")
+	if !ok {
+		return
+	}
+	key := fmt.Sprintf("%s\x00%s\x00%d", entityID, e.ConnID, seq)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if e.Type == ReqHeaders {
+		var head RequestHead
+		if json.Unmarshal(e.Data, &head) != nil {
+			return
+		}
+		x.nextID++
+		d := &indexDoc{
+			SearchHit: SearchHit{EntityID: entityID, ConnID: e.ConnID, Seq: seq, Time: e.Time, User: e.User, Method: head.Method, Path: head.Path, Host: head.Host},
+			key:       key,
+			header:    http.Header{},
+			terms:     map[string]struct{}{},
+		}
+		x.docs[x.nextID] = d
+		x.order = append(x.order, x.nextID)
+		x.open[key] = x.nextID
+		x.index(x.nextID, d, head.Method, head.Path, head.Host)
+		x.indexHeader(x.nextID, d, head.Header)
+		x.evict()
+		return
+	}
+
+	id, ok := x.open[key]
+	if !ok {
+		return
+	}
+	d := x.docs[id]
+	switch e.Type {
+	case ResHeaders:
+		if m, _ := e.Meta.(map[string]any); m["interim"] == true {
+			return
+		}
+		var head ResponseHead
+		if json.Unmarshal(e.Data, &head) != nil {
+			return
+		}
+		d.Status = head.Code
+		x.index(id, d, head.Status)
+		x.indexHeader(id, d, head.Header)
+	case ReqBody, ResBody:
+		if room := maxIndexedBody - d.bodyBytes; room > 0 {
+			text := bodyText(e)
+			if len(text) > room {
+				text = text[:room]
+			}
+			d.bodyBytes += len(text)
+			x.index(id, d, text)
+		}
+	}
+}
+
+func (x *SearchIndex) index(id uint64, d *indexDoc, texts ...string) {
+	for _, text := range texts {
+		for _, term := range tokenize(text) {
+			if _, ok := d.terms[term]; ok {
+				continue
+			}
+			d.terms[term] = struct{}{}
+			p := x.postings[term]
+			if p == nil {
+				p = make(map[uint64]struct{})
+				x.postings[term] = p
+			}
+			p[id] = struct{}{}
+		}
+	}
+}
+
+func (x *SearchIndex) indexHeader(id uint64, d *indexDoc, h http.Header) {
+	for name, values := range h {
+		for _, v := range values {
+			d.header.Add(name, v)
+			x.index(id, d, name, v)
+		}
+	}
+}
+
+// evict drops the oldest exchanges beyond maxDocs
+func (x *SearchIndex) evict() {
+	for x.maxDocs > 0 && len(x.docs) > x.maxDocs && len(x.order) > 0 {
+		x.remove(x.order[0])
+		x.order = x.order[1:]
+	}
+}
+
+func (x *SearchIndex) remove(id uint64) {
+	d, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for term := range d.terms {
+		if p := x.postings[term]; p != nil {
+			delete(p, id)
+			if len(p) == 0 {
+				delete(x.postings, term)
+			}
+		}
+	}
+	if x.open[d.key] == id {
+		delete(x.open, d.key)
+	}
+	delete(x.docs, id)
+}
+
+// Prune drops exchanges captured before their entity's cutoff; a zero
+// cutoff keeps them
+func (x *SearchIndex) Prune(cutoff func(entityID string) time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	kept := x.order[:0]
+	for _, id := range x.order {
+		if d, ok := x.docs[id]; ok && d.Time.Before(cutoff(d.EntityID)) {
+			x.remove(id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	x.order = kept
+}
+
+// Len returns the number of indexed exchanges
+func (x *SearchIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.docs)
+}
+
+// Search returns the exchanges matching q, newest first, together with the
+// total number of matches. allow, when set, restricts results to the entities
+// it accepts.
+func (x *SearchIndex) Search(q *SearchQuery, allow func(entityID string) bool) ([]SearchHit, int) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var candidates map[uint64]struct{}
+	for _, term := range q.Terms {
+		matches := x.lookup(term)
+		if candidates == nil {
+			candidates = matches
+			continue
+		}
+		for id := range candidates {
+			if _, ok := matches[id]; !ok {
+				delete(candidates, id)
+			}
+		}
+	}
+	if candidates == nil {
+		candidates = make(map[uint64]struct{}, len(x.docs))
+		for id := range x.docs {
+			candidates[id] = struct{}{}
+		}
+	}
+
+	allowed := map[string]bool{}
+	var hits []SearchHit
+	for id := range candidates {
+		d := x.docs[id]
+		if !q.match(d) {
+			continue
+		}
+		if allow != nil {
+			ok, seen := allowed[d.EntityID]
+			if !seen {
+				ok = allow(d.EntityID)
+				allowed[d.EntityID] = ok
+			}
+			if !ok {
+				continue
+			}
+		}
+		hits = append(hits, d.SearchHit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if !hits[i].Time.Equal(hits[j].Time) {
+			return hits[i].Time.After(hits[j].Time)
+		}
+		return hits[i].EntityID < hits[j].EntityID
+	})
+	total := len(hits)
+	if q.Offset >= len(hits) {
+		return []SearchHit{}, total
+	}
+	hits = hits[q.Offset:]
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, total
+}
+
+// lookup returns a copy of the postings for a term; a trailing "*" matches by prefix
+func (x *SearchIndex) lookup(term string) map[uint64]struct{} {
+	out := map[uint64]struct{}{}
+	if prefix, ok := strings.CutSuffix(term, "*"); ok {
+		for t, p := range x.postings {
+			if strings.HasPrefix(t, prefix) {
+				for id := range p {
+					out[id] = struct{}{}
+				}
+			}
+		}
+		return out
+	}
+	for id := range x.postings[term] {
+		out[id] = struct{}{}
+	}
+	return out
+}
+
+// tokenize lowercases text and splits it into letter and digit runs
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := fields[:0]
+	for _, f := range fields {
+		if len(f) <= maxTermLen {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// bodyText returns the searchable text of a body event: the decoded view when
+// present, otherwise the data if it is valid UTF-8
+func bodyText(e Event) string {
+	m, _ := e.Meta.(map[string]any)
+	var parts []string
+	switch d := m["decoded"].(type) {
+	case *DecodedBody:
+		parts = append(parts, d.Text)
+		for _, f := range d.Fields {
+			parts = append(parts, f.Name, f.Value)
+		}
+		for _, p := range d.Parts {
+			parts = append(parts, p.Name, p.Text)
+		}
+	case map[string]any:
+		// decoded view of a persisted event
+		if t, ok := d["text"].(string); ok {
+			parts = append(parts, t)
+		}
+		for _, key := range []string{"fields", "parts"} {
+			list, _ := d[key].([]any)
+			for _, item := range list {
+				f, _ := item.(map[string]any)
+				for _, k := range []string{"name", "value", "text"} {
+					if s, ok := f[k].(string); ok {
+						parts = append(parts, s)
+					}
+				}
+			}
+		}
+	}
+	if raw, _ := m["raw"].(bool); !raw && utf8.Valid(e.Data) {
+		parts = append(parts, string(e.Data))
+	}
+	return strings.Join(parts, " ")
+}
+
+// SearchQuery is a parsed search. Different filters must all match; repeated
+// filters of the same kind match any of their values.
+type SearchQuery struct {
+	// Terms must all occur in the exchange; a trailing "*" matches by prefix
+	Terms    []string
+	Status   [][2]int
+	Methods  []string
+	Paths    []string
+	Hosts    []string
+	Entities []string
+	Conns    []string
+	Users    []string
+	// Headers are "name" (present) or "name=value" (value contains) filters
+	Headers      []string
+	Since, Until time.Time
+	Limit        int
+	Offset       int
+}
+
+// ParseSearchQuery parses a query such as `status:5xx path:/api/* since:1h timeout`.
+//
+// Filters are status (404, 5xx or 400-499), method, path and host (with "*"
+// wildcards), tunnel (the capture entity ID), conn, user, header (name or
+// name=value) and since/until (a duration before now such as 30m, 2h or 7d,
+// or an RFC3339 time). Other words, or quoted phrases, are full-text terms.
+func ParseSearchQuery(q string, now time.Time) (*SearchQuery, error) {
+	out := &SearchQuery{}
+	for _, word := range splitQuery(q) {
+		key, value, ok := strings.Cut(word, ":")
+		if !ok || value == "" || strings.ContainsAny(key, " \"") {
+			out.Terms = append(out.Terms, queryTerms(word)...)
+			continue
+		}
+		switch key = strings.ToLower(key); key {
+		case "status":
+			r, err := parseStatusFilter(value)
+			if err != nil {
+				return nil, err
+			}
+			out.Status = append(out.Status, r)
+		case "method":
+			out.Methods = append(out.Methods, strings.ToUpper(value))
+		case "path":
+			out.Paths = append(out.Paths, value)
+		case "host":
+			out.Hosts = append(out.Hosts, strings.ToLower(value))
+		case "tunnel", "entity":
+			out.Entities = append(out.Entities, value)
+		case "conn":
+			out.Conns = append(out.Conns, value)
+		case "user":
+			out.Users = append(out.Users, value)
+		case "header":
+			out.Headers = append(out.Headers, value)
+		case "since", "until":
+			t, err := parseSearchTime(value, now)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			if key == "since" {
+				out.Since = t
+			} else {
+				out.Until = t
+			}
+		default:
+			// Not a filter, e.g. a URL or "key:value" text
+			out.Terms = append(out.Terms, queryTerms(word)...)
+		}
+	}
+	return out, nil
+}
+
+// splitQuery splits on spaces, keeping double-quoted phrases together
+func splitQuery(q string) []string {
+	var out []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if cur.Len() > 0 {
+				out = append(out, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		out = append(out, cur.String())
+	}
+	return out
+}
+
+// queryTerms tokenizes a free-text word, keeping a trailing prefix wildcard
+func queryTerms(word string) []string {
+	terms := tokenize(word)
+	if strings.HasSuffix(word, "*") && len(terms) > 0 {
+		terms[len(terms)-1] += "*"
+	}
+	return terms
+}
+
+func parseStatusFilter(v string) ([2]int, error) {
+	v = strings.ToLower(v)
+	if len(v) == 3 && strings.HasSuffix(v, "xx") && v[0] >= '1' && v[0] <= '5' {
+		c := int(v[0]-'0') * 100
+		return [2]int{c, c + 99}, nil
+	}
+	if lo, hi, ok := strings.Cut(v, "-"); ok {
+		a, err1 := strconv.Atoi(lo)
+		b, err2 := strconv.Atoi(hi)
+		if err1 == nil && err2 == nil && a <= b {
+			return [2]int{a, b}, nil
+		}
+	} else if c, err := strconv.Atoi(v); err == nil {
+		return [2]int{c, c}, nil
+	}
+	return [2]int{}, fmt.Errorf("invalid status filter %q", v)
+}
+
+func parseSearchTime(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.Add(-time.Duration(n) * 24 * time.Hour), nil
+		}
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("expected a duration (30m, 2h, 7d) or RFC3339 time")
+	}
+	return now.Add(-d), nil
+}
+
+func (q *SearchQuery) match(d *indexDoc) bool {
+	if !q.Since.IsZero() && d.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && d.Time.After(q.Until) {
+		return false
+	}
+	if len(q.Status) > 0 && !anyMatch(len(q.Status), func(i int) bool { return d.Status >= q.Status[i][0] && d.Status <= q.Status[i][1] }) {
+		return false
+	}
+	path, _, _ := strings.Cut(d.Path, "?")
+	filters := []struct {
+		values []string
+		match  func(v string) bool
+	}{
+		{q.Methods, func(v string) bool { return d.Method == v }},
+		{q.Paths, func(v string) bool { return globMatch(v, path) || globMatch(v, d.Path) }},
+		{q.Hosts, func(v string) bool { return globMatch(v, strings.ToLower(d.Host)) }},
+		{q.Entities, func(v string) bool { return globMatch(v, d.EntityID) }},
+		{q.Conns, func(v string) bool { return d.ConnID == v }},
+		{q.Users, func(v string) bool { return d.User == v }},
+		{q.Headers, func(v string) bool {
+			name, value, hasValue := strings.Cut(v, "=")
+			values := d.header.Values(name)
+			if !hasValue {
+				return len(values) > 0
+			}
+			return anyMatch(len(values), func(i int) bool {
+				return strings.Contains(strings.ToLower(values[i]), strings.ToLower(value))
+			})
+		}},
+	}
+	for _, f := range filters {
+		if len(f.values) > 0 && !anyMatch(len(f.values), func(i int) bool { return f.match(f.values[i]) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyMatch(n int, fn func(i int) bool) bool {
+	for i := 0; i < n; i++ {
+		if fn(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches s against pattern, where "*" matches any run of characters
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, p)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(p):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,89 @@
+package capture
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CHISEL_CAPTURE_DIR", dir)
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "true")
+	svc := NewService(500, 64*1024)
+
+	exchange := func(entity, conn, req, res string) {
+		tap := &TapImpl{svc: svc, meta: Meta{Username: "ann", ConnID: conn}, tunnelID: entity, maxEvents: 500}
+		tap.SrcWriter().Write([]byte(req))
+		tap.DstWriter().Write([]byte(res))
+	}
+	body := `{"error":"upstream timeout","password":"hunter2"}`
+	exchange("tun-a", "1", "GET /api/users/7?verbose=1 HTTP/1.1\r\nHost: api.local\r\nX-Request-Id: abc-123\r\n\r\n",
+		"HTTP/1.1 503 Service Unavailable\r\nContent-Type: application/json\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body)
+	exchange("tun-a", "2", "POST /login HTTP/1.1\r\nHost: api.local\r\nContent-Length: 5\r\n\r\nhello",
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	exchange("listener-b", "1", "GET /api/health HTTP/1.1\r\nHost: other.local\r\n\r\n", "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+	// Session aliases are not indexed
+	exchange("sess-1", "1", "GET /api/users/7 HTTP/1.1\r\nHost: api.local\r\n\r\n", "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n")
+
+	search := func(svc *Service, q string, allow func(string) bool) []SearchHit {
+		t.Helper()
+		query, err := ParseSearchQuery(q, time.Now())
+		if err != nil {
+			t.Fatalf("ParseSearchQuery(%q): %v", q, err)
+		}
+		hits, total := svc.Search(query, allow)
+		if total != len(hits) {
+			t.Fatalf("%q: total %d for %d hits", q, total, len(hits))
+		}
+		return hits
+	}
+	cases := []struct {
+		q    string
+		want int
+	}{
+		{"status:5xx path:/api/* since:1h", 1},
+		{"status:400-599", 2},
+		{"path:/api/*", 2},
+		{"timeout", 1},
+		{`"upstream timeout" method:get`, 1},
+		{"upstr*", 1},
+		{"hunter2", 0},
+		{"header:x-request-id=ABC", 1},
+		{"header:x-request-id", 1},
+		{"host:*.local method:POST hello", 1},
+		{"tunnel:tun-* user:ann", 2},
+		{"until:1h", 0},
+		{"verbose", 1},
+	}
+	for _, c := range cases {
+		if got := search(svc, c.q, nil); len(got) != c.want {
+			t.Fatalf("%q: got %d hits, want %d: %+v", c.q, len(got), c.want, got)
+		}
+	}
+	if hits := search(svc, "status:503", nil); hits[0].EntityID != "tun-a" || hits[0].ConnID != "1" || hits[0].Seq != 1 || hits[0].Path != "/api/users/7?verbose=1" {
+		t.Fatalf("unexpected hit %+v", hits[0])
+	}
+	if hits := search(svc, "", func(id string) bool { return id == "listener-b" }); len(hits) != 1 || hits[0].Status != 404 {
+		t.Fatalf("expected access filter to apply, got %+v", hits)
+	}
+	query, _ := ParseSearchQuery("", time.Now())
+	query.Limit, query.Offset = 1, 1
+	if hits, total := svc.Search(query, nil); len(hits) != 1 || total != 3 {
+		t.Fatalf("unexpected page: %d hits of %d", len(hits), total)
+	}
+	if _, err := ParseSearchQuery("status:6zz", time.Now()); err == nil {
+		t.Fatalf("expected invalid status filter to fail")
+	}
+
+	// The index is rebuilt from persisted logs
+	svc.Close()
+	restarted := NewService(500, 64*1024)
+	if got := search(restarted, "status:5xx timeout", nil); len(got) != 1 {
+		t.Fatalf("expected rebuilt index to find the exchange, got %+v", got)
+	}
+	restarted.index.Prune(func(string) time.Time { return time.Now().Add(time.Minute) })
+	if n := restarted.index.Len(); n != 0 {
+		t.Fatalf("expected pruned index, got %d exchanges", n)
+	}
+}
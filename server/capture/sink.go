@@ -0,0 +1,486 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sink types
+const (
+	SinkWebhook = "webhook"
+	SinkTCP     = "tcp"
+	SinkFile    = "file"
+)
+
+// SinkConfig configures an external consumer of capture events
+type SinkConfig struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Disabled bool   `json:"disabled,omitempty"`
+	// URL and Headers configure webhook sinks, which POST batches as
+	// {"events": [...]}
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Address is the host:port tcp sinks write newline-delimited JSON to
+	Address string `json:"address,omitempty"`
+	// Path is the newline-delimited JSON file of file sinks, rotated to
+	// Path.N at RotateBytes; MaxFiles bounds the rotated files kept
+	Path        string `json:"path,omitempty"`
+	RotateBytes int64  `json:"rotate_bytes,omitempty"`
+	MaxFiles    int    `json:"max_files,omitempty"`
+	// Entities selects tunnel, listener and multicast IDs ("*" wildcards),
+	// Types event types; empty selects all
+	Entities []string    `json:"entities,omitempty"`
+	Types    []EventType `json:"types,omitempty"`
+	// QueueSize bounds the events waiting for delivery, beyond which events
+	// are dropped; batches are sent at BatchSize events or after FlushMillis
+	QueueSize   int `json:"queue_size,omitempty"`
+	BatchSize   int `json:"batch_size,omitempty"`
+	FlushMillis int `json:"flush_ms,omitempty"`
+}
+
+// Validate checks the sink's type and destination
+func (c SinkConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("sink name is required")
+	}
+	switch c.Type {
+	case SinkWebhook:
+		if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+			return fmt.Errorf("sink %s: url must be http(s)", c.Name)
+		}
+	case SinkTCP:
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return fmt.Errorf("sink %s: invalid address: %w", c.Name, err)
+		}
+	case SinkFile:
+		if c.Path == "" {
+			return fmt.Errorf("sink %s: path is required", c.Name)
+		}
+	default:
+		return fmt.Errorf("sink %s: unknown type %q", c.Name, c.Type)
+	}
+	if c.QueueSize < 0 || c.BatchSize < 0 || c.FlushMillis < 0 || c.RotateBytes < 0 || c.MaxFiles < 0 {
+		return fmt.Errorf("sink %s: sizes must not be negative", c.Name)
+	}
+	return nil
+}
+
+// Sink delivers batches of capture events
+type Sink interface {
+	Write(events []Event) error
+	Close() error
+}
+
+// NewSink creates the sink a config describes
+func NewSink(c SinkConfig) (Sink, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch c.Type {
+	case SinkWebhook:
+		return &webhookSink{url: c.URL, headers: c.Headers, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	case SinkTCP:
+		return &tcpSink{addr: c.Address}, nil
+	default:
+		rotate := c.RotateBytes
+		if rotate <= 0 {
+			rotate = 50 * 1024 * 1024
+		}
+		return &fileSink{path: c.Path, rotateBytes: rotate, maxFiles: c.MaxFiles}, nil
+	}
+}
+
+// SinkStats reports a sink's delivery and backpressure counters
+type SinkStats struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Queued    int    `json:"queued"`
+	QueueSize int    `json:"queue_size"`
+	Delivered int64  `json:"delivered"`
+	// Dropped counts events discarded because the queue was full, Failed
+	// those whose batch could not be delivered
+	Dropped     int64     `json:"dropped"`
+	Failed      int64     `json:"failed"`
+	Batches     int64     `json:"batches"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+}
+
+// sinkRetries is how often a failed batch is retried before it is counted
+// as failed; retries hold up the queue, which then drops new events
+const sinkRetries = 3
+
+// sinkRunner feeds one sink from a bounded queue
+type sinkRunner struct {
+	cfg      SinkConfig
+	sink     Sink
+	types    map[EventType]bool
+	mu       sync.RWMutex
+	closed   bool
+	queue    chan Event
+	done     chan struct{}
+	batch    int
+	interval time.Duration
+
+	delivered, dropped, failed, batches atomic.Int64
+	errMu                               sync.Mutex
+	lastErr                             string
+	lastErrAt                           time.Time
+}
+
+func newSinkRunner(c SinkConfig, sink Sink) *sinkRunner {
+	r := &sinkRunner{cfg: c, sink: sink, done: make(chan struct{}), batch: c.BatchSize, interval: time.Duration(c.FlushMillis) * time.Millisecond}
+	size := c.QueueSize
+	if size <= 0 {
+		size = 10000
+	}
+	r.queue = make(chan Event, size)
+	if r.batch <= 0 {
+		r.batch = 100
+	}
+	if r.interval <= 0 {
+		r.interval = time.Second
+	}
+	if len(c.Types) > 0 {
+		r.types = make(map[EventType]bool, len(c.Types))
+		for _, t := range c.Types {
+			r.types[t] = true
+		}
+	}
+	go r.run()
+	return r
+}
+
+// accepts reports whether the sink takes an entity's event
+func (r *sinkRunner) accepts(entityID string, e Event) bool {
+	if r.types != nil && !r.types[e.Type] {
+		return false
+	}
+	if len(r.cfg.Entities) == 0 {
+		return true
+	}
+	if anyGlob(r.cfg.Entities, entityID) {
+		return true
+	}
+	// per-remote IDs ({id}-r{n}) follow their entity
+	if m := perRemoteSuffix.FindStringSubmatch(entityID); m != nil {
+		return anyGlob(r.cfg.Entities, m[1])
+	}
+	return false
+}
+
+// enqueue queues an event without blocking
+func (r *sinkRunner) enqueue(e Event) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.queue <- e:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+func (r *sinkRunner) run() {
+	defer close(r.done)
+	timer := time.NewTimer(r.interval)
+	defer timer.Stop()
+	var batch []Event
+	for {
+		select {
+		case e, ok := <-r.queue:
+			if !ok {
+				r.deliver(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) < r.batch {
+				continue
+			}
+		case <-timer.C:
+		}
+		r.deliver(batch)
+		batch = nil
+		timer.Reset(r.interval)
+	}
+}
+
+func (r *sinkRunner) deliver(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	for attempt := 0; attempt <= sinkRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(100<<attempt) * time.Millisecond)
+		}
+		if err = r.sink.Write(batch); err == nil {
+			r.delivered.Add(int64(len(batch)))
+			r.batches.Add(1)
+			return
+		}
+	}
+	r.failed.Add(int64(len(batch)))
+	r.errMu.Lock()
+	r.lastErr, r.lastErrAt = err.Error(), time.Now()
+	r.errMu.Unlock()
+}
+
+func (r *sinkRunner) stats() SinkStats {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return SinkStats{
+		Name:        r.cfg.Name,
+		Type:        r.cfg.Type,
+		Queued:      len(r.queue),
+		QueueSize:   cap(r.queue),
+		Delivered:   r.delivered.Load(),
+		Dropped:     r.dropped.Load(),
+		Failed:      r.failed.Load(),
+		Batches:     r.batches.Load(),
+		LastError:   r.lastErr,
+		LastErrorAt: r.lastErrAt,
+	}
+}
+
+// Close delivers the queued events and closes the sink
+func (r *sinkRunner) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.queue)
+	r.mu.Unlock()
+	<-r.done
+	return r.sink.Close()
+}
+
+// webhookSink POSTs each batch as a JSON document
+type webhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (w *webhookSink) Write(events []Event) error {
+	body, err := json.Marshal(map[string]any{"events": events})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+	return nil
+}
+
+func (w *webhookSink) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+// tcpSink writes newline-delimited JSON events to a TCP connection,
+// redialing after a failure
+type tcpSink struct {
+	addr string
+	conn net.Conn
+}
+
+func (t *tcpSink) Write(events []Event) error {
+	if t.conn == nil {
+		conn, err := net.DialTimeout("tcp", t.addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		t.conn = conn
+	}
+	t.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := t.conn.Write(encodeLines(events)); err != nil {
+		t.conn.Close()
+		t.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (t *tcpSink) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.Close()
+}
+
+// fileSink appends newline-delimited JSON events to a file, rotating it to
+// path.N once it reaches rotateBytes and keeping the newest maxFiles
+type fileSink struct {
+	path        string
+	rotateBytes int64
+	maxFiles    int
+	f           *os.File
+	size        int64
+}
+
+func (s *fileSink) Write(events []Event) error {
+	if s.f == nil {
+		if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		s.f, s.size = f, 0
+		if st, err := f.Stat(); err == nil {
+			s.size = st.Size()
+		}
+	}
+	w := bufio.NewWriter(s.f)
+	for _, e := range events {
+		if s.size >= s.rotateBytes && s.size > 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if err := s.rotate(); err != nil {
+				return err
+			}
+			w.Reset(s.f)
+		}
+		b, _ := json.Marshal(e)
+		b = append(b, '\n')
+		n, err := w.Write(b)
+		if err != nil {
+			return err
+		}
+		s.size += int64(n)
+	}
+	return w.Flush()
+}
+
+// rotate moves the current file to the next free path.N and reopens it
+func (s *fileSink) rotate() error {
+	s.f.Close()
+	s.f = nil
+	next := 1
+	for fileExists(s.path + "." + strconv.Itoa(next)) {
+		next++
+	}
+	if err := os.Rename(s.path, s.path+"."+strconv.Itoa(next)); err != nil {
+		return err
+	}
+	if s.maxFiles > 0 {
+		for i := next - s.maxFiles; i >= 1; i-- {
+			os.Remove(s.path + "." + strconv.Itoa(i))
+		}
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	s.f, s.size = f, 0
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+func encodeLines(events []Event) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		enc.Encode(e)
+	}
+	return buf.Bytes()
+}
+
+// SetSinks replaces the capture event sinks. Events queued for the previous
+// sinks are delivered before they are closed.
+func (s *Service) SetSinks(configs []SinkConfig) error {
+	names := map[string]bool{}
+	var runners []*sinkRunner
+	for _, c := range configs {
+		if names[c.Name] {
+			return fmt.Errorf("duplicate sink name %q", c.Name)
+		}
+		names[c.Name] = true
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range configs {
+		if c.Disabled {
+			continue
+		}
+		sink, err := NewSink(c)
+		if err != nil {
+			for _, r := range runners {
+				r.Close()
+			}
+			return err
+		}
+		runners = append(runners, newSinkRunner(c, sink))
+	}
+	s.sinkMu.Lock()
+	old := s.sinks
+	s.sinks = runners
+	s.sinkConfigs = configs
+	s.sinkMu.Unlock()
+	for _, r := range old {
+		r.Close()
+	}
+	return nil
+}
+
+// Sinks returns the configured sinks and the statistics of the active ones
+func (s *Service) Sinks() ([]SinkConfig, []SinkStats) {
+	s.sinkMu.RLock()
+	defer s.sinkMu.RUnlock()
+	stats := make([]SinkStats, 0, len(s.sinks))
+	for _, r := range s.sinks {
+		stats = append(stats, r.stats())
+	}
+	return s.sinkConfigs, stats
+}
+
+// publish queues an event for every sink that takes it
+func (s *Service) publish(entityID string, e Event) {
+	s.sinkMu.RLock()
+	defer s.sinkMu.RUnlock()
+	for _, r := range s.sinks {
+		if r.accepts(entityID, e) {
+			r.enqueue(e)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSinks(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+
+	var mu sync.Mutex
+	var batches [][]Event
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Events []Event }
+		if r.Header.Get("Authorization") != "Bearer t" || json.NewDecoder(r.Body).Decode(&body) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		batches = append(batches, body.Events)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan Event, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			var e Event
+			json.Unmarshal(sc.Bytes(), &e)
+			lines <- e
+		}
+	}()
+
+	dir := t.TempDir()
+	svc := NewService(500, 64*1024)
+	if err := svc.SetSinks([]SinkConfig{{Name: "x", Type: "kafka"}}); err == nil {
+		t.Fatalf("expected an unknown sink type to be rejected")
+	}
+	if err := svc.SetSinks([]SinkConfig{
+		{Name: "hook", Type: SinkWebhook, URL: hook.URL, Headers: map[string]string{"Authorization": "Bearer t"}, BatchSize: 2, FlushMillis: 20},
+		{Name: "tcp", Type: SinkTCP, Address: ln.Addr().String(), Entities: []string{"tun-a"}, Types: []EventType{ReqHeaders}, FlushMillis: 10},
+		{Name: "file", Type: SinkFile, Path: filepath.Join(dir, "events.jsonl"), RotateBytes: 200, MaxFiles: 2, Entities: []string{"lst-*"}},
+		{Name: "off", Type: SinkFile, Path: filepath.Join(dir, "off.jsonl"), Disabled: true},
+	}); err != nil {
+		t.Fatalf("SetSinks: %v", err)
+	}
+
+	svc.AddEvent("tun-a-r0", Event{Time: time.Now(), TunnelID: "tun-a-r0", ConnID: "c1", Type: ReqHeaders, Meta: map[string]any{"seq": 1}}, 500)
+	svc.AddEvent("tun-a-r0", Event{Time: time.Now(), TunnelID: "tun-a-r0", ConnID: "c1", Type: ReqBody, Data: []byte("hi")}, 500)
+	for i := 0; i < 8; i++ {
+		svc.AddEvent("lst-b", Event{Time: time.Now(), TunnelID: "lst-b", ConnID: "c2", Type: ResBody, Data: []byte("0123456789")}, 500)
+	}
+
+	select {
+	case e := <-lines:
+		if e.TunnelID != "tun-a-r0" || e.Type != ReqHeaders {
+			t.Fatalf("unexpected tcp event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event reached the tcp sink")
+	}
+
+	_, stats := svc.Sinks()
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 active sinks, got %+v", stats)
+	}
+	if err := svc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	mu.Lock()
+	total := 0
+	for _, b := range batches {
+		if len(b) > 2 {
+			t.Fatalf("batch of %d events exceeds the batch size", len(b))
+		}
+		total += len(b)
+	}
+	mu.Unlock()
+	if total != 10 {
+		t.Fatalf("expected 10 events delivered to the webhook, got %d", total)
+	}
+
+	// The file sink rotated and kept two rotated files
+	var n int
+	for _, name := range []string{"events.jsonl", "events.jsonl.2", "events.jsonl.3"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("expected %s: %v", name, err)
+		}
+		n += len(b)
+	}
+	if fileExists(filepath.Join(dir, "events.jsonl.1")) || fileExists(filepath.Join(dir, "off.jsonl")) {
+		t.Fatalf("expected the oldest rotated file to be removed and the disabled sink to write nothing")
+	}
+	if n == 0 {
+		t.Fatalf("expected file sink output")
+	}
+}
+
+func TestSinkBackpressure(t *testing.T) {
+	block := make(chan struct{})
+	sink := &blockingSink{block: block}
+	r := newSinkRunner(SinkConfig{Name: "slow", Type: SinkFile, QueueSize: 2, BatchSize: 1}, sink)
+	for i := 0; i < 10; i++ {
+		r.enqueue(Event{Type: Metric})
+	}
+	st := r.stats()
+	if st.Dropped < 7 || st.QueueSize != 2 {
+		t.Fatalf("expected dropped events once the queue filled, got %+v", st)
+	}
+	close(block)
+	r.Close()
+	if st := r.stats(); st.Delivered+st.Dropped != 10 || st.Queued != 0 {
+		t.Fatalf("unexpected stats after close %+v", st)
+	}
+}
+
+type blockingSink struct{ block chan struct{} }
+
+func (b *blockingSink) Write([]Event) error {
+	<-b.block
+	return nil
+}
+
+func (b *blockingSink) Close() error { return nil }
@@ -0,0 +1,478 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+// Capture store kinds selected with CHISEL_CAPTURE_STORE
+const (
+	StoreJSONL    = "jsonl"
+	StoreDatabase = "db"
+	StoreS3       = "s3"
+)
+
+// CaptureStore persists captured events per entity (tunnel, listener or
+// multicast tunnel). Appends arrive in capture order from a single writer
+// goroutine; reads may run concurrently with them.
+type CaptureStore interface {
+	// Append stores a batch of events for one entity
+	Append(entityID string, events []Event) error
+	// Entities lists the entity IDs with stored events
+	Entities() ([]string, error)
+	// Connections lists the connection IDs stored for an entity
+	Connections(entityID string) ([]string, error)
+	// Events returns every stored event for an entity
+	Events(entityID string) ([]Event, error)
+	// Prune removes each entity's events captured before cutoff(entityID);
+	// a zero cutoff keeps them
+	Prune(cutoff func(entityID string) time.Time) error
+	// Close flushes buffered data and releases resources
+	Close() error
+}
+
+// storeOp is a queued append, or a flush marker when flushed is set
+type storeOp struct {
+	entityID string
+	event    Event
+	flushed  chan struct{}
+}
+
+// asyncStore queues appends so that the capture hot path never waits on
+// storage. Queued events are batched per entity by one writer goroutine;
+// events are dropped rather than blocking when the queue is full.
+type asyncStore struct {
+	CaptureStore
+	mu       sync.RWMutex
+	closed   bool
+	queue    chan storeOp
+	done     chan struct{}
+	maxBatch int
+	dropped  atomic.Int64
+	failed   atomic.Int64
+}
+
+func newAsyncStore(st CaptureStore, queueSize int) *asyncStore {
+	if queueSize <= 0 {
+		queueSize = 10000
+	}
+	a := &asyncStore{
+		CaptureStore: st,
+		queue:        make(chan storeOp, queueSize),
+		done:         make(chan struct{}),
+		maxBatch:     1000,
+	}
+	go a.run()
+	return a
+}
+
+// enqueue queues an event without blocking
+func (a *asyncStore) enqueue(entityID string, e Event) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.queue <- storeOp{entityID: entityID, event: e}:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// Flush waits until every event queued before the call has been appended
+func (a *asyncStore) Flush() {
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		return
+	}
+	ch := make(chan struct{})
+	a.queue <- storeOp{flushed: ch}
+	a.mu.RUnlock()
+	<-ch
+}
+
+func (a *asyncStore) run() {
+	defer close(a.done)
+	for op := range a.queue {
+		batch := map[string][]Event{}
+		var order []string
+		var waiters []chan struct{}
+		add := func(op storeOp) {
+			if op.flushed != nil {
+				waiters = append(waiters, op.flushed)
+				return
+			}
+			if _, ok := batch[op.entityID]; !ok {
+				order = append(order, op.entityID)
+			}
+			batch[op.entityID] = append(batch[op.entityID], op.event)
+		}
+		add(op)
+	drain:
+		for n := 1; n < a.maxBatch; n++ {
+			select {
+			case op, ok := <-a.queue:
+				if !ok {
+					break drain
+				}
+				add(op)
+			default:
+				break drain
+			}
+		}
+		for _, id := range order {
+			if err := a.CaptureStore.Append(id, batch[id]); err != nil {
+				a.failed.Add(int64(len(batch[id])))
+			}
+		}
+		for _, ch := range waiters {
+			close(ch)
+		}
+	}
+}
+
+// Close drains the queue and closes the underlying store
+func (a *asyncStore) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+	<-a.done
+	return a.CaptureStore.Close()
+}
+
+// JSONLStore keeps one JSON-lines file per connection under a directory per
+// entity, rotating files once they reach rotateBytes: <dir>/<entity>/<conn>.log
+// is the current file and <conn>.log.N are rotated ones.
+type JSONLStore struct {
+	dir         string
+	rotateBytes int64
+}
+
+// NewJSONLStore creates a JSONL store rooted at dir
+func NewJSONLStore(dir string, rotateBytes int64) *JSONLStore {
+	_ = os.MkdirAll(dir, 0o755)
+	return &JSONLStore{dir: dir, rotateBytes: rotateBytes}
+}
+
+// Append writes the events to their connection files, opening each file once per batch
+func (j *JSONLStore) Append(entityID string, events []Event) error {
+	dir := filepath.Join(j.dir, entityID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	byConn := map[string][]Event{}
+	var conns []string
+	for _, e := range events {
+		// events are filed by connection
+		if e.ConnID == "" {
+			continue
+		}
+		if _, ok := byConn[e.ConnID]; !ok {
+			conns = append(conns, e.ConnID)
+		}
+		byConn[e.ConnID] = append(byConn[e.ConnID], e)
+	}
+	var firstErr error
+	for _, connID := range conns {
+		if err := j.appendConn(dir, connID, byConn[connID]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (j *JSONLStore) appendConn(dir, connID string, events []Event) error {
+	path := filepath.Join(dir, fmt.Sprintf("%s.log", connID))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	var size int64
+	if st, err := f.Stat(); err == nil {
+		size = st.Size()
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range events {
+		// rotate if needed
+		if size >= j.rotateBytes && size > 0 {
+			if err := w.Flush(); err != nil {
+				f.Close()
+				return err
+			}
+			_ = f.Close()
+			// find next suffix
+			for i := 1; ; i++ {
+				rot := filepath.Join(dir, fmt.Sprintf("%s.log.%d", connID, i))
+				if _, err := os.Stat(rot); os.IsNotExist(err) {
+					_ = os.Rename(path, rot)
+					break
+				}
+			}
+			// reopen fresh file
+			f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				return err
+			}
+			w.Reset(f)
+			size = 0
+		}
+		b, _ := json.Marshal(e)
+		b = append(b, '\n')
+		n, _ := w.Write(b)
+		size += int64(n)
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Entities lists the entity directories
+func (j *JSONLStore) Entities() ([]string, error) {
+	ents, err := os.ReadDir(j.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	out := []string{}
+	for _, e := range ents {
+		if e.IsDir() {
+			out = append(out, e.Name())
+		}
+	}
+	return out, nil
+}
+
+// Connections returns known connection IDs for an entity based on its files
+func (j *JSONLStore) Connections(entityID string) ([]string, error) {
+	dir := filepath.Join(j.dir, entityID)
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	m := map[string]struct{}{}
+	for _, e := range ents {
+		name := e.Name()
+		if strings.HasSuffix(name, ".log") || strings.Contains(name, ".log.") {
+			base := name
+			if i := strings.Index(base, ".log"); i >= 0 {
+				base = base[:i]
+			}
+			if base != "" {
+				m[base] = struct{}{}
+			}
+		}
+	}
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Events reads every connection file of an entity, rotated files included
+func (j *JSONLStore) Events(entityID string) ([]Event, error) {
+	ids, err := j.Connections(entityID)
+	if err != nil {
+		return nil, err
+	}
+	var out []Event
+	for _, connID := range ids {
+		for _, p := range j.connectionLogFiles(entityID, connID) {
+			out = append(out, loadEventsFromFile(p)...)
+		}
+	}
+	return out, nil
+}
+
+// LatestLogFile returns the latest file path for a given connection (base or highest rotated)
+func (j *JSONLStore) LatestLogFile(entityID, connID string) (string, error) {
+	files := j.connectionLogFiles(entityID, connID)
+	if len(files) == 0 {
+		return filepath.Join(j.dir, entityID, connID+".log"), os.ErrNotExist
+	}
+	return files[len(files)-1], nil
+}
+
+// connectionLogFiles returns a connection's log files oldest first: rotated
+// files in suffix order followed by the current file
+func (j *JSONLStore) connectionLogFiles(entityID, connID string) []string {
+	dir := filepath.Join(j.dir, entityID)
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	type rotated struct {
+		i    int
+		path string
+	}
+	var rot []rotated
+	for _, e := range ents {
+		var i int
+		if _, err := fmt.Sscanf(e.Name(), connID+".log.%d", &i); err == nil && strings.HasPrefix(e.Name(), connID+".log.") {
+			rot = append(rot, rotated{i, filepath.Join(dir, e.Name())})
+		}
+	}
+	sort.Slice(rot, func(a, b int) bool { return rot[a].i < rot[b].i })
+	out := make([]string, 0, len(rot)+1)
+	for _, r := range rot {
+		out = append(out, r.path)
+	}
+	if base := filepath.Join(dir, connID+".log"); fileExists(base) {
+		out = append(out, base)
+	}
+	return out
+}
+
+// Prune deletes files last written before their entity's cutoff
+func (j *JSONLStore) Prune(cutoff func(entityID string) time.Time) error {
+	ids, err := j.Entities()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		before := cutoff(id)
+		if before.IsZero() {
+			continue
+		}
+		ents, err := os.ReadDir(filepath.Join(j.dir, id))
+		if err != nil {
+			continue
+		}
+		for _, e := range ents {
+			if info, err := e.Info(); err == nil && !info.IsDir() && info.ModTime().Before(before) {
+				_ = os.Remove(filepath.Join(j.dir, id, e.Name()))
+			}
+		}
+	}
+	return nil
+}
+
+// Close is a no-op; files are closed after every batch
+func (j *JSONLStore) Close() error { return nil }
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// loadEventsFromFile reads events from a single JSONL file
+func loadEventsFromFile(filePath string) []Event {
+	var events []Event
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return events
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err == nil {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// CaptureDB is the part of database.Database used by DatabaseStore
+type CaptureDB interface {
+	AppendCaptureEvents(records []*database.CaptureEventRecord) error
+	ListCaptureEntities() ([]string, error)
+	ListCaptureConnections(entityID string) ([]string, error)
+	ListCaptureEvents(entityID string) ([]*database.CaptureEventRecord, error)
+	DeleteCaptureEventsBefore(entityID string, cutoff time.Time) (int64, error)
+}
+
+// DatabaseStore keeps capture events in the capture_events table of the
+// server's SQLite or PostgreSQL database
+type DatabaseStore struct {
+	db CaptureDB
+}
+
+// NewDatabaseStore creates a store over db; the database is owned by the caller
+func NewDatabaseStore(db CaptureDB) *DatabaseStore {
+	return &DatabaseStore{db: db}
+}
+
+func (d *DatabaseStore) Append(entityID string, events []Event) error {
+	recs := make([]*database.CaptureEventRecord, 0, len(events))
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		recs = append(recs, &database.CaptureEventRecord{EntityID: entityID, ConnID: e.ConnID, At: e.Time, Data: string(b)})
+	}
+	return d.db.AppendCaptureEvents(recs)
+}
+
+func (d *DatabaseStore) Entities() ([]string, error) { return d.db.ListCaptureEntities() }
+
+func (d *DatabaseStore) Connections(entityID string) ([]string, error) {
+	return d.db.ListCaptureConnections(entityID)
+}
+
+func (d *DatabaseStore) Events(entityID string) ([]Event, error) {
+	rows, err := d.db.ListCaptureEvents(entityID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Event, 0, len(rows))
+	for _, r := range rows {
+		var e Event
+		if err := json.Unmarshal([]byte(r.Data), &e); err == nil {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (d *DatabaseStore) Prune(cutoff func(entityID string) time.Time) error {
+	ids, err := d.db.ListCaptureEntities()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if before := cutoff(id); !before.IsZero() {
+			if _, err := d.db.DeleteCaptureEventsBefore(id, before); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DatabaseStore) Close() error { return nil }
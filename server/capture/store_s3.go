@@ -0,0 +1,547 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// S3Config configures an S3-compatible object store. Objects are addressed
+// path-style (<endpoint>/<bucket>/<key>), which AWS, MinIO and most
+// compatible stores accept.
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to every object key
+	Prefix string
+	// SegmentBytes is the uncompressed size at which an entity's buffered
+	// events are uploaded as one segment
+	SegmentBytes int
+	// SegmentAge is the longest events stay buffered before upload
+	SegmentAge time.Duration
+	// MaxBacklogBytes bounds an entity's events held back by failed uploads,
+	// 16 segments by default. Beyond it the oldest segment is dropped.
+	MaxBacklogBytes int
+	Client          *http.Client
+	// Logf reports dropped segments; log.Printf when nil
+	Logf func(format string, args ...interface{})
+}
+
+// S3ConfigFromEnv reads CHISEL_CAPTURE_S3_* settings
+func S3ConfigFromEnv() S3Config {
+	return S3Config{
+		Endpoint:        settings.Env("CAPTURE_S3_ENDPOINT"),
+		Bucket:          settings.Env("CAPTURE_S3_BUCKET"),
+		Region:          settings.Env("CAPTURE_S3_REGION"),
+		AccessKey:       settings.Env("CAPTURE_S3_ACCESS_KEY"),
+		SecretKey:       settings.Env("CAPTURE_S3_SECRET_KEY"),
+		Prefix:          settings.Env("CAPTURE_S3_PREFIX"),
+		SegmentBytes:    settings.EnvInt("CAPTURE_S3_SEGMENT_BYTES", 4*1024*1024),
+		SegmentAge:      settings.EnvDuration("CAPTURE_S3_SEGMENT_AGE", time.Minute),
+		MaxBacklogBytes: settings.EnvInt("CAPTURE_S3_MAX_BACKLOG_BYTES", 0),
+	}
+}
+
+// S3Store batches each entity's events into gzip-compressed JSONL segments
+// and uploads them as objects named
+//
+//	<prefix><entity>/<first unix nanos>-<last unix nanos>-<random>.jsonl.gz
+//
+// so that reads list segments in time order and retention can prune by name.
+// Buffered and uploading segments are included in reads.
+type S3Store struct {
+	cfg       S3Config
+	mu        sync.Mutex
+	pending   map[string]*s3Segment
+	uploading map[*s3Segment]struct{}
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	// dropped counts segments given up on after failed uploads
+	dropped atomic.Int64
+}
+
+// s3Segment is an entity's buffered events awaiting upload
+type s3Segment struct {
+	entityID    string
+	events      []Event
+	size        int
+	first, last time.Time
+	opened      time.Time
+}
+
+// NewS3Store creates a store and starts uploading segments as they age
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("capture s3 store requires an endpoint and bucket")
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.SegmentBytes <= 0 {
+		cfg.SegmentBytes = 4 * 1024 * 1024
+	}
+	if cfg.SegmentAge <= 0 {
+		cfg.SegmentAge = time.Minute
+	}
+	if cfg.MaxBacklogBytes <= 0 {
+		cfg.MaxBacklogBytes = 16 * cfg.SegmentBytes
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 60 * time.Second}
+	}
+	if cfg.Logf == nil {
+		cfg.Logf = log.Printf
+	}
+	s := &S3Store{
+		cfg:       cfg,
+		pending:   make(map[string]*s3Segment),
+		uploading: make(map[*s3Segment]struct{}),
+		stop:      make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.ageLoop()
+	return s, nil
+}
+
+func (s *S3Store) ageLoop() {
+	defer s.wg.Done()
+	tick := s.cfg.SegmentAge / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			var due []*s3Segment
+			for id, seg := range s.pending {
+				if time.Since(seg.opened) >= s.cfg.SegmentAge {
+					due = append(due, seg)
+					delete(s.pending, id)
+					s.uploading[seg] = struct{}{}
+				}
+			}
+			s.mu.Unlock()
+			for _, seg := range due {
+				_ = s.upload(seg)
+			}
+		}
+	}
+}
+
+// Append buffers the events and uploads the entity's segment once it is full
+func (s *S3Store) Append(entityID string, events []Event) error {
+	s.mu.Lock()
+	seg := s.pending[entityID]
+	if seg == nil {
+		seg = &s3Segment{entityID: entityID, opened: time.Now()}
+		s.pending[entityID] = seg
+	}
+	for _, e := range events {
+		if seg.first.IsZero() || e.Time.Before(seg.first) {
+			seg.first = e.Time
+		}
+		if e.Time.After(seg.last) {
+			seg.last = e.Time
+		}
+		seg.events = append(seg.events, e)
+		seg.size += len(e.Data) + 256
+	}
+	if seg.size < s.cfg.SegmentBytes {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.pending, entityID)
+	s.uploading[seg] = struct{}{}
+	s.mu.Unlock()
+	return s.upload(seg)
+}
+
+// upload writes a segment that was moved to uploading. On failure its events
+// are returned to the front of the entity's pending segment for a later retry,
+// unless that would grow the entity's backlog beyond MaxBacklogBytes, in
+// which case the failed segment, the oldest, is dropped.
+func (s *S3Store) upload(seg *s3Segment) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, e := range seg.events {
+		_ = enc.Encode(e)
+	}
+	_ = zw.Close()
+	var rnd [4]byte
+	_, _ = rand.Read(rnd[:])
+	key := fmt.Sprintf("%s%s/%020d-%020d-%s.jsonl.gz", s.cfg.Prefix, seg.entityID, seg.first.UnixNano(), seg.last.UnixNano(), hex.EncodeToString(rnd[:]))
+	err := s.request(http.MethodPut, key, nil, buf.Bytes(), nil)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploading, seg)
+	if err != nil {
+		cur := s.pending[seg.entityID]
+		backlog := seg.size
+		if cur != nil {
+			backlog += cur.size
+		}
+		if backlog > s.cfg.MaxBacklogBytes {
+			n := s.dropped.Add(1)
+			s.cfg.Logf("capture: dropped %d events of %s after a failed upload (%d segments dropped so far): %v", len(seg.events), seg.entityID, n, err)
+			return err
+		}
+		if cur != nil {
+			seg.events = append(seg.events, cur.events...)
+			seg.size += cur.size
+			if cur.first.Before(seg.first) {
+				seg.first = cur.first
+			}
+			if cur.last.After(seg.last) {
+				seg.last = cur.last
+			}
+		}
+		seg.opened = time.Now()
+		s.pending[seg.entityID] = seg
+	}
+	return err
+}
+
+// Dropped returns the number of segments dropped after failed uploads
+func (s *S3Store) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// s3ListResult is the subset of a ListObjectsV2 response used here
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// list returns the keys and common prefixes under prefix
+func (s *S3Store) list(prefix, delimiter string) (keys, prefixes []string, err error) {
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			q.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		var res s3ListResult
+		err := s.request(http.MethodGet, "", q, nil, func(body io.Reader) error {
+			return xml.NewDecoder(body).Decode(&res)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range res.Contents {
+			keys = append(keys, c.Key)
+		}
+		for _, p := range res.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return keys, prefixes, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// Entities lists the entity prefixes in the bucket and entities still buffered
+func (s *S3Store) Entities() ([]string, error) {
+	_, prefixes, err := s.list(s.cfg.Prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]struct{}{}
+	for _, p := range prefixes {
+		if id := strings.TrimSuffix(strings.TrimPrefix(p, s.cfg.Prefix), "/"); id != "" {
+			m[id] = struct{}{}
+		}
+	}
+	s.mu.Lock()
+	for id := range s.pending {
+		m[id] = struct{}{}
+	}
+	for seg := range s.uploading {
+		m[seg.entityID] = struct{}{}
+	}
+	s.mu.Unlock()
+	out := make([]string, 0, len(m))
+	for id := range m {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Connections derives the connection IDs from the entity's events
+func (s *S3Store) Connections(entityID string) ([]string, error) {
+	events, err := s.Events(entityID)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]struct{}{}
+	for _, e := range events {
+		if e.ConnID != "" {
+			m[e.ConnID] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(m))
+	for id := range m {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Events downloads the entity's segments in key order, followed by events
+// that are still being uploaded or buffered
+func (s *S3Store) Events(entityID string) ([]Event, error) {
+	keys, _, err := s.list(s.cfg.Prefix+entityID+"/", "")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	var out []Event
+	for _, key := range keys {
+		err := s.request(http.MethodGet, key, nil, nil, func(body io.Reader) error {
+			zr, err := gzip.NewReader(body)
+			if err != nil {
+				return err
+			}
+			sc := bufio.NewScanner(zr)
+			sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+			for sc.Scan() {
+				var e Event
+				if json.Unmarshal(sc.Bytes(), &e) == nil {
+					out = append(out, e)
+				}
+			}
+			return sc.Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	var inflight []*s3Segment
+	for seg := range s.uploading {
+		if seg.entityID == entityID {
+			inflight = append(inflight, seg)
+		}
+	}
+	sort.Slice(inflight, func(i, j int) bool { return inflight[i].first.Before(inflight[j].first) })
+	for _, seg := range inflight {
+		out = append(out, seg.events...)
+	}
+	if seg := s.pending[entityID]; seg != nil {
+		out = append(out, seg.events...)
+	}
+	s.mu.Unlock()
+	return out, nil
+}
+
+// Prune deletes segments whose newest event is older than their entity's cutoff
+func (s *S3Store) Prune(cutoff func(entityID string) time.Time) error {
+	keys, _, err := s.list(s.cfg.Prefix, "")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		entityID, name, ok := strings.Cut(strings.TrimPrefix(key, s.cfg.Prefix), "/")
+		parts := strings.SplitN(name, "-", 3)
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		before := cutoff(entityID)
+		last, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || before.IsZero() || !time.Unix(0, last).Before(before) {
+			continue
+		}
+		if err := s.request(http.MethodDelete, key, nil, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the age loop and uploads every buffered segment
+func (s *S3Store) Close() error {
+	close(s.stop)
+	s.wg.Wait()
+	s.mu.Lock()
+	var segs []*s3Segment
+	for id, seg := range s.pending {
+		segs = append(segs, seg)
+		delete(s.pending, id)
+		s.uploading[seg] = struct{}{}
+	}
+	s.mu.Unlock()
+	var firstErr error
+	for _, seg := range segs {
+		if err := s.upload(seg); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// request sends a signed request for key (the bucket itself when empty) and
+// hands a successful response body to read
+func (s *S3Store) request(method, key string, query url.Values, body []byte, read func(io.Reader) error) error {
+	u := s.cfg.Endpoint + "/" + s3Escape(s.cfg.Bucket, false)
+	if key != "" {
+		u += "/" + s3Escape(key, false)
+	}
+	if len(query) > 0 {
+		u += "?" + s3Query(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.cfg.AccessKey != "" {
+		signV4(req, payloadHash, s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, "s3", time.Now())
+	}
+	res, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("s3 %s %s: %s: %s", method, key, res.Status, strings.TrimSpace(string(msg)))
+	}
+	if read != nil {
+		return read(res.Body)
+	}
+	return nil
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header covering the
+// host and every x-amz-* header of the request
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		if l := strings.ToLower(name); strings.HasPrefix(l, "x-amz-") {
+			headers[l] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		canonHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		s3Query(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Query encodes a query string in the canonical SigV4 form: sorted by
+// key with every value strictly escaped
+func s3Query(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything except unreserved characters, and '/'
+// unless escapeSlash is set
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,296 @@
+package capture
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+// fakeS3 is a local stand-in for an S3-compatible object store. It checks
+// payload hashes and SigV4 signatures and pages listings two keys at a time.
+type fakeS3 struct {
+	t       *testing.T
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+	puts    int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+		return
+	}
+	at, _ := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	check.Header.Set("X-Amz-Content-Sha256", r.Header.Get("X-Amz-Content-Sha256"))
+	signV4(check, hex.EncodeToString(sum[:]), "AKID", "SECRET", "us-east-1", "s3", at)
+	if check.Header.Get("Authorization") != r.Header.Get("Authorization") {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket)
+	if !ok {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(path, "/")
+	switch {
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		f.puts++
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case key == "":
+		q := r.URL.Query()
+		prefix, delim, after := q.Get("prefix"), q.Get("delimiter"), q.Get("continuation-token")
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		type content struct {
+			Key string `xml:"Key"`
+		}
+		type common struct {
+			Prefix string `xml:"Prefix"`
+		}
+		var res struct {
+			XMLName        xml.Name  `xml:"ListBucketResult"`
+			Contents       []content `xml:"Contents"`
+			CommonPrefixes []common  `xml:"CommonPrefixes"`
+			IsTruncated    bool      `xml:"IsTruncated"`
+			Next           string    `xml:"NextContinuationToken,omitempty"`
+		}
+		seen := map[string]bool{}
+		for _, k := range keys {
+			if delim != "" {
+				if i := strings.Index(k[len(prefix):], delim); i >= 0 {
+					if p := k[:len(prefix)+i+1]; !seen[p] {
+						seen[p] = true
+						res.CommonPrefixes = append(res.CommonPrefixes, common{p})
+					}
+					continue
+				}
+			}
+			if k <= after {
+				continue
+			}
+			if len(res.Contents) == 2 {
+				res.IsTruncated, res.Next = true, res.Contents[1].Key
+				break
+			}
+			res.Contents = append(res.Contents, content{k})
+		}
+		xml.NewEncoder(w).Encode(res)
+	default:
+		b, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+func TestCaptureStores(t *testing.T) {
+	s3 := &fakeS3{t: t, bucket: "captures", objects: map[string][]byte{}}
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	db := database.NewDatabase(&database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(t.TempDir(), "test.db")})
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	stores := map[string]func() CaptureStore{
+		StoreJSONL: func() CaptureStore { return NewJSONLStore(t.TempDir(), 300) },
+		StoreDatabase: func() CaptureStore {
+			return NewDatabaseStore(db)
+		},
+		StoreS3: func() CaptureStore {
+			st, err := NewS3Store(S3Config{Endpoint: srv.URL, Bucket: "captures", AccessKey: "AKID", SecretKey: "SECRET", Prefix: "chissl/", SegmentBytes: 600, SegmentAge: time.Hour})
+			if err != nil {
+				t.Fatalf("NewS3Store: %v", err)
+			}
+			return st
+		},
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for kind, open := range stores {
+		t.Run(kind, func(t *testing.T) {
+			st := open()
+			var want []Event
+			for i := 0; i < 8; i++ {
+				e := Event{Time: old.Add(time.Duration(i) * time.Second), TunnelID: "tun-a", ConnID: []string{"c1", "c2"}[i%2], Type: ReqBody, Data: []byte(strings.Repeat("x", 100))}
+				want = append(want, e)
+				if err := st.Append("tun-a", []Event{e}); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+			fresh := Event{Time: time.Now(), TunnelID: "lst-b", ConnID: "c9", Type: ConnOpen, Meta: map[string]any{"seq": 1}}
+			if err := st.Append("lst-b", []Event{fresh}); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+
+			if ids, err := st.Entities(); err != nil || strings.Join(ids, ",") != "lst-b,tun-a" {
+				t.Fatalf("Entities = %v, %v", ids, err)
+			}
+			if ids, err := st.Connections("tun-a"); err != nil || strings.Join(ids, ",") != "c1,c2" {
+				t.Fatalf("Connections = %v, %v", ids, err)
+			}
+			got, err := st.Events("tun-a")
+			if err != nil || len(got) != len(want) {
+				t.Fatalf("Events returned %d events, %v", len(got), err)
+			}
+			sort.SliceStable(got, func(i, j int) bool { return got[i].Time.Before(got[j].Time) })
+			for i := range want {
+				if !got[i].Time.Equal(want[i].Time) || got[i].ConnID != want[i].ConnID || !bytes.Equal(got[i].Data, want[i].Data) {
+					t.Fatalf("event %d = %+v, want %+v", i, got[i], want[i])
+				}
+			}
+
+			// Close flushes buffered data; old events are pruned
+			if err := st.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if err := st.Prune(func(string) time.Time { return time.Now().Add(-24 * time.Hour) }); err != nil {
+				t.Fatalf("Prune: %v", err)
+			}
+			if kind == StoreJSONL {
+				// files are pruned by modification time
+				return
+			}
+			if got, _ := st.Events("tun-a"); len(got) != 0 {
+				t.Fatalf("expected old events to be pruned, got %d", len(got))
+			}
+			if got, _ := st.Events("lst-b"); len(got) != 1 || got[0].Meta.(map[string]any)["seq"] != float64(1) {
+				t.Fatalf("expected the recent event to survive, got %+v", got)
+			}
+		})
+	}
+
+	// Segments are batched and compressed
+	if s3.puts < 2 {
+		t.Fatalf("expected several segments, got %d uploads", s3.puts)
+	}
+	for key, b := range s3.objects {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil || !strings.HasPrefix(key, "chissl/lst-b/") || !strings.HasSuffix(key, ".jsonl.gz") {
+			t.Fatalf("unexpected object %q: %v", key, err)
+		}
+		if lines, _ := io.ReadAll(zr); bytes.Count(lines, []byte("\n")) != 1 {
+			t.Fatalf("unexpected segment contents %q", lines)
+		}
+	}
+}
+
+func TestServiceStore(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "true")
+	t.Setenv("CHISEL_CAPTURE_DIR", t.TempDir())
+	svc := NewService(500, 64*1024)
+	if svc.StoreKind() != StoreJSONL || !svc.Persisting() {
+		t.Fatalf("expected the default jsonl store, got %q", svc.StoreKind())
+	}
+	for i := 0; i < 100; i++ {
+		svc.AddEvent("t", Event{Time: time.Now(), TunnelID: "t", ConnID: "c1", Type: ReqBody, Data: []byte{byte(i)}}, 500)
+	}
+	// Reads wait for queued writes
+	if got := svc.Events("t"); len(got) != 100 || got[99].Data[0] != 99 {
+		t.Fatalf("expected 100 persisted events in order, got %d", len(got))
+	}
+	if p, err := svc.LatestLogFile("t", "c1"); err != nil || filepath.Base(p) != "c1.log" {
+		t.Fatalf("LatestLogFile = %q, %v", p, err)
+	}
+	if err := svc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A database store is set once the database is available, loading what it holds
+	t.Setenv("CHISEL_CAPTURE_STORE", "db")
+	svc = NewService(500, 64*1024)
+	if svc.StoreKind() != StoreDatabase || svc.Persisting() {
+		t.Fatalf("expected a pending database store")
+	}
+	db := database.NewDatabase(&database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(t.TempDir(), "test.db")})
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	NewDatabaseStore(db).Append("t", []Event{{Time: time.Now(), TunnelID: "t", ConnID: "c1", Type: ConnOpen}})
+	svc.SetStore(NewDatabaseStore(db))
+	if got := svc.GetRecent("t", 500); len(got) != 1 {
+		t.Fatalf("expected the stored event in the ring, got %d", len(got))
+	}
+	if _, err := svc.LatestLogFile("t", "c1"); err == nil {
+		t.Fatalf("expected no log file for the database store")
+	}
+	svc.Close()
+}
+
+func TestSignV4(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	sum := sha256.Sum256(nil)
+	signV4(req, hex.EncodeToString(sum[:]), "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Authorization = %s", got)
+	}
+}
+
+func TestS3StoreBacklog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "SlowDown", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	var logged []string
+	st, err := NewS3Store(S3Config{Endpoint: srv.URL, Bucket: "captures", SegmentBytes: 600, MaxBacklogBytes: 2000, SegmentAge: time.Hour,
+		Logf: func(format string, args ...interface{}) { logged = append(logged, fmt.Sprintf(format, args...)) }})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	defer st.Close()
+	for i := 0; i < 20; i++ {
+		st.Append("tun-a", []Event{{Time: time.Now(), TunnelID: "tun-a", ConnID: "c1", Type: ReqBody, Data: []byte(strings.Repeat("x", 100))}})
+	}
+	// Failed segments are kept for a retry up to the backlog limit
+	st.mu.Lock()
+	backlog := st.pending["tun-a"]
+	st.mu.Unlock()
+	if backlog == nil || backlog.size > 2000 {
+		t.Fatalf("expected a bounded backlog, got %+v", backlog)
+	}
+	if st.Dropped() == 0 || len(logged) != int(st.Dropped()) || !strings.Contains(logged[0], "tun-a") {
+		t.Fatalf("expected dropped segments to be counted and logged, got %d: %q", st.Dropped(), logged)
+	}
+}
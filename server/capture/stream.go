@@ -0,0 +1,89 @@
+package capture
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LastEventID returns the ID of an entity's latest event
+func (s *Service) LastEventID(entityID string) uint64 {
+	return s.ring(entityID, 500).LastID()
+}
+
+// EventsSince returns an entity's events with IDs above lastID in ID order,
+// from the ring when it still holds them and from the store otherwise, and
+// how many of them could not be recovered
+func (s *Service) EventsSince(entityID string, lastID uint64) ([]Event, uint64) {
+	ring := s.ring(entityID, 500)
+	latest := ring.LastID()
+	if latest <= lastID {
+		return nil, 0
+	}
+	events := ring.Snapshot()
+	if len(events) == 0 || events[0].ID > lastID+1 {
+		if s.store.Load() != nil {
+			events = s.Events(entityID)
+		}
+	}
+	out := make([]Event, 0, len(events))
+	for _, e := range events {
+		if e.ID > lastID && e.ID <= latest {
+			out = append(out, e)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, latest - lastID - uint64(len(out))
+}
+
+// StreamFilter selects the events of a capture stream by type, connection
+// and request path. A zero StreamFilter selects every event.
+type StreamFilter struct {
+	Types []EventType
+	Conns []string
+	// Paths match request paths ("*" wildcards, query excluded); the events
+	// of the exchanges they select are kept
+	Paths []string
+
+	exchanges map[string]bool
+}
+
+// Match reports whether e passes the filter. Events must be matched in
+// stream order: path filters follow exchanges from their request headers.
+func (f *StreamFilter) Match(e Event) bool {
+	if len(f.Conns) > 0 && !slices.Contains(f.Conns, e.ConnID) {
+		return false
+	}
+	if len(f.Paths) > 0 && !f.matchPath(e) {
+		return false
+	}
+	return len(f.Types) == 0 || slices.Contains(f.Types, e.Type)
+}
+
+// matchPath reports whether e belongs to an exchange selected by Paths
+func (f *StreamFilter) matchPath(e Event) bool {
+	seq, ok := metaInt(e.Meta, "seq")
+	if !ok {
+		if e.Type == ConnClose {
+			for k := range f.exchanges {
+				if strings.HasPrefix(k, e.ConnID+"|") {
+					delete(f.exchanges, k)
+				}
+			}
+		}
+		return false
+	}
+	key := e.ConnID + "|" + strconv.FormatInt(seq, 10)
+	if e.Type == ReqHeaders {
+		m, _ := e.Meta.(map[string]any)
+		path, _ := m["path"].(string)
+		keep := (&connPolicy{include: f.Paths}).keepPath(path)
+		if f.exchanges == nil {
+			f.exchanges = make(map[string]bool)
+		}
+		f.exchanges[key] = keep
+		return keep
+	}
+	return f.exchanges[key]
+}
@@ -0,0 +1,85 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/tunnel"
+)
+
+func TestEventsSince(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "true")
+	t.Setenv("CHISEL_CAPTURE_DIR", t.TempDir())
+	svc := NewService(500, 64*1024)
+	for i := 0; i < 20; i++ {
+		svc.AddEvent("t", Event{Time: time.Now(), TunnelID: "t", ConnID: "c1", Type: ReqBody}, 5)
+	}
+	if id := svc.LastEventID("t"); id != 20 {
+		t.Fatalf("LastEventID = %d", id)
+	}
+	ids := func(events []Event) (out []uint64) {
+		for _, e := range events {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+
+	// Recent events come from the ring, older ones from the store
+	if got, missed := svc.EventsSince("t", 17); missed != 0 || len(got) != 3 || got[0].ID != 18 {
+		t.Fatalf("EventsSince(17) = %v, %d missed", ids(got), missed)
+	}
+	if got, missed := svc.EventsSince("t", 2); missed != 0 || len(got) != 18 || got[0].ID != 3 || got[17].ID != 20 {
+		t.Fatalf("EventsSince(2) = %v, %d missed", ids(got), missed)
+	}
+	if got, missed := svc.EventsSince("t", 20); missed != 0 || len(got) != 0 {
+		t.Fatalf("expected nothing after the latest event, got %v", ids(got))
+	}
+	svc.Close()
+
+	// IDs continue after a restart; without a store, lost events are counted
+	svc = NewService(500, 64*1024)
+	svc.AddEvent("t", Event{Time: time.Now(), TunnelID: "t", ConnID: "c1", Type: ReqBody}, 5)
+	if id := svc.LastEventID("t"); id != 21 {
+		t.Fatalf("expected IDs to continue after a restart, got %d", id)
+	}
+	svc.Close()
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	mem := NewService(500, 64*1024)
+	for i := 0; i < 10; i++ {
+		mem.AddEvent("t", Event{Time: time.Now(), TunnelID: "t", ConnID: "c1", Type: ReqBody}, 5)
+	}
+	if got, missed := mem.EventsSince("t", 2); missed != 3 || len(got) != 5 || got[0].ID != 6 {
+		t.Fatalf("EventsSince(2) = %v, %d missed", ids(got), missed)
+	}
+}
+
+func TestStreamFilter(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+	for _, c := range []struct{ conn, path string }{{"c1", "/api/items?x=1"}, {"c2", "/health"}} {
+		tap := newTap(svc, "t", 500, tunnel.Meta{ConnID: c.conn}, nil)
+		tap.OnOpen()
+		tap.SrcWriter().Write([]byte("POST " + c.path + " HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\n\r\nhi"))
+		tap.DstWriter().Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+		tap.OnClose(0, 0)
+	}
+	match := func(f *StreamFilter) string {
+		var out []string
+		for _, e := range svc.GetRecent("t", 500) {
+			if f.Match(e) {
+				out = append(out, e.ConnID+":"+string(e.Type))
+			}
+		}
+		return strings.Join(out, ",")
+	}
+	if got := match(&StreamFilter{Paths: []string{"/api/*"}, Types: []EventType{ReqBody, ResBody}}); got != "c1:req_body,c1:res_body" {
+		t.Fatalf("unexpected path and type match %s", got)
+	}
+	if got := match(&StreamFilter{Conns: []string{"c2"}, Types: []EventType{ReqHeaders}}); got != "c2:req_headers" {
+		t.Fatalf("unexpected connection match %s", got)
+	}
+	if got := match(&StreamFilter{}); strings.Count(got, ",") < 11 {
+		t.Fatalf("expected every event to match, got %s", got)
+	}
+}
@@ -1,112 +1,474 @@
 package capture
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
 	"io"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/NextChapterSoftware/chissl/share/settings"
 )
 
-// HTTP-aware tap that parses request/response when possible and publishes events to the Service.
-
-const maxPeek = 4096
+// HTTP-aware tap that parses request/response exchanges and publishes events to the Service.
+//
+// Each direction is fed through an incremental HTTP/1.x parser, so keep-alive
+// connections, pipelined requests, chunked bodies and header blocks split
+// across packets produce one ReqHeaders/ResHeaders event per message. Every
+// message carries a per-connection sequence number ("seq") pairing a response
+// with its request; body events hold de-chunked bytes and the last one of a
+// message is flagged with "end" and carries the decoded view of the whole
+// body (see DecodeBody). When redaction rules apply to bodies, a message's
+// body is held until it ends and emitted as a single event, so the rules see
+// secrets split across writes and compressed bodies as a whole. After an accepted websocket upgrade both directions
+// are decoded as frames and emitted as WSMessage events. Other non-HTTP
+// traffic is emitted as raw chunks.
 
 type TapImpl struct {
 	svc       *Service
 	meta      Meta
 	tunnelID  string
 	maxEvents int
-	// state
-	emittedReqHeaders bool
-	emittedResHeaders bool
+	// policy filters what is captured; nil captures everything
+	policy *connPolicy
+
+	mu       sync.Mutex
+	src, dst *dirWriter
+	// sequence numbers of requests seen so far and of those awaiting a response
+	reqSeq  int
+	pending []pendingRequest
+	resSeq  int
+	// ws is set once a websocket upgrade was accepted
+	ws *wsExtensions
+	// skipped holds the sequence numbers of exchanges excluded by path
+	skipped map[int]bool
+	// accepted and channelOpen time the connection; timings holds those of
+	// exchanges still in progress
+	accepted, channelOpen time.Time
+	timings               map[int]*ExchangeTiming
+}
+
+type pendingRequest struct {
+	seq    int
+	method string
+}
+
+// RequestHead is the Data payload of a ReqHeaders event
+type RequestHead struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Proto  string      `json:"proto"`
+	Host   string      `json:"host"`
+	Header http.Header `json:"header"`
+}
+
+// ResponseHead is the Data payload of a ResHeaders event
+type ResponseHead struct {
+	Status string      `json:"status"`
+	Code   int         `json:"code"`
+	Proto  string      `json:"proto"`
+	Header http.Header `json:"header"`
 }
 
 type Meta struct {
 	Username string
 	Remote   settings.Remote
 	ConnID   string
+	// Peer is the address of the connecting client, when known
+	Peer string
+	// ReplayOf links a replayed exchange to the captured request it resends
+	ReplayOf string
+	// Accepted is when the connection was accepted, when known
+	Accepted time.Time
+}
+
+func (t *TapImpl) emit(typ EventType, meta map[string]any, data []byte) {
+	t.emitEvent(typ, meta, data, false)
+}
+
+// emitEvent publishes an event unless it belongs to an exchange excluded by path
+func (t *TapImpl) emitEvent(typ EventType, meta map[string]any, data []byte, truncated bool) {
+	if seq, ok := meta["seq"].(int); ok && t.policy != nil {
+		t.mu.Lock()
+		skip := t.skipped[seq]
+		t.mu.Unlock()
+		if skip {
+			return
+		}
+	}
+	meta["conn_id"] = t.meta.ConnID
+	if t.meta.ReplayOf != "" {
+		meta["replay_of"] = t.meta.ReplayOf
+	}
+	t.svc.AddEvent(t.tunnelID, Event{Time: time.Now(), TunnelID: t.tunnelID, User: t.meta.Username, ConnID: t.meta.ConnID, Type: typ, Meta: meta, Data: data, Truncated: truncated}, t.maxEvents)
 }
 
 func (t *TapImpl) OnOpen() {
-	t.svc.AddEvent(t.tunnelID, Event{Time: time.Now(), TunnelID: t.tunnelID, User: t.meta.Username, ConnID: t.meta.ConnID, Type: ConnOpen, Meta: map[string]any{"conn_id": t.meta.ConnID}}, t.maxEvents)
+	if t.policy.disabled() {
+		// Uncaptured connections still count towards the entity's metrics
+		if t.svc != nil && t.svc.onConnDelta != nil {
+			t.svc.onConnDelta(t.tunnelID, +1)
+		}
+		return
+	}
+	t.mu.Lock()
+	t.accepted = t.meta.Accepted
+	if t.accepted.IsZero() {
+		t.accepted = time.Now()
+	}
+	t.mu.Unlock()
+	meta := map[string]any{}
+	if t.meta.Peer != "" {
+		meta["peer"] = t.meta.Peer
+	}
+	if t.meta.Remote.RemotePort != "" {
+		meta["target"] = t.meta.Remote.Remote()
+	}
+	t.emit(ConnOpen, meta, nil)
 	if t.svc != nil && t.svc.onConnDelta != nil {
 		t.svc.onConnDelta(t.tunnelID, +1)
 	}
 }
 
 func (t *TapImpl) OnClose(sent, received int64) {
+	if t.policy.disabled() {
+		if t.svc != nil && t.svc.onMetric != nil {
+			t.svc.onMetric(t.tunnelID, sent, received)
+		}
+		if t.svc != nil && t.svc.onConnDelta != nil {
+			t.svc.onConnDelta(t.tunnelID, -1)
+		}
+		return
+	}
+	// Bodies delimited by connection close end here
+	t.mu.Lock()
+	writers := []*dirWriter{t.src, t.dst}
+	t.mu.Unlock()
+	for _, w := range writers {
+		if w != nil {
+			w.mu.Lock()
+			w.stream.close()
+			w.flushHeld()
+			w.mu.Unlock()
+		}
+	}
+	// Exchanges cut short by the close get their timing now
+	now := time.Now()
+	t.mu.Lock()
+	var open []*ExchangeTiming
+	for _, tm := range t.timings {
+		tm.Closed = &now
+		open = append(open, tm)
+	}
+	t.timings = nil
+	t.mu.Unlock()
+	sort.Slice(open, func(i, j int) bool { return open[i].seq < open[j].seq })
+	for _, tm := range open {
+		t.emitTiming(tm)
+	}
 	// Emit metrics and conn close
-	t.svc.AddEvent(t.tunnelID, Event{Time: time.Now(), TunnelID: t.tunnelID, User: t.meta.Username, ConnID: t.meta.ConnID, Type: Metric, Meta: map[string]any{"conn_id": t.meta.ConnID, "sent": sent, "received": received}}, t.maxEvents)
+	t.emit(Metric, map[string]any{"sent": sent, "received": received}, nil)
 	if t.svc != nil && t.svc.onMetric != nil {
 		t.svc.onMetric(t.tunnelID, sent, received)
 	}
-	t.svc.AddEvent(t.tunnelID, Event{Time: time.Now(), TunnelID: t.tunnelID, User: t.meta.Username, ConnID: t.meta.ConnID, Type: ConnClose, Meta: map[string]any{"conn_id": t.meta.ConnID}}, t.maxEvents)
+	t.emit(ConnClose, map[string]any{}, nil)
 	if t.svc != nil && t.svc.onConnDelta != nil {
 		t.svc.onConnDelta(t.tunnelID, -1)
 	}
 }
 
+// OnChannelOpen records when the SSH channel of the connection opened
+func (t *TapImpl) OnChannelOpen() {
+	t.mu.Lock()
+	t.channelOpen = time.Now()
+	t.mu.Unlock()
+}
+
+// emitTiming publishes the timing of an exchange
+func (t *TapImpl) emitTiming(tm *ExchangeTiming) {
+	j, _ := json.Marshal(tm)
+	meta := map[string]any{"seq": tm.seq}
+	for phase, d := range tm.Phases() {
+		meta[phase+"_ms"] = harMillis(d)
+	}
+	t.emit(Timing, meta, j)
+}
+
 // Implement separate writers for src and dst directions
 
 type dirWriter struct {
 	t *TapImpl
 	// true if src->dst (client to upstream); false for dst->src (upstream to client)
-	src bool
+	src    bool
+	mu     sync.Mutex
+	stream *httpStream
+	// state of the message currently being parsed in this direction
+	seq      int
+	response bool
+	seenHTTP bool
+	header   http.Header
+	ws       *wsDecoder
+	wsFailed bool
+	// body of the current message, kept up to the capture size limit for decoding
+	body          []byte
+	bodyTruncated bool
+	// kept counts the body bytes of the current message captured under the policy limit
+	kept int
+	// held is set while body bytes are kept back for redaction
+	held bool
+	// interim is set while the current message is a 1xx response
+	interim bool
+	// start is when the first byte of the current message arrived, zero
+	// between messages; now is the time of the write being parsed
+	start, now time.Time
 }
 
 // SrcWriter receives bytes from client -> upstream
-func (t *TapImpl) SrcWriter() io.Writer { return &dirWriter{t: t, src: true} }
+func (t *TapImpl) SrcWriter() io.Writer {
+	if t.policy.disabled() {
+		return io.Discard
+	}
+	return t.writer(true)
+}
 
 // DstWriter receives bytes from upstream -> client
-func (t *TapImpl) DstWriter() io.Writer { return &dirWriter{t: t, src: false} }
+func (t *TapImpl) DstWriter() io.Writer {
+	if t.policy.disabled() {
+		return io.Discard
+	}
+	return t.writer(false)
+}
+
+// writer returns the per-direction writer, so repeated calls share parser state
+func (t *TapImpl) writer(src bool) *dirWriter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := &t.dst
+	if src {
+		w = &t.src
+	}
+	if *w == nil {
+		*w = &dirWriter{t: t, src: src, response: !src}
+		(*w).stream = newHTTPStream(*w)
+	}
+	return *w
+}
 
 func (w *dirWriter) Write(p []byte) (int, error) {
-	// Best-effort: parse HTTP headers once per direction
-	if isLikelyHTTP(p) {
-		br := bufio.NewReader(bytes.NewReader(p))
-		if w.src && !w.t.emittedReqHeaders {
-			if req, err := http.ReadRequest(br); err == nil {
-				j, _ := json.Marshal(map[string]any{
-					"method": req.Method,
-					"path":   req.URL.String(),
-					"header": req.Header,
-				})
-				w.t.svc.AddEvent(w.t.tunnelID, Event{Time: time.Now(), TunnelID: w.t.tunnelID, User: w.t.meta.Username, ConnID: w.t.meta.ConnID, Type: ReqHeaders, Meta: map[string]any{"conn_id": w.t.meta.ConnID, "method": req.Method, "path": req.URL.String()}, Data: j}, w.t.maxEvents)
-				w.t.emittedReqHeaders = true
-			}
-		} else if !w.src && !w.t.emittedResHeaders {
-			if res, err := http.ReadResponse(br, nil); err == nil {
-				j, _ := json.Marshal(map[string]any{
-					"status": res.Status,
-					"code":   res.StatusCode,
-					"header": res.Header,
-				})
-				w.t.svc.AddEvent(w.t.tunnelID, Event{Time: time.Now(), TunnelID: w.t.tunnelID, User: w.t.meta.Username, ConnID: w.t.meta.ConnID, Type: ResHeaders, Meta: map[string]any{"conn_id": w.t.meta.ConnID, "status": res.Status, "code": res.StatusCode}, Data: j}, w.t.maxEvents)
-				w.t.emittedResHeaders = true
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.now = time.Now()
+	if w.start.IsZero() {
+		w.start = w.now
+	}
+	w.stream.write(p)
+	// A message ending in p may be followed by the start of the next one
+	if w.start.IsZero() && len(w.stream.buf) > 0 {
+		w.start = w.now
+	}
+	return len(p), nil
+}
+
+// firstByte returns when the first byte of the current message arrived
+func (w *dirWriter) firstByte() time.Time {
+	if w.start.IsZero() {
+		return w.now
+	}
+	return w.start
+}
+
+// onEnd completes the timing of the message's exchange
+func (w *dirWriter) onEnd() {
+	t := w.t
+	w.start = time.Time{}
+	now := time.Now()
+	t.mu.Lock()
+	tm := t.timings[w.seq]
+	if tm == nil || w.interim {
+		t.mu.Unlock()
+		return
+	}
+	if !w.response {
+		tm.RequestEnd = &now
+		t.mu.Unlock()
+		return
+	}
+	tm.ResponseEnd = &now
+	delete(t.timings, w.seq)
+	t.mu.Unlock()
+	t.emitTiming(tm)
+}
+
+// onHeaders assigns the message a sequence number and emits its header event.
+// Messages are classified by their start line rather than by direction, so
+// synthetic captures that write requests to either side still pair up.
+func (w *dirWriter) onHeaders(m *httpMessage) string {
+	t := w.t
+	w.seenHTTP = true
+	w.body, w.bodyTruncated, w.kept, w.interim, w.held = nil, false, 0, false, false
+	first := w.firstByte()
+	if req := m.Request; req != nil {
+		w.response = false
+		w.header = req.Header
+		t.mu.Lock()
+		t.reqSeq++
+		w.seq = t.reqSeq
+		t.pending = append(t.pending, pendingRequest{seq: w.seq, method: req.Method})
+		if t.timings == nil {
+			t.timings = make(map[int]*ExchangeTiming)
+		}
+		t.timings[w.seq] = newExchangeTiming(w.seq, t.accepted, t.channelOpen, first)
+		if !t.policy.keepPath(req.URL.Path) {
+			if t.skipped == nil {
+				t.skipped = make(map[int]bool)
 			}
+			t.skipped[w.seq] = true
 		}
+		t.mu.Unlock()
+
+		j, _ := json.Marshal(RequestHead{Method: req.Method, Path: req.URL.String(), Proto: req.Proto, Host: req.Host, Header: req.Header})
+		t.emit(ReqHeaders, map[string]any{"seq": w.seq, "method": req.Method, "path": req.URL.String(), "offset": m.Offset, "header_bytes": m.HeaderBytes}, j)
+		return ""
 	}
-	// Always emit raw chunk (truncated)
-	cp := make([]byte, len(p))
-	copy(cp, p)
+
+	res := m.Response
+	w.response = true
+	w.header = res.Header
+	interim := res.StatusCode >= 100 && res.StatusCode < 200 && res.StatusCode != http.StatusSwitchingProtocols
+	method := ""
+	t.mu.Lock()
+	if len(t.pending) > 0 {
+		w.seq, method = t.pending[0].seq, t.pending[0].method
+		// Interim (1xx) responses precede the final response to the same request
+		if !interim {
+			t.pending = t.pending[1:]
+		}
+	} else {
+		w.seq = t.resSeq + 1
+	}
+	if !interim {
+		t.resSeq = w.seq
+	}
+	w.interim = interim
+	if tm := t.timings[w.seq]; tm != nil && tm.ResponseStart == nil {
+		tm.ResponseStart = &first
+	}
+	if ext, ok := websocketUpgrade(res); ok {
+		t.ws = ext
+	}
+	t.mu.Unlock()
+
+	j, _ := json.Marshal(ResponseHead{Status: res.Status, Code: res.StatusCode, Proto: res.Proto, Header: res.Header})
+	meta := map[string]any{"seq": w.seq, "status": res.Status, "code": res.StatusCode, "offset": m.Offset, "header_bytes": m.HeaderBytes}
+	if interim {
+		meta["interim"] = true
+	}
+	t.emit(ResHeaders, meta, j)
+	return method
+}
+
+func (w *dirWriter) onBody(data []byte, end bool, wireBytes int64) {
 	etype := ReqBody
-	if !w.src {
+	if w.response {
 		etype = ResBody
 	}
-	w.t.svc.AddEvent(w.t.tunnelID, Event{Time: time.Now(), TunnelID: w.t.tunnelID, User: w.t.meta.Username, ConnID: w.t.meta.ConnID, Type: etype, Meta: map[string]any{"conn_id": w.t.meta.ConnID}, Data: cp}, w.t.maxEvents)
-	return len(p), nil
+	if w.t.policy.bodiesOmitted() {
+		// Headers-only capture keeps the end of the message and its size
+		if end {
+			w.t.emitEvent(etype, map[string]any{"seq": w.seq, "end": true, "body_bytes": wireBytes, "omitted": true}, nil, wireBytes > 0)
+		}
+		return
+	}
+	data, capped := w.t.policy.capBody(w.kept, data)
+	w.kept += len(data)
+	if capped {
+		w.bodyTruncated = true
+		if len(data) == 0 && !end {
+			return
+		}
+	}
+	if room := w.t.svc.maxBytes - len(w.body); room < len(data) {
+		w.body = append(w.body, data[:max(room, 0)]...)
+		w.bodyTruncated = true
+	} else {
+		w.body = append(w.body, data...)
+	}
+	meta := map[string]any{"seq": w.seq}
+	if !w.t.svc.redactsBodies(w.t.tunnelID) {
+		data = append([]byte(nil), data...)
+	} else {
+		if !end {
+			w.held = true
+			return
+		}
+		data, capped = w.body, w.bodyTruncated
+		w.held = false
+		// the whole body is in data, so the redactor can decode it
+		if enc := w.header.Get("Content-Encoding"); len(contentCodings(enc)) > 0 {
+			meta["content_encoding"] = enc
+		}
+	}
+	if end {
+		meta["end"] = true
+		meta["body_bytes"] = wireBytes
+		if len(w.body) > 0 {
+			meta["decoded"] = DecodeBody(w.header, w.body, w.bodyTruncated)
+		}
+		w.body, w.bodyTruncated = nil, false
+	}
+	w.t.emitEvent(etype, meta, data, capped)
+}
+
+// flushHeld emits the body bytes held for redaction of a message cut short
+func (w *dirWriter) flushHeld() {
+	if !w.held {
+		return
+	}
+	etype := ReqBody
+	if w.response {
+		etype = ResBody
+	}
+	w.t.emitEvent(etype, map[string]any{"seq": w.seq}, w.body, w.bodyTruncated)
+	w.body, w.bodyTruncated, w.held = nil, false, false
 }
 
-func isLikelyHTTP(b []byte) bool {
-	bb := bytes.ToUpper(bytes.TrimSpace(b))
-	for _, m := range [][]byte{[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("PATCH "), []byte("DELETE "), []byte("HEAD "), []byte("OPTIONS "), []byte("HTTP/")} {
-		if bytes.HasPrefix(bb, m) {
-			return true
+func (w *dirWriter) onOpaque(data []byte) {
+	// Opaque bytes keep the direction's role once HTTP was seen (e.g. after an upgrade)
+	if w.t.policy.bodiesOmitted() {
+		return
+	}
+	etype := ReqBody
+	if (w.seenHTTP && w.response) || (!w.seenHTTP && !w.src) {
+		etype = ResBody
+	}
+	if w.ws == nil && !w.wsFailed && w.seenHTTP {
+		w.t.mu.Lock()
+		ext := w.t.ws
+		w.t.mu.Unlock()
+		if ext != nil {
+			direction := "client_to_server"
+			if etype == ResBody {
+				direction = "server_to_client"
+			}
+			w.ws = newWSDecoder(ext, etype == ReqBody, func(m *wsMessage) {
+				payload, capped := w.t.policy.capBody(0, m.payload)
+				w.t.emitEvent(WSMessage, m.meta(direction), payload, capped)
+			})
 		}
 	}
-	return false
+	if w.ws != nil {
+		rest, err := w.ws.write(data)
+		if err == nil {
+			return
+		}
+		// Not websocket after all; keep the remainder as raw bytes
+		w.ws, w.wsFailed = nil, true
+		data = rest
+	}
+	if len(data) == 0 {
+		return
+	}
+	data, capped := w.t.policy.capBody(0, data)
+	w.t.emitEvent(etype, map[string]any{"raw": true}, append([]byte(nil), data...), capped)
 }
@@ -0,0 +1,214 @@
+package capture
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+)
+
+// Latency phases of an exchange
+const (
+	// PhaseConnect runs from accepting the connection to its SSH channel
+	// being open: a round trip over the SSH hop plus the client's dial of
+	// the target
+	PhaseConnect = "connect"
+	// PhaseRequest runs from the first to the last request byte
+	PhaseRequest = "request"
+	// PhaseWait runs from the last request byte to the first response byte:
+	// the SSH hop both ways and the local app's processing
+	PhaseWait = "wait"
+	// PhaseReceive runs from the first to the last response byte
+	PhaseReceive = "receive"
+	// PhaseTotal runs from the first request byte to the last response byte
+	PhaseTotal = "total"
+)
+
+// LatencyPhases lists the phases in the order they happen
+var LatencyPhases = []string{PhaseConnect, PhaseRequest, PhaseWait, PhaseReceive, PhaseTotal}
+
+// ExchangeTiming is the Data payload of a Timing event: when each step of an
+// exchange happened. Steps that did not happen are nil. ChannelOpen is set on
+// the first exchange of a tunnel connection only.
+type ExchangeTiming struct {
+	Accepted      time.Time  `json:"accepted"`
+	ChannelOpen   *time.Time `json:"channel_open,omitempty"`
+	RequestStart  time.Time  `json:"request_start"`
+	RequestEnd    *time.Time `json:"request_end,omitempty"`
+	ResponseStart *time.Time `json:"response_start,omitempty"`
+	ResponseEnd   *time.Time `json:"response_end,omitempty"`
+	// Closed is set when the connection closed before the exchange completed,
+	// and filled in from the connection's close in timing reports
+	Closed *time.Time `json:"closed,omitempty"`
+
+	seq int
+}
+
+func newExchangeTiming(seq int, accepted, channelOpen, requestStart time.Time) *ExchangeTiming {
+	tm := &ExchangeTiming{Accepted: accepted, RequestStart: requestStart, seq: seq}
+	if seq == 1 && !channelOpen.IsZero() {
+		tm.ChannelOpen = &channelOpen
+	}
+	return tm
+}
+
+// Phases returns the durations of the phases whose bounds are known
+func (tm *ExchangeTiming) Phases() map[string]time.Duration {
+	out := map[string]time.Duration{}
+	span := func(phase string, from time.Time, to *time.Time) {
+		if !from.IsZero() && to != nil && !to.Before(from) {
+			out[phase] = to.Sub(from)
+		}
+	}
+	span(PhaseConnect, tm.Accepted, tm.ChannelOpen)
+	span(PhaseRequest, tm.RequestStart, tm.RequestEnd)
+	if tm.RequestEnd != nil {
+		span(PhaseWait, *tm.RequestEnd, tm.ResponseStart)
+	}
+	if tm.ResponseStart != nil {
+		span(PhaseReceive, *tm.ResponseStart, tm.ResponseEnd)
+	}
+	span(PhaseTotal, tm.RequestStart, tm.ResponseEnd)
+	return out
+}
+
+// TimedExchange is the timing of one captured exchange
+type TimedExchange struct {
+	ConnID string          `json:"conn_id"`
+	Seq    int64           `json:"seq"`
+	Method string          `json:"method,omitempty"`
+	Path   string          `json:"path,omitempty"`
+	Status int             `json:"status,omitempty"`
+	Timing *ExchangeTiming `json:"timing"`
+	// Phases holds the durations in milliseconds
+	Phases map[string]float64 `json:"phases"`
+}
+
+// LatencyHistogram summarizes the durations of one phase in milliseconds
+type LatencyHistogram struct {
+	Count   int               `json:"count"`
+	Min     float64           `json:"min"`
+	Max     float64           `json:"max"`
+	Mean    float64           `json:"mean"`
+	P50     float64           `json:"p50"`
+	P90     float64           `json:"p90"`
+	P99     float64           `json:"p99"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// HistogramBucket counts the durations up to LE milliseconds that exceed
+// the previous bucket's bound; the last bucket is unbounded (LE is -1)
+type HistogramBucket struct {
+	LE    float64 `json:"le"`
+	Count int     `json:"count"`
+}
+
+// latencyBounds are the upper bounds of the histogram buckets in milliseconds
+var latencyBounds = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// TimingReport holds the timings of an entity's exchanges and their
+// latency histograms by phase
+type TimingReport struct {
+	Exchanges  []TimedExchange              `json:"exchanges"`
+	Histograms map[string]*LatencyHistogram `json:"histograms"`
+}
+
+// TimingOptions filters the exchanges of a timing report
+type TimingOptions struct {
+	Since   time.Time
+	Until   time.Time
+	ConnIDs []string
+	// Limit caps the exchanges listed, keeping the newest; histograms
+	// cover every exchange. Zero lists all of them.
+	Limit int
+}
+
+// BuildTimings collects the Timing events of an entity into a report
+func BuildTimings(events []Event, opts TimingOptions) *TimingReport {
+	wanted := map[string]bool{}
+	for _, id := range opts.ConnIDs {
+		wanted[id] = true
+	}
+	closed := map[string]time.Time{}
+	for _, e := range events {
+		if e.Type == ConnClose {
+			closed[e.ConnID] = e.Time
+		}
+	}
+	report := &TimingReport{Exchanges: []TimedExchange{}, Histograms: map[string]*LatencyHistogram{}}
+	values := map[string][]float64{}
+	for _, x := range Exchanges(events) {
+		if x.Timing == nil || (len(wanted) > 0 && !wanted[x.ConnID]) {
+			continue
+		}
+		tm := *x.Timing
+		if !opts.Since.IsZero() && tm.RequestStart.Before(opts.Since) {
+			continue
+		}
+		if !opts.Until.IsZero() && !tm.RequestStart.Before(opts.Until) {
+			continue
+		}
+		if c, ok := closed[x.ConnID]; ok && tm.Closed == nil {
+			tm.Closed = &c
+		}
+		tx := TimedExchange{ConnID: x.ConnID, Seq: x.Seq, Timing: &tm, Phases: map[string]float64{}}
+		if head, ok := x.RequestHead(); ok {
+			tx.Method, tx.Path = head.Method, head.Path
+		}
+		if head, ok := x.ResponseHead(); ok {
+			tx.Status = head.Code
+		}
+		for phase, d := range tm.Phases() {
+			ms := harMillis(d)
+			tx.Phases[phase] = ms
+			values[phase] = append(values[phase], ms)
+		}
+		report.Exchanges = append(report.Exchanges, tx)
+	}
+	sort.SliceStable(report.Exchanges, func(i, j int) bool {
+		return report.Exchanges[i].Timing.RequestStart.Before(report.Exchanges[j].Timing.RequestStart)
+	})
+	if opts.Limit > 0 && len(report.Exchanges) > opts.Limit {
+		report.Exchanges = report.Exchanges[len(report.Exchanges)-opts.Limit:]
+	}
+	for phase, v := range values {
+		report.Histograms[phase] = newLatencyHistogram(v)
+	}
+	return report
+}
+
+func newLatencyHistogram(values []float64) *LatencyHistogram {
+	sort.Float64s(values)
+	h := &LatencyHistogram{Count: len(values), Min: values[0], Max: values[len(values)-1]}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	h.Mean = math.Round(sum/float64(len(values))*1000) / 1000
+	h.P50, h.P90, h.P99 = percentile(values, 50), percentile(values, 90), percentile(values, 99)
+	i := 0
+	for _, le := range latencyBounds {
+		b := HistogramBucket{LE: le}
+		for ; i < len(values) && values[i] <= le; i++ {
+			b.Count++
+		}
+		h.Buckets = append(h.Buckets, b)
+	}
+	h.Buckets = append(h.Buckets, HistogramBucket{LE: -1, Count: len(values) - i})
+	return h
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
+
+// decodeTiming reads the payload of a Timing event
+func decodeTiming(e *Event) *ExchangeTiming {
+	var tm ExchangeTiming
+	if json.Unmarshal(e.Data, &tm) != nil {
+		return nil
+	}
+	return &tm
+}
@@ -0,0 +1,87 @@
+package capture
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/tunnel"
+)
+
+func TestTapTimings(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+	accepted := time.Now().Add(-50 * time.Millisecond)
+	tap := newTap(svc, "t", 500, tunnel.Meta{ConnID: "c1", Accepted: accepted}, nil)
+	tap.OnOpen()
+	tap.OnChannelOpen()
+	tap.SrcWriter().Write([]byte("POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nab"))
+	time.Sleep(5 * time.Millisecond)
+	tap.SrcWriter().Write([]byte("cdGET /b HTTP/1.1\r\nHost: x\r\n\r\nGET /c HTTP/1.1\r\n"))
+	time.Sleep(5 * time.Millisecond)
+	tap.DstWriter().Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+	time.Sleep(5 * time.Millisecond)
+	tap.DstWriter().Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nokHTTP/1.1 204 No Content\r\n\r\n"))
+	tap.SrcWriter().Write([]byte("Host: x\r\n\r\n"))
+	tap.OnClose(0, 0)
+
+	// Persisted events come back with JSON-decoded meta
+	raw, _ := json.Marshal(svc.GetRecent("t", 500))
+	var events []Event
+	json.Unmarshal(raw, &events)
+	report := BuildTimings(events, TimingOptions{})
+	if len(report.Exchanges) != 3 {
+		t.Fatalf("expected 3 timed exchanges, got %+v", report.Exchanges)
+	}
+	a, b, c := report.Exchanges[0], report.Exchanges[1], report.Exchanges[2]
+	if a.Path != "/a" || a.Status != 200 || b.Path != "/b" || b.Status != 204 || c.Path != "/c" || c.Status != 0 {
+		t.Fatalf("unexpected exchanges %+v", report.Exchanges)
+	}
+	if !a.Timing.Accepted.Equal(accepted) || a.Timing.ChannelOpen == nil || a.Phases[PhaseConnect] < 50 {
+		t.Fatalf("expected the connect phase from accept to channel open, got %+v", a.Phases)
+	}
+	if _, ok := b.Phases[PhaseConnect]; ok {
+		t.Fatalf("expected the connect phase on the first exchange only")
+	}
+	// The request body arrived in two writes; the interim response is the first response byte
+	if a.Phases[PhaseRequest] < 5 || a.Phases[PhaseWait] < 5 || a.Phases[PhaseReceive] < 5 || a.Phases[PhaseTotal] < 15 {
+		t.Fatalf("unexpected phases %+v", a.Phases)
+	}
+	// The third request never got a response and ended with the connection
+	if c.Timing.ResponseStart != nil || c.Timing.Closed == nil || c.Timing.RequestEnd == nil {
+		t.Fatalf("expected an unanswered exchange closed with the connection, got %+v", c.Timing)
+	}
+	if a.Timing.Closed == nil || a.Timing.Closed.Before(*c.Timing.Closed) {
+		t.Fatalf("expected the close time on every exchange, got %v", a.Timing.Closed)
+	}
+	if h := report.Histograms[PhaseTotal]; h == nil || h.Count != 2 || h.Max != a.Phases[PhaseTotal] {
+		t.Fatalf("unexpected total histogram %+v", h)
+	}
+	if h := report.Histograms[PhaseRequest]; h == nil || h.Count != 3 {
+		t.Fatalf("unexpected request histogram %+v", h)
+	}
+
+	if got := BuildTimings(events, TimingOptions{Limit: 1}); len(got.Exchanges) != 1 || got.Exchanges[0].Path != "/c" || got.Histograms[PhaseRequest].Count != 3 {
+		t.Fatalf("expected the newest exchange and full histograms, got %+v", got)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var values []float64
+	for i := 1; i <= 100; i++ {
+		values = append(values, float64(i*10))
+	}
+	h := newLatencyHistogram(values)
+	if h.Count != 100 || h.Min != 10 || h.Max != 1000 || h.Mean != 505 || h.P50 != 500 || h.P90 != 900 || h.P99 != 990 {
+		t.Fatalf("unexpected summary %+v", h)
+	}
+	counts := map[float64]int{}
+	total := 0
+	for _, b := range h.Buckets {
+		counts[b.LE] = b.Count
+		total += b.Count
+	}
+	if total != 100 || counts[10] != 1 || counts[25] != 1 || counts[100] != 5 || counts[1000] != 50 || counts[-1] != 0 {
+		t.Fatalf("unexpected buckets %+v", h.Buckets)
+	}
+}
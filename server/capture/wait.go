@@ -0,0 +1,281 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestMatch describes the exchanges WaitFor waits for. Empty fields match
+// anything.
+type RequestMatch struct {
+	Method string `json:"method,omitempty"`
+	// Path matches the request path without its query ("*" wildcards)
+	Path string `json:"path,omitempty"`
+	// Headers match request header values by name ("*" wildcards)
+	Headers map[string]string `json:"headers,omitempty"`
+	// JSON matches members of a JSON request body by dotted path, array
+	// elements by index, against the given values
+	JSON         map[string]any `json:"json,omitempty"`
+	BodyContains string         `json:"body_contains,omitempty"`
+	// Response waits for the response as well; Status also matches its code
+	Response bool `json:"response,omitempty"`
+	Status   int  `json:"status,omitempty"`
+	// AfterID and Since select earlier traffic: exchanges completed after
+	// the event ID or captured since the time. Without them only exchanges
+	// completed after the wait started match.
+	AfterID *uint64   `json:"after_id,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+}
+
+// MatchedExchange is an exchange found by WaitFor. Bodies are decoded text
+// as captured, after redaction.
+type MatchedExchange struct {
+	EntityID string `json:"entity_id"`
+	ConnID   string `json:"conn_id"`
+	Seq      int64  `json:"seq"`
+	// EventID is the ID of the event completing the exchange; waiting with
+	// it as after_id finds the next matching exchange
+	EventID      uint64        `json:"event_id"`
+	Time         time.Time     `json:"time"`
+	Request      *RequestHead  `json:"request"`
+	RequestBody  string        `json:"request_body,omitempty"`
+	Response     *ResponseHead `json:"response,omitempty"`
+	ResponseBody string        `json:"response_body,omitempty"`
+	Truncated    bool          `json:"truncated,omitempty"`
+}
+
+// WaitFor blocks until an exchange matching m is captured for an entity or
+// ctx is done, returning ctx's error
+func (s *Service) WaitFor(ctx context.Context, entityID string, m RequestMatch) (*MatchedExchange, error) {
+	m.Method = strings.ToUpper(m.Method)
+	if m.Status != 0 {
+		m.Response = true
+	}
+	ch := s.Subscribe(entityID)
+	defer s.Unsubscribe(entityID, ch)
+
+	var cursor uint64
+	switch {
+	case m.AfterID != nil:
+		cursor = *m.AfterID
+	case m.Since.IsZero():
+		cursor = s.LastEventID(entityID)
+	}
+	open := map[string][]Event{}
+	check := func(e Event) *MatchedExchange {
+		cursor = max(cursor, e.ID)
+		if !m.Since.IsZero() && e.Time.Before(m.Since) {
+			return nil
+		}
+		seq, ok := metaInt(e.Meta, "seq")
+		if !ok {
+			if e.Type == ConnClose {
+				// bodies read until close are complete by now
+				defer deleteConn(open, e.ConnID)
+				for key, events := range open {
+					if strings.HasPrefix(key, e.ConnID+"|") {
+						if x := m.match(entityID, e.ID, events, true); x != nil {
+							return x
+						}
+					}
+				}
+			}
+			return nil
+		}
+		key := e.ConnID + "|" + strconv.FormatInt(seq, 10)
+		open[key] = append(open[key], e)
+		if x := m.match(entityID, e.ID, open[key], false); x != nil {
+			return x
+		}
+		if m.complete(open[key]) {
+			delete(open, key)
+		}
+		return nil
+	}
+	catchUp := func() *MatchedExchange {
+		events, _ := s.EventsSince(entityID, cursor)
+		for _, e := range events {
+			if x := check(e); x != nil {
+				return x
+			}
+		}
+		return nil
+	}
+
+	if x := catchUp(); x != nil {
+		return x, nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case e := <-ch:
+			if e.ID <= cursor {
+				continue
+			}
+			if e.ID > cursor+1 {
+				if x := catchUp(); x != nil {
+					return x, nil
+				}
+				if e.ID <= cursor {
+					continue
+				}
+			}
+			if x := check(e); x != nil {
+				return x, nil
+			}
+		}
+	}
+}
+
+func deleteConn(open map[string][]Event, connID string) {
+	for key := range open {
+		if strings.HasPrefix(key, connID+"|") {
+			delete(open, key)
+		}
+	}
+}
+
+// complete reports whether an exchange's events can no longer change a match
+func (m *RequestMatch) complete(events []Event) bool {
+	x := Exchanges(events)[0]
+	if m.Response {
+		return x.ResponseBodySize >= 0 || (x.Response != nil && !expectsBody(x.Response))
+	}
+	return x.Response != nil
+}
+
+// match returns the exchange of events if it is complete enough to be
+// matched and matches. closed reports that its connection has ended.
+func (m *RequestMatch) match(entityID string, eventID uint64, events []Event, closed bool) *MatchedExchange {
+	x := Exchanges(events)[0]
+	head, ok := x.RequestHead()
+	if !ok {
+		return nil
+	}
+	// Wait for the request body, or the response when asked for
+	requestDone := x.RequestBodySize >= 0 || x.Response != nil || !expectsBody(x.Request) || closed
+	if !requestDone {
+		return nil
+	}
+	var res *ResponseHead
+	if m.Response {
+		if res, ok = x.ResponseHead(); !ok {
+			return nil
+		}
+		if x.ResponseBodySize < 0 && expectsBody(x.Response) && head.Method != http.MethodHead && !closed {
+			return nil
+		}
+	}
+
+	if m.Method != "" && head.Method != m.Method {
+		return nil
+	}
+	if m.Path != "" && !(&connPolicy{include: []string{m.Path}}).keepPath(head.Path) {
+		return nil
+	}
+	for name, pattern := range m.Headers {
+		if !globMatch(pattern, head.Header.Get(name)) {
+			return nil
+		}
+	}
+	if m.Status != 0 && res.Code != m.Status {
+		return nil
+	}
+	body := decodedText(head.Header, x.RequestBody)
+	if m.BodyContains != "" && !strings.Contains(body, m.BodyContains) {
+		return nil
+	}
+	if len(m.JSON) > 0 {
+		var doc any
+		if json.Unmarshal([]byte(body), &doc) != nil {
+			return nil
+		}
+		for path, want := range m.JSON {
+			got, ok := jsonLookup(doc, path)
+			if !ok || !jsonEqual(got, want) {
+				return nil
+			}
+		}
+	}
+
+	out := &MatchedExchange{EntityID: entityID, ConnID: x.ConnID, Seq: x.Seq, EventID: eventID, Time: x.Request.Time,
+		Request: head, RequestBody: body, Truncated: x.Truncated}
+	if res != nil {
+		out.Response = res
+		out.ResponseBody = decodedText(res.Header, x.ResponseBody)
+	}
+	return out
+}
+
+// expectsBody reports whether a captured message head announces a body
+func expectsBody(e *Event) bool {
+	m, _ := e.Meta.(map[string]any)
+	if code, ok := metaInt(m, "code"); ok && (code == http.StatusNoContent || code == http.StatusNotModified || code < 200) {
+		return false
+	}
+	var head struct {
+		Header http.Header `json:"header"`
+	}
+	json.Unmarshal(e.Data, &head)
+	if head.Header.Get("Transfer-Encoding") != "" {
+		return true
+	}
+	if cl := head.Header.Get("Content-Length"); cl != "" {
+		return cl != "0"
+	}
+	// responses without a length run until the connection closes
+	return e.Type == ResHeaders
+}
+
+// decodedText reverses a body's content codings
+func decodedText(header http.Header, body []byte) string {
+	if header.Get("Content-Encoding") != "" {
+		if decoded, err := decodeContent(header.Get("Content-Encoding"), body); err == nil {
+			body = decoded
+		}
+	}
+	return string(body)
+}
+
+// jsonLookup finds a member of a decoded JSON document by dotted path
+func jsonLookup(doc any, path string) (any, bool) {
+	if path == "" {
+		return doc, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := doc.(type) {
+		case map[string]any:
+			child, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			doc = child
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// jsonEqual compares JSON values after normalizing want through encoding/json
+func jsonEqual(got, want any) bool {
+	b, err := json.Marshal(want)
+	if err != nil {
+		return false
+	}
+	var norm any
+	json.Unmarshal(b, &norm)
+	return reflect.DeepEqual(got, norm)
+}
@@ -0,0 +1,78 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/tunnel"
+)
+
+func TestWaitFor(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+	exchange := func(conn, req, res string) {
+		tap := newTap(svc, "t", 500, tunnel.Meta{ConnID: conn}, nil)
+		tap.OnOpen()
+		tap.SrcWriter().Write([]byte(req))
+		if res != "" {
+			tap.DstWriter().Write([]byte(res))
+		}
+		tap.OnClose(0, 0)
+	}
+	order := func(status string) string {
+		body := `{"order":{"id":7,"status":"` + status + `"},"items":[{"sku":"a"}]}`
+		return "POST /api/orders?v=2 HTTP/1.1\r\nHost: x\r\nContent-Type: application/json\r\nContent-Length: " +
+			strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	}
+	wait := func(m RequestMatch, timeout time.Duration) (*MatchedExchange, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return svc.WaitFor(ctx, "t", m)
+	}
+
+	// Traffic before the wait is ignored unless asked for
+	mark := svc.LastEventID("t")
+	exchange("c0", order("paid"), "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	if _, err := wait(RequestMatch{Path: "/api/orders"}, 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout for earlier traffic, got %v", err)
+	}
+	if x, err := wait(RequestMatch{Path: "/api/orders", AfterID: &mark}, time.Second); err != nil || x.ConnID != "c0" {
+		t.Fatalf("expected the exchange captured after the mark, got %+v %v", x, err)
+	}
+
+	// Matches on method, path, headers and JSON members as they arrive
+	done := make(chan *MatchedExchange)
+	go func() {
+		x, err := wait(RequestMatch{Method: "post", Path: "/api/*", Headers: map[string]string{"Content-Type": "application/*"},
+			JSON: map[string]any{"order.status": "paid", "order.id": 7, "items.0.sku": "a"}, Status: 201}, 5*time.Second)
+		if err != nil {
+			t.Errorf("WaitFor: %v", err)
+		}
+		done <- x
+	}()
+	time.Sleep(50 * time.Millisecond)
+	exchange("c1", order("pending"), "HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n")
+	exchange("c2", "GET /api/orders HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n")
+	exchange("c3", order("paid"), "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	exchange("c4", order("paid"), "HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok")
+	x := <-done
+	if x == nil || x.ConnID != "c4" || x.Response == nil || x.Response.Code != 201 || x.ResponseBody != "ok" || x.Request.Method != "POST" {
+		t.Fatalf("unexpected match %+v", x)
+	}
+	if x.EventID == 0 || x.EventID > svc.LastEventID("t") {
+		t.Fatalf("unexpected event ID %d", x.EventID)
+	}
+
+	// Without a response, the request alone matches once its body is in
+	since := time.Now()
+	tap := newTap(svc, "t", 500, tunnel.Meta{ConnID: "c5"}, nil)
+	tap.OnOpen()
+	tap.SrcWriter().Write([]byte(order("shipped")))
+	if x, err := wait(RequestMatch{JSON: map[string]any{"order.status": "shipped"}, Since: since}, time.Second); err != nil || x.ConnID != "c5" || x.Response != nil {
+		t.Fatalf("expected the request without its response, got %+v %v", x, err)
+	}
+	tap.OnClose(0, 0)
+}
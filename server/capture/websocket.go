@@ -0,0 +1,320 @@
+package capture
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// WebSocket (RFC 6455) frame decoding for captured connections.
+//
+// After a "101 Switching Protocols" response that accepts a websocket upgrade,
+// both directions of the connection are decoded as frames. Fragmented
+// messages are reassembled, client masking is removed and permessage-deflate
+// (RFC 7692) payloads are inflated; each message becomes one WSMessage event.
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// maxWSMessage bounds the payload kept while reassembling a message
+const maxWSMessage = 1 << 20
+
+// maxWSInflated bounds the size of an inflated message
+const maxWSInflated = 4 << 20
+
+// wsWindow is the deflate window kept for context takeover
+const wsWindow = 32 * 1024
+
+var wsOpNames = map[byte]string{
+	wsOpText:   "text",
+	wsOpBinary: "binary",
+	wsOpClose:  "close",
+	wsOpPing:   "ping",
+	wsOpPong:   "pong",
+}
+
+// wsExtensions holds the permessage-deflate parameters negotiated in the handshake
+type wsExtensions struct {
+	deflate                 bool
+	clientNoContextTakeover bool
+	serverNoContextTakeover bool
+}
+
+// websocketUpgrade reports whether res accepts a websocket upgrade and returns its extensions
+func websocketUpgrade(res *http.Response) (*wsExtensions, bool) {
+	if res.StatusCode != http.StatusSwitchingProtocols || !strings.EqualFold(res.Header.Get("Upgrade"), "websocket") {
+		return nil, false
+	}
+	ext := &wsExtensions{}
+	for _, v := range res.Header.Values("Sec-WebSocket-Extensions") {
+		for _, e := range strings.Split(v, ",") {
+			params := strings.Split(e, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			ext.deflate = true
+			for _, p := range params[1:] {
+				switch strings.TrimSpace(p) {
+				case "client_no_context_takeover":
+					ext.clientNoContextTakeover = true
+				case "server_no_context_takeover":
+					ext.serverNoContextTakeover = true
+				}
+			}
+		}
+	}
+	return ext, true
+}
+
+// wsMessage is a reassembled message or control frame
+type wsMessage struct {
+	opcode     byte
+	payload    []byte
+	size       int64
+	fragments  int
+	masked     bool
+	compressed bool
+	truncated  bool
+	err        string
+}
+
+var errNotWebSocket = errors.New("invalid websocket frame")
+
+// wsDecoder decodes the frames of one direction
+type wsDecoder struct {
+	emit func(m *wsMessage)
+	buf  []byte
+
+	// current frame
+	inFrame   bool
+	fin       bool
+	opcode    byte
+	masked    bool
+	key       [4]byte
+	keyPos    int
+	remaining int64
+	control   []byte
+
+	// current data message
+	msg *wsMessage
+
+	// permessage-deflate state
+	deflate           bool
+	noContextTakeover bool
+	window            []byte
+	// inflateBroken is set once the shared window was lost
+	inflateBroken bool
+}
+
+func newWSDecoder(ext *wsExtensions, fromClient bool, emit func(m *wsMessage)) *wsDecoder {
+	d := &wsDecoder{emit: emit, deflate: ext.deflate}
+	if fromClient {
+		d.noContextTakeover = ext.clientNoContextTakeover
+	} else {
+		d.noContextTakeover = ext.serverNoContextTakeover
+	}
+	return d
+}
+
+// write decodes p. On a protocol error it returns errNotWebSocket together
+// with the bytes that could not be decoded.
+func (d *wsDecoder) write(p []byte) ([]byte, error) {
+	d.buf = append(d.buf, p...)
+	for len(d.buf) > 0 {
+		if !d.inFrame {
+			n, err := d.readHeader()
+			if err != nil {
+				rest := d.buf
+				d.buf = nil
+				return rest, err
+			}
+			if n == 0 {
+				return nil, nil
+			}
+			d.buf = d.buf[n:]
+			if d.remaining == 0 {
+				d.finishFrame()
+			}
+			continue
+		}
+
+		n := int64(len(d.buf))
+		if n > d.remaining {
+			n = d.remaining
+		}
+		chunk := append([]byte(nil), d.buf[:n]...)
+		if d.masked {
+			for i := range chunk {
+				chunk[i] ^= d.key[d.keyPos&3]
+				d.keyPos++
+			}
+		}
+		if d.opcode >= wsOpClose {
+			d.control = append(d.control, chunk...)
+		} else {
+			d.msg.size += n
+			if room := maxWSMessage - len(d.msg.payload); room < len(chunk) {
+				chunk = chunk[:max(room, 0)]
+				d.msg.truncated = true
+			}
+			d.msg.payload = append(d.msg.payload, chunk...)
+		}
+		d.remaining -= n
+		d.buf = d.buf[n:]
+		if d.remaining == 0 {
+			d.finishFrame()
+		}
+	}
+	d.buf = nil
+	return nil, nil
+}
+
+// readHeader parses a frame header, returning its size or 0 if incomplete
+func (d *wsDecoder) readHeader() (int, error) {
+	if len(d.buf) < 2 {
+		return 0, nil
+	}
+	b0, b1 := d.buf[0], d.buf[1]
+	fin, rsv1, opcode := b0&0x80 != 0, b0&0x40 != 0, b0&0x0F
+	masked := b1&0x80 != 0
+	n := 2
+	length := int64(b1 & 0x7F)
+	switch length {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if masked {
+		n += 4
+	}
+	if len(d.buf) < n {
+		return 0, nil
+	}
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(d.buf[2:4]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(d.buf[2:10]))
+	}
+
+	if b0&0x30 != 0 || length < 0 {
+		return 0, errNotWebSocket
+	}
+	switch opcode {
+	case wsOpClose, wsOpPing, wsOpPong:
+		if !fin || length > 125 {
+			return 0, errNotWebSocket
+		}
+		d.control = nil
+	case wsOpText, wsOpBinary:
+		if d.msg != nil {
+			return 0, errNotWebSocket
+		}
+		d.msg = &wsMessage{opcode: opcode, masked: masked, compressed: rsv1 && d.deflate}
+	case wsOpContinuation:
+		if d.msg == nil {
+			return 0, errNotWebSocket
+		}
+	default:
+		return 0, errNotWebSocket
+	}
+
+	d.inFrame, d.fin, d.opcode, d.masked, d.remaining, d.keyPos = true, fin, opcode, masked, length, 0
+	if masked {
+		copy(d.key[:], d.buf[n-4:n])
+	}
+	return n, nil
+}
+
+func (d *wsDecoder) finishFrame() {
+	d.inFrame = false
+	if d.opcode >= wsOpClose {
+		d.emit(&wsMessage{opcode: d.opcode, payload: d.control, size: int64(len(d.control)), fragments: 1, masked: d.masked})
+		d.control = nil
+		return
+	}
+	d.msg.fragments++
+	if !d.fin {
+		return
+	}
+	m := d.msg
+	d.msg = nil
+	if m.compressed {
+		d.inflate(m)
+	}
+	d.emit(m)
+}
+
+// inflate decompresses a permessage-deflate payload in place
+func (d *wsDecoder) inflate(m *wsMessage) {
+	if d.inflateBroken {
+		m.err = "compression context lost; payload left compressed"
+		return
+	}
+	if m.truncated {
+		m.err = "message too large to inflate"
+		if !d.noContextTakeover {
+			d.inflateBroken = true
+		}
+		return
+	}
+	data := append(m.payload, 0x00, 0x00, 0xff, 0xff)
+	r := flate.NewReaderDict(bytes.NewReader(data), d.window)
+	out, err := io.ReadAll(io.LimitReader(r, maxWSInflated+1))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		m.err = "inflate: " + err.Error()
+		d.inflateBroken = !d.noContextTakeover
+		return
+	}
+	if len(out) > maxWSInflated {
+		out = out[:maxWSInflated]
+		m.truncated = true
+		d.inflateBroken = !d.noContextTakeover
+	}
+	m.payload, m.size = out, int64(len(out))
+	if d.noContextTakeover {
+		return
+	}
+	d.window = append(d.window, out...)
+	if len(d.window) > wsWindow {
+		d.window = append([]byte(nil), d.window[len(d.window)-wsWindow:]...)
+	}
+}
+
+// meta describes the message for its WSMessage event
+func (m *wsMessage) meta(direction string) map[string]any {
+	meta := map[string]any{
+		"direction":  direction,
+		"opcode":     m.opcode,
+		"type":       wsOpNames[m.opcode],
+		"size":       m.size,
+		"fragments":  m.fragments,
+		"masked":     m.masked,
+		"compressed": m.compressed,
+	}
+	if m.truncated {
+		meta["truncated"] = true
+	}
+	if m.err != "" {
+		meta["error"] = m.err
+	}
+	if m.opcode == wsOpClose && len(m.payload) >= 2 {
+		meta["close_code"] = binary.BigEndian.Uint16(m.payload[:2])
+		if reason := m.payload[2:]; utf8.Valid(reason) {
+			meta["close_reason"] = string(reason)
+		}
+	}
+	return meta
+}
@@ -0,0 +1,127 @@
+package capture
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"testing"
+)
+
+// wsFrame encodes a single frame, masking it when key is set
+func wsFrame(fin bool, rsv1 bool, opcode byte, payload []byte, key []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	out := []byte{b0}
+	var mask byte
+	if key != nil {
+		mask = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		out = append(out, mask|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		out = append(out, mask|126)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(payload)))
+	default:
+		out = append(out, mask|127)
+		out = binary.BigEndian.AppendUint64(out, uint64(len(payload)))
+	}
+	if key != nil {
+		out = append(out, key...)
+		for i, c := range payload {
+			out = append(out, c^key[i%4])
+		}
+		return out
+	}
+	return append(out, payload...)
+}
+
+func TestWebSocketCapture(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := NewService(500, 64*1024)
+	tap := &TapImpl{svc: svc, meta: Meta{ConnID: "c1"}, tunnelID: "ws", maxEvents: 500}
+	key := []byte{1, 2, 3, 4}
+
+	// Server messages share one compression context
+	var zbuf bytes.Buffer
+	zw, _ := flate.NewWriter(&zbuf, flate.BestCompression)
+	compress := func(s string) []byte {
+		zbuf.Reset()
+		zw.Write([]byte(s))
+		zw.Flush()
+		return bytes.TrimSuffix(append([]byte(nil), zbuf.Bytes()...), []byte{0, 0, 0xff, 0xff})
+	}
+
+	tap.OnOpen()
+	tap.SrcWriter().Write([]byte("GET /chat HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Extensions: permessage-deflate\r\n\r\n"))
+	tap.DstWriter().Write(append([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Extensions: permessage-deflate; client_no_context_takeover\r\n\r\n"),
+		wsFrame(true, true, wsOpText, compress("hello hello hello"), nil)...))
+
+	// A fragmented client message with a ping in between, split mid-header
+	client := append(wsFrame(false, false, wsOpText, []byte("frag"), key), wsFrame(true, false, wsOpPing, []byte("p"), key)...)
+	client = append(client, wsFrame(true, false, wsOpContinuation, []byte("mented"), key)...)
+	tap.SrcWriter().Write(client[:3])
+	tap.SrcWriter().Write(client[3:])
+
+	tap.DstWriter().Write(wsFrame(true, true, wsOpText, compress("hello hello hello again"), nil))
+	tap.DstWriter().Write(wsFrame(true, false, wsOpClose, append([]byte{0x03, 0xe8}, "bye"...), nil))
+	tap.OnClose(0, 0)
+
+	var msgs []Event
+	for _, e := range svc.GetRecent("ws", 500) {
+		switch e.Type {
+		case WSMessage:
+			msgs = append(msgs, e)
+		case ReqBody, ResBody:
+			t.Fatalf("unexpected raw %s event: %q", e.Type, e.Data)
+		}
+	}
+	want := []struct {
+		direction, typ, data string
+	}{
+		{"server_to_client", "text", "hello hello hello"},
+		{"client_to_server", "ping", "p"},
+		{"client_to_server", "text", "fragmented"},
+		{"server_to_client", "text", "hello hello hello again"},
+		{"server_to_client", "close", "\x03\xe8bye"},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("expected %d messages, got %d", len(want), len(msgs))
+	}
+	for i, w := range want {
+		m := msgs[i].Meta.(map[string]any)
+		if m["direction"] != w.direction || m["type"] != w.typ || string(msgs[i].Data) != w.data {
+			t.Fatalf("message %d: got %v %q, want %+v", i, m, msgs[i].Data, w)
+		}
+	}
+	if m := msgs[2].Meta.(map[string]any); m["fragments"] != 2 || m["masked"] != true {
+		t.Fatalf("expected masked message of 2 fragments, got %v", m)
+	}
+	if m := msgs[3].Meta.(map[string]any); m["compressed"] != true || m["error"] != nil {
+		t.Fatalf("expected inflated message, got %v", m)
+	}
+	if m := msgs[4].Meta.(map[string]any); m["close_code"] != uint16(1000) || m["close_reason"] != "bye" {
+		t.Fatalf("unexpected close frame meta: %v", m)
+	}
+}
+
+func TestWebSocketCaptureFallsBackToRaw(t *testing.T) {
+	var got []*wsMessage
+	d := newWSDecoder(&wsExtensions{}, false, func(m *wsMessage) { got = append(got, m) })
+	if rest, err := d.write(wsFrame(true, false, wsOpBinary, []byte{1, 2}, nil)); err != nil || rest != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Reserved opcode
+	rest, err := d.write([]byte{0x83, 0x00, 'x'})
+	if err != errNotWebSocket || !bytes.Equal(rest, []byte{0x83, 0x00, 'x'}) {
+		t.Fatalf("expected undecoded remainder, got %q %v", rest, err)
+	}
+	if len(got) != 1 || got[0].opcode != wsOpBinary {
+		t.Fatalf("unexpected messages: %+v", got)
+	}
+}
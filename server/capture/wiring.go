@@ -6,28 +6,44 @@ import (
 	"io"
 )
 
-// NewTapFactory creates a tunnel TapFactory bound to this capture Service and a tunnel id/user
+// NewTapFactory creates a tunnel TapFactory bound to this capture Service and a tunnel id/user.
+// Each connection is captured according to the entity's capture policy.
 func NewTapFactory(svc *Service, tunnelID string, username string, maxEvents int) tunnel.TapFactory {
 	return func(meta tunnel.Meta) tunnel.Tap {
-		return &TapImpl{svc: svc, meta: Meta{Username: meta.Username, Remote: meta.Remote, ConnID: meta.ConnID}, tunnelID: tunnelID, maxEvents: maxEvents}
+		return newTap(svc, tunnelID, maxEvents, meta, svc.connPolicy(tunnelID))
 	}
 }
 
+// newTap creates the tap of one connection under a resolved policy
+func newTap(svc *Service, tunnelID string, maxEvents int, meta tunnel.Meta, policy *connPolicy) *TapImpl {
+	return &TapImpl{svc: svc, meta: Meta{Username: meta.Username, Remote: meta.Remote, ConnID: meta.ConnID, Peer: meta.Peer, Accepted: meta.Accepted}, tunnelID: tunnelID, maxEvents: maxEvents, policy: policy}
+}
+
+// NewReplayTap creates a tap recording a replayed exchange under tunnelID.
+// Its events carry the given connection ID and link back to replayOf.
+func NewReplayTap(svc *Service, tunnelID string, username string, connID string, replayOf string, maxEvents int) tunnel.Tap {
+	return &TapImpl{svc: svc, meta: Meta{Username: username, ConnID: connID, ReplayOf: replayOf}, tunnelID: tunnelID, maxEvents: maxEvents}
+}
+
 // NewPerRemoteTapFactory creates a TapFactory which assigns events to per-remote IDs (baseID-r{index})
 // portToIndex maps remote.LocalPort -> index used in the ID suffix
 func NewPerRemoteTapFactory(svc *Service, baseTunnelID string, username string, maxEvents int, portToIndex map[string]int) tunnel.TapFactory {
 	return func(meta tunnel.Meta) tunnel.Tap {
-		idx := 0
-		if portToIndex != nil {
-			if v, ok := portToIndex[meta.Remote.LocalPort]; ok {
-				idx = v
-			}
-		}
-		id := fmt.Sprintf("%s-r%d", baseTunnelID, idx)
+		id := perRemoteTapID(baseTunnelID, portToIndex, meta)
 		return NewTapFactory(svc, id, username, maxEvents)(meta)
 	}
 }
 
+func perRemoteTapID(baseTunnelID string, portToIndex map[string]int, meta tunnel.Meta) string {
+	idx := 0
+	if portToIndex != nil {
+		if v, ok := portToIndex[meta.Remote.LocalPort]; ok {
+			idx = v
+		}
+	}
+	return fmt.Sprintf("%s-r%d", baseTunnelID, idx)
+}
+
 // dualTap duplicates lifecycle and byte streams to two underlying taps
 type dualTap struct{ a, b tunnel.Tap }
 
@@ -47,6 +63,13 @@ func (d dualTap) OnClose(s, r int64) {
 		d.b.OnClose(s, r)
 	}
 }
+func (d dualTap) OnChannelOpen() {
+	for _, t := range []tunnel.Tap{d.a, d.b} {
+		if ct, ok := t.(tunnel.ChannelTap); ok {
+			ct.OnChannelOpen()
+		}
+	}
+}
 func (d dualTap) SrcWriter() io.Writer { return io.MultiWriter(d.a.SrcWriter(), d.b.SrcWriter()) }
 func (d dualTap) DstWriter() io.Writer { return io.MultiWriter(d.a.DstWriter(), d.b.DstWriter()) }
 
@@ -63,15 +86,19 @@ func NewDualTapFactory(svc *Service, baseTunnelID string, username string, maxEv
 // - base session ID (sess-...)
 // - per-remote session IDs (sess-...-rN)
 // - canonical per-user+ports tunnel ID (tun-<b64user>-<lp>-<rp>)
+// All three are captured under one policy, that of the canonical ID when
+// it has one, so that sampling picks the same connections for each.
 func NewTripleTapFactoryWithCanonical(svc *Service, baseTunnelID string, username string, maxEvents int, portToIndex map[string]int, unameEnc string) tunnel.TapFactory {
-	perRemote := NewPerRemoteTapFactory(svc, baseTunnelID, username, maxEvents, portToIndex)
-	base := NewTapFactory(svc, baseTunnelID, username, maxEvents)
 	return func(meta tunnel.Meta) tunnel.Tap {
 		// Canonical ID derived from remote ports
 		lp := meta.Remote.LocalPort
 		rp := meta.Remote.RemotePort
 		canonicalID := fmt.Sprintf("tun-%s-%s-%s", unameEnc, lp, rp)
-		canonical := NewTapFactory(svc, canonicalID, username, maxEvents)
-		return dualTap{a: base(meta), b: dualTap{a: perRemote(meta), b: canonical(meta)}}
+		perRemoteID := perRemoteTapID(baseTunnelID, portToIndex, meta)
+		policy := svc.connPolicy(canonicalID, perRemoteID, baseTunnelID)
+		base := newTap(svc, baseTunnelID, maxEvents, meta, policy)
+		perRemote := newTap(svc, perRemoteID, maxEvents, meta, policy)
+		canonical := newTap(svc, canonicalID, maxEvents, meta, policy)
+		return dualTap{a: base, b: dualTap{a: perRemote, b: canonical}}
 	}
 }
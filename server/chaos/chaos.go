@@ -0,0 +1,263 @@
+// Package chaos injects faults into the responses of proxy listeners: added
+// latency, error statuses, connections dropped mid-body, throttled bandwidth
+// and corrupted payloads. Rules are scoped by method and path pattern, and
+// every enabled rule matching a request applies with its own probability.
+package chaos
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/server/mock"
+)
+
+// maxLatency caps the latency and jitter of a rule
+const maxLatency = 60 * time.Second
+
+// Config is the chaos configuration of a listener
+type Config struct {
+	// Enabled switches all rules on or off without losing them
+	Enabled bool `json:"enabled"`
+	// Seed makes the random choices reproducible; 0 seeds from the clock
+	Seed  int64  `json:"seed,omitempty"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule scopes faults to requests. Empty match fields match anything.
+type Rule struct {
+	Name     string `json:"name,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+	Method   string `json:"method,omitempty"`
+	// Path is a pattern as in mock rules: "{name}" or "*" matches one
+	// segment and "{name...}" the rest of the path
+	Path string `json:"path,omitempty"`
+	// Probability is the share of matching requests the rule applies to.
+	// Decoding defaults it to 1; an explicit 0 never applies the rule.
+	Probability float64 `json:"probability"`
+
+	// LatencyMillis delays the request, plus up to JitterMillis more
+	LatencyMillis int `json:"latency_ms,omitempty"`
+	JitterMillis  int `json:"jitter_ms,omitempty"`
+	// ErrorStatus answers with this status instead of proxying the request.
+	// ErrorBody defaults to a JSON error.
+	ErrorStatus int    `json:"error_status,omitempty"`
+	ErrorBody   string `json:"error_body,omitempty"`
+	// Drop closes the connection after DropAfterBytes of the response body,
+	// or at its end when the body is shorter
+	Drop           bool  `json:"drop,omitempty"`
+	DropAfterBytes int64 `json:"drop_after_bytes,omitempty"`
+	// BandwidthBytes throttles the response body to this many bytes a second
+	BandwidthBytes int64 `json:"bandwidth_bps,omitempty"`
+	// CorruptRate is the share of response body bytes replaced by others
+	CorruptRate float64 `json:"corrupt_rate,omitempty"`
+}
+
+// UnmarshalJSON decodes a rule, applying it to every matching request unless
+// the probability says otherwise
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	type rule Rule
+	v := rule{Probability: 1}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*r = Rule(v)
+	return nil
+}
+
+// Injector holds a compiled configuration
+type Injector struct {
+	config Config
+	rules  []*compiledRule
+	mu     sync.Mutex
+	rnd    *rand.Rand
+}
+
+type compiledRule struct {
+	Rule
+	segments []string
+}
+
+// Parse decodes a stored configuration; an empty string is a disabled one
+func Parse(s string) (Config, error) {
+	cfg := Config{Rules: []Rule{}}
+	if strings.TrimSpace(s) == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(s), &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid chaos configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// New compiles a configuration, reporting the first invalid rule
+func New(cfg Config) (*Injector, error) {
+	if cfg.Rules == nil {
+		cfg.Rules = []Rule{}
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	in := &Injector{config: cfg, rnd: rand.New(rand.NewSource(seed))}
+	for i, r := range cfg.Rules {
+		c, err := compile(r)
+		if err != nil {
+			name := r.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(i+1)
+			}
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		in.rules = append(in.rules, c)
+	}
+	return in, nil
+}
+
+func compile(r Rule) (*compiledRule, error) {
+	r.Method = strings.ToUpper(strings.TrimSpace(r.Method))
+	switch {
+	case r.Probability < 0 || r.Probability > 1:
+		return nil, fmt.Errorf("probability must be between 0 and 1")
+	case r.CorruptRate < 0 || r.CorruptRate > 1:
+		return nil, fmt.Errorf("corrupt_rate must be between 0 and 1")
+	case r.LatencyMillis < 0 || time.Duration(r.LatencyMillis)*time.Millisecond > maxLatency,
+		r.JitterMillis < 0 || time.Duration(r.JitterMillis)*time.Millisecond > maxLatency:
+		return nil, fmt.Errorf("latency_ms and jitter_ms must be between 0 and %d", maxLatency.Milliseconds())
+	case r.ErrorStatus != 0 && (r.ErrorStatus < 100 || r.ErrorStatus > 999):
+		return nil, fmt.Errorf("invalid error_status %d", r.ErrorStatus)
+	case r.DropAfterBytes < 0 || r.BandwidthBytes < 0:
+		return nil, fmt.Errorf("drop_after_bytes and bandwidth_bps must not be negative")
+	}
+	c := &compiledRule{Rule: r}
+	if r.Path != "" {
+		segments, err := mock.ParsePattern(r.Path)
+		if err != nil {
+			return nil, err
+		}
+		c.segments = segments
+	}
+	return c, nil
+}
+
+// Config returns the configuration the injector was compiled from
+func (in *Injector) Config() Config {
+	return in.config
+}
+
+// Fault is what the rules applying to one request inject
+type Fault struct {
+	// Rules names the applied rules, by position when unnamed
+	Rules  []string
+	Delay  time.Duration
+	Status int
+	Body   string
+	// DropAfter is the number of body bytes after which the connection is
+	// closed, -1 for none
+	DropAfter   int64
+	Bandwidth   int64
+	CorruptRate float64
+	seed        int64
+}
+
+// Plan rolls the rules matching r. It returns nil when chaos is disabled or
+// no rule applies. Latencies add up; of the other faults the first error
+// status, the earliest drop, the lowest bandwidth and the highest corruption
+// rate win.
+func (in *Injector) Plan(r *http.Request) *Fault {
+	if in == nil || !in.config.Enabled {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var f *Fault
+	for i, c := range in.rules {
+		if !c.match(r) || (c.Probability < 1 && in.rnd.Float64() >= c.Probability) {
+			continue
+		}
+		if f == nil {
+			f = &Fault{DropAfter: -1, seed: in.rnd.Int63()}
+		}
+		name := c.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+		}
+		f.Rules = append(f.Rules, name)
+		f.Delay += time.Duration(c.LatencyMillis) * time.Millisecond
+		if c.JitterMillis > 0 {
+			f.Delay += time.Duration(in.rnd.Int63n(int64(c.JitterMillis)+1)) * time.Millisecond
+		}
+		if c.ErrorStatus != 0 && f.Status == 0 {
+			f.Status, f.Body = c.ErrorStatus, c.ErrorBody
+		}
+		if c.Drop && (f.DropAfter < 0 || c.DropAfterBytes < f.DropAfter) {
+			f.DropAfter = c.DropAfterBytes
+		}
+		if c.BandwidthBytes > 0 && (f.Bandwidth == 0 || c.BandwidthBytes < f.Bandwidth) {
+			f.Bandwidth = c.BandwidthBytes
+		}
+		f.CorruptRate = max(f.CorruptRate, c.CorruptRate)
+	}
+	return f
+}
+
+func (c *compiledRule) match(r *http.Request) bool {
+	if c.Disabled || (c.Method != "" && c.Method != r.Method) {
+		return false
+	}
+	if c.segments != nil {
+		if _, ok := mock.MatchPattern(c.segments, r.URL.Path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler applies the injector current at each request to next, passing
+// requests through untouched while it is nil or disabled. Swapping the
+// injector takes effect on the next request.
+func Handler(next http.Handler, injector *atomic.Pointer[Injector]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := injector.Load().Plan(r)
+		if f == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if f.Delay > 0 {
+			t := time.NewTimer(f.Delay)
+			select {
+			case <-t.C:
+			case <-r.Context().Done():
+				t.Stop()
+				return
+			}
+		}
+		if f.Status != 0 {
+			body := f.Body
+			if body == "" {
+				b, _ := json.Marshal(map[string]string{"error": "injected fault: " + strings.Join(f.Rules, ", ")})
+				body = string(b)
+			}
+			if json.Valid([]byte(body)) {
+				w.Header().Set("Content-Type", "application/json")
+			} else {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			}
+			w = newFaultWriter(w, r, f)
+			w.WriteHeader(f.Status)
+			w.Write([]byte(body))
+		} else {
+			w = newFaultWriter(w, r, f)
+			next.ServeHTTP(w, r)
+		}
+		if f.DropAfter >= 0 {
+			w.(*faultWriter).drop()
+		}
+	})
+}
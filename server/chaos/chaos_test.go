@@ -0,0 +1,120 @@
+package chaos
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRuleValidation(t *testing.T) {
+	for _, r := range []Rule{
+		{Probability: 1.5},
+		{CorruptRate: -0.1},
+		{LatencyMillis: 61000},
+		{ErrorStatus: 42},
+		{Drop: true, DropAfterBytes: -1},
+		{Path: "users"},
+	} {
+		if _, err := New(Config{Rules: []Rule{r}}); err == nil {
+			t.Fatalf("expected rule %+v to be rejected", r)
+		}
+	}
+	if cfg, err := Parse(""); err != nil || cfg.Enabled || cfg.Rules == nil {
+		t.Fatalf("unexpected empty configuration %+v, %v", cfg, err)
+	}
+	cfg, err := Parse(`{"enabled": true, "rules": [{"name": "always"}, {"name": "never", "probability": 0}]}`)
+	if err != nil || cfg.Rules[0].Probability != 1 || cfg.Rules[1].Probability != 0 {
+		t.Fatalf("expected an omitted probability to default to 1, got %+v, %v", cfg.Rules, err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	in, err := New(Config{Enabled: true, Seed: 1, Rules: []Rule{
+		{Name: "slow", Probability: 1, Path: "/api/{rest...}", LatencyMillis: 100, JitterMillis: 50},
+		{Name: "fail", Probability: 1, Method: "post", Path: "/api/orders", ErrorStatus: 503},
+		{Name: "off", Probability: 1, Disabled: true, ErrorStatus: 500},
+		{Name: "narrow", Probability: 1, Path: "/api/orders", BandwidthBytes: 100, Drop: true, DropAfterBytes: 10},
+		{Name: "wide", Probability: 1, Method: "POST", BandwidthBytes: 1000, Drop: true, DropAfterBytes: 5, CorruptRate: 0.5},
+		{Name: "never", Path: "/health", Probability: 0, ErrorStatus: 500},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := in.Plan(httptest.NewRequest("POST", "/api/orders", nil))
+	if f == nil || strings.Join(f.Rules, ",") != "slow,fail,narrow,wide" {
+		t.Fatalf("unexpected rules %+v", f)
+	}
+	if f.Delay < 100*time.Millisecond || f.Delay > 150*time.Millisecond || f.Status != 503 ||
+		f.Bandwidth != 100 || f.DropAfter != 5 || f.CorruptRate != 0.5 {
+		t.Fatalf("unexpected fault %+v", f)
+	}
+	if f := in.Plan(httptest.NewRequest("GET", "/health", nil)); f != nil {
+		t.Fatalf("expected no fault, got %+v", f)
+	}
+	in.config.Enabled = false
+	if f := in.Plan(httptest.NewRequest("POST", "/api/orders", nil)); f != nil {
+		t.Fatalf("expected a disabled configuration to inject nothing, got %+v", f)
+	}
+	var none *Injector
+	if none.Plan(httptest.NewRequest("GET", "/", nil)) != nil {
+		t.Fatal("expected a nil injector to inject nothing")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	payload := strings.Repeat("abcdefghij", 20)
+	var injector atomic.Pointer[Injector]
+	srv := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, payload)
+	}), &injector))
+	defer srv.Close()
+	set := func(rules ...Rule) {
+		in, err := New(Config{Enabled: true, Seed: 3, Rules: rules})
+		if err != nil {
+			t.Fatal(err)
+		}
+		injector.Store(in)
+	}
+	get := func() (int, string, error) {
+		res, err := http.Get(srv.URL + "/data")
+		if err != nil {
+			return 0, "", err
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		return res.StatusCode, string(b), err
+	}
+
+	if code, body, err := get(); err != nil || code != 200 || body != payload {
+		t.Fatalf("expected a pass-through without configuration, got %d %q %v", code, body, err)
+	}
+
+	set(Rule{Probability: 1, ErrorStatus: 502, ErrorBody: "bad gateway"})
+	if code, body, _ := get(); code != 502 || body != "bad gateway" {
+		t.Fatalf("expected the injected error, got %d %q", code, body)
+	}
+
+	set(Rule{Probability: 1, CorruptRate: 0.2})
+	code, body, err := get()
+	if err != nil || code != 200 || len(body) != len(payload) || body == payload {
+		t.Fatalf("expected a corrupted body of the same length, got %d %q %v", code, body, err)
+	}
+
+	set(Rule{Probability: 1, Drop: true, DropAfterBytes: 25})
+	if _, body, err := get(); err == nil || body != payload[:25] {
+		t.Fatalf("expected the connection to drop after 25 bytes, got %q %v", body, err)
+	}
+
+	set(Rule{Probability: 1, BandwidthBytes: 1000, LatencyMillis: 50})
+	start := time.Now()
+	if _, body, err := get(); err != nil || body != payload {
+		t.Fatalf("expected a throttled body, got %q %v", body, err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("expected 200 bytes at 1000 bytes/s after 50ms latency to take longer, took %v", elapsed)
+	}
+}
@@ -0,0 +1,86 @@
+package chaos
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// faultWriter throttles, corrupts and cuts off a response body
+type faultWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	f       *Fault
+	rnd     *rand.Rand
+	written int64
+}
+
+func newFaultWriter(w http.ResponseWriter, r *http.Request, f *Fault) *faultWriter {
+	return &faultWriter{ResponseWriter: w, r: r, f: f, rnd: rand.New(rand.NewSource(f.seed))}
+}
+
+func (w *faultWriter) Write(p []byte) (int, error) {
+	if w.f.CorruptRate > 0 {
+		p = w.corrupt(p)
+	}
+	n := 0
+	for len(p) > 0 {
+		if w.f.DropAfter >= 0 && w.written >= w.f.DropAfter {
+			w.drop()
+		}
+		chunk := p
+		if w.f.DropAfter >= 0 {
+			chunk = chunk[:min(int64(len(chunk)), w.f.DropAfter-w.written)]
+		}
+		if w.f.Bandwidth > 0 {
+			// Write in pieces of a twentieth of a second each
+			chunk = chunk[:min(int64(len(chunk)), max(w.f.Bandwidth/20, 1))]
+		}
+		m, err := w.ResponseWriter.Write(chunk)
+		n += m
+		w.written += int64(m)
+		p = p[m:]
+		if err != nil {
+			return n, err
+		}
+		if w.f.Bandwidth > 0 {
+			w.Flush()
+			t := time.NewTimer(time.Duration(m) * time.Second / time.Duration(w.f.Bandwidth))
+			select {
+			case <-t.C:
+			case <-w.r.Context().Done():
+				t.Stop()
+				return n, w.r.Context().Err()
+			}
+		}
+	}
+	return n, nil
+}
+
+// corrupt returns a copy of p with a share of its bytes replaced
+func (w *faultWriter) corrupt(p []byte) []byte {
+	out := make([]byte, len(p))
+	copy(out, p)
+	for i := range out {
+		if w.rnd.Float64() < w.f.CorruptRate {
+			out[i] ^= byte(1 + w.rnd.Intn(255))
+		}
+	}
+	return out
+}
+
+// drop sends what was written so far and aborts the response, which makes
+// the server close the connection without completing the body
+func (w *faultWriter) drop() {
+	w.Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func (w *faultWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *faultWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -170,6 +170,7 @@ function getProviderIcon(provider) {
         case 'google': return 'fab fa-google';
         case 'microsoft': return 'fab fa-microsoft';
         case 'github': return 'fab fa-github';
+        case 'oidc': return 'fab fa-openid';
         default: return 'fas fa-shield-alt';
     }
 }
@@ -201,6 +202,8 @@ function getSSOLoginUrl(provider) {
         case 'microsoft':
         case 'github':
             return '/auth/' + provider;
+        case 'oidc':
+            return '/auth/oidc/login';
         default:
             return '/auth/sso/' + provider;
     }
@@ -20,6 +20,8 @@ function showTrafficPayloads(entityId, entityType) {
         '<div class="nav flex-column nav-pills" role="tablist">' +
         '<a class="nav-link active" data-toggle="pill" href="#live-tab" role="tab">Live Traffic</a>' +
         '<a class="nav-link" data-toggle="pill" href="#recent-tab" role="tab">Recent (Last 50)</a>' +
+        '<a class="nav-link" data-toggle="pill" href="#compare-tab" role="tab" onclick="loadCompareExchanges(\'' + entityId + '\', \'' + entityType + '\')">Compare</a>' +
+        '<a class="nav-link" data-toggle="pill" href="#policy-tab" role="tab" onclick="loadCapturePolicy(\'' + entityId + '\', \'' + entityType + '\')">Capture Policy</a>' +
         '</div>' +
         '</div>' +
         '<div class="col-md-9">' +
@@ -55,6 +57,10 @@ function showTrafficPayloads(entityId, entityType) {
         '<div>' +
         '<button class="btn btn-outline-primary btn-sm mr-2" onclick="loadRecentTraffic(\'' + entityId + '\', \'' + entityType + '\')">' +
         '<i class="fas fa-sync"></i> Refresh</button>' +
+        '<button class="btn btn-outline-primary btn-sm mr-2" onclick="exportTrafficHAR(\'' + entityId + '\', \'' + entityType + '\')" title="Download captured HTTP exchanges as HAR 1.2">' +
+        '<i class="fas fa-file-export"></i> Export HAR</button>' +
+        '<button class="btn btn-outline-primary btn-sm mr-2" onclick="exportTrafficPCAP(\'' + entityId + '\', \'' + entityType + '\')" title="Download captured connections as pcapng for Wireshark">' +
+        '<i class="fas fa-network-wired"></i> Export PCAP</button>' +
         '<div class="btn-group btn-group-sm" role="group">' +
         '<button id="prettyBtn" class="btn btn-outline-secondary active" onclick="toggleView(\'pretty\')">' +
         '<i class="fas fa-code"></i> Pretty</button>' +
@@ -67,6 +73,50 @@ function showTrafficPayloads(entityId, entityType) {
         '<div class="text-muted">Loading recent traffic...</div>' +
         '</div>' +
         '</div>' +
+        '<div class="tab-pane fade" id="compare-tab" role="tabpanel">' +
+        '<div class="form-row">' +
+        '<div class="form-group col-md-6"><label for="compareA">Exchange A</label>' +
+        '<select id="compareA" class="form-control form-control-sm"></select></div>' +
+        '<div class="form-group col-md-6"><label for="compareB">Exchange B</label>' +
+        '<select id="compareB" class="form-control form-control-sm"></select></div>' +
+        '</div>' +
+        '<div class="form-row align-items-end">' +
+        '<div class="form-group col-md-6"><label for="compareBEntity">B from tunnel, listener or multicast ID</label>' +
+        '<div class="input-group input-group-sm"><input id="compareBEntity" class="form-control" value="' + escapeHtml(entityId) + '">' +
+        '<div class="input-group-append"><button class="btn btn-outline-secondary" type="button" onclick="loadCompareExchanges(\'' + entityId + '\', \'' + entityType + '\', true)">Load</button></div></div></div>' +
+        '<div class="form-group col-md-6 text-right">' +
+        '<button class="btn btn-primary btn-sm" onclick="compareExchanges(\'' + entityId + '\')"><i class="fas fa-columns"></i> Compare</button></div>' +
+        '</div>' +
+        '<div id="compare-result" style="height: 320px; overflow-y: auto; border: 1px solid #ddd; padding: 10px; background: #f8f9fa;">' +
+        '<div class="text-muted">Pick two captured exchanges to compare</div>' +
+        '</div>' +
+        '</div>' +
+        '<div class="tab-pane fade" id="policy-tab" role="tabpanel">' +
+        '<form id="capturePolicyForm" onsubmit="saveCapturePolicy(\'' + entityId + '\', \'' + entityType + '\'); return false;">' +
+        '<div class="form-row">' +
+        '<div class="form-group col-md-4"><label for="policyMode">Mode</label>' +
+        '<select id="policyMode" class="form-control form-control-sm">' +
+        '<option value="full">Full</option><option value="headers">Headers only</option><option value="off">Disabled</option>' +
+        '</select></div>' +
+        '<div class="form-group col-md-4"><label for="policySampleRate">Sample rate (0-1)</label>' +
+        '<input id="policySampleRate" type="number" min="0" max="1" step="0.01" class="form-control form-control-sm" placeholder="1"></div>' +
+        '<div class="form-group col-md-4"><label for="policyMaxBody">Max body bytes</label>' +
+        '<input id="policyMaxBody" type="number" min="0" class="form-control form-control-sm" placeholder="server default"></div>' +
+        '</div>' +
+        '<div class="form-row">' +
+        '<div class="form-group col-md-5"><label for="policyInclude">Include paths (one per line, * wildcard)</label>' +
+        '<textarea id="policyInclude" rows="3" class="form-control form-control-sm" placeholder="/api/*"></textarea></div>' +
+        '<div class="form-group col-md-5"><label for="policyExclude">Exclude paths</label>' +
+        '<textarea id="policyExclude" rows="3" class="form-control form-control-sm" placeholder="/health"></textarea></div>' +
+        '<div class="form-group col-md-2"><label for="policyRetention">Retention days</label>' +
+        '<input id="policyRetention" type="number" min="0" class="form-control form-control-sm" placeholder="default"></div>' +
+        '</div>' +
+        '<button type="submit" class="btn btn-primary btn-sm"><i class="fas fa-save"></i> Save</button>' +
+        '<button type="button" class="btn btn-outline-secondary btn-sm ml-2" onclick="resetCapturePolicy(\'' + entityId + '\', \'' + entityType + '\')">' +
+        '<i class="fas fa-undo"></i> Reset to default</button>' +
+        '<span id="policyStatus" class="ml-3 text-muted"></span>' +
+        '</form>' +
+        '</div>' +
         '</div>' +
         '</div>' +
         '</div>' +
@@ -121,8 +171,24 @@ function startLiveTraffic(entityId, entityType) {
         }
     };
 
+    // The server reports events it could not replay after a reconnect
+    liveEventSource.addEventListener('dropped', function(event) {
+        try {
+            var info = JSON.parse(event.data);
+            $('#live-traffic').append('<div class="text-warning border-bottom pb-2 mb-2"><i class="fas fa-exclamation-triangle"></i> ' +
+                info.count + ' event(s) were missed</div>');
+        } catch (e) {
+            console.error('Failed to parse dropped notification:', e);
+        }
+    });
+
+    liveEventSource.onopen = function() {
+        $('#liveStatus').html('<span class="text-success"><i class="fas fa-circle"></i> Live - Connected</span>');
+    };
+
     liveEventSource.onerror = function(event) {
-        $('#liveStatus').html('<span class="text-danger"><i class="fas fa-exclamation-circle"></i> Connection Error</span>');
+        // EventSource reconnects on its own, resuming from the last event ID
+        $('#liveStatus').html('<span class="text-danger"><i class="fas fa-exclamation-circle"></i> Connection Error - Reconnecting</span>');
         setTimeout(function() {
             if (liveEventSource && liveEventSource.readyState === EventSource.CLOSED) {
                 stopLiveTraffic();
@@ -169,7 +235,9 @@ function appendLiveTraffic(data) {
         html += '</div>';
     }
     
-    if (data.body) {
+    if (data.meta && data.meta.decoded) {
+        html += formatDecodedBody(data.meta.decoded);
+    } else if (data.body) {
         html += '<div class="mt-1"><small class="text-muted">Body:</small><br>';
         html += '<pre class="traffic-payload">' + escapeHtml(data.body) + '</pre>';
         html += '</div>';
@@ -184,6 +252,171 @@ function appendLiveTraffic(data) {
     container.scrollTop = container.scrollHeight;
 }
 
+function exportTrafficHAR(entityId, entityType) {
+    function normPlural(type){ if(type==='tunnel') return 'tunnels'; if(type==='listener') return 'listeners'; if((type||'').indexOf('multicast')===0) return 'multicast'; return type; }
+    window.open('/api/capture/' + normPlural(entityType) + '/' + encodeURIComponent(entityId) + '/har', '_blank');
+}
+
+function exportTrafficPCAP(entityId, entityType) {
+    function normPlural(type){ if(type==='tunnel') return 'tunnels'; if(type==='listener') return 'listeners'; if((type||'').indexOf('multicast')===0) return 'multicast'; return type; }
+    window.open('/api/capture/' + normPlural(entityType) + '/' + encodeURIComponent(entityId) + '/pcap', '_blank');
+}
+
+function capturePolicyURL(entityId, entityType) {
+    function normPlural(type){ if(type==='tunnel') return 'tunnels'; if(type==='listener') return 'listeners'; if((type||'').indexOf('multicast')===0) return 'multicast'; return type; }
+    return '/api/capture/' + normPlural(entityType) + '/' + encodeURIComponent(entityId) + '/policy';
+}
+
+function loadCapturePolicy(entityId, entityType) {
+    $.get(capturePolicyURL(entityId, entityType))
+        .done(function(data) {
+            var p = data.effective || {};
+            $('#policyMode').val(p.mode || 'full');
+            $('#policySampleRate').val(p.sample_rate || '');
+            $('#policyMaxBody').val(p.max_body_bytes || '');
+            $('#policyInclude').val((p.include_paths || []).join('\n'));
+            $('#policyExclude').val((p.exclude_paths || []).join('\n'));
+            $('#policyRetention').val(p.retention_days || '');
+            var source = { server: 'Set in the dashboard', client: 'Requested by the client profile', 'default': 'Server defaults' };
+            $('#policyStatus').removeClass('text-danger').addClass('text-muted').text(source[data.source] || '');
+        })
+        .fail(function(xhr) {
+            $('#policyStatus').removeClass('text-muted').addClass('text-danger').text('Failed to load policy: ' + (xhr.responseText || xhr.status));
+        });
+}
+
+function saveCapturePolicy(entityId, entityType, policy) {
+    function lines(sel) { return ($(sel).val() || '').split('\n').map(function(s) { return s.trim(); }).filter(function(s) { return s; }); }
+    if (!policy) {
+        policy = {
+            mode: $('#policyMode').val(),
+            sample_rate: parseFloat($('#policySampleRate').val()) || 0,
+            max_body_bytes: parseInt($('#policyMaxBody').val(), 10) || 0,
+            include_paths: lines('#policyInclude'),
+            exclude_paths: lines('#policyExclude'),
+            retention_days: parseInt($('#policyRetention').val(), 10) || 0
+        };
+        if (policy.mode === 'full') {
+            policy.mode = '';
+        }
+    }
+    $.ajax({ url: capturePolicyURL(entityId, entityType), method: 'PUT', contentType: 'application/json', data: JSON.stringify(policy) })
+        .done(function() {
+            loadCapturePolicy(entityId, entityType);
+        })
+        .fail(function(xhr) {
+            $('#policyStatus').removeClass('text-muted').addClass('text-danger').text(xhr.responseText || 'Failed to save policy');
+        });
+}
+
+function resetCapturePolicy(entityId, entityType) {
+    saveCapturePolicy(entityId, entityType, {});
+}
+
+// Fill an exchange picker with the captured requests of an entity
+function fillExchangeSelect(select, url) {
+    return $.get(url).done(function(events) {
+        var html = '';
+        (events || []).forEach(function(e) {
+            if (e.type !== 'req_headers' || !e.meta || !e.meta.seq) {
+                return;
+            }
+            html = '<option value="' + escapeHtml(e.conn_id + '|' + e.meta.seq) + '">' +
+                escapeHtml(new Date(e.time).toLocaleTimeString() + ' ' + e.meta.method + ' ' + e.meta.path + ' (' + e.conn_id + ' #' + e.meta.seq + ')') +
+                '</option>' + html;
+        });
+        $(select).html(html || '<option value="">No captured requests</option>');
+    }).fail(function() {
+        $(select).html('<option value="">Failed to load exchanges</option>');
+    });
+}
+
+function loadCompareExchanges(entityId, entityType, onlyB) {
+    function normPlural(type){ if(type==='tunnel') return 'tunnels'; if(type==='listener') return 'listeners'; if((type||'').indexOf('multicast')===0) return 'multicast'; return type; }
+    var other = $('#compareBEntity').val() || entityId;
+    if (!onlyB) {
+        fillExchangeSelect('#compareA', '/api/capture/' + normPlural(entityType) + '/' + encodeURIComponent(entityId) + '/recent');
+    }
+    if (other === entityId) {
+        fillExchangeSelect('#compareB', '/api/capture/' + normPlural(entityType) + '/' + encodeURIComponent(entityId) + '/recent');
+        return;
+    }
+    // Other entities may be of any type; the first one that answers wins
+    var types = ['tunnels', 'listeners', 'multicast'];
+    (function next(i) {
+        if (i >= types.length) {
+            $('#compareB').html('<option value="">No captured requests</option>');
+            return;
+        }
+        fillExchangeSelect('#compareB', '/api/capture/' + types[i] + '/' + encodeURIComponent(other) + '/recent')
+            .done(function(events) { if (!(events || []).length) next(i + 1); })
+            .fail(function() { next(i + 1); });
+    })(0);
+}
+
+function compareExchanges(entityId) {
+    var a = ($('#compareA').val() || '').split('|');
+    var b = ($('#compareB').val() || '').split('|');
+    if (a.length !== 2 || b.length !== 2) {
+        $('#compare-result').html('<div class="text-warning">Pick two exchanges first</div>');
+        return;
+    }
+    var query = $.param({
+        a_entity: entityId, a_conn: a[0], a_seq: a[1],
+        b_entity: $('#compareBEntity').val() || entityId, b_conn: b[0], b_seq: b[1]
+    });
+    $.get('/api/capture/diff?' + query)
+        .done(function(diff) {
+            $('#compare-result').html(formatExchangeDiff(diff));
+        })
+        .fail(function(xhr) {
+            $('#compare-result').html('<div class="text-danger">' + escapeHtml(xhr.responseText || 'Failed to compare exchanges') + '</div>');
+        });
+}
+
+// Render a structured exchange diff (GET /api/capture/diff)
+function formatExchangeDiff(diff) {
+    if (diff.equal) {
+        return '<div class="text-success"><i class="fas fa-check"></i> The exchanges are identical</div>';
+    }
+    function val(v) { return escapeHtml(typeof v === 'string' ? v : JSON.stringify(v)); }
+    function section(title, m) {
+        var html = '<h6 class="mt-2">' + title + '</h6>';
+        (m.start || []).forEach(function(c) {
+            html += '<div><code>' + escapeHtml(c.field) + '</code>: <del class="text-danger">' + val(c.a) + '</del> &rarr; <ins class="text-success">' + val(c.b) + '</ins></div>';
+        });
+        (m.headers || []).forEach(function(h) {
+            var cls = h.op === 'added' ? 'text-success' : h.op === 'removed' ? 'text-danger' : 'text-warning';
+            html += '<div class="' + cls + '"><small>' + escapeHtml(h.op) + '</small> <code>' + escapeHtml(h.name) + '</code>' +
+                (h.a ? ' <del>' + val(h.a.join(', ')) + '</del>' : '') + (h.b ? ' <ins>' + val(h.b.join(', ')) + '</ins>' : '') + '</div>';
+        });
+        var body = m.body || {};
+        var label = body.kind + ' body, ' + body.size_a + ' &rarr; ' + body.size_b + ' bytes' + (body.truncated ? ', truncated' : '');
+        html += '<div class="mt-1"><small class="text-muted">' + label + '</small></div>';
+        if (body.equal) {
+            return html + '<small class="text-muted">Bodies are identical</small>';
+        }
+        (body.json || []).forEach(function(c) {
+            var cls = c.op === 'added' ? 'text-success' : c.op === 'removed' ? 'text-danger' : 'text-warning';
+            html += '<div class="' + cls + '"><code>' + escapeHtml(c.path || '(root)') + '</code> ' + escapeHtml(c.op) +
+                ('a' in c ? ' <del>' + val(c.a) + '</del>' : '') + ('b' in c ? ' <ins>' + val(c.b) + '</ins>' : '') + '</div>';
+        });
+        if (body.lines && body.lines.length) {
+            html += '<pre class="traffic-payload">';
+            body.lines.forEach(function(l) {
+                var mark = l.op === 'added' ? '+' : l.op === 'removed' ? '-' : ' ';
+                var cls = l.op === 'added' ? 'text-success' : l.op === 'removed' ? 'text-danger' : 'text-muted';
+                html += '<span class="' + cls + '">' + mark + ' ' + escapeHtml(l.text) + '</span>\n';
+            });
+            html += '</pre>';
+        } else if (body.kind === 'binary') {
+            html += '<small class="text-warning">(binary content differs)</small>';
+        }
+        return html;
+    }
+    return section('Request', diff.request) + section('Response', diff.response);
+}
+
 function loadRecentTraffic(entityId, entityType) {
     var filter = $('#filterType').val();
     function normPlural(type){ if(type==='tunnel') return 'tunnels'; if(type==='listener') return 'listeners'; if((type||'').indexOf('multicast')===0) return 'multicast'; return type; }
@@ -246,7 +479,9 @@ function formatPrettyTraffic(item) {
         html += '</div>';
     }
     
-    if (item.body) {
+    if (item.meta && item.meta.decoded) {
+        html += formatDecodedBody(item.meta.decoded);
+    } else if (item.body) {
         html += '<div class="mt-1"><small class="text-muted">Body:</small><br>';
         try {
             var parsed = JSON.parse(item.body);
@@ -260,6 +495,57 @@ function formatPrettyTraffic(item) {
     return html;
 }
 
+// Render the server-side decoded view of a body (meta.decoded on the final body event)
+function formatDecodedBody(decoded) {
+    var label = decoded.kind + (decoded.content_type ? ', ' + decoded.content_type : '') +
+        (decoded.encoding ? ', decoded from ' + decoded.encoding : '') + ', ' + decoded.size + ' bytes' +
+        (decoded.truncated ? ', truncated' : '');
+    var html = '<div class="mt-1"><small class="text-muted">Body (' + escapeHtml(label) + '):</small><br>';
+
+    if (decoded.fields && decoded.fields.length) {
+        html += '<table class="table table-sm table-bordered mb-1"><tbody>';
+        decoded.fields.forEach(function(f) {
+            html += '<tr><td><code>' + escapeHtml(f.name) + '</code></td><td>' + escapeHtml(f.value) + '</td></tr>';
+        });
+        html += '</tbody></table>';
+    }
+    if (decoded.parts && decoded.parts.length) {
+        decoded.parts.forEach(function(p) {
+            html += '<div class="border-left pl-2 mb-1"><small><strong>' + escapeHtml(p.name || '(unnamed part)') + '</strong>' +
+                (p.filename ? ' &middot; ' + escapeHtml(p.filename) : '') +
+                (p.content_type ? ' &middot; ' + escapeHtml(p.content_type) : '') + ' &middot; ' + p.size + ' bytes</small>';
+            if (p.image) {
+                html += '<br><small>' + formatImageInfo(p.image) + '</small>';
+            } else if (p.text) {
+                html += '<pre class="traffic-payload">' + escapeHtml(p.text) + '</pre>';
+            }
+            html += '</div>';
+        });
+    }
+    if (decoded.image) {
+        html += '<small>' + formatImageInfo(decoded.image) + '</small>';
+    }
+    if (decoded.text) {
+        html += '<pre class="traffic-payload">' + escapeHtml(decoded.text) + '</pre>';
+    }
+    if (decoded.kind === 'binary') {
+        html += '<small class="text-muted">(binary content)</small>';
+    }
+    if (decoded.error) {
+        html += '<br><small class="text-warning">' + escapeHtml(decoded.error) + '</small>';
+    }
+    html += '</div>';
+    return html;
+}
+
+function formatImageInfo(img) {
+    var text = '<i class="fas fa-image"></i> ' + escapeHtml((img.format || 'image').toUpperCase());
+    if (img.width && img.height) {
+        text += ' ' + img.width + '&times;' + img.height;
+    }
+    return text;
+}
+
 function formatRawTraffic(item) {
     var html = '<div class="mt-1"><pre class="traffic-payload">';
     
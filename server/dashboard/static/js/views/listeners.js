@@ -66,7 +66,7 @@ function loadListenersData() {
                         console.log('🚫 Skipping AI listener:', listener.id, 'mode:', listener.mode);
                         return; // Skip AI listeners entirely
                     }
-                    if (!(modeStr === 'proxy' || modeStr === 'static')) {
+                    if (!(modeStr === 'proxy' || modeStr === 'static' || modeStr === 'mock' || modeStr === 'openapi' || modeStr === 'record' || modeStr === 'replay')) {
                         console.log('🚫 Skipping non-regular listener:', listener.id, 'mode:', listener.mode);
                         return; // Skip any unknown modes
                     }
@@ -88,6 +88,14 @@ function loadListenersData() {
                                 listener.response.substring(0, 50) + '...' :
                                 listener.response) : 'Empty';
                         targetDisplay = '<small class="text-muted">' + escapeHtml(responsePreview) + '</small>';
+                    } else if (listener.mode === 'mock') {
+                        targetDisplay = '<small class="text-muted">Rule-based mock</small>';
+                    } else if (listener.mode === 'openapi') {
+                        targetDisplay = '<small class="text-muted">OpenAPI mock</small>';
+                    } else if (listener.mode === 'record') {
+                        targetDisplay = '<small class="text-muted">Recording → ' + escapeHtml(listener.target_url || 'N/A') + '</small>';
+                    } else if (listener.mode === 'replay') {
+                        targetDisplay = '<small class="text-muted">Replaying fixtures (' + escapeHtml(listener.match_keys || 'method,path,query') + ')</small>';
                     } else if (listener.mode === 'ai-mock') {
                         var aiStatus = listener.ai_generation_status || 'pending';
                         var statusClass = aiStatus === 'success' ? 'text-success' :
@@ -118,6 +126,11 @@ function loadListenersData() {
                         '<i class="fas fa-eye"></i></button>' +
                         '<button class="btn btn-outline-warning" onclick="editListener(\'' + listener.id + '\')" title="Edit Listener">' +
                         '<i class="fas fa-edit"></i></button>' +
+                        (listener.mode === 'record' || listener.mode === 'replay' ?
+                            '<button class="btn btn-outline-secondary" onclick="exportFixtures(\'' + listener.id + '\')" title="Export Fixtures">' +
+                            '<i class="fas fa-download"></i></button>' +
+                            '<button class="btn btn-outline-secondary" onclick="importFixtures(\'' + listener.id + '\')" title="Import Fixtures">' +
+                            '<i class="fas fa-upload"></i></button>' : '') +
                         '<button class="btn btn-outline-danger" onclick="deleteListener(\'' + listener.id + '\')" title="Delete">' +
                         '<i class="fas fa-trash"></i></button>' +
                         '</div>' +
@@ -149,6 +162,12 @@ function loadListenersData() {
         });
 }
 
+// Starting point for new mock listeners
+var mockRulesExample = JSON.stringify([
+    { name: 'get user', method: 'GET', path: '/users/{id}', response: { status: 200, body: '{"id": {{json .Params.id}}, "name": "Ada"}' } },
+    { name: 'create user', method: 'POST', path: '/users', json: { role: 'admin' }, response: { status: 201, headers: { 'Location': '/users/{{uuid}}' }, delay_ms: 100 } }
+], null, 2);
+
 // Listener management functions
 function showAddListenerModal() {
     console.log('Opening Add Listener Modal - AI Mock API option should be visible');
@@ -173,6 +192,10 @@ function showAddListenerModal() {
         '<select class="form-control" id="newMode" onchange="toggleModeFields()">' +
         '<option value="proxy">Proxy</option>' +
         '<option value="static">Static Response</option>' +
+        '<option value="mock">Mock Rules</option>' +
+        '<option value="openapi">OpenAPI Mock</option>' +
+        '<option value="record">Record</option>' +
+        '<option value="replay">Replay</option>' +
         '<option value="ai-mock" style="background-color: #e3f2fd; font-weight: bold;">🤖 AI Mock API</option>' +
         '</select>' +
         '<small class="form-text text-muted">Select "AI Mock API" to create intelligent mock APIs from OpenAPI specifications</small>' +
@@ -185,6 +208,21 @@ function showAddListenerModal() {
         '<label for="newResponse">Static Response</label>' +
         '<textarea class="form-control" id="newResponse" rows="4" placeholder="Hello World!"></textarea>' +
         '</div>' +
+        '<div class="form-group" id="mockRulesGroup" style="display: none;">' +
+        '<label for="newMockRules">Mock Rules (JSON)</label>' +
+        '<textarea class="form-control" id="newMockRules" rows="8" style="font-family: monospace;">' + escapeHtml(mockRulesExample) + '</textarea>' +
+        '<small class="form-text text-muted">Ordered rules; the first matching one answers. Bodies and header values are Go templates over the request, e.g. {{.Params.id}}, {{json .Query.page}}, {{get .JSON &quot;user.name&quot;}}</small>' +
+        '</div>' +
+        '<div class="form-group" id="openapiGroup" style="display: none;">' +
+        '<label for="newOpenAPISpec">OpenAPI 3 Specification (JSON or YAML)</label>' +
+        '<textarea class="form-control" id="newOpenAPISpec" rows="10" style="font-family: monospace;"></textarea>' +
+        '<small class="form-text text-muted">Responses use the spec\'s examples, or data generated from the schemas. No AI provider needed.</small>' +
+        '</div>' +
+        '<div class="form-group" id="matchKeysGroup" style="display: none;">' +
+        '<label for="newMatchKeys">Match Keys</label>' +
+        '<input type="text" class="form-control" id="newMatchKeys" value="method,path,query">' +
+        '<small class="form-text text-muted">What a request must share with a recorded one to be answered by it: any of method, path, query, body. Import fixtures after creating the listener.</small>' +
+        '</div>' +
         '<div class="form-group" id="aiGroup" style="display: none; background-color: #f8f9fa; padding: 15px; border-radius: 5px; border: 2px solid #007bff;">' +
         '<h5 style="color: #007bff; margin-bottom: 15px;">🤖 AI Mock API Configuration</h5>' +
         '<div class="row">' +
@@ -256,11 +294,20 @@ function toggleModeFields() {
     $('#targetUrlGroup').hide();
     $('#responseGroup').hide();
     $('#aiGroup').hide();
+    $('#mockRulesGroup').hide();
+    $('#openapiGroup').hide();
+    $('#matchKeysGroup').hide();
 
-    if (mode === 'proxy') {
+    if (mode === 'proxy' || mode === 'record') {
         $('#targetUrlGroup').show();
+    } else if (mode === 'replay') {
+        $('#matchKeysGroup').show();
     } else if (mode === 'static') {
         $('#responseGroup').show();
+    } else if (mode === 'mock') {
+        $('#mockRulesGroup').show();
+    } else if (mode === 'openapi') {
+        $('#openapiGroup').show();
     } else if (mode === 'ai-mock') {
         console.log('AI Mock mode selected - showing AI fields');
         $('#aiGroup').show();
@@ -279,6 +326,31 @@ function createListener() {
         use_tls: $('#newUseTLS').is(':checked')
     };
 
+    if (mode === 'mock' || mode === 'openapi' || mode === 'record' || mode === 'replay') {
+        if (mode === 'openapi') {
+            listenerData.openapi_spec = $('#newOpenAPISpec').val();
+        } else if (mode === 'replay') {
+            listenerData.match_keys = splitMatchKeys($('#newMatchKeys').val());
+        } else if (mode === 'mock') {
+            try {
+                listenerData.rules = JSON.parse($('#newMockRules').val() || '[]');
+            } catch (e) {
+                alert('Mock rules are not valid JSON: ' + e.message);
+                return;
+            }
+        }
+        $.ajax({ url: '/api/listeners', method: 'POST', data: JSON.stringify(listenerData), contentType: 'application/json' })
+        .done(function() {
+            $('#addListenerModal').modal('hide');
+            loadListenersData();
+            alert('Listener created successfully');
+        })
+        .fail(function(xhr) {
+            alert('Failed to create listener: ' + (xhr.responseText || 'Unknown error'));
+        });
+        return;
+    }
+
     // Handle AI-specific data
     if (mode === 'ai-mock') {
         listenerData.ai_provider_id = $('#newAIProvider').val();
@@ -357,11 +429,33 @@ function editListener(listenerID) {
                 '<label for="editMode">Mode (read-only)</label>' +
                 '<input type="text" class="form-control" id="editMode" value="' + listener.mode + '" readonly>' +
                 '</div>' +
-                (listener.mode === 'proxy' ? 
+                (listener.mode === 'mock' ?
+                    '<div class="form-group">' +
+                    '<label for="editMockRules">Mock Rules (JSON)</label>' +
+                    '<textarea class="form-control" id="editMockRules" rows="10" style="font-family: monospace;">Loading...</textarea>' +
+                    '</div>' :
+                listener.mode === 'openapi' ?
+                    '<div class="form-group">' +
+                    '<label for="editOpenAPISpec">OpenAPI 3 Specification (JSON or YAML)</label>' +
+                    '<textarea class="form-control" id="editOpenAPISpec" rows="12" style="font-family: monospace;">' + escapeHtml(listener.openapi_spec || '') + '</textarea>' +
+                    '</div>' :
+                listener.mode === 'replay' ?
+                    '<div class="form-group">' +
+                    '<label for="editMatchKeys">Match Keys</label>' +
+                    '<input type="text" class="form-control" id="editMatchKeys" value="' + escapeHtml(listener.match_keys || 'method,path,query') + '">' +
+                    '<small class="form-text text-muted">Any of method, path, query, body</small>' +
+                    '</div>' :
+                listener.mode === 'proxy' || listener.mode === 'record' ? 
                     '<div class="form-group">' +
                     '<label for="editTargetUrl">Target URL</label>' +
                     '<input type="url" class="form-control" id="editTargetUrl" value="' + (listener.target_url || '') + '">' +
-                    '</div>' : 
+                    '</div>' +
+                    (listener.mode === 'proxy' ?
+                        '<div class="form-group">' +
+                        '<label for="editChaos">Fault Injection (JSON)</label>' +
+                        '<textarea class="form-control" id="editChaos" rows="8" style="font-family: monospace;">Loading...</textarea>' +
+                        '<small class="form-text text-muted">{"enabled": true, "rules": [{"method", "path", "probability", "latency_ms", "jitter_ms", "error_status", "error_body", "drop", "drop_after_bytes", "bandwidth_bps", "corrupt_rate"}]}. Every enabled matching rule applies, to all requests unless "probability" (0 to 1) is given; changes take effect immediately.</small>' +
+                        '</div>' : '') : 
                     '<div class="form-group">' +
                     '<label for="editResponse">Static Response</label>' +
                     '<textarea class="form-control" id="editResponse" rows="4">' + (listener.response || '') + '</textarea>' +
@@ -382,6 +476,16 @@ function editListener(listenerID) {
 
             $('body').append(modalHtml);
             $('#editListenerModal').modal('show');
+            if (listener.mode === 'mock') {
+                $.get('/api/listener/' + listener.id + '/rules').done(function(data) {
+                    $('#editMockRules').val(JSON.stringify(data.rules || [], null, 2));
+                });
+            }
+            if (listener.mode === 'proxy') {
+                $.get('/api/listener/' + listener.id + '/chaos').done(function(data) {
+                    $('#editChaos').val(JSON.stringify(data, null, 2));
+                });
+            }
 
             $('#editListenerModal').on('hidden.bs.modal', function() {
                 $(this).remove();
@@ -393,12 +497,44 @@ function editListener(listenerID) {
 }
 
 function updateListener(listenerID) {
+    if ($('#editMockRules').length) {
+        var rules;
+        try {
+            rules = JSON.parse($('#editMockRules').val() || '[]');
+        } catch (e) {
+            alert('Mock rules are not valid JSON: ' + e.message);
+            return;
+        }
+        $.ajax({ url: '/api/listener/' + listenerID + '/rules', method: 'PUT', data: JSON.stringify({ rules: rules }), contentType: 'application/json' })
+        .fail(function(xhr) {
+            alert('Failed to update mock rules: ' + (xhr.responseText || 'Unknown error'));
+        });
+    }
+    if ($('#editChaos').length) {
+        var chaos;
+        try {
+            chaos = JSON.parse($('#editChaos').val() || '{}');
+        } catch (e) {
+            alert('Fault injection is not valid JSON: ' + e.message);
+            return;
+        }
+        $.ajax({ url: '/api/listener/' + listenerID + '/chaos', method: 'PUT', data: JSON.stringify(chaos), contentType: 'application/json' })
+        .fail(function(xhr) {
+            alert('Failed to update fault injection: ' + (xhr.responseText || 'Unknown error'));
+        });
+    }
     var updateData = {
         name: $('#editName').val().trim(),
         target_url: $('#editTargetUrl').val(),
         response: $('#editResponse').val(),
         use_tls: $('#editUseTLS').is(':checked')
     };
+    if ($('#editOpenAPISpec').length) {
+        updateData.openapi_spec = $('#editOpenAPISpec').val();
+    }
+    if ($('#editMatchKeys').length) {
+        updateData.match_keys = splitMatchKeys($('#editMatchKeys').val());
+    }
 
     $.ajax({
         url: '/api/listener/' + listenerID,
@@ -423,6 +559,39 @@ function updateListener(listenerID) {
     });
 }
 
+function splitMatchKeys(value) {
+    return (value || '').split(',').map(function(k) { return k.trim(); }).filter(function(k) { return k; });
+}
+
+// Download the fixtures of a record or replay listener as JSON
+function exportFixtures(listenerID) {
+    window.location = '/api/listener/' + listenerID + '/fixtures?download=true';
+}
+
+// Replace the fixtures of a record or replay listener with an exported file
+function importFixtures(listenerID) {
+    var input = $('<input type="file" accept=".json,application/json">');
+    input.on('change', function() {
+        var file = this.files && this.files[0];
+        if (!file) {
+            return;
+        }
+        var reader = new FileReader();
+        reader.onload = function(e) {
+            $.ajax({ url: '/api/listener/' + listenerID + '/fixtures?replace=true', method: 'POST', data: e.target.result, contentType: 'application/json' })
+            .done(function(data) {
+                loadListenersData();
+                alert('Imported ' + data.imported + ' fixtures');
+            })
+            .fail(function(xhr) {
+                alert('Failed to import fixtures: ' + (xhr.responseText || 'Unknown error'));
+            });
+        };
+        reader.readAsText(file);
+    });
+    input.trigger('click');
+}
+
 function deleteListener(listenerID) {
     if (confirm('Are you sure you want to delete this listener? This will stop the listener and remove all associated data.')) {
         $.ajax({
@@ -21,6 +21,9 @@ function loadServerSettingsView() {
         '<a class="nav-link" id="security-tab" data-toggle="tab" href="#security" role="tab">Security</a>' +
         '</li>' +
         '<li class="nav-item">' +
+        '<a class="nav-link" id="access-tab" data-toggle="tab" href="#access" role="tab">Access Control</a>' +
+        '</li>' +
+        '<li class="nav-item">' +
         '<a class="nav-link" id="ai-providers-tab" data-toggle="tab" href="#ai-providers" role="tab">AI Providers</a>' +
         '</li>' +
         '</ul>' +
@@ -35,6 +38,11 @@ function loadServerSettingsView() {
         '<div class="text-center text-muted">Loading security settings...</div>' +
         '</div>' +
         '</div>' +
+        '<div class="tab-pane fade" id="access" role="tabpanel">' +
+        '<div id="access-control-content">' +
+        '<div class="text-center text-muted">Loading groups and roles...</div>' +
+        '</div>' +
+        '</div>' +
 
         '<div class="tab-pane fade" id="ai-providers" role="tabpanel">' +
         '<div class="d-flex justify-content-between align-items-center mb-3">' +
@@ -66,6 +74,8 @@ function loadServerSettingsView() {
             loadAIProviders();
         } else if (target === '#security') {
             loadSecuritySettings();
+        } else if (target === '#access') {
+            loadAccessControl();
         } else if (target === '#general') {
             loadGeneralSettings();
         }
@@ -118,6 +128,16 @@ function loadGeneralSettings() {
         '<div class="text-center text-muted">Loading SSO configurations...</div>' +
         '</div>' +
         '</div>' +
+        '</div>' +
+        '<div class="card">' +
+        '<div class="card-header">' +
+        '<h3 class="card-title">SCIM Provisioning</h3>' +
+        '</div>' +
+        '<div class="card-body">' +
+        '<div id="scim-settings-container">' +
+        '<div class="text-center text-muted">Loading SCIM settings...</div>' +
+        '</div>' +
+        '</div>' +
         '</div></div>' +
         '</div>';
 
@@ -136,6 +156,7 @@ function loadGeneralSettings() {
     loadSettingsSystemInfo();
     loadReservedPortsThreshold();
     loadSSOConfigs();
+    loadSCIMSettings();
 }
 
 // AI Provider Management Functions
@@ -607,6 +628,63 @@ function loadSSOConfigs() {
         });
 }
 
+function loadSCIMSettings() {
+    $.get('/api/settings/scim')
+        .done(function(scim) {
+            var html = '';
+            if (!scim.available) {
+                html = '<div class="text-muted">SCIM provisioning requires a database.</div>';
+            } else {
+                html = '<div class="form-group">' +
+                    '<label>SCIM Base URL</label>' +
+                    '<input type="text" class="form-control" readonly value="' + escapeHtml(scim.endpoint) + '">' +
+                    '</div>' +
+                    '<p>Status: ' + (scim.enabled ?
+                        '<span class="badge badge-success">Enabled</span>' :
+                        '<span class="badge badge-secondary">Disabled</span>') + '</p>' +
+                    '<div id="scim-token-display"></div>' +
+                    '<button class="btn btn-primary btn-sm" onclick="generateSCIMToken()">' +
+                    '<i class="fas fa-key"></i> ' + (scim.enabled ? 'Rotate Token' : 'Generate Token') + '</button>' +
+                    (scim.enabled ? '<button class="btn btn-danger btn-sm ml-2" onclick="revokeSCIMToken()">' +
+                        '<i class="fas fa-ban"></i> Revoke Token</button>' : '') +
+                    '<small class="form-text text-muted">Configure your identity provider to push users and groups to this endpoint using the bearer token.</small>';
+            }
+            $('#scim-settings-container').html(html);
+        })
+        .fail(function() {
+            $('#scim-settings-container').html('<div class="text-danger text-center">Failed to load SCIM settings</div>');
+        });
+}
+
+function generateSCIMToken() {
+    if (!confirm('Generate a new SCIM token? Any existing token stops working immediately.')) {
+        return;
+    }
+    $.post('/api/settings/scim/token')
+        .done(function(res) {
+            loadSCIMSettings();
+            setTimeout(function() {
+                $('#scim-token-display').html('<div class="alert alert-warning">' +
+                    '<strong>Copy this token now; it will not be shown again:</strong><br>' +
+                    '<code>' + escapeHtml(res.token) + '</code></div>');
+            }, 300);
+        })
+        .fail(function(xhr) {
+            alert('Failed to generate SCIM token: ' + (xhr.responseText || 'Unknown error'));
+        });
+}
+
+function revokeSCIMToken() {
+    if (!confirm('Revoke the SCIM token? Provisioning from your identity provider will stop.')) {
+        return;
+    }
+    $.ajax({ url: '/api/settings/scim/token', method: 'DELETE' })
+        .done(function() { loadSCIMSettings(); })
+        .fail(function(xhr) {
+            alert('Failed to revoke SCIM token: ' + (xhr.responseText || 'Unknown error'));
+        });
+}
+
 function formatUptime(seconds) {
     var days = Math.floor(seconds / 86400);
     var hours = Math.floor((seconds % 86400) / 3600);
@@ -682,11 +760,13 @@ function loadSecuritySettings() {
     $.when(
         $.get('/api/settings/login-backoff'),
         $.get('/api/settings/ip-rate'),
-        $.get('/api/settings/session')
-    ).done(function(lbRes, ipRes, sessRes) {
+        $.get('/api/settings/session'),
+        $.get('/api/settings/mfa')
+    ).done(function(lbRes, ipRes, sessRes, mfaRes) {
         var lb = (lbRes && lbRes[0]) || {};
         var ip = (ipRes && ipRes[0]) || {};
         var sess = (sessRes && sessRes[0]) || {};
+        var mfa = (mfaRes && mfaRes[0]) || {};
         var html = '' +
         '<div class="row">' +
         '  <div class="col-md-8">' +
@@ -755,6 +835,17 @@ function loadSecuritySettings() {
         '      </div>' +
         '    </div>' +
         '    <div class="card mb-3">' +
+        '      <div class="card-header"><h3 class="card-title">Two-Factor Authentication</h3></div>' +
+        '      <div class="card-body">' +
+        '        <div class="form-group form-check">' +
+        '           <input type="checkbox" class="form-check-input" id="mfa-required" ' + ((mfa.required ? 'checked' : '')) + '>' +
+        '           <label class="form-check-label" for="mfa-required">Require TOTP for all database users on dashboard login</label>' +
+        '           <small class="form-text text-muted">Users without an authenticator are asked to enroll at their next sign-in</small>' +
+        '        </div>' +
+        '        <button class="btn btn-primary" onclick="updateMFASettings()"><i class="fas fa-save"></i> Save</button>' +
+        '      </div>' +
+        '    </div>' +
+        '    <div class="card mb-3">' +
         '      <div class="card-header d-flex justify-content-between align-items-center">' +
         '        <h3 class="card-title mb-0">Recent Security Events</h3>' +
         '        <button class="btn btn-sm btn-outline-secondary" onclick="loadSecurityEvents()"><i class="fas fa-sync"></i> Refresh</button>' +
@@ -763,6 +854,25 @@ function loadSecuritySettings() {
         '        <div id="security-events-list" class="small text-muted">Loading...</div>' +
         '      </div>' +
         '    </div>' +
+        '    <div class="card mb-3">' +
+        '      <div class="card-header d-flex justify-content-between align-items-center">' +
+        '        <h3 class="card-title mb-0">Audit Log</h3>' +
+        '        <div>' +
+        '          <button class="btn btn-sm btn-outline-secondary" onclick="verifyAuditLog()"><i class="fas fa-link"></i> Verify chain</button>' +
+        '          <a class="btn btn-sm btn-outline-secondary" href="/api/audit/export?format=json"><i class="fas fa-download"></i> JSON</a>' +
+        '          <a class="btn btn-sm btn-outline-secondary" href="/api/audit/export?format=csv"><i class="fas fa-download"></i> CSV</a>' +
+        '          <button class="btn btn-sm btn-outline-secondary" onclick="loadAuditLog()"><i class="fas fa-sync"></i> Refresh</button>' +
+        '        </div>' +
+        '      </div>' +
+        '      <div class="card-body">' +
+        '        <div class="form-group form-check">' +
+        '           <input type="checkbox" class="form-check-input" id="audit-forward" onchange="updateAuditSettings()">' +
+        '           <label class="form-check-label" for="audit-forward">Forward audit entries to security webhooks</label>' +
+        '        </div>' +
+        '        <div id="audit-verify-result" class="small mb-2"></div>' +
+        '        <div id="audit-log-list" class="small text-muted">Loading...</div>' +
+        '      </div>' +
+        '    </div>' +
         '    <div class="card">' +
         '      <div class="card-header"><h3 class="card-title">Security Event Webhooks</h3></div>' +
         '      <div class="card-body">' +
@@ -797,6 +907,7 @@ function loadSecuritySettings() {
         '</div>';
         $('#security-settings-content').html(html);
         loadSecurityEvents();
+        loadAuditLog();
         loadSecurityWebhooks();
     }).fail(function() {
         $('#security-settings-content').html('<div class="alert alert-danger">Failed to load security settings</div>');
@@ -849,6 +960,57 @@ function loadSecurityEvents() {
     });
 }
 
+function loadAuditLog() {
+  $.get('/api/settings/audit').done(function(cfg){
+    $('#audit-forward').prop('checked', !!(cfg && cfg.forward_webhooks));
+  });
+  $.get('/api/audit?limit=20')
+    .done(function(items){
+      if (!items || items.length === 0) {
+        $('#audit-log-list').html('<div class="text-muted">No audit entries</div>');
+        return;
+      }
+      var html = '<ul class="list-group list-group-flush">';
+      items.forEach(function(e){
+        var when = new Date(e.at).toLocaleString();
+        html += '<li class="list-group-item p-2">' +
+          '<span class="badge badge-' + (e.status >= 400 ? 'warning' : 'secondary') + ' mr-2">' + e.status + '</span>' +
+          '<strong>' + escapeHtml(e.action) + '</strong> ' + escapeHtml(e.target || '') +
+          ' <span class="text-muted">by ' + escapeHtml(e.actor) + (e.ip ? ' from ' + escapeHtml(e.ip) : '') + '</span>' +
+          ' <span class="text-muted float-right">#' + e.seq + ' ' + when + '</span>' +
+          (e.diff ? '<div class="text-monospace text-muted text-break">' + escapeHtml(e.diff) + '</div>' : '') +
+          '</li>';
+      });
+      html += '</ul>';
+      $('#audit-log-list').html(html);
+    })
+    .fail(function(){
+      $('#audit-log-list').html('<div class="text-danger">Failed to load audit log</div>');
+    });
+}
+
+function verifyAuditLog() {
+  $.get('/api/audit/verify')
+    .done(function(res){
+      if (res.valid) {
+        $('#audit-verify-result').html('<span class="text-success"><i class="fas fa-check"></i> Chain intact (' + res.checked + ' entries)</span>');
+      } else {
+        $('#audit-verify-result').html('<span class="text-danger"><i class="fas fa-exclamation-triangle"></i> Chain broken at entry #' + res.broken_at + '</span>');
+      }
+    })
+    .fail(function(xhr){ alert('Failed to verify audit log: ' + (xhr.responseText || 'Unknown error')); });
+}
+
+function updateAuditSettings() {
+  $.ajax({
+    url: '/api/settings/audit',
+    method: 'PUT',
+    contentType: 'application/json',
+    data: JSON.stringify({ forward_webhooks: $('#audit-forward').is(':checked') })
+  })
+  .fail(function(xhr){ alert('Failed to update audit settings: ' + (xhr.responseText || 'Unknown error')); });
+}
+
 function loadSecurityWebhooks() {
   $.get('/api/security/webhooks')
     .done(function(rows){
@@ -924,6 +1086,17 @@ function updateSessionSettings() {
   .fail(function(xhr){ alert('Failed to update session TTL: ' + (xhr.responseText || 'Unknown error')); });
 }
 
+function updateMFASettings() {
+  $.ajax({
+    url: '/api/settings/mfa',
+    method: 'PUT',
+    contentType: 'application/json',
+    data: JSON.stringify({ required: $('#mfa-required').is(':checked') })
+  })
+  .done(function(){ alert('Two-factor policy updated'); })
+  .fail(function(xhr){ alert('Failed to update two-factor policy: ' + (xhr.responseText || 'Unknown error')); });
+}
+
 function updateIPRateSettings() {
   var payload = {
     max_per_minute: parseInt($('#ip-max-per-minute').val(), 10) || 0,
@@ -945,3 +1118,201 @@ function testSecurityWebhook(id) {
     .done(function(){ alert('Test message sent'); })
     .fail(function(xhr){ alert('Failed to send test: ' + (xhr.responseText || 'Unknown error')); });
 }
+
+// ---- Access Control: groups and roles ----
+
+var accessControlGroups = [];
+var accessControlRoles = [];
+
+function loadAccessControl() {
+    $.when($.get('/api/groups'), $.get('/api/roles'))
+        .done(function(groupsRes, rolesRes) {
+            accessControlGroups = groupsRes[0] || [];
+            accessControlRoles = rolesRes[0] || [];
+            renderAccessControl();
+        })
+        .fail(function() {
+            $('#access-control-content').html('<div class="text-danger text-center">Failed to load groups and roles</div>');
+        });
+}
+
+function groupNameByID(id) {
+    for (var i = 0; i < accessControlGroups.length; i++) {
+        if (accessControlGroups[i].id === id) {
+            return accessControlGroups[i].name;
+        }
+    }
+    return id;
+}
+
+function describeRule(rule) {
+    var text = rule.action;
+    if (rule.ports) text += ' ports ' + rule.ports;
+    if (rule.modes && rule.modes.length) text += ' modes ' + rule.modes.join('/');
+    if (rule.scope) text += ' scope ' + rule.scope;
+    return text;
+}
+
+function renderAccessControl() {
+    var groupsHtml = '';
+    accessControlGroups.forEach(function(g) {
+        groupsHtml += '<tr>' +
+            '<td><strong>' + escapeHtml(g.name) + '</strong>' + (g.external_id ? ' <span class="badge badge-info">SCIM</span>' : '') + '</td>' +
+            '<td>' + escapeHtml((g.members || []).join(', ')) + '</td>' +
+            '<td><div class="btn-group btn-group-sm">' +
+            '<button class="btn btn-outline-warning" onclick="showGroupModal(\'' + g.id + '\')"><i class="fas fa-edit"></i></button>' +
+            '<button class="btn btn-outline-danger" onclick="deleteGroup(\'' + g.id + '\')"><i class="fas fa-trash"></i></button>' +
+            '</div></td></tr>';
+    });
+    if (!groupsHtml) {
+        groupsHtml = '<tr><td colspan="3" class="text-center text-muted">No groups defined</td></tr>';
+    }
+
+    var rolesHtml = '';
+    accessControlRoles.forEach(function(r) {
+        var bindings = (r.bindings || []).map(function(b) {
+            return b.type === 'group' ? 'group:' + groupNameByID(b.subject) : b.subject;
+        });
+        rolesHtml += '<tr>' +
+            '<td><strong>' + escapeHtml(r.name) + '</strong><br><small class="text-muted">' + escapeHtml(r.description || '') + '</small></td>' +
+            '<td>' + (r.rules || []).map(function(rule) {
+                return '<span class="badge badge-secondary mr-1">' + escapeHtml(describeRule(rule)) + '</span>';
+            }).join('') + '</td>' +
+            '<td>' + escapeHtml(bindings.join(', ')) + '</td>' +
+            '<td><div class="btn-group btn-group-sm">' +
+            '<button class="btn btn-outline-warning" onclick="showRoleModal(\'' + r.id + '\')"><i class="fas fa-edit"></i></button>' +
+            '<button class="btn btn-outline-danger" onclick="deleteRole(\'' + r.id + '\')"><i class="fas fa-trash"></i></button>' +
+            '</div></td></tr>';
+    });
+    if (!rolesHtml) {
+        rolesHtml = '<tr><td colspan="4" class="text-center text-muted">No roles defined. Users without roles may open tunnels, create listeners and view their own captures.</td></tr>';
+    }
+
+    var html = '<div class="row">' +
+        '<div class="col-md-5"><div class="card">' +
+        '<div class="card-header"><h3 class="card-title">Groups</h3>' +
+        '<div class="card-tools"><button class="btn btn-primary btn-sm" onclick="showGroupModal()"><i class="fas fa-plus"></i> Add Group</button></div>' +
+        '</div>' +
+        '<div class="card-body"><table class="table table-striped">' +
+        '<thead><tr><th>Name</th><th>Members</th><th></th></tr></thead>' +
+        '<tbody>' + groupsHtml + '</tbody></table></div>' +
+        '</div></div>' +
+        '<div class="col-md-7"><div class="card">' +
+        '<div class="card-header"><h3 class="card-title">Roles</h3>' +
+        '<div class="card-tools"><button class="btn btn-primary btn-sm" onclick="showRoleModal()"><i class="fas fa-plus"></i> Add Role</button></div>' +
+        '</div>' +
+        '<div class="card-body"><table class="table table-striped">' +
+        '<thead><tr><th>Role</th><th>Rules</th><th>Granted to</th><th></th></tr></thead>' +
+        '<tbody>' + rolesHtml + '</tbody></table></div>' +
+        '</div></div>' +
+        '</div>';
+    $('#access-control-content').html(html);
+}
+
+function showAccessModal(id, title, body, onSave) {
+    var modalHtml = '<div class="modal fade" id="' + id + '" tabindex="-1" role="dialog">' +
+        '<div class="modal-dialog modal-lg" role="document"><div class="modal-content">' +
+        '<div class="modal-header"><h4 class="modal-title">' + title + '</h4>' +
+        '<button type="button" class="close" data-dismiss="modal" aria-label="Close"><span aria-hidden="true">&times;</span></button>' +
+        '</div>' +
+        '<div class="modal-body">' + body + '</div>' +
+        '<div class="modal-footer">' +
+        '<button type="button" class="btn btn-secondary" data-dismiss="modal">Cancel</button>' +
+        '<button type="button" class="btn btn-primary" id="' + id + '-save">Save</button>' +
+        '</div></div></div></div>';
+    $('#' + id).remove();
+    $('body').append(modalHtml);
+    $('#' + id + '-save').on('click', onSave);
+    $('#' + id).modal({ backdrop: true, keyboard: true, show: true });
+    $('#' + id).on('hidden.bs.modal', function() { $(this).remove(); });
+}
+
+function showGroupModal(id) {
+    var group = accessControlGroups.filter(function(g) { return g.id === id; })[0] || { name: '', members: [] };
+    var body = '<div class="form-group"><label>Name</label>' +
+        '<input type="text" class="form-control" id="groupName" value="' + escapeHtml(group.name) + '"></div>' +
+        '<div class="form-group"><label>Members</label>' +
+        '<input type="text" class="form-control" id="groupMembers" value="' + escapeHtml((group.members || []).join(', ')) + '">' +
+        '<small class="form-text text-muted">Comma-separated usernames</small></div>';
+    showAccessModal('groupModal', id ? 'Edit Group' : 'Add Group', body, function() {
+        var payload = {
+            name: $('#groupName').val(),
+            members: $('#groupMembers').val().split(',').map(function(m) { return m.trim(); }).filter(Boolean)
+        };
+        $.ajax({
+            url: id ? '/api/groups/' + encodeURIComponent(id) : '/api/groups',
+            method: id ? 'PUT' : 'POST',
+            contentType: 'application/json',
+            data: JSON.stringify(payload)
+        })
+        .done(function() { $('#groupModal').modal('hide'); loadAccessControl(); })
+        .fail(function(xhr) { alert('Failed to save group: ' + (xhr.responseText || 'Unknown error')); });
+    });
+}
+
+function deleteGroup(id) {
+    if (!confirm('Delete group "' + groupNameByID(id) + '"? Roles granted to it are removed as well.')) {
+        return;
+    }
+    $.ajax({ url: '/api/groups/' + encodeURIComponent(id), method: 'DELETE' })
+        .done(function() { loadAccessControl(); })
+        .fail(function(xhr) { alert('Failed to delete group: ' + (xhr.responseText || 'Unknown error')); });
+}
+
+function showRoleModal(id) {
+    var role = accessControlRoles.filter(function(r) { return r.id === id; })[0] || { name: '', description: '', rules: [], bindings: [] };
+    var bindings = (role.bindings || []).map(function(b) {
+        return b.type === 'group' ? 'group:' + groupNameByID(b.subject) : b.subject;
+    });
+    var example = '[{"action": "tunnel:open", "ports": "8000-8999"},\n {"action": "listener:create", "modes": ["sink"]},\n {"action": "capture:view", "scope": "group"}]';
+    var body = '<div class="form-group"><label>Name</label>' +
+        '<input type="text" class="form-control" id="roleName" value="' + escapeHtml(role.name) + '"></div>' +
+        '<div class="form-group"><label>Description</label>' +
+        '<input type="text" class="form-control" id="roleDescription" value="' + escapeHtml(role.description || '') + '"></div>' +
+        '<div class="form-group"><label>Rules (JSON)</label>' +
+        '<textarea class="form-control" id="roleRules" rows="6" style="font-family: monospace;" placeholder=\'' + example + '\'>' +
+        escapeHtml(role.rules && role.rules.length ? JSON.stringify(role.rules, null, 2) : '') + '</textarea>' +
+        '<small class="form-text text-muted">Actions: admin, tunnel:open, listener:create, capture:view, multicast:manage. ' +
+        'Optional fields: ports (e.g. "8000-8999,9090"), modes (listener modes), scope (own, group, all).</small></div>' +
+        '<div class="form-group"><label>Granted to</label>' +
+        '<input type="text" class="form-control" id="roleBindings" value="' + escapeHtml(bindings.join(', ')) + '">' +
+        '<small class="form-text text-muted">Comma-separated usernames and group:&lt;name&gt; entries</small></div>';
+    showAccessModal('roleModal', id ? 'Edit Role' : 'Add Role', body, function() {
+        var rules = [];
+        var rulesText = $('#roleRules').val().trim();
+        if (rulesText) {
+            try {
+                rules = JSON.parse(rulesText);
+            } catch (e) {
+                alert('Rules must be valid JSON: ' + e.message);
+                return;
+            }
+        }
+        var payload = {
+            name: $('#roleName').val(),
+            description: $('#roleDescription').val(),
+            rules: rules,
+            bindings: $('#roleBindings').val().split(',').map(function(b) { return b.trim(); }).filter(Boolean).map(function(b) {
+                return b.indexOf('group:') === 0 ? { type: 'group', subject: b.substring(6) } : { type: 'user', subject: b };
+            })
+        };
+        $.ajax({
+            url: id ? '/api/roles/' + encodeURIComponent(id) : '/api/roles',
+            method: id ? 'PUT' : 'POST',
+            contentType: 'application/json',
+            data: JSON.stringify(payload)
+        })
+        .done(function() { $('#roleModal').modal('hide'); loadAccessControl(); })
+        .fail(function(xhr) { alert('Failed to save role: ' + (xhr.responseText || 'Unknown error')); });
+    });
+}
+
+function deleteRole(id) {
+    var role = accessControlRoles.filter(function(r) { return r.id === id; })[0];
+    if (!confirm('Delete role "' + (role ? role.name : id) + '"?')) {
+        return;
+    }
+    $.ajax({ url: '/api/roles/' + encodeURIComponent(id), method: 'DELETE' })
+        .done(function() { loadAccessControl(); })
+        .fail(function(xhr) { alert('Failed to delete role: ' + (xhr.responseText || 'Unknown error')); });
+}
@@ -43,6 +43,18 @@ function loadUserSettingsView() {
         '</div>' +
         '</div>' +
         '<div class="row mt-3">' +
+        '<div class="col-md-6">' +
+        '<div class="card">' +
+        '<div class="card-header">' +
+        '<h3 class="card-title"><i class="fas fa-shield-alt"></i> Two-Factor Authentication</h3>' +
+        '</div>' +
+        '<div class="card-body" id="mfa-settings">' +
+        '<div class="text-center text-muted">Loading...</div>' +
+        '</div>' +
+        '</div>' +
+        '</div>' +
+        '</div>' +
+        '<div class="row mt-3">' +
         '<div class="col-12">' +
         '<div id="user-port-info"></div>' +
         '</div>' +
@@ -53,6 +65,7 @@ function loadUserSettingsView() {
     loadAPITokenBanner();
     loadUserTokens();
     loadUserPortInfo();
+    loadUserMFA();
 
     // Handle profile form submission
     $('#profileForm').on('submit', function(e) {
@@ -230,6 +243,88 @@ function revokeToken(tokenId) {
     }
 }
 
+// Two-factor (TOTP) management
+function loadUserMFA() {
+    $.get('/api/user/mfa')
+    .done(function(data) {
+        var html = '';
+        if (!data.available) {
+            html = '<div class="text-muted">Two-factor authentication requires a database-backed server.</div>';
+        } else if (data.enabled) {
+            html = '<p><span class="badge badge-success">Enabled</span> ' +
+                '<small class="text-muted">' + (data.recovery_codes_remaining || 0) + ' recovery codes remaining</small></p>' +
+                '<div class="form-group"><input type="text" class="form-control" id="mfaCode" placeholder="Current code"></div>' +
+                '<button class="btn btn-sm btn-outline-primary mr-2" onclick="regenerateMFARecoveryCodes()"><i class="fas fa-redo"></i> New recovery codes</button>' +
+                '<button class="btn btn-sm btn-danger" onclick="disableMFA()"><i class="fas fa-times"></i> Disable</button>';
+        } else {
+            html = '<p><span class="badge badge-secondary">Disabled</span>' +
+                (data.required ? ' <small class="text-warning">Required by your administrator</small>' : '') + '</p>' +
+                '<div id="mfa-enroll-details"></div>' +
+                '<button class="btn btn-sm btn-primary" id="mfaEnrollBtn" onclick="enrollMFA()"><i class="fas fa-qrcode"></i> Set up authenticator</button>';
+        }
+        $('#mfa-settings').html(html);
+    })
+    .fail(function() {
+        $('#mfa-settings').html('<div class="text-center text-danger">Failed to load two-factor status</div>');
+    });
+}
+
+function enrollMFA() {
+    $.post('/api/user/mfa/enroll')
+    .done(function(data) {
+        $('#mfa-enroll-details').html(
+            '<div class="text-center mb-2"><img alt="QR code" src="' + escapeHtml(data.qr_code || '') + '"></div>' +
+            '<div class="form-group"><label class="small">Secret</label>' +
+            '<input type="text" class="form-control form-control-sm" value="' + escapeHtml(data.secret) + '" readonly></div>' +
+            '<div class="form-group"><label class="small">Recovery codes (shown once)</label>' +
+            '<pre class="border rounded p-2 small">' + escapeHtml((data.recovery_codes || []).join('\n')) + '</pre></div>' +
+            '<div class="form-group"><input type="text" class="form-control" id="mfaCode" placeholder="6-digit code"></div>' +
+            '<button class="btn btn-sm btn-success" onclick="verifyMFA()"><i class="fas fa-check"></i> Verify and enable</button>'
+        );
+        $('#mfaEnrollBtn').hide();
+    })
+    .fail(function(xhr) {
+        alert('Failed to start enrollment: ' + (xhr.responseText || 'Unknown error'));
+    });
+}
+
+function verifyMFA() {
+    $.ajax({
+        url: '/api/user/mfa/verify',
+        method: 'POST',
+        contentType: 'application/json',
+        data: JSON.stringify({ code: $('#mfaCode').val().trim() })
+    })
+    .done(function() { loadUserMFA(); })
+    .fail(function(xhr) { alert('Verification failed: ' + (xhr.responseText || 'Unknown error')); });
+}
+
+function regenerateMFARecoveryCodes() {
+    $.ajax({
+        url: '/api/user/mfa/recovery-codes',
+        method: 'POST',
+        contentType: 'application/json',
+        data: JSON.stringify({ code: $('#mfaCode').val().trim() })
+    })
+    .done(function(data) {
+        alert('New recovery codes (shown once):\n\n' + (data.recovery_codes || []).join('\n'));
+        loadUserMFA();
+    })
+    .fail(function(xhr) { alert('Failed to regenerate recovery codes: ' + (xhr.responseText || 'Unknown error')); });
+}
+
+function disableMFA() {
+    if (!confirm('Disable two-factor authentication for your account?')) return;
+    $.ajax({
+        url: '/api/user/mfa',
+        method: 'DELETE',
+        contentType: 'application/json',
+        data: JSON.stringify({ code: $('#mfaCode').val().trim() })
+    })
+    .done(function() { loadUserMFA(); })
+    .fail(function(xhr) { alert('Failed to disable two-factor authentication: ' + (xhr.responseText || 'Unknown error')); });
+}
+
 function updateUserProfile() {
     var profileData = {
         display_name: $('#displayName').val().trim(),
@@ -0,0 +1,238 @@
+// Package fixture holds the exchanges record listeners store and replay
+// listeners serve. Requests are matched to fixtures by a configurable key
+// made of the method, path, query and a hash of the body.
+package fixture
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Match keys
+const (
+	KeyMethod = "method"
+	KeyPath   = "path"
+	// KeyQuery compares query parameters regardless of their order
+	KeyQuery = "query"
+	// KeyBody compares a hash of the body; JSON bodies are hashed in a
+	// canonical form, so member order and whitespace do not matter
+	KeyBody = "body"
+)
+
+// DefaultKeys are used when a listener does not configure match keys
+var DefaultKeys = []string{KeyMethod, KeyPath, KeyQuery}
+
+// ParseKeys validates match keys, returning DefaultKeys for none
+func ParseKeys(keys []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, k := range keys {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k == "" || seen[k] {
+			continue
+		}
+		switch k {
+		case KeyMethod, KeyPath, KeyQuery, KeyBody:
+		default:
+			return nil, fmt.Errorf("unknown match key %q (want method, path, query or body)", k)
+		}
+		seen[k] = true
+		out = append(out, k)
+	}
+	if len(out) == 0 {
+		return DefaultKeys, nil
+	}
+	return out, nil
+}
+
+// SplitKeys reads match keys stored as a comma separated list
+func SplitKeys(stored string) []string {
+	keys, err := ParseKeys(strings.Split(stored, ","))
+	if err != nil {
+		return DefaultKeys
+	}
+	return keys
+}
+
+// Message is the header and body of a recorded request or response
+type Message struct {
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// BodyEncoding is "base64" for bodies that are not UTF-8 text
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+// SetBody stores a body, base64 encoding it unless it is UTF-8 text
+func (m *Message) SetBody(b []byte) {
+	if utf8.Valid(b) {
+		m.Body, m.BodyEncoding = string(b), ""
+		return
+	}
+	m.Body, m.BodyEncoding = base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+// Bytes returns the decoded body
+func (m *Message) Bytes() ([]byte, error) {
+	switch m.BodyEncoding {
+	case "":
+		return []byte(m.Body), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(m.Body)
+	}
+	return nil, fmt.Errorf("unknown body encoding %q", m.BodyEncoding)
+}
+
+// Fixture is a recorded request and the response it got
+type Fixture struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Query is the raw query string as sent
+	Query      string    `json:"query,omitempty"`
+	Request    Message   `json:"request"`
+	Status     int       `json:"status"`
+	Response   Message   `json:"response"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// sensitiveHeaders are left out of recorded requests, so exported fixtures
+// do not carry credentials
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// framingHeaders describe how a message was transferred and are recomputed
+// when it is replayed
+var framingHeaders = []string{"Connection", "Content-Length", "Keep-Alive", "Transfer-Encoding", "Upgrade"}
+
+// New records a request, whose body was already read, and its response
+func New(r *http.Request, reqBody []byte, status int, header http.Header, respBody []byte) *Fixture {
+	f := &Fixture{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Status: status, RecordedAt: time.Now().UTC()}
+	f.Request.Header = r.Header.Clone()
+	for _, name := range append(sensitiveHeaders, framingHeaders...) {
+		f.Request.Header.Del(name)
+	}
+	f.Request.SetBody(reqBody)
+	f.Response.Header = header.Clone()
+	for _, name := range framingHeaders {
+		f.Response.Header.Del(name)
+	}
+	f.Response.SetBody(respBody)
+	return f
+}
+
+// Validate checks a fixture read from an import
+func (f *Fixture) Validate() error {
+	if f.Method == "" || !strings.HasPrefix(f.Path, "/") {
+		return fmt.Errorf("fixture needs a method and a path starting with /")
+	}
+	if f.Status < 100 || f.Status > 999 {
+		return fmt.Errorf("fixture %s %s: invalid status %d", f.Method, f.Path, f.Status)
+	}
+	for _, m := range []*Message{&f.Request, &f.Response} {
+		if _, err := m.Bytes(); err != nil {
+			return fmt.Errorf("fixture %s %s: %w", f.Method, f.Path, err)
+		}
+	}
+	return nil
+}
+
+// Key returns the match key of the fixture's request
+func (f *Fixture) Key(keys []string) string {
+	body, _ := f.Request.Bytes()
+	return key(keys, f.Method, f.Path, f.Query, body)
+}
+
+// RequestKey returns the match key of a request whose body was already read
+func RequestKey(keys []string, r *http.Request, body []byte) string {
+	return key(keys, r.Method, r.URL.Path, r.URL.RawQuery, body)
+}
+
+func key(keys []string, method, path, query string, body []byte) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		switch k {
+		case KeyMethod:
+			parts[i] = strings.ToUpper(method)
+		case KeyPath:
+			parts[i] = path
+		case KeyQuery:
+			if values, err := url.ParseQuery(query); err == nil {
+				parts[i] = values.Encode()
+			} else {
+				parts[i] = query
+			}
+		case KeyBody:
+			parts[i] = BodyHash(body)
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// BodyHash hashes a body, canonicalizing JSON first
+func BodyHash(body []byte) string {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && json.Valid(trimmed) {
+		var doc any
+		if json.Unmarshal(trimmed, &doc) == nil {
+			body, _ = json.Marshal(doc)
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Document is the export and import format of a listener's fixtures
+type Document struct {
+	MatchKeys []string   `json:"match_keys"`
+	Fixtures  []*Fixture `json:"fixtures"`
+}
+
+// Set serves fixtures to a replay listener. Fixtures sharing a key are
+// served in the order they were recorded, the last one repeating, so a
+// replayed sequence of calls to one endpoint sees the same progression.
+type Set struct {
+	keys  []string
+	mu    sync.Mutex
+	byKey map[string][]*Fixture
+	next  map[string]int
+}
+
+// NewSet indexes fixtures by their match key
+func NewSet(fixtures []*Fixture, keys []string) *Set {
+	s := &Set{keys: keys, byKey: map[string][]*Fixture{}, next: map[string]int{}}
+	for _, f := range fixtures {
+		k := f.Key(keys)
+		s.byKey[k] = append(s.byKey[k], f)
+	}
+	return s
+}
+
+// Len returns the number of fixtures in the set
+func (s *Set) Len() int {
+	n := 0
+	for _, list := range s.byKey {
+		n += len(list)
+	}
+	return n
+}
+
+// Match returns the fixture to answer a request with
+func (s *Set) Match(r *http.Request, body []byte) (*Fixture, bool) {
+	k := RequestKey(s.keys, r, body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.byKey[k]
+	if len(list) == 0 {
+		return nil, false
+	}
+	i := min(s.next[k], len(list)-1)
+	s.next[k] = i + 1
+	return list[i], true
+}
@@ -0,0 +1,94 @@
+package fixture
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	if keys, err := ParseKeys(nil); err != nil || strings.Join(keys, ",") != "method,path,query" {
+		t.Fatalf("expected the default keys, got %v, %v", keys, err)
+	}
+	if keys, err := ParseKeys([]string{" Body", "path", "body"}); err != nil || strings.Join(keys, ",") != "body,path" {
+		t.Fatalf("unexpected keys %v, %v", keys, err)
+	}
+	if _, err := ParseKeys([]string{"header"}); err == nil {
+		t.Fatal("expected an unknown key to be rejected")
+	}
+	if keys := SplitKeys("method, body"); strings.Join(keys, ",") != "method,body" {
+		t.Fatalf("unexpected stored keys %v", keys)
+	}
+}
+
+func TestNew(t *testing.T) {
+	r := httptest.NewRequest("POST", "/login?next=/home", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Trace", "1")
+	f := New(r, []byte(`{"user":"ada"}`), 200, http.Header{"Content-Length": {"3"}, "Content-Type": {"image/png"}}, []byte{0x89, 'P', 0xff})
+	if f.Request.Header.Get("Authorization") != "" || f.Request.Header.Get("X-Trace") != "1" {
+		t.Fatalf("unexpected request headers %v", f.Request.Header)
+	}
+	if f.Response.Header.Get("Content-Length") != "" || f.Response.BodyEncoding != "base64" {
+		t.Fatalf("unexpected response %+v", f.Response)
+	}
+
+	// Fixtures survive an export and import unchanged
+	b, _ := json.Marshal(f)
+	var back Fixture
+	if err := json.Unmarshal(b, &back); err != nil || back.Validate() != nil {
+		t.Fatalf("fixture did not round trip: %v", err)
+	}
+	if body, _ := back.Response.Bytes(); string(body) != "\x89P\xff" || back.Query != "next=/home" {
+		t.Fatalf("unexpected fixture %+v", back)
+	}
+	if err := (&Fixture{Method: "GET", Path: "/", Status: 200, Response: Message{BodyEncoding: "gzip"}}).Validate(); err == nil {
+		t.Fatal("expected an unknown encoding to be rejected")
+	}
+}
+
+func TestSetMatch(t *testing.T) {
+	record := func(method, target, body, answer string) *Fixture {
+		return New(httptest.NewRequest(method, target, nil), []byte(body), 200, http.Header{}, []byte(answer))
+	}
+	fixtures := []*Fixture{
+		record("GET", "/items?a=1&b=2", "", "first"),
+		record("GET", "/items?a=1&b=2", "", "second"),
+		record("POST", "/items", `{"name":"x","size":1}`, "created"),
+	}
+	match := func(s *Set, method, target, body string) string {
+		f, ok := s.Match(httptest.NewRequest(method, target, nil), []byte(body))
+		if !ok {
+			return ""
+		}
+		b, _ := f.Response.Bytes()
+		return string(b)
+	}
+
+	s := NewSet(fixtures, DefaultKeys)
+	if s.Len() != 3 {
+		t.Fatalf("expected 3 fixtures, got %d", s.Len())
+	}
+	// Query order does not matter and a sequence ends on its last response
+	for _, want := range []string{"first", "second", "second"} {
+		if got := match(s, "GET", "/items?b=2&a=1", ""); got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+	if got := match(s, "GET", "/items?a=2", ""); got != "" {
+		t.Fatalf("expected no match for another query, got %q", got)
+	}
+	if got := match(s, "POST", "/items", "ignored"); got != "created" {
+		t.Fatalf("expected the body to be ignored, got %q", got)
+	}
+
+	s = NewSet(fixtures, []string{KeyMethod, KeyPath, KeyBody})
+	if got := match(s, "POST", "/items?x=1", "{\"size\": 1, \"name\": \"x\"}"); got != "created" {
+		t.Fatalf("expected equal JSON to match, got %q", got)
+	}
+	if got := match(s, "POST", "/items", `{"name":"y","size":1}`); got != "" {
+		t.Fatalf("expected another body not to match, got %q", got)
+	}
+}
@@ -9,13 +9,19 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/NextChapterSoftware/chissl/server/capture"
+	"github.com/NextChapterSoftware/chissl/server/chaos"
+	"github.com/NextChapterSoftware/chissl/server/fixture"
+	"github.com/NextChapterSoftware/chissl/server/mock"
+	"github.com/NextChapterSoftware/chissl/server/openapi"
 	"github.com/NextChapterSoftware/chissl/share/database"
 	"github.com/NextChapterSoftware/chissl/share/settings"
 	"github.com/NextChapterSoftware/chissl/share/tunnel"
@@ -37,8 +43,28 @@ type ActiveListener struct {
 	Server     *http.Server
 	Cancel     context.CancelFunc
 	TapFactory tunnel.TapFactory
+	// mock holds the compiled rules of a mock listener
+	mock atomic.Pointer[mock.Router]
+	// spec holds the document of an openapi listener
+	spec atomic.Pointer[openapi.Spec]
+	// fixtures holds the fixtures a replay listener serves
+	fixtures atomic.Pointer[fixture.Set]
+	// chaos holds the faults a proxy listener injects
+	chaos atomic.Pointer[chaos.Injector]
 }
 
+// maxFixtureBody caps the request and response bodies record listeners store; larger
+// exchanges are proxied without being recorded
+const maxFixtureBody = 10 << 20
+
+// Record listeners keep the latest maxFixturesPerKey exchanges for each match
+// key, which replay serves in order, and at most maxListenerFixtures in total.
+// Imports are refused beyond the total.
+const (
+	maxFixturesPerKey   = 20
+	maxListenerFixtures = 1000
+)
+
 // NewListenerManager creates a new listener manager
 func NewListenerManager(captureService *capture.Service, db database.Database, tlsConfig *tls.Config) *ListenerManager {
 	return &ListenerManager{
@@ -61,15 +87,57 @@ func (lm *ListenerManager) StartListener(config *database.Listener, tapFactory t
 		}
 	}
 
+	activeListener := &ActiveListener{
+		ID:         config.ID,
+		Config:     config,
+		TapFactory: tapFactory,
+	}
+
 	// Create HTTP handler based on mode
 	var handler http.Handler
 	switch config.Mode {
 	case "sink":
 		handler = lm.createSinkHandler(config, tapFactory)
 	case "proxy":
-		handler = lm.createProxyHandler(config, tapFactory)
+		cfg, err := chaos.Parse(config.Chaos)
+		if err != nil {
+			return err
+		}
+		injector, err := chaos.New(cfg)
+		if err != nil {
+			return err
+		}
+		activeListener.chaos.Store(injector)
+		handler = chaos.Handler(lm.createProxyHandler(config, tapFactory, nil), &activeListener.chaos)
+	case "record":
+		handler = lm.createProxyHandler(config, tapFactory, lm.recordFixture(config))
+	case "replay":
+		set, err := lm.loadFixtures(config)
+		if err != nil {
+			return err
+		}
+		activeListener.fixtures.Store(set)
+		handler = lm.createReplayHandler(config, tapFactory, &activeListener.fixtures)
 	case "ai-mock":
 		handler = lm.createAIMockHandler(config, tapFactory)
+	case "mock":
+		rules, err := mock.Parse(config.MockRules)
+		if err != nil {
+			return err
+		}
+		router, err := mock.New(rules)
+		if err != nil {
+			return err
+		}
+		activeListener.mock.Store(router)
+		handler = lm.createMockHandler(config, tapFactory, &activeListener.mock)
+	case "openapi":
+		spec, err := openapi.Parse(config.OpenAPISpec)
+		if err != nil {
+			return err
+		}
+		activeListener.spec.Store(spec)
+		handler = lm.createOpenAPIMockHandler(config, tapFactory, &activeListener.spec)
 	default:
 		return fmt.Errorf("unsupported listener mode: %s", config.Mode)
 	}
@@ -82,13 +150,8 @@ func (lm *ListenerManager) StartListener(config *database.Listener, tapFactory t
 		TLSConfig: lm.tlsConfig, // Use the main server's TLS config
 	}
 
-	activeListener := &ActiveListener{
-		ID:         config.ID,
-		Config:     config,
-		Server:     server,
-		Cancel:     cancel,
-		TapFactory: tapFactory,
-	}
+	activeListener.Server = server
+	activeListener.Cancel = cancel
 
 	// Start server in goroutine
 	go func() {
@@ -185,6 +248,92 @@ func (lm *ListenerManager) StopListener(listenerID string) error {
 	return nil
 }
 
+// UpdateMockRules swaps the rules of a running mock listener. Listeners
+// that are not running pick up their stored rules when started.
+func (lm *ListenerManager) UpdateMockRules(listenerID string, router *mock.Router) {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	if l, ok := lm.listeners[listenerID]; ok && l.Config.Mode == "mock" {
+		l.mock.Store(router)
+	}
+}
+
+// UpdateOpenAPISpec swaps the document of a running openapi listener
+func (lm *ListenerManager) UpdateOpenAPISpec(listenerID string, spec *openapi.Spec) {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	if l, ok := lm.listeners[listenerID]; ok && l.Config.Mode == "openapi" {
+		l.spec.Store(spec)
+	}
+}
+
+// UpdateChaos swaps the fault injection of a running proxy listener
+func (lm *ListenerManager) UpdateChaos(listenerID string, injector *chaos.Injector) {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	if l, ok := lm.listeners[listenerID]; ok && l.Config.Mode == "proxy" {
+		l.chaos.Store(injector)
+	}
+}
+
+// ReloadFixtures reloads the fixtures of a running replay listener after
+// they or the listener's match keys changed in the database
+func (lm *ListenerManager) ReloadFixtures(config *database.Listener) error {
+	lm.mu.RLock()
+	l, ok := lm.listeners[config.ID]
+	lm.mu.RUnlock()
+	if !ok || l.Config.Mode != "replay" {
+		return nil
+	}
+	set, err := lm.loadFixtures(config)
+	if err != nil {
+		return err
+	}
+	l.fixtures.Store(set)
+	return nil
+}
+
+// loadFixtures reads a listener's fixtures from the database
+func (lm *ListenerManager) loadFixtures(config *database.Listener) (*fixture.Set, error) {
+	keys := fixture.SplitKeys(config.MatchKeys)
+	if lm.db == nil {
+		return fixture.NewSet(nil, keys), nil
+	}
+	records, err := lm.db.ListListenerFixtures(config.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fixtures: %w", err)
+	}
+	fixtures := make([]*fixture.Fixture, 0, len(records))
+	for _, rec := range records {
+		var f fixture.Fixture
+		if err := json.Unmarshal([]byte(rec.Data), &f); err != nil {
+			return nil, fmt.Errorf("invalid fixture %d: %w", rec.ID, err)
+		}
+		fixtures = append(fixtures, &f)
+	}
+	return fixture.NewSet(fixtures, keys), nil
+}
+
+// recordFixture returns the hook through which a record listener stores the
+// exchanges it proxies, dropping the oldest beyond the fixture limits
+func (lm *ListenerManager) recordFixture(config *database.Listener) func(f *fixture.Fixture) {
+	keys := fixture.SplitKeys(config.MatchKeys)
+	return func(f *fixture.Fixture) {
+		if lm.db == nil {
+			return
+		}
+		data, _ := json.Marshal(f)
+		key := f.Key(keys)
+		if err := lm.db.AddListenerFixtures([]*database.ListenerFixtureRecord{{ListenerID: config.ID, At: f.RecordedAt, Data: string(data), MatchKey: key}}); err != nil {
+			fmt.Printf("Failed to record fixture for listener %s: %v\n", config.ID, err)
+			return
+		}
+		if _, err := lm.db.TrimListenerFixtures(config.ID, key, maxFixturesPerKey, maxListenerFixtures); err != nil {
+			fmt.Printf("Failed to trim fixtures of listener %s: %v\n", config.ID, err)
+		}
+	}
+}
+
 // GetActiveListener returns an active listener by ID
 func (lm *ListenerManager) GetActiveListener(listenerID string) (*ActiveListener, bool) {
 	lm.mu.RLock()
@@ -225,6 +374,7 @@ func (lm *ListenerManager) createSinkHandler(config *database.Listener, tapFacto
 				Username: config.Username,
 				Remote:   settings.Remote{LocalHost: "127.0.0.1", LocalPort: strconv.Itoa(config.Port), RemoteHost: "127.0.0.1", RemotePort: strconv.Itoa(config.Port)},
 				ConnID:   connID,
+				Peer:     r.RemoteAddr,
 			}
 			tap = tapFactory(meta)
 			if tap != nil {
@@ -244,22 +394,12 @@ func (lm *ListenerManager) createSinkHandler(config *database.Listener, tapFacto
 		// Log the request
 		if tap != nil {
 			// Capture request
-			reqData := fmt.Sprintf("%s %s %s\r\n", r.Method, r.URL.String(), r.Proto)
-			for name, values := range r.Header {
-				for _, value := range values {
-					reqData += fmt.Sprintf("%s: %s\r\n", name, value)
-				}
-			}
-			reqData += "\r\n"
-
-			// Read body if present
+			var body []byte
 			if r.Body != nil {
-				body, _ := io.ReadAll(r.Body)
-				reqData += string(body)
+				body, _ = io.ReadAll(r.Body)
 				r.Body.Close()
 			}
-
-			tap.DstWriter().Write([]byte(reqData))
+			captureRequest(tap, r, body)
 		}
 
 		// Send configured response or default
@@ -274,15 +414,53 @@ func (lm *ListenerManager) createSinkHandler(config *database.Listener, tapFacto
 
 		// Log the response
 		if tap != nil {
-			respData := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s",
-				len(response), response)
-			tap.SrcWriter().Write([]byte(respData))
+			captureResponse(tap, http.StatusOK, w.Header(), []byte(response))
+		}
+	})
+}
+
+// createMockHandler creates a handler that answers requests from the
+// listener's mock rules
+func (lm *ListenerManager) createMockHandler(config *database.Listener, tapFactory tunnel.TapFactory, rules *atomic.Pointer[mock.Router]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+			r.Body.Close()
+		}
+
+		var tap tunnel.Tap
+		if tapFactory != nil {
+			meta := tunnel.Meta{
+				Username: config.Username,
+				Remote:   settings.Remote{LocalHost: "127.0.0.1", LocalPort: strconv.Itoa(config.Port), RemoteHost: "127.0.0.1", RemotePort: strconv.Itoa(config.Port)},
+				ConnID:   fmt.Sprintf("%s-%d", r.RemoteAddr, time.Now().UnixNano()),
+				Peer:     r.RemoteAddr,
+			}
+			tap = tapFactory(meta)
+		}
+		if tap != nil {
+			tap.OnOpen()
+			captureRequest(tap, r, body)
+		}
+
+		status, header, out, _ := rules.Load().Respond(r, body)
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		w.Write(out)
+
+		if tap != nil {
+			captureResponse(tap, status, header, out)
+			tap.OnClose(int64(len(out)), int64(len(body)))
 		}
 	})
 }
 
-// createProxyHandler creates a handler that proxies requests to a target URL
-func (lm *ListenerManager) createProxyHandler(config *database.Listener, tapFactory tunnel.TapFactory) http.Handler {
+// createProxyHandler creates a handler that proxies requests to a target URL.
+// When record is set, each complete exchange is passed to it as a fixture.
+func (lm *ListenerManager) createProxyHandler(config *database.Listener, tapFactory tunnel.TapFactory, record func(f *fixture.Fixture)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		connID := fmt.Sprintf("%s-%d", r.RemoteAddr, time.Now().UnixNano())
 
@@ -296,6 +474,7 @@ func (lm *ListenerManager) createProxyHandler(config *database.Listener, tapFact
 				Username: config.Username,
 				Remote:   settings.Remote{LocalHost: "127.0.0.1", LocalPort: strconv.Itoa(config.Port), RemoteHost: "127.0.0.1", RemotePort: strconv.Itoa(config.Port)},
 				ConnID:   connID,
+				Peer:     r.RemoteAddr,
 			}
 			tap = tapFactory(meta)
 			if tap != nil {
@@ -325,12 +504,33 @@ func (lm *ListenerManager) createProxyHandler(config *database.Listener, tapFact
 		fullTargetURL.Path = r.URL.Path
 		fullTargetURL.RawQuery = r.URL.RawQuery
 
+		// Capture the original request; the exchange with the upstream is
+		// recorded as the client saw it. The body is forwarded as it arrives
+		// and copied to the capture, which keeps it up to its own limit, and
+		// to the recording up to the fixture limit.
+		var reqBody *teeBody
+		var recordedReq *limitedBuffer
+		if tap != nil || record != nil {
+			reqBody = &teeBody{body: r.Body, closed: make(chan struct{})}
+			if tap != nil {
+				var captured io.Writer
+				captured, reqBody.finish = captureRequestHead(tap, r)
+				reqBody.sinks = append(reqBody.sinks, captured)
+			}
+			if record != nil {
+				recordedReq = &limitedBuffer{limit: maxFixtureBody}
+				reqBody.sinks = append(reqBody.sinks, recordedReq)
+			}
+			r.Body = reqBody
+		}
+
 		// Create proxy request
 		proxyReq, err := http.NewRequest(r.Method, fullTargetURL.String(), r.Body)
 		if err != nil {
 			http.Error(w, "Failed to create proxy request", http.StatusInternalServerError)
 			return
 		}
+		proxyReq.ContentLength = r.ContentLength
 
 		// Copy headers but rewrite Host header to target host
 		for name, values := range r.Header {
@@ -347,32 +547,6 @@ func (lm *ListenerManager) createProxyHandler(config *database.Listener, tapFact
 		proxyReq.Host = targetURL.Host
 		proxyReq.Header.Set("Host", targetURL.Host)
 
-		// Log the original request (before proxy)
-		if tap != nil {
-			reqData := fmt.Sprintf("=== ORIGINAL REQUEST ===\r\n")
-			reqData += fmt.Sprintf("%s %s %s\r\n", r.Method, r.URL.String(), r.Proto)
-			reqData += fmt.Sprintf("Host: %s\r\n", r.Host)
-			for name, values := range r.Header {
-				for _, value := range values {
-					reqData += fmt.Sprintf("%s: %s\r\n", name, value)
-				}
-			}
-			reqData += "\r\n"
-
-			// Also log the proxy request details
-			reqData += fmt.Sprintf("=== PROXY REQUEST ===\r\n")
-			reqData += fmt.Sprintf("%s %s %s\r\n", proxyReq.Method, proxyReq.URL.String(), proxyReq.Proto)
-			reqData += fmt.Sprintf("Host: %s\r\n", proxyReq.Host)
-			for name, values := range proxyReq.Header {
-				for _, value := range values {
-					reqData += fmt.Sprintf("%s: %s\r\n", name, value)
-				}
-			}
-			reqData += "\r\n"
-
-			tap.DstWriter().Write([]byte(reqData))
-		}
-
 		// Also log to console for debugging
 		fmt.Printf("Proxying: %s %s -> %s\n", r.Method, r.URL.String(), proxyReq.URL.String())
 
@@ -402,24 +576,16 @@ func (lm *ListenerManager) createProxyHandler(config *database.Listener, tapFact
 			errMsg := fmt.Sprintf("Proxy request failed: %v", err)
 			fmt.Printf("Proxy error for %s: %v\n", proxyReq.URL.String(), err)
 			if tap != nil {
-				tap.SrcWriter().Write([]byte(fmt.Sprintf("=== PROXY ERROR ===\r\n%s\r\n", errMsg)))
+				captureResponse(tap, http.StatusBadGateway, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, []byte(errMsg+"\n"))
 			}
 			http.Error(w, fmt.Sprintf("Proxy request failed: %v", err), http.StatusBadGateway)
 			return
 		}
 		defer resp.Body.Close()
 
-		// Log the upstream response first
+		// Log the upstream response head; the body follows as it is streamed
 		if tap != nil {
-			respData := fmt.Sprintf("=== UPSTREAM RESPONSE ===\r\n")
-			respData += fmt.Sprintf("HTTP/1.1 %d %s\r\n", resp.StatusCode, resp.Status)
-			for name, values := range resp.Header {
-				for _, value := range values {
-					respData += fmt.Sprintf("%s: %s\r\n", name, value)
-				}
-			}
-			respData += "\r\n"
-			tap.SrcWriter().Write([]byte(respData))
+			tap.DstWriter().Write(responseHead(resp.StatusCode, resp.Header, resp.ContentLength))
 		}
 
 		// Copy response headers (potentially modify them here if needed)
@@ -430,13 +596,133 @@ func (lm *ListenerManager) createProxyHandler(config *database.Listener, tapFact
 		}
 		w.WriteHeader(resp.StatusCode)
 
-		// Stream response body while capturing it
+		// Stream response body while capturing and recording it
+		var body io.Reader = resp.Body
 		if tap != nil {
 			// Use a tee reader to capture the response body
-			teeReader := io.TeeReader(resp.Body, tap.SrcWriter())
-			io.Copy(w, teeReader)
+			body = io.TeeReader(body, tap.DstWriter())
+		}
+		var recorded *limitedBuffer
+		if record != nil {
+			recorded = &limitedBuffer{limit: maxFixtureBody}
+			body = io.TeeReader(body, recorded)
+		}
+		_, err = io.Copy(w, body)
+		if recorded == nil || err != nil || recorded.overflow {
+			return
+		}
+		// The transport closes the request body once it is done with it
+		select {
+		case <-reqBody.closed:
+		case <-r.Context().Done():
+			return
+		}
+		if reqBody.complete() && !recordedReq.overflow {
+			record(fixture.New(r, recordedReq.Bytes(), resp.StatusCode, resp.Header, recorded.Bytes()))
+		}
+	})
+}
+
+// teeBody copies a request body to its sinks as it is read. finish, if set,
+// is called once the body has been read to its end.
+type teeBody struct {
+	body   io.ReadCloser
+	sinks  []io.Writer
+	finish func()
+	mu     sync.Mutex
+	eof    bool
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.body.Read(p)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, w := range t.sinks {
+		w.Write(p[:n])
+	}
+	if err == io.EOF && !t.eof {
+		t.eof = true
+		if t.finish != nil {
+			t.finish()
+		}
+	}
+	return n, err
+}
+
+func (t *teeBody) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return t.body.Close()
+}
+
+// complete reports whether the whole body was read
+func (t *teeBody) complete() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.eof
+}
+
+// limitedBuffer keeps what is written to it up to a limit, then notes the
+// overflow and drops the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.overflow || b.Len()+len(p) > b.limit {
+		b.overflow = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// createReplayHandler creates a handler that answers requests from the
+// listener's fixtures, with a 404 for requests no fixture matches
+func (lm *ListenerManager) createReplayHandler(config *database.Listener, tapFactory tunnel.TapFactory, fixtures *atomic.Pointer[fixture.Set]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+			r.Body.Close()
+		}
+
+		var tap tunnel.Tap
+		if tapFactory != nil {
+			meta := tunnel.Meta{
+				Username: config.Username,
+				Remote:   settings.Remote{LocalHost: "127.0.0.1", LocalPort: strconv.Itoa(config.Port), RemoteHost: "127.0.0.1", RemotePort: strconv.Itoa(config.Port)},
+				ConnID:   fmt.Sprintf("%s-%d", r.RemoteAddr, time.Now().UnixNano()),
+				Peer:     r.RemoteAddr,
+			}
+			tap = tapFactory(meta)
+		}
+		if tap != nil {
+			tap.OnOpen()
+			captureRequest(tap, r, body)
+		}
+
+		status := http.StatusNotFound
+		header := http.Header{"Content-Type": {"application/json"}}
+		var out []byte
+		if f, ok := fixtures.Load().Match(r, body); ok {
+			status, header = f.Status, f.Response.Header.Clone()
+			out, _ = f.Response.Bytes()
 		} else {
-			io.Copy(w, resp.Body)
+			out, _ = json.Marshal(map[string]string{"error": "no fixture matches " + r.Method + " " + r.URL.RequestURI()})
+		}
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		w.Write(out)
+
+		if tap != nil {
+			captureResponse(tap, status, header, out)
+			tap.OnClose(int64(len(out)), int64(len(body)))
 		}
 	})
 }
@@ -454,6 +740,7 @@ func (lm *ListenerManager) createAIMockHandler(config *database.Listener, tapFac
 				Username: config.Username,
 				Remote:   settings.Remote{LocalHost: "127.0.0.1", LocalPort: strconv.Itoa(config.Port), RemoteHost: "127.0.0.1", RemotePort: strconv.Itoa(config.Port)},
 				ConnID:   connID,
+				Peer:     r.RemoteAddr,
 			}
 			tap = tapFactory(meta)
 			if tap != nil {
@@ -470,26 +757,13 @@ func (lm *ListenerManager) createAIMockHandler(config *database.Listener, tapFac
 			}
 		}
 
-		// Log the request
+		// Read the body for validation, and log the request
+		var bodyBytes []byte
+		if r.Body != nil {
+			bodyBytes, _ = io.ReadAll(r.Body)
+		}
 		if tap != nil {
-			reqData := fmt.Sprintf("%s %s %s\r\n", r.Method, r.URL.String(), r.Proto)
-			reqData += fmt.Sprintf("Host: %s\r\n", r.Host)
-			for name, values := range r.Header {
-				for _, value := range values {
-					reqData += fmt.Sprintf("%s: %s\r\n", name, value)
-				}
-			}
-			reqData += "\r\n"
-
-			// Read and log request body if present
-			if r.Body != nil {
-				bodyBytes, _ := io.ReadAll(r.Body)
-				reqData += string(bodyBytes)
-				// Restore body for further processing
-				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-			}
-
-			tap.DstWriter().Write([]byte(reqData))
+			captureRequest(tap, r, bodyBytes)
 		}
 
 		// Get AI listener and active version
@@ -533,168 +807,196 @@ func (lm *ListenerManager) createAIMockHandler(config *database.Listener, tapFac
 			return
 		}
 
-		// Try exact path match first
-		pathData, found := paths[r.URL.Path]
-		if !found {
-			// Try to find a matching path pattern (simple matching for now)
-			for path, data := range paths {
-				if matchesPath(r.URL.Path, path) {
-					pathData = data
-					found = true
-					break
-				}
-			}
-		}
-
-		if !found {
-			http.Error(w, "Path not found in AI mock responses", http.StatusNotFound)
+		// The spec validates requests and resolves references in the
+		// generated responses; mocks keep working without a usable one
+		spec, _ := openapi.Parse(activeVersion.OpenAPISpec)
+		router, err := openapi.NewRouter(paths)
+		if err != nil {
+			http.Error(w, "Invalid AI response paths: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		serveOpenAPIMock(w, r, bodyBytes, tap, spec, router, nil)
+	})
+}
 
-		// Get method data
-		pathMap, ok := pathData.(map[string]interface{})
-		if !ok {
-			http.Error(w, "Invalid path data format", http.StatusInternalServerError)
-			return
+// createOpenAPIMockHandler creates a handler that answers requests from the
+// listener's OpenAPI document without an AI provider: examples are served
+// as they are, and responses without one are synthesized from their schema,
+// seeded by the request so repeated requests get the same response
+func (lm *ListenerManager) createOpenAPIMockHandler(config *database.Listener, tapFactory tunnel.TapFactory, spec *atomic.Pointer[openapi.Spec]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+			r.Body.Close()
 		}
 
-		methodData, ok := pathMap[strings.ToUpper(r.Method)]
-		if !ok {
-			// Try lowercase
-			methodData, ok = pathMap[strings.ToLower(r.Method)]
-			if !ok {
-				http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
-				return
+		var tap tunnel.Tap
+		if tapFactory != nil {
+			meta := tunnel.Meta{
+				Username: config.Username,
+				Remote:   settings.Remote{LocalHost: "127.0.0.1", LocalPort: strconv.Itoa(config.Port), RemoteHost: "127.0.0.1", RemotePort: strconv.Itoa(config.Port)},
+				ConnID:   fmt.Sprintf("%s-%d", r.RemoteAddr, time.Now().UnixNano()),
+				Peer:     r.RemoteAddr,
 			}
+			tap = tapFactory(meta)
 		}
-
-		// Get responses
-		methodMap, ok := methodData.(map[string]interface{})
-		if !ok {
-			http.Error(w, "Invalid method data format", http.StatusInternalServerError)
-			return
-		}
-
-		responses_data, ok := methodMap["responses"]
-		if !ok {
-			http.Error(w, "No responses defined", http.StatusInternalServerError)
-			return
-		}
-
-		responsesMap, ok := responses_data.(map[string]interface{})
-		if !ok {
-			http.Error(w, "Invalid responses format", http.StatusInternalServerError)
-			return
+		if tap != nil {
+			tap.OnOpen()
+			captureRequest(tap, r, body)
 		}
 
-		// Choose response (prefer 200, then first available)
-		var statusCode string
-		var responseData interface{}
-
-		if data, ok := responsesMap["200"]; ok {
-			statusCode = "200"
-			responseData = data
-		} else {
-			// Get first available response
-			for code, data := range responsesMap {
-				statusCode = code
-				responseData = data
-				break
+		doc := spec.Load()
+		sent := serveOpenAPIMock(w, r, body, tap, doc, doc.Router, func(res *openapi.Response) (interface{}, bool) {
+			schema := res.Media["schema"]
+			if schema == nil {
+				return nil, false
 			}
-		}
+			return doc.Synthesize(schema, openapi.Seed(r.Method, r.URL.RequestURI(), strconv.Itoa(res.Status), res.ContentType)), true
+		})
 
-		if responseData == nil {
-			http.Error(w, "No response data available", http.StatusInternalServerError)
-			return
-		}
-
-		// Extract response content
-		responseMap, ok := responseData.(map[string]interface{})
-		if !ok {
-			http.Error(w, "Invalid response data format", http.StatusInternalServerError)
-			return
+		if tap != nil {
+			tap.OnClose(sent, int64(len(body)))
 		}
+	})
+}
 
-		content, ok := responseMap["content"]
-		if !ok {
-			// Simple response without content structure
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(200)
-			json.NewEncoder(w).Encode(responseData)
-			return
+// serveOpenAPIMock answers r from the operations router matches, which
+// follow the OpenAPI paths object, and returns the size of the response
+// body. When spec is set, requests are validated against its matching
+// operation first. Responses without an example are synthesized by
+// fallback, or fail when it is nil.
+func serveOpenAPIMock(w http.ResponseWriter, r *http.Request, body []byte, tap tunnel.Tap, spec *openapi.Spec, router *openapi.Router,
+	fallback func(res *openapi.Response) (interface{}, bool)) int64 {
+	var sent int64
+	respond := func(code int, header http.Header, out []byte) {
+		for name, values := range header {
+			w.Header()[name] = values
 		}
-
-		contentMap, ok := content.(map[string]interface{})
-		if !ok {
-			http.Error(w, "Invalid content format", http.StatusInternalServerError)
-			return
+		w.WriteHeader(code)
+		w.Write(out)
+		sent = int64(len(out))
+		if tap != nil {
+			captureResponse(tap, code, w.Header(), out)
 		}
+	}
+	fail := func(err error) {
+		code, out := openapi.ErrorResponse(err)
+		respond(code, http.Header{"Content-Type": {"application/json"}}, out)
+	}
 
-		// Get JSON content
-		jsonContent, ok := contentMap["application/json"]
-		if !ok {
-			// Try other content types
-			for contentType, data := range contentMap {
-				w.Header().Set("Content-Type", contentType)
-				code, _ := strconv.Atoi(statusCode)
-				if code == 0 {
-					code = 200
-				}
-				w.WriteHeader(code)
-
-				if dataMap, ok := data.(map[string]interface{}); ok {
-					if example, ok := dataMap["example"]; ok {
-						json.NewEncoder(w).Encode(example)
-					} else {
-						json.NewEncoder(w).Encode(data)
-					}
-				} else {
-					json.NewEncoder(w).Encode(data)
-				}
-				return
+	match, err := router.Match(r.Method, r.URL.Path)
+	if err != nil {
+		fail(err)
+		return sent
+	}
+	if spec != nil {
+		if specMatch, err := spec.Router.Match(r.Method, r.URL.Path); err == nil {
+			if err := spec.ValidateRequest(specMatch, r, body); err != nil {
+				fail(err)
+				return sent
 			}
-
-			http.Error(w, "No supported content type found", http.StatusInternalServerError)
-			return
 		}
+	}
 
-		jsonMap, ok := jsonContent.(map[string]interface{})
-		if !ok {
-			http.Error(w, "Invalid JSON content format", http.StatusInternalServerError)
-			return
-		}
+	res, err := spec.SelectResponse(match.Operation, r)
+	if err != nil {
+		fail(err)
+		return sent
+	}
+	header := res.Header
+	if res.ContentType == "" && res.Media == nil {
+		example, ok, err := spec.Example(res, "")
+		if err != nil || !ok {
+			respond(res.Status, header, nil)
+			return sent
+		}
+		// Simple response without content structure
+		header.Set("Content-Type", "application/json")
+		respond(res.Status, header, openapi.Encode("application/json", example))
+		return sent
+	}
+	example, ok, err := spec.Example(res, openapi.ParsePrefer(r.Header).Example)
+	if err != nil {
+		fail(err)
+		return sent
+	}
+	if !ok && fallback != nil {
+		example, ok = fallback(res)
+	}
+	if !ok {
+		fail(&openapi.Error{Status: http.StatusInternalServerError, Message: fmt.Sprintf("no example for the %d %s response of %s %s", res.Status, res.ContentType, r.Method, match.Template)})
+		return sent
+	}
+	header.Set("Content-Type", res.ContentType)
+	respond(res.Status, header, openapi.Encode(res.ContentType, example))
+	return sent
+}
 
-		example, ok := jsonMap["example"]
-		if !ok {
-			http.Error(w, "No example data found", http.StatusInternalServerError)
-			return
-		}
+// captureRequest writes r to the tap's client-to-upstream stream as an
+// HTTP/1.1 message framed by Content-Length, so the capture parser can
+// delimit it regardless of how the body arrived.
+func captureRequest(tap tunnel.Tap, r *http.Request, body []byte) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\n", r.Method, r.URL.RequestURI(), r.Host)
+	writeCaptureHeader(&b, r.Header)
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(body))
+	b.Write(body)
+	tap.SrcWriter().Write(b.Bytes())
+}
 
-		// Send response
-		w.Header().Set("Content-Type", "application/json")
-		code, _ := strconv.Atoi(statusCode)
-		if code == 0 {
-			code = 200
-		}
-		w.WriteHeader(code)
+// captureRequestHead writes a request head to the tap's client-to-upstream
+// stream. It returns the writer the body is to be captured through and a
+// function ending it; bodies of unknown length are framed as chunked.
+func captureRequestHead(tap tunnel.Tap, r *http.Request) (io.Writer, func()) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\n", r.Method, r.URL.RequestURI(), r.Host)
+	writeCaptureHeader(&b, r.Header)
+	w := tap.SrcWriter()
+	if r.ContentLength >= 0 {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", r.ContentLength)
+		w.Write(b.Bytes())
+		return w, func() {}
+	}
+	b.WriteString("Transfer-Encoding: chunked\r\n\r\n")
+	w.Write(b.Bytes())
+	cw := httputil.NewChunkedWriter(w)
+	return cw, func() {
+		cw.Close()
+		io.WriteString(w, "\r\n")
+	}
+}
 
-		responseBody, _ := json.Marshal(example)
-		w.Write(responseBody)
+// captureResponse writes a complete response to the tap's upstream-to-client stream
+func captureResponse(tap tunnel.Tap, code int, header http.Header, body []byte) {
+	w := tap.DstWriter()
+	w.Write(responseHead(code, header, int64(len(body))))
+	w.Write(body)
+}
 
-		// Log the response and update sent bytes
-		if tap != nil {
-			respData := fmt.Sprintf("HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
-			respData += fmt.Sprintf("Content-Type: application/json\r\n")
-			respData += fmt.Sprintf("Content-Length: %d\r\n", len(responseBody))
-			respData += "\r\n"
-			respData += string(responseBody)
-			tap.DstWriter().Write([]byte(respData))
-		}
-	})
+// responseHead renders a status line and headers; a negative contentLength
+// leaves the body delimited by the end of the connection
+func responseHead(code int, header http.Header, contentLength int64) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	writeCaptureHeader(&b, header)
+	if contentLength >= 0 {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", contentLength)
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
 }
 
-// matchesPath performs simple path matching (can be enhanced for path parameters)
-func matchesPath(requestPath, templatePath string) bool {
-	// For now, just exact match - can be enhanced to support path parameters like /users/{id}
-	return requestPath == templatePath
+// writeCaptureHeader writes header fields except those describing the framing,
+// which the capture re-derives from the body it records
+func writeCaptureHeader(b *bytes.Buffer, header http.Header) {
+	for name, values := range header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Host", "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		for _, value := range values {
+			fmt.Fprintf(b, "%s: %s\r\n", name, value)
+		}
+	}
 }
@@ -0,0 +1,52 @@
+package chserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NextChapterSoftware/chissl/server/capture"
+	"github.com/NextChapterSoftware/chissl/server/fixture"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+func TestProxyStreamsRequestBody(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		io.WriteString(w, "got "+string(b))
+	}))
+	defer upstream.Close()
+
+	svc := capture.NewService(500, 64*1024)
+	recorded := make(chan *fixture.Fixture, 1)
+	lm := &ListenerManager{}
+	config := &database.Listener{ID: "l1", Username: "ann", Port: 1, Mode: "record", TargetURL: upstream.URL}
+	srv := httptest.NewServer(lm.createProxyHandler(config, capture.NewTapFactory(svc, "l1", "ann", 500), func(f *fixture.Fixture) {
+		recorded <- f
+	}))
+	defer srv.Close()
+
+	// A body of unknown length is sent chunked
+	body := strings.Repeat("chunk", 1000)
+	res, err := http.Post(srv.URL+"/upload", "text/plain", io.MultiReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "got "+body {
+		t.Fatalf("expected the upstream to receive the whole body, got %d bytes", len(b))
+	}
+
+	exchanges := capture.Exchanges(svc.GetRecent("l1", 500))
+	if len(exchanges) != 1 || string(exchanges[0].RequestBody) != body || exchanges[0].Response == nil {
+		t.Fatalf("expected the streamed request body to be captured, got %+v", exchanges)
+	}
+	f := <-recorded
+	if f.Method != "POST" || f.Request.Body != body {
+		t.Fatalf("unexpected fixture %s %s with a %d byte body", f.Method, f.Path, len(f.Request.Body))
+	}
+}
@@ -0,0 +1,47 @@
+package mock
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are available to response templates in addition to the
+// request fields, e.g. {{json .Params.id}} or {{get .JSON "user.name"}}
+var templateFuncs = template.FuncMap{
+	// json renders a value as JSON, quoting strings
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// get looks up a member of a decoded JSON value by dotted path
+	"get": func(doc any, path string) any {
+		v, _ := lookup(doc, path)
+		return v
+	},
+	// default returns def when v is empty
+	"default": func(def, v any) any {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	"now": func() string {
+		return time.Now().UTC().Format(time.RFC3339)
+	},
+	"unix": func() int64 {
+		return time.Now().Unix()
+	},
+	"uuid": func() string {
+		var b [16]byte
+		rand.Read(b[:])
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
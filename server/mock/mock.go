@@ -0,0 +1,380 @@
+// Package mock answers HTTP requests from an ordered list of rules. The first
+// rule whose method, path pattern, query, headers and JSON body fields match a
+// request renders its response; response headers and bodies are Go templates
+// over the request.
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// maxDelay caps the delay of a rule's response
+const maxDelay = 60 * time.Second
+
+// Rule matches requests and describes the response to them. Empty match
+// fields match anything.
+type Rule struct {
+	Name   string `json:"name,omitempty"`
+	Method string `json:"method,omitempty"`
+	// Path is a pattern: "{name}" captures one path segment, "{name...}" the
+	// rest of the path and "*" matches any one segment
+	Path string `json:"path,omitempty"`
+	// Query and Headers match parameter and header values ("*" wildcards)
+	Query   map[string]string `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// JSON matches members of a JSON request body by dotted path, array
+	// elements by index, against the given values
+	JSON     map[string]any `json:"json,omitempty"`
+	Response Response       `json:"response"`
+}
+
+// Response is the templated response of a rule
+type Response struct {
+	// Status defaults to 200
+	Status int `json:"status,omitempty"`
+	// Headers values are templates; Content-Type defaults to JSON when the
+	// body looks like JSON
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// DelayMillis delays the response, up to a minute
+	DelayMillis int `json:"delay_ms,omitempty"`
+}
+
+// Request is the data response templates are executed with
+type Request struct {
+	Method string
+	Path   string
+	// Params holds the path segments captured by the rule's pattern
+	Params  map[string]string
+	Query   map[string]string
+	Headers map[string]string
+	Body    string
+	// JSON is the decoded request body, nil unless it is JSON
+	JSON any
+}
+
+// Router holds compiled rules
+type Router struct {
+	rules []*compiledRule
+}
+
+type compiledRule struct {
+	Rule
+	segments []string
+	headers  map[string]*template.Template
+	body     *template.Template
+}
+
+// Parse decodes a JSON list of rules; an empty string holds no rules
+func Parse(s string) ([]Rule, error) {
+	var rules []Rule
+	if strings.TrimSpace(s) == "" {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, fmt.Errorf("invalid mock rules: %w", err)
+	}
+	return rules, nil
+}
+
+// New compiles rules, reporting the first invalid one
+func New(rules []Rule) (*Router, error) {
+	rt := &Router{}
+	for i, r := range rules {
+		c, err := compile(r)
+		if err != nil {
+			name := r.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(i+1)
+			}
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		rt.rules = append(rt.rules, c)
+	}
+	return rt, nil
+}
+
+func compile(r Rule) (*compiledRule, error) {
+	r.Method = strings.ToUpper(strings.TrimSpace(r.Method))
+	if r.Response.Status != 0 && (r.Response.Status < 100 || r.Response.Status > 999) {
+		return nil, fmt.Errorf("invalid status %d", r.Response.Status)
+	}
+	if r.Response.DelayMillis < 0 || time.Duration(r.Response.DelayMillis)*time.Millisecond > maxDelay {
+		return nil, fmt.Errorf("delay_ms must be between 0 and %d", maxDelay.Milliseconds())
+	}
+	c := &compiledRule{Rule: r, headers: map[string]*template.Template{}}
+	if r.Path != "" {
+		segments, err := ParsePattern(r.Path)
+		if err != nil {
+			return nil, err
+		}
+		c.segments = segments
+	}
+	var err error
+	if c.body, err = newTemplate("body", r.Response.Body); err != nil {
+		return nil, err
+	}
+	for name, value := range r.Response.Headers {
+		if c.headers[name], err = newTemplate(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func newTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return t, nil
+}
+
+// Rules returns the number of compiled rules
+func (rt *Router) Rules() int {
+	return len(rt.rules)
+}
+
+// ServeHTTP answers r from the first matching rule, or 404 when none
+// matches. It is the handler of mock listeners.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	status, header, out, _ := rt.Respond(r, body)
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// Respond renders the response of the first rule matching r, whose body
+// was already read, after the rule's delay. ok is false when no rule
+// matched and a 404 is returned.
+func (rt *Router) Respond(r *http.Request, body []byte) (status int, header http.Header, out []byte, ok bool) {
+	req := NewRequest(r, body)
+	for _, c := range rt.rules {
+		params, matched := c.match(req)
+		if !matched {
+			continue
+		}
+		req.Params = params
+		status, header, out = c.render(req)
+		if d := time.Duration(c.Response.DelayMillis) * time.Millisecond; d > 0 {
+			select {
+			case <-time.After(d):
+			case <-r.Context().Done():
+			}
+		}
+		return status, header, out, true
+	}
+	header = http.Header{"Content-Type": {"application/json"}}
+	out, _ = json.Marshal(map[string]string{"error": "no mock rule matches " + r.Method + " " + r.URL.Path})
+	return http.StatusNotFound, header, out, false
+}
+
+// NewRequest builds the template data of a request
+func NewRequest(r *http.Request, body []byte) *Request {
+	req := &Request{Method: r.Method, Path: r.URL.Path, Params: map[string]string{}, Query: map[string]string{}, Headers: map[string]string{}, Body: string(body)}
+	for k, v := range r.URL.Query() {
+		req.Query[k] = v[0]
+	}
+	for k, v := range r.Header {
+		req.Headers[k] = v[0]
+	}
+	if r.Host != "" {
+		req.Headers["Host"] = r.Host
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		var doc any
+		if json.Unmarshal(body, &doc) == nil {
+			req.JSON = doc
+		}
+	}
+	return req
+}
+
+func (c *compiledRule) match(req *Request) (map[string]string, bool) {
+	if c.Method != "" && c.Method != req.Method {
+		return nil, false
+	}
+	params := map[string]string{}
+	if c.segments != nil {
+		var ok bool
+		if params, ok = MatchPattern(c.segments, req.Path); !ok {
+			return nil, false
+		}
+	}
+	for name, pattern := range c.Query {
+		v, ok := req.Query[name]
+		if !ok || !globMatch(pattern, v) {
+			return nil, false
+		}
+	}
+	for name, pattern := range c.Headers {
+		v, ok := req.Headers[http.CanonicalHeaderKey(name)]
+		if !ok || !globMatch(pattern, v) {
+			return nil, false
+		}
+	}
+	if len(c.JSON) > 0 {
+		if req.JSON == nil {
+			return nil, false
+		}
+		for path, want := range c.JSON {
+			got, ok := lookup(req.JSON, path)
+			if !ok || !jsonEqual(got, want) {
+				return nil, false
+			}
+		}
+	}
+	return params, true
+}
+
+func (c *compiledRule) render(req *Request) (int, http.Header, []byte) {
+	status := c.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	var b bytes.Buffer
+	if err := c.body.Execute(&b, req); err != nil {
+		return http.StatusInternalServerError, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, []byte("mock template error: " + err.Error())
+	}
+	header := http.Header{}
+	for name, t := range c.headers {
+		var v bytes.Buffer
+		if err := t.Execute(&v, req); err != nil {
+			return http.StatusInternalServerError, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, []byte("mock template error: " + err.Error())
+		}
+		header.Set(name, v.String())
+	}
+	if header.Get("Content-Type") == "" && b.Len() > 0 {
+		if json.Valid(b.Bytes()) {
+			header.Set("Content-Type", "application/json")
+		} else {
+			header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+	}
+	return status, header, b.Bytes()
+}
+
+// ParsePattern splits a path pattern into segments, checking its parameters
+func ParsePattern(pattern string) ([]string, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("path pattern %q must start with /", pattern)
+	}
+	segments := strings.Split(pattern[1:], "/")
+	seen := map[string]bool{}
+	for i, s := range segments {
+		if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+			if strings.ContainsAny(s, "{}") {
+				return nil, fmt.Errorf("path pattern %q: parameters must span a whole segment", pattern)
+			}
+			continue
+		}
+		name := strings.TrimSuffix(s[1:len(s)-1], "...")
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("path pattern %q: empty or repeated parameter", pattern)
+		}
+		if strings.HasSuffix(s, "...}") && i != len(segments)-1 {
+			return nil, fmt.Errorf("path pattern %q: %s must be the last segment", pattern, s)
+		}
+		seen[name] = true
+	}
+	return segments, nil
+}
+
+// MatchPattern matches a request path against the segments of a pattern,
+// returning the captured parameters
+func MatchPattern(segments []string, path string) (map[string]string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	params := map[string]string{}
+	for i, s := range segments {
+		if strings.HasSuffix(s, "...}") {
+			params[s[1:len(s)-4]] = strings.Join(parts[min(i, len(parts)):], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch {
+		case s == "*":
+			if parts[i] == "" {
+				return nil, false
+			}
+		case strings.HasPrefix(s, "{"):
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[s[1:len(s)-1]] = parts[i]
+		case s != parts[i]:
+			return nil, false
+		}
+	}
+	return params, len(parts) == len(segments)
+}
+
+// globMatch matches s against a pattern where "*" matches any run of characters
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, p)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(p):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// lookup finds a member of a decoded JSON document by dotted path
+func lookup(doc any, path string) (any, bool) {
+	if path == "" {
+		return doc, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := doc.(type) {
+		case map[string]any:
+			child, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			doc = child
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// jsonEqual compares JSON values after normalizing want through encoding/json
+func jsonEqual(got, want any) bool {
+	b, err := json.Marshal(want)
+	if err != nil {
+		return false
+	}
+	var norm any
+	json.Unmarshal(b, &norm)
+	return reflect.DeepEqual(got, norm)
+}
@@ -0,0 +1,85 @@
+package mock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouter(t *testing.T) {
+	rules, err := Parse(`[
+		{"name": "paid order", "method": "post", "path": "/orders/{id}", "json": {"order.status": "paid", "items.0.qty": 2},
+		 "response": {"status": 201, "headers": {"Location": "/orders/{{.Params.id}}"}, "body": "{\"id\": {{json .Params.id}}, \"by\": {{json (get .JSON \"order.customer\")}}}"}},
+		{"method": "GET", "path": "/orders/{id}", "query": {"view": "full*"}, "headers": {"x-tenant": "acme"},
+		 "response": {"body": "full {{.Params.id}} for {{index .Headers \"X-Tenant\"}}", "delay_ms": 20}},
+		{"path": "/files/{rest...}", "response": {"body": "{{.Method}} {{.Params.rest}}"}},
+		{"path": "/*/health", "response": {"status": 204}}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, err := New(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/orders/42", `{"order":{"status":"paid","customer":"ann"},"items":[{"qty":2}]}`, nil)
+	if w.Code != 201 || w.Header().Get("Location") != "/orders/42" || w.Body.String() != `{"id": "42", "by": "ann"}` || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	// JSON fields must all match
+	if w := do("POST", "/orders/42", `{"order":{"status":"open"},"items":[{"qty":2}]}`, nil); w.Code != 404 {
+		t.Fatalf("expected no match for another status, got %d", w.Code)
+	}
+	start := time.Now()
+	w = do("GET", "/orders/7?view=fuller", "", http.Header{"X-Tenant": {"acme"}})
+	if w.Code != 200 || w.Body.String() != "full 7 for acme" || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("unexpected response %d %s after %v", w.Code, w.Body, time.Since(start))
+	}
+	if w := do("GET", "/orders/7?view=full", "", nil); w.Code != 404 {
+		t.Fatalf("expected the header to be required, got %d", w.Code)
+	}
+	if w := do("DELETE", "/files/a/b.txt", "", nil); w.Body.String() != "DELETE a/b.txt" {
+		t.Fatalf("unexpected rest parameter %s", w.Body)
+	}
+	if w := do("GET", "/api/health", "", nil); w.Code != 204 {
+		t.Fatalf("expected the wildcard segment to match, got %d", w.Code)
+	}
+	if w := do("GET", "/api/v1/health", "", nil); w.Code != 404 || !strings.Contains(w.Body.String(), "no mock rule") {
+		t.Fatalf("expected a 404 for unmatched requests, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRuleValidation(t *testing.T) {
+	for _, rules := range []string{
+		`[{"path": "orders"}]`,
+		`[{"path": "/a/{id}/{id}"}]`,
+		`[{"path": "/a/{rest...}/b"}]`,
+		`[{"path": "/a/x{id}"}]`,
+		`[{"response": {"status": 42}}]`,
+		`[{"response": {"delay_ms": 600000}}]`,
+		`[{"response": {"body": "{{.Nope"}}]`,
+	} {
+		parsed, err := Parse(rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(parsed); err == nil {
+			t.Errorf("expected %s to be rejected", rules)
+		}
+	}
+	if _, err := Parse(`{"path": "/"}`); err == nil {
+		t.Errorf("expected a non-list to be rejected")
+	}
+}
@@ -179,6 +179,7 @@ func (m *MulticastManager) createWebhookHandler(cfg *database.MulticastTunnel) h
 				Username: cfg.Owner,
 				Remote:   settings.Remote{LocalHost: "127.0.0.1", LocalPort: strconv.Itoa(cfg.Port), RemoteHost: "127.0.0.1", RemotePort: strconv.Itoa(cfg.Port)},
 				ConnID:   connID,
+				Peer:     r.RemoteAddr,
 			}
 			tap = capture.NewTapFactory(m.capture, cfg.ID, cfg.Owner, 500)(meta)
 		}
@@ -274,6 +275,7 @@ func (m *MulticastManager) createBidirectionalHandler(cfg *database.MulticastTun
 				Username: cfg.Owner,
 				Remote:   settings.Remote{LocalHost: "127.0.0.1", LocalPort: strconv.Itoa(cfg.Port), RemoteHost: "127.0.0.1", RemotePort: strconv.Itoa(cfg.Port)},
 				ConnID:   connID,
+				Peer:     r.RemoteAddr,
 			}
 			tap = capture.NewTapFactory(m.capture, cfg.ID, cfg.Owner, 500)(meta)
 		}
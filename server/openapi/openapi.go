@@ -0,0 +1,233 @@
+// Package openapi serves mock listeners from OpenAPI 3 documents: it matches
+// requests against path templates, validates them against the operation's
+// parameters and request body schema, selects the response to send from the
+// request's Prefer and Accept headers, and synthesizes bodies from schemas
+// for responses without examples.
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Error is a request the document cannot answer, with the status to reply with
+type Error struct {
+	Status  int      `json:"-"`
+	Message string   `json:"error"`
+	Details []string `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Details) == 0 {
+		return e.Message
+	}
+	return e.Message + ": " + strings.Join(e.Details, "; ")
+}
+
+// ErrorResponse renders err as a JSON error body, with its status when it
+// is an *Error and 500 otherwise
+func ErrorResponse(err error) (int, []byte) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	b, _ := json.Marshal(e)
+	return e.Status, b
+}
+
+// Spec is a parsed OpenAPI document
+type Spec struct {
+	doc    map[string]any
+	Router *Router
+}
+
+// Parse reads an OpenAPI document in JSON or YAML
+func Parse(text string) (*Spec, error) {
+	var doc any
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		if yerr := yaml.Unmarshal([]byte(text), &doc); yerr != nil {
+			return nil, fmt.Errorf("invalid OpenAPI document: %w", yerr)
+		}
+		doc = normalize(doc)
+	}
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid OpenAPI document: not an object")
+	}
+	if v, _ := m["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		return nil, fmt.Errorf("invalid OpenAPI document: openapi version %q is not 3.x", v)
+	}
+	paths, ok := m["paths"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid OpenAPI document: no paths")
+	}
+	router, err := NewRouter(paths)
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{doc: m, Router: router}, nil
+}
+
+// normalize turns the maps yaml decodes with non-string keys, such as
+// response codes, into JSON objects
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			v[k] = normalize(child)
+		}
+		return v
+	case map[any]any:
+		out := make(map[string]any, len(v))
+		for k, child := range v {
+			out[fmt.Sprint(k)] = normalize(child)
+		}
+		return out
+	case []any:
+		for i, child := range v {
+			v[i] = normalize(child)
+		}
+		return v
+	}
+	return v
+}
+
+// Resolve follows local "$ref" pointers ("#/components/schemas/User") until
+// it reaches a value that is not a reference. Unresolvable references
+// resolve to nil.
+func (s *Spec) Resolve(v any) any {
+	for depth := 0; depth < 32; depth++ {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return v
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return v
+		}
+		if s == nil || !strings.HasPrefix(ref, "#/") {
+			return nil
+		}
+		var cur any = s.doc
+		for _, key := range strings.Split(ref[2:], "/") {
+			key = strings.ReplaceAll(strings.ReplaceAll(key, "~1", "/"), "~0", "~")
+			obj, ok := cur.(map[string]any)
+			if !ok {
+				return nil
+			}
+			cur = obj[key]
+		}
+		v = cur
+	}
+	return nil
+}
+
+// Router matches request paths against the path templates of a document
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	template string
+	item     map[string]any
+	pattern  *regexp.Regexp
+	names    []string
+	segments []string
+}
+
+// Match is an operation found for a request
+type Match struct {
+	// Template is the path template, e.g. "/users/{id}"
+	Template  string
+	Item      map[string]any
+	Operation map[string]any
+	// Params holds the path parameters by name
+	Params map[string]string
+}
+
+var paramExpr = regexp.MustCompile(`\{([^{}/]+)\}`)
+
+// NewRouter compiles the path templates of a paths object. Operations are
+// looked up by method in either case, so the upper-case keys of generated
+// mock responses work as well as the spec's own.
+func NewRouter(paths map[string]any) (*Router, error) {
+	rt := &Router{}
+	for template, v := range paths {
+		item, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		if !strings.HasPrefix(template, "/") {
+			return nil, fmt.Errorf("invalid path %q: must start with /", template)
+		}
+		r := &route{template: template, item: item, segments: strings.Split(template[1:], "/")}
+		var expr strings.Builder
+		expr.WriteString("^")
+		last := 0
+		for _, loc := range paramExpr.FindAllStringSubmatchIndex(template, -1) {
+			expr.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+			expr.WriteString("([^/]+)")
+			r.names = append(r.names, template[loc[2]:loc[3]])
+			last = loc[1]
+		}
+		expr.WriteString(regexp.QuoteMeta(template[last:]))
+		expr.WriteString("$")
+		r.pattern = regexp.MustCompile(expr.String())
+		rt.routes = append(rt.routes, r)
+	}
+	// Concrete paths win over templated ones: "/users/me" before "/users/{id}"
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		a, b := rt.routes[i].segments, rt.routes[j].segments
+		for k := 0; k < len(a) && k < len(b); k++ {
+			at, bt := strings.Contains(a[k], "{"), strings.Contains(b[k], "{")
+			if at != bt {
+				return !at
+			}
+		}
+		return rt.routes[i].template < rt.routes[j].template
+	})
+	return rt, nil
+}
+
+// Match finds the operation for a request. It returns an *Error with status
+// 404 when no path matches and 405 when the path has no such operation.
+func (rt *Router) Match(method, path string) (*Match, error) {
+	var allowed *route
+	for _, r := range rt.routes {
+		sub := r.pattern.FindStringSubmatch(path)
+		if sub == nil {
+			continue
+		}
+		op := operation(r.item, method)
+		if op == nil {
+			if allowed == nil {
+				allowed = r
+			}
+			continue
+		}
+		m := &Match{Template: r.template, Item: r.item, Operation: op, Params: map[string]string{}}
+		for i, name := range r.names {
+			m.Params[name] = sub[i+1]
+		}
+		return m, nil
+	}
+	if allowed != nil {
+		return nil, &Error{Status: http.StatusMethodNotAllowed, Message: "method " + method + " not allowed on " + allowed.template}
+	}
+	return nil, &Error{Status: http.StatusNotFound, Message: "no path matches " + path}
+}
+
+func operation(item map[string]any, method string) map[string]any {
+	if op, ok := item[strings.ToLower(method)].(map[string]any); ok {
+		return op
+	}
+	op, _ := item[strings.ToUpper(method)].(map[string]any)
+	return op
+}
@@ -0,0 +1,253 @@
+package openapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const petstore = `
+openapi: 3.0.3
+info: {title: Pets, version: "1"}
+paths:
+  /pets:
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/NewPet'}
+      responses:
+        201:
+          content:
+            application/json: {example: {id: 1, name: Rex}}
+  /pets/mine:
+    get:
+      responses:
+        200: {content: {application/json: {example: [{id: 7}]}}}
+  /pets/{petId}:
+    parameters:
+      - {name: petId, in: path, required: true, schema: {type: integer, minimum: 1}}
+    get:
+      parameters:
+        - {name: fields, in: query, schema: {type: array, items: {type: string, enum: [name, tag]}}}
+      responses:
+        200:
+          headers:
+            X-Rate-Limit: {schema: {type: integer, example: 100}}
+          content:
+            application/json:
+              examples:
+                rex: {value: {id: 1, name: Rex}}
+                tom: {value: {id: 2, name: Tom}}
+            text/plain: {example: "Rex"}
+        404: {$ref: '#/components/responses/NotFound'}
+  /files/{name}.{ext}:
+    get:
+      responses:
+        default: {description: file}
+components:
+  schemas:
+    NewPet:
+      type: object
+      required: [name]
+      additionalProperties: false
+      properties:
+        name: {type: string, minLength: 1}
+        age: {type: integer, minimum: 0}
+        tags: {type: array, items: {type: string}, maxItems: 2}
+  responses:
+    NotFound:
+      content:
+        application/json: {example: {error: not found}}
+`
+
+func TestRouter(t *testing.T) {
+	spec, err := Parse(petstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		method, path, template string
+		params                 map[string]string
+		status                 int
+	}{
+		{"GET", "/pets/42", "/pets/{petId}", map[string]string{"petId": "42"}, 0},
+		{"GET", "/pets/mine", "/pets/mine", map[string]string{}, 0},
+		{"GET", "/files/report.pdf", "/files/{name}.{ext}", map[string]string{"name": "report", "ext": "pdf"}, 0},
+		{"DELETE", "/pets/42", "", nil, http.StatusMethodNotAllowed},
+		{"GET", "/pets/42/toys", "", nil, http.StatusNotFound},
+	} {
+		m, err := spec.Router.Match(c.method, c.path)
+		if c.status != 0 {
+			var e *Error
+			if !errors.As(err, &e) || e.Status != c.status {
+				t.Fatalf("%s %s: expected %d, got %v", c.method, c.path, c.status, err)
+			}
+			continue
+		}
+		if err != nil || m.Template != c.template || len(m.Params) != len(c.params) {
+			t.Fatalf("%s %s: unexpected match %+v, %v", c.method, c.path, m, err)
+		}
+		for k, v := range c.params {
+			if m.Params[k] != v {
+				t.Fatalf("%s %s: param %s = %q", c.method, c.path, k, m.Params[k])
+			}
+		}
+	}
+	// Generated mock responses use upper-case methods
+	rt, _ := NewRouter(map[string]any{"/users/{id}": map[string]any{"GET": map[string]any{}}})
+	if m, err := rt.Match("GET", "/users/9"); err != nil || m.Params["id"] != "9" {
+		t.Fatalf("unexpected match %+v, %v", m, err)
+	}
+	if _, err := Parse(`{"swagger": "2.0", "paths": {}}`); err == nil {
+		t.Fatal("expected Swagger 2 to be rejected")
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	spec, err := Parse(petstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := func(method, target, ct, body string) error {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if ct != "" {
+			r.Header.Set("Content-Type", ct)
+		}
+		m, err := spec.Router.Match(method, r.URL.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return spec.ValidateRequest(m, r, []byte(body))
+	}
+	details := func(err error) string {
+		var e *Error
+		if !errors.As(err, &e) {
+			return "<nil>"
+		}
+		return strings.Join(e.Details, "; ")
+	}
+
+	if err := validate("GET", "/pets/42?fields=name,tag", "", ""); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := details(validate("GET", "/pets/abc?fields=size", "", "")); got != "path parameter petId must be of type integer; query parameter fields[0] must be one of the enumerated values" {
+		t.Fatalf("unexpected problems %q", got)
+	}
+	if got := details(validate("GET", "/pets/0", "", "")); got != "path parameter petId must be at least 1" {
+		t.Fatalf("unexpected problems %q", got)
+	}
+	if err := validate("POST", "/pets", "application/json", `{"name":"Rex","age":3,"tags":["a"]}`); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := details(validate("POST", "/pets", "application/json", `{"age":-1.5,"tags":["a","b","c"],"owner":"x"}`)); got != "body.age must be of type integer; body.name is required; body.owner is not allowed; body.tags must have at most 2 items" {
+		t.Fatalf("unexpected problems %q", got)
+	}
+	if got := details(validate("POST", "/pets", "", "")); got != "request body is required" {
+		t.Fatalf("unexpected problems %q", got)
+	}
+	var e *Error
+	if err := validate("POST", "/pets", "text/xml", "<pet/>"); !errors.As(err, &e) || e.Status != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %v", err)
+	}
+}
+
+func TestSelectResponse(t *testing.T) {
+	spec, err := Parse(petstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(target string, header http.Header) (int, string, string, error) {
+		r := httptest.NewRequest("GET", target, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		m, err := spec.Router.Match("GET", r.URL.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := spec.SelectResponse(m.Operation, r)
+		if err != nil {
+			return 0, "", "", err
+		}
+		example, _, err := spec.Example(res, ParsePrefer(r.Header).Example)
+		if err != nil {
+			return 0, "", "", err
+		}
+		return res.Status, res.ContentType, string(Encode(res.ContentType, example)), nil
+	}
+
+	if code, ct, body, _ := serve("/pets/1", nil); code != 200 || ct != "application/json" || body != `{"id":1,"name":"Rex"}` {
+		t.Fatalf("unexpected default response %d %s %s", code, ct, body)
+	}
+	if code, ct, body, _ := serve("/pets/1", http.Header{"Accept": {"text/*;q=0.9, application/json;q=0.5"}}); code != 200 || ct != "text/plain" || body != "Rex" {
+		t.Fatalf("unexpected negotiated response %d %s %s", code, ct, body)
+	}
+	if _, _, body, _ := serve("/pets/1", http.Header{"Prefer": {"example=tom"}}); body != `{"id":2,"name":"Tom"}` {
+		t.Fatalf("unexpected preferred example %s", body)
+	}
+	if code, _, body, _ := serve("/pets/1", http.Header{"Prefer": {"code=404"}}); code != 404 || body != `{"error":"not found"}` {
+		t.Fatalf("unexpected preferred code %d %s", code, body)
+	}
+	if code, _, _, _ := serve("/files/a.txt", nil); code != 200 {
+		t.Fatalf("expected the default response to answer 200, got %d", code)
+	}
+	for _, c := range []struct {
+		header http.Header
+		status int
+	}{
+		{http.Header{"Accept": {"application/xml"}}, http.StatusNotAcceptable},
+		{http.Header{"Prefer": {"code=500"}}, http.StatusBadRequest},
+		{http.Header{"Prefer": {"example=felix"}}, http.StatusBadRequest},
+	} {
+		_, _, _, err := serve("/pets/1", c.header)
+		var e *Error
+		if !errors.As(err, &e) || e.Status != c.status {
+			t.Fatalf("%v: expected %d, got %v", c.header, c.status, err)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/pets/1", nil)
+	m, _ := spec.Router.Match("GET", "/pets/1")
+	if res, _ := spec.SelectResponse(m.Operation, r); res.Header.Get("X-Rate-Limit") != "100" {
+		t.Fatalf("expected the header example, got %v", res.Header)
+	}
+}
+
+func TestSynthesize(t *testing.T) {
+	spec, err := Parse(`{"openapi": "3.1.0", "paths": {}, "components": {"schemas": {
+		"Node": {"type": "object", "required": ["id", "kind"], "properties": {
+			"id": {"type": "string", "format": "uuid"},
+			"kind": {"enum": ["leaf", "branch"]},
+			"weight": {"type": "integer", "minimum": 10, "maximum": 20},
+			"ratio": {"type": ["number", "null"], "exclusiveMaximum": 1},
+			"email": {"type": "string", "maxLength": 8},
+			"tags": {"type": "array", "items": {"type": "string", "minLength": 12}, "minItems": 2, "maxItems": 2},
+			"children": {"type": "array", "items": {"$ref": "#/components/schemas/Node"}, "maxItems": 1},
+			"meta": {"allOf": [{"properties": {"a": {"type": "boolean"}}}, {"properties": {"b": {"const": 3}}}]}
+		}}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := map[string]any{"$ref": "#/components/schemas/Node"}
+	v := spec.Synthesize(schema, 7)
+	if problems := spec.validateValue(schema, v, "value"); len(problems) > 0 {
+		t.Fatalf("synthesized value %v does not conform: %v", v, problems)
+	}
+	a, b := string(Encode("application/json", v)), string(Encode("application/json", spec.Synthesize(schema, 7)))
+	if a != b {
+		t.Fatalf("expected equal seeds to give equal values, got %s and %s", a, b)
+	}
+	if c := string(Encode("application/json", spec.Synthesize(schema, 8))); c == a {
+		t.Fatalf("expected another seed to give another value, got %s", c)
+	}
+	if meta, _ := v.(map[string]any)["meta"].(map[string]any); meta["b"] != float64(3) || meta["a"] == nil {
+		t.Fatalf("expected allOf members to merge, got %v", meta)
+	}
+	if Seed("GET", "/a") == Seed("GET/", "a") {
+		t.Fatal("expected seed parts to be separated")
+	}
+}
@@ -0,0 +1,241 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prefer holds the hints of a request's Prefer header, e.g.
+// "Prefer: code=404, example=notFound"
+type Prefer struct {
+	Code    int
+	Example string
+}
+
+// ParsePrefer reads the code and example preferences of a request
+func ParsePrefer(h http.Header) Prefer {
+	var p Prefer
+	for _, value := range h.Values("Prefer") {
+		for _, token := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+			key, val, _ := strings.Cut(strings.TrimSpace(token), "=")
+			val = strings.Trim(strings.TrimSpace(val), `"`)
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "code", "status":
+				p.Code, _ = strconv.Atoi(val)
+			case "example":
+				p.Example = val
+			}
+		}
+	}
+	return p
+}
+
+// Response is the response selected for a request
+type Response struct {
+	Status int
+	// ContentType is the negotiated media type, empty when the response has
+	// no content
+	ContentType string
+	// Object is the response object and Media its media type object for
+	// ContentType, holding example, examples and schema
+	Object map[string]any
+	Media  map[string]any
+	Header http.Header
+}
+
+// SelectResponse picks an operation's response: the one for the code the
+// request prefers, or else its first success response, then its content in
+// the media type the Accept header ranks highest. It returns an *Error with
+// status 400 when the preferred code is not described and 406 when no
+// content type is acceptable. s may be nil for documents without components.
+func (s *Spec) SelectResponse(op map[string]any, r *http.Request) (*Response, error) {
+	responses, _ := s.Resolve(op["responses"]).(map[string]any)
+	if len(responses) == 0 {
+		return nil, &Error{Status: http.StatusNotImplemented, Message: "no responses described for " + r.Method + " " + r.URL.Path}
+	}
+	prefer := ParsePrefer(r.Header)
+	key, status := pickStatus(responses, prefer.Code)
+	if key == "" {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("no %d response described for %s %s", prefer.Code, r.Method, r.URL.Path)}
+	}
+	obj, _ := s.Resolve(responses[key]).(map[string]any)
+	res := &Response{Status: status, Object: obj, Header: http.Header{}}
+	for name, v := range asMap(obj["headers"]) {
+		hm := asMap(s.Resolve(v))
+		example, ok := hm["example"]
+		if !ok {
+			example, ok = asMap(s.Resolve(hm["schema"]))["example"]
+		}
+		if ok && !strings.EqualFold(name, "Content-Type") {
+			res.Header.Set(name, fmt.Sprint(example))
+		}
+	}
+	content := asMap(obj["content"])
+	if len(content) == 0 {
+		return res, nil
+	}
+	ct := negotiate(content, r.Header.Get("Accept"))
+	if ct == "" {
+		return nil, &Error{Status: http.StatusNotAcceptable, Message: "no acceptable content type",
+			Details: []string{"available: " + strings.Join(sortedKeys(content), ", ")}}
+	}
+	res.ContentType = ct
+	res.Media = asMap(content[ct])
+	return res, nil
+}
+
+// pickStatus finds the response key for a preferred code, trying "404",
+// "4XX" and "default"; without a preference it takes the lowest 2xx code,
+// "2XX", the lowest code, then "default"
+func pickStatus(responses map[string]any, code int) (string, int) {
+	find := func(key string) (string, bool) {
+		for k := range responses {
+			if strings.EqualFold(k, key) {
+				return k, true
+			}
+		}
+		return "", false
+	}
+	if code != 0 {
+		for _, key := range []string{strconv.Itoa(code), strconv.Itoa(code/100) + "XX", "default"} {
+			if k, ok := find(key); ok {
+				return k, code
+			}
+		}
+		return "", 0
+	}
+	var codes []int
+	for k := range responses {
+		if c, err := strconv.Atoi(k); err == nil {
+			codes = append(codes, c)
+		}
+	}
+	sort.Ints(codes)
+	for _, c := range codes {
+		if c >= 200 && c < 300 {
+			return strconv.Itoa(c), c
+		}
+	}
+	if k, ok := find("2XX"); ok {
+		return k, http.StatusOK
+	}
+	if len(codes) > 0 {
+		return strconv.Itoa(codes[0]), codes[0]
+	}
+	for _, k := range sortedKeys(responses) {
+		if len(k) == 3 && strings.HasSuffix(strings.ToUpper(k), "XX") && k[0] >= '1' && k[0] <= '5' {
+			return k, int(k[0]-'0') * 100
+		}
+	}
+	if k, ok := find("default"); ok {
+		return k, http.StatusOK
+	}
+	return "", 0
+}
+
+// negotiate returns the content key the Accept header ranks highest,
+// preferring JSON when the client accepts anything
+func negotiate(content map[string]any, accept string) string {
+	keys := sortedKeys(content)
+	sort.SliceStable(keys, func(i, j int) bool { return isJSON(baseType(keys[i])) && !isJSON(baseType(keys[j])) })
+	if strings.TrimSpace(accept) == "" {
+		return keys[0]
+	}
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		if q <= 0 {
+			continue
+		}
+		for _, key := range keys {
+			spec, ok := acceptMatch(mt, baseType(key))
+			if ok && (q > bestQ || (q == bestQ && spec > bestSpecificity)) {
+				best, bestQ, bestSpecificity = key, q, spec
+			}
+		}
+	}
+	return best
+}
+
+// acceptMatch reports whether an Accept media range covers a content type,
+// and how specific the range is
+func acceptMatch(accepted, ct string) (int, bool) {
+	switch {
+	case accepted == "*/*":
+		return 0, true
+	case strings.HasSuffix(accepted, "/*"):
+		return 1, strings.HasPrefix(ct, strings.TrimSuffix(accepted, "*"))
+	}
+	return 2, accepted == ct
+}
+
+func baseType(key string) string {
+	if mt, _, err := mime.ParseMediaType(key); err == nil {
+		return mt
+	}
+	return strings.ToLower(key)
+}
+
+// Example returns the example of a selected response: the named one of its
+// examples when name is set, else its example, else its first example by
+// name. It returns false when there is none, and an *Error with status 400
+// when the named example does not exist.
+func (s *Spec) Example(res *Response, name string) (any, bool, error) {
+	examples := asMap(res.Media["examples"])
+	if name != "" {
+		v, ok := examples[name]
+		if !ok {
+			return nil, false, &Error{Status: http.StatusBadRequest, Message: "no example named " + name,
+				Details: []string{"available: " + strings.Join(sortedKeys(examples), ", ")}}
+		}
+		return exampleValue(s.Resolve(v)), true, nil
+	}
+	if v, ok := res.Media["example"]; ok {
+		return v, true, nil
+	}
+	if keys := sortedKeys(examples); len(keys) > 0 {
+		return exampleValue(s.Resolve(examples[keys[0]])), true, nil
+	}
+	// Generated mock responses may put the example on the response itself
+	if v, ok := res.Object["example"]; ok && res.Media == nil {
+		return v, true, nil
+	}
+	return nil, false, nil
+}
+
+// exampleValue unwraps an Example object's value
+func exampleValue(v any) any {
+	if m, ok := v.(map[string]any); ok {
+		if value, ok := m["value"]; ok {
+			return value
+		}
+	}
+	return v
+}
+
+// Encode renders an example in a content type: strings as they are unless
+// the type is JSON, anything else as JSON
+func Encode(contentType string, v any) []byte {
+	if s, ok := v.(string); ok && !isJSON(baseType(contentType)) {
+		return []byte(s)
+	}
+	b, _ := json.Marshal(normalizeJSON(v))
+	return b
+}
+
+func asMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
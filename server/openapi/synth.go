@@ -0,0 +1,237 @@
+package openapi
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Past shallowDepth, synthesized values leave out optional properties and
+// keep arrays at their minimum size, which ends recursive schemas; maxDepth
+// bounds the nesting regardless
+const (
+	shallowDepth = 4
+	maxDepth     = 8
+)
+
+var words = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliet", "kilo", "lima"}
+var names = []string{"Ada Lovelace", "Alan Turing", "Grace Hopper", "Edsger Dijkstra", "Barbara Liskov", "Donald Knuth"}
+
+// synthEpoch anchors synthesized dates so they do not change between runs
+var synthEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Seed derives a generator seed from strings, such as a request's method
+// and path, so the same request always gets the same synthesized response
+func Seed(parts ...string) int64 {
+	h := fnv.New64a()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return int64(h.Sum64() &^ (1 << 63))
+}
+
+// Synthesize builds a value conforming to a schema. Examples, defaults and
+// enums in the schema are used where present; other values are drawn from a
+// generator seeded with seed, so equal seeds give equal values.
+func (s *Spec) Synthesize(schema any, seed int64) any {
+	g := &synth{spec: s, rnd: rand.New(rand.NewSource(seed))}
+	return g.value(schema, "", 0)
+}
+
+type synth struct {
+	spec *Spec
+	rnd  *rand.Rand
+}
+
+func (g *synth) value(schema any, name string, depth int) any {
+	sm, ok := g.spec.Resolve(schema).(map[string]any)
+	if !ok || depth > maxDepth {
+		return nil
+	}
+	for _, key := range []string{"example", "const", "default"} {
+		if v, ok := sm[key]; ok {
+			return normalizeJSON(v)
+		}
+	}
+	if examples := asList(sm["examples"]); len(examples) > 0 {
+		return normalizeJSON(examples[0])
+	}
+	if enum := asList(sm["enum"]); len(enum) > 0 {
+		return normalizeJSON(enum[g.rnd.Intn(len(enum))])
+	}
+	if all := asList(sm["allOf"]); len(all) > 0 {
+		merged := map[string]any{}
+		for _, sub := range all {
+			if obj, ok := g.value(sub, name, depth+1).(map[string]any); ok {
+				for k, v := range obj {
+					merged[k] = v
+				}
+			}
+		}
+		if obj, ok := g.object(sm, depth).(map[string]any); ok {
+			for k, v := range obj {
+				merged[k] = v
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if alts := asList(sm[key]); len(alts) > 0 {
+			return g.value(alts[g.rnd.Intn(len(alts))], name, depth+1)
+		}
+	}
+
+	types := schemaTypes(sm)
+	delete(types, "null")
+	typ := ""
+	for _, t := range []string{"object", "array", "string", "integer", "number", "boolean"} {
+		if types[t] {
+			typ = t
+			break
+		}
+	}
+	if typ == "" {
+		switch {
+		case sm["properties"] != nil:
+			typ = "object"
+		case sm["items"] != nil:
+			typ = "array"
+		default:
+			typ = "string"
+		}
+	}
+	switch typ {
+	case "object":
+		return g.object(sm, depth)
+	case "array":
+		lo, hi := 1, 3
+		if n, ok := number(sm["minItems"]); ok {
+			lo = int(n)
+		}
+		if n, ok := number(sm["maxItems"]); ok {
+			hi = int(n)
+		}
+		hi = max(lo, hi)
+		if depth >= shallowDepth {
+			hi = lo
+		}
+		out := make([]any, lo+g.rnd.Intn(hi-lo+1))
+		for i := range out {
+			out[i] = g.value(sm["items"], name, depth+1)
+		}
+		return out
+	case "integer":
+		lo, hi := g.bounds(sm, 1, 1000)
+		return float64(int64(math.Ceil(lo)) + g.rnd.Int63n(int64(math.Floor(hi)-math.Ceil(lo))+1))
+	case "number":
+		lo, hi := g.bounds(sm, 0, 1000)
+		return math.Round((lo+g.rnd.Float64()*(hi-lo))*100) / 100
+	case "boolean":
+		return g.rnd.Intn(2) == 1
+	}
+	return g.str(sm, name)
+}
+
+func (g *synth) object(sm map[string]any, depth int) any {
+	out := map[string]any{}
+	props := asMap(sm["properties"])
+	required := map[string]bool{}
+	for _, name := range asList(sm["required"]) {
+		if n, ok := name.(string); ok {
+			required[n] = true
+		}
+	}
+	for _, name := range sortedKeys(props) {
+		if depth >= shallowDepth && !required[name] {
+			continue
+		}
+		if v := g.value(props[name], name, depth+1); v != nil {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+func (g *synth) bounds(sm map[string]any, lo, hi float64) (float64, float64) {
+	if n, ok := number(sm["minimum"]); ok {
+		lo = n
+		hi = math.Max(hi, lo+1000)
+	}
+	if n, ok := number(sm["exclusiveMinimum"]); ok {
+		lo = n + 1
+	}
+	if n, ok := number(sm["maximum"]); ok {
+		hi = n
+	}
+	if n, ok := number(sm["exclusiveMaximum"]); ok {
+		hi = n - 1
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// str builds a string for a schema's format, or from the property name
+func (g *synth) str(sm map[string]any, name string) string {
+	format, _ := sm["format"].(string)
+	day := time.Duration(g.rnd.Intn(365*24)) * time.Hour
+	var v string
+	switch format {
+	case "date-time":
+		v = synthEpoch.Add(day).Format(time.RFC3339)
+	case "date":
+		v = synthEpoch.Add(day).Format("2006-01-02")
+	case "time":
+		v = synthEpoch.Add(day).Format("15:04:05")
+	case "email":
+		v = words[g.rnd.Intn(len(words))] + "@example.com"
+	case "uuid":
+		b := make([]byte, 16)
+		g.rnd.Read(b)
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		v = fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	case "uri", "url":
+		v = "https://example.com/" + words[g.rnd.Intn(len(words))]
+	case "hostname":
+		v = words[g.rnd.Intn(len(words))] + ".example.com"
+	case "ipv4":
+		v = fmt.Sprintf("192.0.2.%d", 1+g.rnd.Intn(254))
+	case "ipv6":
+		v = fmt.Sprintf("2001:db8::%x", 1+g.rnd.Intn(0xfffe))
+	case "byte":
+		v = "c2FtcGxl"
+	default:
+		lower := strings.ToLower(name)
+		switch {
+		case strings.Contains(lower, "email"):
+			v = words[g.rnd.Intn(len(words))] + "@example.com"
+		case strings.Contains(lower, "name"):
+			v = names[g.rnd.Intn(len(names))]
+		case strings.HasSuffix(lower, "id"):
+			v = fmt.Sprint(1 + g.rnd.Intn(9999))
+			if prefix := strings.TrimRight(strings.TrimSuffix(lower, "id"), "_-"); prefix != "" {
+				v = prefix + "-" + v
+			}
+		default:
+			v = words[g.rnd.Intn(len(words))]
+			if name == "" {
+				v += fmt.Sprintf("-%d", g.rnd.Intn(1000))
+			}
+		}
+	}
+	if n, ok := number(sm["maxLength"]); ok && len(v) > int(n) {
+		v = v[:int(n)]
+	}
+	if n, ok := number(sm["minLength"]); ok {
+		for len(v) < int(n) {
+			v += "x"
+		}
+	}
+	return v
+}
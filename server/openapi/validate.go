@@ -0,0 +1,392 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidateRequest checks a matched request, whose body was already read,
+// against the operation's parameters and request body. It returns an *Error
+// with status 400, or 415 for a body of a type the operation does not accept.
+func (s *Spec) ValidateRequest(m *Match, r *http.Request, body []byte) error {
+	var problems []string
+	for _, p := range s.parameters(m) {
+		problems = append(problems, s.validateParameter(p, m, r)...)
+	}
+	if rb, ok := s.Resolve(m.Operation["requestBody"]).(map[string]any); ok {
+		if err := s.validateBody(rb, r, body, &problems); err != nil {
+			return err
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &Error{Status: http.StatusBadRequest, Message: "request validation failed", Details: problems}
+	}
+	return nil
+}
+
+// parameters merges the path item's parameters with the operation's, which
+// override them by name and location
+func (s *Spec) parameters(m *Match) []map[string]any {
+	var out []map[string]any
+	index := map[string]int{}
+	for _, list := range []any{m.Item["parameters"], m.Operation["parameters"]} {
+		params, _ := list.([]any)
+		for _, v := range params {
+			p, ok := s.Resolve(v).(map[string]any)
+			if !ok {
+				continue
+			}
+			key := fmt.Sprint(p["in"], ":", p["name"])
+			if i, ok := index[key]; ok {
+				out[i] = p
+				continue
+			}
+			index[key] = len(out)
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (s *Spec) validateParameter(p map[string]any, m *Match, r *http.Request) []string {
+	name, _ := p["name"].(string)
+	in, _ := p["in"].(string)
+	required, _ := p["required"].(bool)
+	var values []string
+	switch in {
+	case "path":
+		if v, ok := m.Params[name]; ok {
+			values = []string{v}
+		}
+		required = true
+	case "query":
+		values = r.URL.Query()[name]
+	case "header":
+		// Accept, Content-Type and Authorization are described elsewhere
+		switch http.CanonicalHeaderKey(name) {
+		case "Accept", "Content-Type", "Authorization":
+			return nil
+		}
+		values = r.Header.Values(name)
+	default:
+		return nil
+	}
+	where := in + " parameter " + name
+	if len(values) == 0 {
+		if required {
+			return []string{where + " is required"}
+		}
+		return nil
+	}
+	schema := s.Resolve(p["schema"])
+	if schema == nil {
+		return nil
+	}
+	return s.validateValue(schema, coerce(s, schema, values), where)
+}
+
+// coerce converts parameter strings to the JSON types of their schema, so
+// "42" validates as an integer; values that do not convert stay strings and
+// fail validation
+func coerce(s *Spec, schema any, values []string) any {
+	sm, _ := schema.(map[string]any)
+	types := schemaTypes(sm)
+	if types["array"] {
+		if len(values) == 1 && strings.Contains(values[0], ",") {
+			values = strings.Split(values[0], ",")
+		}
+		items := s.Resolve(sm["items"])
+		out := make([]any, len(values))
+		for i, v := range values {
+			out[i] = coerce(s, items, []string{v})
+		}
+		return out
+	}
+	v := values[0]
+	switch {
+	case types["integer"] || types["number"]:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	case types["boolean"]:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+func (s *Spec) validateBody(rb map[string]any, r *http.Request, body []byte, problems *[]string) error {
+	required, _ := rb["required"].(bool)
+	if len(body) == 0 {
+		if required {
+			*problems = append(*problems, "request body is required")
+		}
+		return nil
+	}
+	content, _ := rb["content"].(map[string]any)
+	if len(content) == 0 {
+		return nil
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "" {
+		ct = "application/json"
+	}
+	mediaType := matchMediaType(content, ct)
+	if mediaType == "" {
+		return &Error{Status: http.StatusUnsupportedMediaType, Message: "unsupported content type " + ct,
+			Details: []string{"expected one of " + strings.Join(sortedKeys(content), ", ")}}
+	}
+	if !isJSON(ct) {
+		return nil
+	}
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		*problems = append(*problems, "request body is not valid JSON: "+err.Error())
+		return nil
+	}
+	media, _ := content[mediaType].(map[string]any)
+	if schema := s.Resolve(media["schema"]); schema != nil {
+		*problems = append(*problems, s.validateValue(schema, doc, "body")...)
+	}
+	return nil
+}
+
+// matchMediaType finds the content key for a request's media type, trying
+// the exact type, then "type/*" and "*/*"
+func matchMediaType(content map[string]any, ct string) string {
+	for key := range content {
+		if base, _, _ := mime.ParseMediaType(key); strings.EqualFold(base, ct) {
+			return key
+		}
+	}
+	major, _, _ := strings.Cut(ct, "/")
+	for _, want := range []string{major + "/*", "*/*"} {
+		if _, ok := content[want]; ok {
+			return want
+		}
+	}
+	return ""
+}
+
+func isJSON(ct string) bool {
+	return ct == "application/json" || strings.HasSuffix(ct, "+json")
+}
+
+func schemaTypes(schema map[string]any) map[string]bool {
+	types := map[string]bool{}
+	switch t := schema["type"].(type) {
+	case string:
+		types[t] = true
+	case []any:
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types[s] = true
+			}
+		}
+	}
+	if nullable, _ := schema["nullable"].(bool); nullable {
+		types["null"] = true
+	}
+	return types
+}
+
+// validateValue checks a decoded JSON value against a schema, returning a
+// problem per violation prefixed with where it occurred
+func (s *Spec) validateValue(schema any, v any, where string) []string {
+	sm, ok := s.Resolve(schema).(map[string]any)
+	if !ok {
+		return nil
+	}
+	var problems []string
+	for _, sub := range asList(sm["allOf"]) {
+		problems = append(problems, s.validateValue(sub, v, where)...)
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		alts := asList(sm[key])
+		if len(alts) == 0 {
+			continue
+		}
+		matched := 0
+		for _, sub := range alts {
+			if len(s.validateValue(sub, v, where)) == 0 {
+				matched++
+			}
+		}
+		switch {
+		case key == "oneOf" && matched != 1:
+			problems = append(problems, fmt.Sprintf("%s must match exactly one of %d schemas", where, len(alts)))
+		case matched == 0:
+			problems = append(problems, fmt.Sprintf("%s must match at least one of %d schemas", where, len(alts)))
+		}
+	}
+
+	types := schemaTypes(sm)
+	if v == nil {
+		if len(types) > 0 && !types["null"] {
+			problems = append(problems, where+" must not be null")
+		}
+		return problems
+	}
+	if len(types) > 0 && !typeMatches(types, v) {
+		return append(problems, fmt.Sprintf("%s must be of type %s", where, strings.Join(sortedKeys(types), " or ")))
+	}
+	if enum := asList(sm["enum"]); len(enum) > 0 {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(normalizeJSON(e), v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, where+" must be one of the enumerated values")
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := float64(len([]rune(v)))
+		if min, ok := number(sm["minLength"]); ok && n < min {
+			problems = append(problems, fmt.Sprintf("%s must be at least %v characters", where, min))
+		}
+		if max, ok := number(sm["maxLength"]); ok && n > max {
+			problems = append(problems, fmt.Sprintf("%s must be at most %v characters", where, max))
+		}
+		if p, ok := sm["pattern"].(string); ok {
+			if re, err := regexp.Compile(p); err == nil && !re.MatchString(v) {
+				problems = append(problems, fmt.Sprintf("%s must match pattern %s", where, p))
+			}
+		}
+	case float64:
+		problems = append(problems, checkRange(sm, v, where)...)
+	case []any:
+		if min, ok := number(sm["minItems"]); ok && float64(len(v)) < min {
+			problems = append(problems, fmt.Sprintf("%s must have at least %v items", where, min))
+		}
+		if max, ok := number(sm["maxItems"]); ok && float64(len(v)) > max {
+			problems = append(problems, fmt.Sprintf("%s must have at most %v items", where, max))
+		}
+		if items := sm["items"]; items != nil {
+			for i, item := range v {
+				problems = append(problems, s.validateValue(items, item, where+"["+strconv.Itoa(i)+"]")...)
+			}
+		}
+	case map[string]any:
+		for _, name := range asList(sm["required"]) {
+			if n, ok := name.(string); ok {
+				if _, present := v[n]; !present {
+					problems = append(problems, where+"."+n+" is required")
+				}
+			}
+		}
+		props, _ := sm["properties"].(map[string]any)
+		for _, name := range sortedKeys(v) {
+			if ps, ok := props[name]; ok {
+				problems = append(problems, s.validateValue(ps, v[name], where+"."+name)...)
+				continue
+			}
+			switch extra := sm["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					problems = append(problems, where+"."+name+" is not allowed")
+				}
+			case map[string]any:
+				problems = append(problems, s.validateValue(extra, v[name], where+"."+name)...)
+			}
+		}
+	}
+	return problems
+}
+
+func typeMatches(types map[string]bool, v any) bool {
+	switch v := v.(type) {
+	case string:
+		return types["string"]
+	case bool:
+		return types["boolean"]
+	case float64:
+		return types["number"] || (types["integer"] && v == math.Trunc(v))
+	case []any:
+		return types["array"]
+	case map[string]any:
+		return types["object"]
+	}
+	return false
+}
+
+// checkRange applies minimum and maximum, with exclusiveMinimum and
+// exclusiveMaximum as OpenAPI 3.0 booleans or 3.1 bounds
+func checkRange(sm map[string]any, v float64, where string) []string {
+	var problems []string
+	if min, ok := number(sm["minimum"]); ok {
+		if excl, _ := sm["exclusiveMinimum"].(bool); excl && v <= min {
+			problems = append(problems, fmt.Sprintf("%s must be greater than %v", where, min))
+		} else if v < min {
+			problems = append(problems, fmt.Sprintf("%s must be at least %v", where, min))
+		}
+	}
+	if max, ok := number(sm["maximum"]); ok {
+		if excl, _ := sm["exclusiveMaximum"].(bool); excl && v >= max {
+			problems = append(problems, fmt.Sprintf("%s must be less than %v", where, max))
+		} else if v > max {
+			problems = append(problems, fmt.Sprintf("%s must be at most %v", where, max))
+		}
+	}
+	if min, ok := number(sm["exclusiveMinimum"]); ok && v <= min {
+		problems = append(problems, fmt.Sprintf("%s must be greater than %v", where, min))
+	}
+	if max, ok := number(sm["exclusiveMaximum"]); ok && v >= max {
+		problems = append(problems, fmt.Sprintf("%s must be less than %v", where, max))
+	}
+	if mult, ok := number(sm["multipleOf"]); ok && mult > 0 {
+		if q := v / mult; math.Abs(q-math.Round(q)) > 1e-9 {
+			problems = append(problems, fmt.Sprintf("%s must be a multiple of %v", where, mult))
+		}
+	}
+	return problems
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func asList(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+// normalizeJSON converts values decoded from YAML to their JSON equivalents
+func normalizeJSON(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	json.Unmarshal(b, &out)
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/NextChapterSoftware/chissl/share/cio"
 	"github.com/NextChapterSoftware/chissl/share/cnet"
 	"github.com/NextChapterSoftware/chissl/share/database"
+	"github.com/NextChapterSoftware/chissl/share/policy"
 	"github.com/NextChapterSoftware/chissl/share/settings"
 	"github.com/NextChapterSoftware/chissl/share/tunnel"
 	"github.com/gorilla/websocket"
@@ -78,6 +79,7 @@ type SecurityConfig struct {
 	LoginBackoff      LoginBackoffConfig `json:"login_backoff"`
 	IPRate            IPRateLimitConfig  `json:"ip_rate"`
 	SessionTTLMinutes int                `json:"session_ttl_minutes"`
+	MFA               MFAConfig          `json:"mfa"`
 }
 
 // Server respresent a chisel service
@@ -105,6 +107,9 @@ type Server struct {
 	// in-memory live tunnels when DB is not used
 	liveMu      sync.RWMutex
 	liveTunnels map[string]*database.Tunnel
+	// live tunnel remotes by capture ID, for replaying captured requests
+	replayMu      sync.RWMutex
+	replayTargets map[string]replayTarget
 	// server-side blocklist of deleted tunnel IDs (visibility override)
 	deletedTunnelIDs map[string]struct{}
 	// login backoff state (per-username and optional per-IP)
@@ -117,6 +122,17 @@ type Server struct {
 	// security events (in-memory ring buffer)
 	securityMu     sync.Mutex
 	securityEvents []SecurityEvent
+	// pending second-factor login challenges keyed by cookie token
+	mfaMu         sync.Mutex
+	mfaChallenges map[string]*mfaChallenge
+	// generic OIDC relying party, rebuilt when its SSO config changes
+	oidcMu       sync.Mutex
+	oidcProvider *auth.OIDCProvider
+	oidcVersion  time.Time
+	// role-based authorization engine
+	policy *policy.Engine
+	// serializes appends to the audit log hash chain
+	auditMu sync.Mutex
 }
 
 // loginBackoff tracks failed login attempts for backoff/lockout
@@ -158,6 +174,8 @@ func NewServer(c *Config) (*Server, error) {
 		sessions:      settings.NewUsers(),
 		startTime:     time.Now(),
 		loginBackoffs: make(map[string]*loginBackoff),
+		mfaChallenges: make(map[string]*mfaChallenge),
+		policy:        policy.NewEngine(),
 	}
 	// initialize per-IP backoff state map
 	server.ipBackoffs = make(map[string]map[string]*loginBackoff)
@@ -229,6 +247,7 @@ func NewServer(c *Config) (*Server, error) {
 	server.Info = true
 	server.users = settings.NewUserIndex(server.Logger)
 	server.liveTunnels = make(map[string]*database.Tunnel)
+	server.replayTargets = make(map[string]replayTarget)
 	// Initialize capture for dashboard if enabled
 	if c.Dashboard.Enabled {
 		// defaults: last 500 events, 64KB per event
@@ -271,6 +290,10 @@ func NewServer(c *Config) (*Server, error) {
 		if err := server.db.MarkStaleListenersClosed(0); err != nil {
 			server.Debugf("Failed to reset stale listeners on startup: %v", err)
 		}
+		// Load persisted MFA policy
+		if v, err := server.db.GetSettingBool("security_mfa_required", server.config.Security.MFA.Required); err == nil {
+			server.config.Security.MFA.Required = v
+		}
 	}
 	// Initialize capture service early (default persist on)
 	if c.Dashboard.Enabled {
@@ -289,6 +312,22 @@ func NewServer(c *Config) (*Server, error) {
 					server.Debugf("AddTunnelConnections failed: %v", err)
 				}
 			})
+			server.loadCaptureRedaction()
+			server.loadCapturePolicies()
+			server.loadCaptureSinks()
+		}
+		switch server.capture.StoreKind() {
+		case capture.StoreDatabase:
+			if server.db != nil {
+				server.capture.SetStore(capture.NewDatabaseStore(server.db))
+				server.Infof("Capture events are stored in the database")
+			} else {
+				server.Infof("Capture store %q requires a database; captures will not be persisted", capture.StoreDatabase)
+			}
+		case capture.StoreS3:
+			if !server.capture.Persisting() {
+				server.Infof("Capture store %q needs CHISEL_CAPTURE_S3_ENDPOINT and CHISEL_CAPTURE_S3_BUCKET; captures will not be persisted", capture.StoreS3)
+			}
 		}
 		// Initialize listener manager (TLS config will be set later in StartContext)
 		server.listeners = NewListenerManager(server.capture, server.db, nil)
@@ -466,7 +505,7 @@ func (s *Server) Close() error {
 	return s.httpServer.Close()
 }
 
-// Shutdown gracefully closes the HTTP server, database, and log manager
+// Shutdown gracefully closes the HTTP server, capture store, database, and log manager
 func (s *Server) Shutdown() error {
 	var firstErr error
 	if s.httpServer != nil {
@@ -474,6 +513,12 @@ func (s *Server) Shutdown() error {
 			firstErr = err
 		}
 	}
+	// Flush queued capture events before the database they may be stored in closes
+	if s.capture != nil {
+		if err := s.capture.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 	if s.db != nil {
 		if err := s.db.Close(); err != nil && firstErr == nil {
 			firstErr = err
@@ -511,7 +556,7 @@ func (s *Server) authUser(c ssh.ConnMetadata, password []byte) (*ssh.Permissions
 	// 2) Check database (password or token)
 	if s.db != nil {
 		if dbUser, err := s.db.GetUser(n); err == nil {
-			if dbUser != nil && dbUser.Password == p {
+			if dbUser != nil && dbUser.Password == p && !dbUser.Disabled {
 				u := &settings.User{Name: dbUser.Username, Pass: dbUser.Password, IsAdmin: dbUser.IsAdmin, Addrs: []*regexp.Regexp{settings.UserAllowAll}}
 				s.sessions.Set(string(c.SessionID()), u)
 				return nil, nil
@@ -520,7 +565,7 @@ func (s *Server) authUser(c ssh.ConnMetadata, password []byte) (*ssh.Permissions
 
 		// Try token authentication
 		if userToken, err := s.db.ValidateUserToken(p); err == nil {
-			if dbUser, err := s.db.GetUser(userToken.Username); err == nil {
+			if dbUser, err := s.db.GetUser(userToken.Username); err == nil && !dbUser.Disabled {
 				u := &settings.User{Name: dbUser.Username, Pass: "", IsAdmin: dbUser.IsAdmin, Addrs: []*regexp.Regexp{settings.UserAllowAll}}
 				s.sessions.Set(string(c.SessionID()), u)
 				return nil, nil
@@ -1,17 +1,24 @@
 package chserver
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/NextChapterSoftware/chissl/server/capture"
 	"github.com/NextChapterSoftware/chissl/share/database"
 )
 
-// SSE: live traffic for a given tunnel or listener
+// GET /api/capture/{tunnels|listeners|multicast}/{id}/stream?type=&conn=&path=
+// Streams an entity's capture events as SSE with their stream IDs. Clients
+// resuming with Last-Event-ID (or last_event_id) first receive what they
+// missed from the ring or the store; events that could not be recovered are
+// reported by a "dropped" event.
 func (s *Server) handleSSEStream(w http.ResponseWriter, r *http.Request) {
 	entityID, entityType := getEntityIDFromPath(r.URL.Path)
 	if entityID == "" {
@@ -22,29 +29,90 @@ func (s *Server) handleSSEStream(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering
-	w.WriteHeader(http.StatusOK)
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	filter := &capture.StreamFilter{Conns: queryList(q, "conn"), Paths: queryList(q, "path")}
+	for _, t := range queryList(q, "type") {
+		filter.Types = append(filter.Types, capture.EventType(t))
+	}
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = q.Get("last_event_id")
+	}
+	var lastID uint64
+	if resume != "" {
+		id, err := strconv.ParseUint(resume, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering
+	w.WriteHeader(http.StatusOK)
 
+	// Subscribe before reading the backlog so that nothing falls in between
 	ch := s.capture.Subscribe(entityID)
 	defer s.capture.Unsubscribe(entityID, ch)
+	if resume == "" {
+		lastID = s.capture.LastEventID(entityID)
+	}
+	send := func(e capture.Event) {
+		lastID = e.ID
+		if !filter.Match(e) {
+			return
+		}
+		b, _ := json.Marshal(e)
+		fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, b)
+	}
+	// catchUp sends the events after lastID, reporting those that are lost
+	catchUp := func() {
+		from := lastID
+		events, missed := s.capture.EventsSince(entityID, lastID)
+		for _, e := range events {
+			send(e)
+		}
+		if missed > 0 {
+			until := s.capture.LastEventID(entityID)
+			lastID = max(lastID, until)
+			b, _ := json.Marshal(map[string]uint64{"count": missed, "after": from, "until": until})
+			fmt.Fprintf(w, "event: dropped\nid: %d\ndata: %s\n\n", lastID, b)
+		}
+	}
+
 	// Send a comment to keep the connection alive
-	fmt.Fprintf(w, ": heartbeat\n\n")
+	fmt.Fprintf(w, "retry: 3000\n: heartbeat\n\n")
+	if resume != "" {
+		catchUp()
+	}
 	flusher.Flush()
 	for {
 		select {
 		case <-r.Context().Done():
 			return
 		case e := <-ch:
-			b, _ := json.Marshal(e)
-			fmt.Fprintf(w, "data: %s\n\n", b)
+			switch {
+			case e.ID <= lastID:
+				// already sent while catching up
+				continue
+			case e.ID > lastID+1:
+				// this subscriber fell behind and missed events
+				catchUp()
+			}
+			if e.ID > lastID {
+				send(e)
+			}
 			flusher.Flush()
 		case <-time.After(15 * time.Second):
 			fmt.Fprintf(w, ": ping\n\n")
@@ -103,12 +171,149 @@ func (s *Server) handleDownloadCaptureLog(w http.ResponseWriter, r *http.Request
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
-	p, err := s.capture.LatestLogFile(tunnelID, connID)
-	if err != nil {
+	if p, err := s.capture.LatestLogFile(tunnelID, connID); err == nil {
+		http.ServeFile(w, r, p)
+		return
+	}
+	// Stores without files serve the connection's events as JSON lines
+	var events []capture.Event
+	for _, e := range s.capture.Events(tunnelID) {
+		if e.ConnID == connID {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
 		http.Error(w, "Log not found", http.StatusNotFound)
 		return
 	}
-	http.ServeFile(w, r, p)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", connID+".log"))
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		enc.Encode(e)
+	}
+}
+
+// Export captured HTTP exchanges of a tunnel, listener or multicast tunnel as HAR 1.2.
+// Optional filters: since/until (RFC3339) and conn (connection IDs, repeated or comma separated).
+func (s *Server) handleExportHAR(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	if !s.userHasEntityAccess(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	since, until, conns, err := captureExportFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	har := capture.BuildHAR(s.capture.Events(entityID), capture.HAROptions{Since: since, Until: until, ConnIDs: conns})
+	filename := fmt.Sprintf("%s-%s.har", entityID, time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	json.NewEncoder(w).Encode(har)
+}
+
+// Export captured connections of a tunnel, listener or multicast tunnel as
+// synthetic TCP flows in pcapng format. Takes the same filters as the HAR export.
+func (s *Server) handleExportPCAP(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	if !s.userHasEntityAccess(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	since, until, conns, err := captureExportFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var buf bytes.Buffer
+	if err := capture.WritePCAPNG(&buf, s.capture.Events(entityID), capture.PCAPOptions{Since: since, Until: until, ConnIDs: conns, Name: entityID}); err != nil {
+		http.Error(w, "Failed to build capture", http.StatusInternalServerError)
+		return
+	}
+	filename := fmt.Sprintf("%s-%s.pcapng", entityID, time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(buf.Bytes())
+}
+
+// Latency breakdown of the captured exchanges of a tunnel, listener or
+// multicast tunnel, with histograms by phase. Takes the HAR export filters
+// and limit, which caps the exchanges listed but not the histograms.
+func (s *Server) handleCaptureTimings(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	if !s.userHasEntityAccess(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	since, until, conns, err := captureExportFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	report := capture.BuildTimings(s.capture.Events(entityID), capture.TimingOptions{Since: since, Until: until, ConnIDs: conns, Limit: limit})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// captureExportFilters reads the since/until and conn filters of the capture exports
+func captureExportFilters(r *http.Request) (since, until time.Time, conns []string, err error) {
+	q := r.URL.Query()
+	for key, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+		if v := q.Get(key); v != "" {
+			t, perr := time.Parse(time.RFC3339, v)
+			if perr != nil {
+				return since, until, nil, fmt.Errorf("Invalid %s: expected RFC3339 time", key)
+			}
+			*dst = t
+		}
+	}
+	return since, until, queryList(q, "conn"), nil
+}
+
+// queryList reads a query parameter given repeatedly or comma separated
+func queryList(q url.Values, key string) []string {
+	var out []string
+	for _, v := range q[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
 }
 
 // Extended API endpoints for dashboard and monitoring
@@ -261,13 +466,13 @@ func (s *Server) userHasTunnelAccess(r *http.Request, tunnelID string) bool {
 		return true
 	}
 
-	// Check if tunnel belongs to user
+	// Otherwise the capture policy decides based on the tunnel owner
 	if s.db != nil {
 		tunnel, err := s.db.GetTunnel(tunnelID)
 		if err != nil {
 			return false
 		}
-		return tunnel.Username == username
+		return s.canViewCaptures(r, tunnel.Username)
 	}
 
 	return false
@@ -434,6 +639,98 @@ func getEntityIDFromPath(path string) (string, string) {
 	return "", ""
 }
 
+// GET /api/capture/search?q=...&limit=&offset=
+// Searches captured exchanges across every tunnel, listener and multicast
+// tunnel the user can access, newest first.
+func (s *Server) handleSearchCaptures(w http.ResponseWriter, r *http.Request) {
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	query, err := capture.ParseSearchQuery(q.Get("q"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.Limit = 50
+	for key, dst := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if v := q.Get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("Invalid %s", key), http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+	switch {
+	case query.Limit == 0:
+		query.Limit = 50
+	case query.Limit > 500:
+		query.Limit = 500
+	}
+
+	hits, total := s.capture.Search(query, s.captureAccessFilter(r))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":   q.Get("q"),
+		"total":   total,
+		"limit":   query.Limit,
+		"offset":  query.Offset,
+		"results": hits,
+	})
+}
+
+// captureAccessFilter reports whether the user may read an entity's
+// captures, whatever its type; nil allows every entity
+func (s *Server) captureAccessFilter(r *http.Request) func(entityID string) bool {
+	if s.isUserAdmin(r.Context()) {
+		return nil
+	}
+	return func(entityID string) bool {
+		for _, entityType := range []string{"tunnel", "listener", "multicast"} {
+			if s.userHasEntityAccess(r, entityID, entityType) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// GET /api/capture/diff?a_entity=&a_conn=&a_seq=&b_entity=&b_conn=&b_seq=
+// Compares two captured exchanges of any tunnels, listeners or multicast
+// tunnels the user can access.
+func (s *Server) handleDiffCaptures(w http.ResponseWriter, r *http.Request) {
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	allow := s.captureAccessFilter(r)
+	var exchanges [2]*capture.Exchange
+	for i, side := range []string{"a", "b"} {
+		entityID, connID := q.Get(side+"_entity"), q.Get(side+"_conn")
+		seq, err := strconv.ParseInt(q.Get(side+"_seq"), 10, 64)
+		if entityID == "" || connID == "" || err != nil || seq <= 0 {
+			http.Error(w, fmt.Sprintf("%[1]s_entity, %[1]s_conn and %[1]s_seq are required", side), http.StatusBadRequest)
+			return
+		}
+		if allow != nil && !allow(entityID) {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		x := capture.FindExchange(s.capture.Events(entityID), connID, seq)
+		if x == nil || x.Request == nil {
+			http.Error(w, fmt.Sprintf("Captured exchange %s not found", side), http.StatusNotFound)
+			return
+		}
+		exchanges[i] = x
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(capture.DiffExchanges(exchanges[0], exchanges[1]))
+}
+
 // Helper function to extract tunnel ID from URL path (backward compatibility)
 func getTunnelIDFromPath(path string) string {
 	// Extract tunnel ID from supported paths:
@@ -511,6 +808,33 @@ func (s *Server) userHasEntityAccess(r *http.Request, entityID, entityType strin
 	return false
 }
 
+// userOwnsEntity checks if user may change a tunnel, listener or multicast
+// tunnel: admins and the owner may, users who can only view it may not
+func (s *Server) userOwnsEntity(r *http.Request, entityID, entityType string) bool {
+	username := s.getCurrentUsername(r)
+	if username == "" {
+		return false
+	}
+	if s.isUserAdmin(r.Context()) {
+		return true
+	}
+	if s.db == nil {
+		return false
+	}
+	switch entityType {
+	case "tunnel":
+		tunnel, err := s.db.GetTunnel(entityID)
+		return err == nil && tunnel.Username == username
+	case "listener":
+		listener, err := s.db.GetListener(entityID)
+		return err == nil && listener.Username == username
+	case "multicast":
+		mt, err := s.db.GetMulticastTunnel(entityID)
+		return err == nil && mt.Owner == username
+	}
+	return false
+}
+
 // userHasListenerAccess checks if user has access to a specific listener
 func (s *Server) userHasListenerAccess(r *http.Request, listenerID string) bool {
 	if s.db == nil {
@@ -527,12 +851,12 @@ func (s *Server) userHasListenerAccess(r *http.Request, listenerID string) bool
 		return true
 	}
 
-	// Check if user owns the listener
+	// Otherwise the capture policy decides based on the listener owner
 	listener, err := s.db.GetListener(listenerID)
 	if err != nil {
 		return false
 	}
-	return listener.Username == username
+	return s.canViewCaptures(r, listener.Username)
 }
 
 // userHasMulticastAccess checks if user can see a multicast tunnel
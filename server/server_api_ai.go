@@ -11,6 +11,7 @@ import (
 
 	"github.com/NextChapterSoftware/chissl/server/capture"
 	"github.com/NextChapterSoftware/chissl/share/database"
+	"github.com/NextChapterSoftware/chissl/share/policy"
 	"github.com/NextChapterSoftware/chissl/share/settings"
 	"github.com/NextChapterSoftware/chissl/share/tunnel"
 	"github.com/google/uuid"
@@ -413,7 +414,7 @@ func (s *Server) isRequestFromAdmin(r *http.Request) bool {
 		// Check database users
 		if s.db != nil {
 			if user, err := s.db.GetUser(username); err == nil {
-				return user.IsAdmin
+				return !user.Disabled && s.isAdminUser(username)
 			}
 		}
 	}
@@ -436,7 +437,7 @@ func (s *Server) isRequestFromAdmin(r *http.Request) bool {
 	if s.db != nil {
 		if user, err := s.db.GetUser(username); err == nil {
 			if user.Password == password {
-				return user.IsAdmin
+				return !user.Disabled && s.isAdminUser(username)
 			}
 		}
 	}
@@ -607,6 +608,12 @@ func (s *Server) handleCreateAIListener(w http.ResponseWriter, r *http.Request)
 		username = "admin" // fallback
 	}
 
+	// Check the role policy for AI mock listeners on this port
+	if d := s.authorize(username, policy.ActionListenerCreate, policy.Resource{Port: req.Port, Mode: "ai-mock"}); !d.Allowed {
+		http.Error(w, d.Reason, http.StatusForbidden)
+		return
+	}
+
 	// Create the regular listener first
 	listener := &database.Listener{
 		ID:       uuid.New().String(), // Generate unique ID
@@ -0,0 +1,65 @@
+package chserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/NextChapterSoftware/chissl/server/chaos"
+)
+
+// Fault injection of proxy listeners. The configuration is stored on the
+// listener as JSON and swapped into a running listener when updated.
+
+// compileChaos validates a configuration, returning it in its stored form
+func compileChaos(cfg chaos.Config) (*chaos.Injector, string, error) {
+	injector, err := chaos.New(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	b, _ := json.Marshal(injector.Config())
+	return injector, string(b), nil
+}
+
+// GET /api/listener/{id}/chaos
+func (s *Server) handleGetChaos(w http.ResponseWriter, r *http.Request) {
+	listener := s.modeListener(w, r, "proxy")
+	if listener == nil {
+		return
+	}
+	cfg, err := chaos.Parse(listener.Chaos)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+// PUT /api/listener/{id}/chaos {enabled, seed, rules: [{name, disabled, method, path, probability, latency_ms, jitter_ms, error_status, error_body, drop, drop_after_bytes, bandwidth_bps, corrupt_rate}]}
+func (s *Server) handleUpdateChaos(w http.ResponseWriter, r *http.Request) {
+	listener := s.modeListener(w, r, "proxy")
+	if listener == nil {
+		return
+	}
+	var cfg chaos.Config
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	injector, stored, err := compileChaos(cfg)
+	if err != nil {
+		http.Error(w, "Invalid chaos configuration: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	listener.Chaos = stored
+	if err := s.db.UpdateListener(listener); err != nil {
+		s.Debugf("Failed to update chaos configuration: %v", err)
+		http.Error(w, "Failed to save chaos configuration", http.StatusInternalServerError)
+		return
+	}
+	if s.listeners != nil {
+		s.listeners.UpdateChaos(listener.ID, injector)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(injector.Config())
+}
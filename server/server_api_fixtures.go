@@ -0,0 +1,123 @@
+package chserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/server/fixture"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+// Fixtures of record and replay listeners. Record listeners add one per
+// proxied exchange, keeping the latest of each match key; the export of one
+// can be imported into another, usually a replay listener, which serves them.
+
+// GET /api/listener/{id}/fixtures
+func (s *Server) handleExportFixtures(w http.ResponseWriter, r *http.Request) {
+	listener := s.modeListener(w, r, "record", "replay")
+	if listener == nil {
+		return
+	}
+	records, err := s.db.ListListenerFixtures(listener.ID)
+	if err != nil {
+		s.Debugf("Failed to list fixtures: %v", err)
+		http.Error(w, "Failed to list fixtures", http.StatusInternalServerError)
+		return
+	}
+	doc := fixture.Document{MatchKeys: fixture.SplitKeys(listener.MatchKeys), Fixtures: make([]*fixture.Fixture, 0, len(records))}
+	for _, rec := range records {
+		var f fixture.Fixture
+		if err := json.Unmarshal([]byte(rec.Data), &f); err != nil {
+			s.Debugf("Skipping invalid fixture %d: %v", rec.ID, err)
+			continue
+		}
+		doc.Fixtures = append(doc.Fixtures, &f)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("download") == "true" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+listener.ID+`-fixtures.json"`)
+	}
+	json.NewEncoder(w).Encode(doc)
+}
+
+// POST /api/listener/{id}/fixtures[?replace=true] {fixtures: [...]}
+// The document's match_keys are ignored: the listener's own decide matching.
+func (s *Server) handleImportFixtures(w http.ResponseWriter, r *http.Request) {
+	listener := s.modeListener(w, r, "record", "replay")
+	if listener == nil {
+		return
+	}
+	var doc fixture.Document
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(doc.Fixtures) > maxListenerFixtures {
+		http.Error(w, fmt.Sprintf("A listener holds at most %d fixtures", maxListenerFixtures), http.StatusBadRequest)
+		return
+	}
+	records := make([]*database.ListenerFixtureRecord, 0, len(doc.Fixtures))
+	for _, f := range doc.Fixtures {
+		if f == nil {
+			continue
+		}
+		if err := f.Validate(); err != nil {
+			http.Error(w, "Invalid fixture: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if f.RecordedAt.IsZero() {
+			f.RecordedAt = time.Now().UTC()
+		}
+		data, _ := json.Marshal(f)
+		records = append(records, &database.ListenerFixtureRecord{ListenerID: listener.ID, At: f.RecordedAt, Data: string(data)})
+	}
+	if r.URL.Query().Get("replace") == "true" {
+		if _, err := s.db.DeleteListenerFixtures(listener.ID); err != nil {
+			s.Debugf("Failed to clear fixtures: %v", err)
+			http.Error(w, "Failed to clear fixtures", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.db.AddListenerFixtures(records); err != nil {
+		s.Debugf("Failed to import fixtures: %v", err)
+		http.Error(w, "Failed to import fixtures", http.StatusInternalServerError)
+		return
+	}
+	// Appending past the limit drops the oldest fixtures
+	if _, err := s.db.TrimListenerFixtures(listener.ID, "", 0, maxListenerFixtures); err != nil {
+		s.Debugf("Failed to trim fixtures: %v", err)
+	}
+	s.reloadFixtures(listener)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "imported": len(records), "match_keys": fixture.SplitKeys(listener.MatchKeys)})
+}
+
+// DELETE /api/listener/{id}/fixtures
+func (s *Server) handleDeleteFixtures(w http.ResponseWriter, r *http.Request) {
+	listener := s.modeListener(w, r, "record", "replay")
+	if listener == nil {
+		return
+	}
+	n, err := s.db.DeleteListenerFixtures(listener.ID)
+	if err != nil {
+		s.Debugf("Failed to delete fixtures: %v", err)
+		http.Error(w, "Failed to delete fixtures", http.StatusInternalServerError)
+		return
+	}
+	s.reloadFixtures(listener)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "deleted": n})
+}
+
+// reloadFixtures refreshes a running replay listener after its fixtures or
+// match keys changed
+func (s *Server) reloadFixtures(listener *database.Listener) {
+	if s.listeners == nil {
+		return
+	}
+	if err := s.listeners.ReloadFixtures(listener); err != nil {
+		s.Debugf("Failed to reload fixtures: %v", err)
+	}
+}
@@ -4,11 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/NextChapterSoftware/chissl/server/capture"
+	"github.com/NextChapterSoftware/chissl/server/chaos"
+	"github.com/NextChapterSoftware/chissl/server/fixture"
+	"github.com/NextChapterSoftware/chissl/server/mock"
+	"github.com/NextChapterSoftware/chissl/server/openapi"
 	"github.com/NextChapterSoftware/chissl/share/database"
+	"github.com/NextChapterSoftware/chissl/share/policy"
 	"github.com/NextChapterSoftware/chissl/share/tunnel"
 )
 
@@ -44,12 +50,16 @@ func (s *Server) handleCreateListener(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Name      string `json:"name"` // human-friendly name
-		Port      int    `json:"port"`
-		Mode      string `json:"mode"` // "sink" or "proxy"
-		TargetURL string `json:"target_url,omitempty"`
-		Response  string `json:"response,omitempty"`
-		UseTLS    bool   `json:"use_tls"` // whether to use TLS
+		Name        string        `json:"name"` // human-friendly name
+		Port        int           `json:"port"`
+		Mode        string        `json:"mode"` // "sink", "proxy", "mock", "openapi", "record" or "replay"
+		TargetURL   string        `json:"target_url,omitempty"`
+		Response    string        `json:"response,omitempty"`
+		Rules       []mock.Rule   `json:"rules,omitempty"`        // for mock mode
+		OpenAPISpec string        `json:"openapi_spec,omitempty"` // for openapi mode, JSON or YAML
+		MatchKeys   []string      `json:"match_keys,omitempty"`   // for replay mode: method, path, query, body
+		Chaos       *chaos.Config `json:"chaos,omitempty"`        // for proxy mode
+		UseTLS      bool          `json:"use_tls"`                // whether to use TLS
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -63,13 +73,48 @@ func (s *Server) handleCreateListener(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Mode != "sink" && req.Mode != "proxy" {
-		http.Error(w, "Mode must be 'sink' or 'proxy'", http.StatusBadRequest)
+	switch req.Mode {
+	case "sink", "proxy", "mock", "openapi", "record", "replay":
+	default:
+		http.Error(w, "Mode must be 'sink', 'proxy', 'mock', 'openapi', 'record' or 'replay'", http.StatusBadRequest)
+		return
+	}
+
+	var mockRules string
+	if req.Mode == "mock" {
+		_, stored, err := compileMockRules(req.Rules)
+		if err != nil {
+			http.Error(w, "Invalid mock rules: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		mockRules = stored
+	}
+
+	if req.Mode == "openapi" {
+		if _, err := openapi.Parse(req.OpenAPISpec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if (req.Mode == "proxy" || req.Mode == "record") && req.TargetURL == "" {
+		http.Error(w, "Target URL required for "+req.Mode+" mode", http.StatusBadRequest)
 		return
 	}
 
-	if req.Mode == "proxy" && req.TargetURL == "" {
-		http.Error(w, "Target URL required for proxy mode", http.StatusBadRequest)
+	var chaosConfig string
+	if req.Mode == "proxy" && req.Chaos != nil {
+		_, stored, err := compileChaos(*req.Chaos)
+		if err != nil {
+			http.Error(w, "Invalid chaos configuration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		chaosConfig = stored
+	}
+
+	matchKeys, err := fixture.ParseKeys(req.MatchKeys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -80,6 +125,12 @@ func (s *Server) handleCreateListener(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check the role policy for this mode and port
+	if d := s.authorize(username, policy.ActionListenerCreate, policy.Resource{Port: req.Port, Mode: req.Mode}); !d.Allowed {
+		http.Error(w, d.Reason, http.StatusForbidden)
+		return
+	}
+
 	// Check if user can use this port
 	if available, errMsg := s.isPortAvailableForUser(req.Port, username); !available {
 		http.Error(w, errMsg, http.StatusForbidden)
@@ -95,9 +146,17 @@ func (s *Server) handleCreateListener(w http.ResponseWriter, r *http.Request) {
 		Mode:      req.Mode,
 		TargetURL: strings.TrimSpace(req.TargetURL), // Trim whitespace
 		Response:  strings.TrimSpace(req.Response),  // Trim whitespace
+		MockRules: mockRules,
+		Chaos:     chaosConfig,
 		UseTLS:    req.UseTLS,
 		Status:    "closed", // Will be set to "open" when started
 	}
+	if req.Mode == "openapi" {
+		listener.OpenAPISpec = req.OpenAPISpec
+	}
+	if req.Mode == "record" || req.Mode == "replay" {
+		listener.MatchKeys = strings.Join(matchKeys, ",")
+	}
 
 	if err := s.db.CreateListener(listener); err != nil {
 		s.Debugf("Failed to create listener: %v", err)
@@ -182,6 +241,10 @@ func (s *Server) handleUpdateListener(w http.ResponseWriter, r *http.Request) {
 		Response  string `json:"response,omitempty"`
 		Status    string `json:"status,omitempty"`
 		UseTLS    *bool  `json:"use_tls,omitempty"` // pointer to distinguish between false and not set
+		// OpenAPISpec replaces the document of an openapi listener
+		OpenAPISpec string `json:"openapi_spec,omitempty"`
+		// MatchKeys changes how a replay listener matches requests to fixtures
+		MatchKeys []string `json:"match_keys,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -218,12 +281,42 @@ func (s *Server) handleUpdateListener(w http.ResponseWriter, r *http.Request) {
 	if req.UseTLS != nil {
 		listener.UseTLS = *req.UseTLS
 	}
+	var spec *openapi.Spec
+	if req.OpenAPISpec != "" {
+		if listener.Mode != "openapi" {
+			http.Error(w, "openapi_spec is only valid for openapi listeners", http.StatusBadRequest)
+			return
+		}
+		if spec, err = openapi.Parse(req.OpenAPISpec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		listener.OpenAPISpec = req.OpenAPISpec
+	}
+	if req.MatchKeys != nil {
+		if listener.Mode != "record" && listener.Mode != "replay" {
+			http.Error(w, "match_keys is only valid for record and replay listeners", http.StatusBadRequest)
+			return
+		}
+		keys, err := fixture.ParseKeys(req.MatchKeys)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		listener.MatchKeys = strings.Join(keys, ",")
+	}
 
 	if err := s.db.UpdateListener(listener); err != nil {
 		s.Debugf("Failed to update listener: %v", err)
 		http.Error(w, "Failed to update listener", http.StatusInternalServerError)
 		return
 	}
+	if spec != nil && s.listeners != nil {
+		s.listeners.UpdateOpenAPISpec(listener.ID, spec)
+	}
+	if req.MatchKeys != nil {
+		s.reloadFixtures(listener)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(listener)
@@ -270,6 +363,9 @@ func (s *Server) handleDeleteListener(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to delete listener", http.StatusInternalServerError)
 		return
 	}
+	if _, err := s.db.DeleteListenerFixtures(listenerID); err != nil {
+		s.Debugf("Failed to delete fixtures: %v", err)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -315,6 +411,34 @@ func (s *Server) canUserAccessListener(r *http.Request, listener *database.Liste
 	return listener.Username == username
 }
 
+// modeListener loads the listener of a /api/listener/{id}/... path for a
+// handler that only applies to the given modes
+func (s *Server) modeListener(w http.ResponseWriter, r *http.Request, modes ...string) *database.Listener {
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return nil
+	}
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 || parts[3] == "" {
+		http.Error(w, "Invalid listener ID", http.StatusBadRequest)
+		return nil
+	}
+	listener, err := s.db.GetListener(parts[3])
+	if err != nil {
+		http.Error(w, "Listener not found", http.StatusNotFound)
+		return nil
+	}
+	if !s.canUserAccessListener(r, listener) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+	if !slices.Contains(modes, listener.Mode) {
+		http.Error(w, "Listener is not in "+strings.Join(modes, " or ")+" mode", http.StatusBadRequest)
+		return nil
+	}
+	return listener
+}
+
 // getAuthenticatedUsername extracts username from request authentication
 func (s *Server) getAuthenticatedUsername(r *http.Request) string {
 	// Check session cookie first
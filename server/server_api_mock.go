@@ -0,0 +1,70 @@
+package chserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/NextChapterSoftware/chissl/server/mock"
+)
+
+// Rules of mock listeners. They are stored on the listener as JSON and
+// swapped into a running listener when updated.
+
+// compileMockRules validates rules, returning them in their stored form
+func compileMockRules(rules []mock.Rule) (*mock.Router, string, error) {
+	if rules == nil {
+		rules = []mock.Rule{}
+	}
+	router, err := mock.New(rules)
+	if err != nil {
+		return nil, "", err
+	}
+	b, _ := json.Marshal(rules)
+	return router, string(b), nil
+}
+
+// GET /api/listener/{id}/rules
+func (s *Server) handleGetMockRules(w http.ResponseWriter, r *http.Request) {
+	listener := s.modeListener(w, r, "mock")
+	if listener == nil {
+		return
+	}
+	rules, err := mock.Parse(listener.MockRules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"listener_id": listener.ID, "rules": rules})
+}
+
+// PUT /api/listener/{id}/rules {rules: [{name, method, path, query, headers, json, response: {status, headers, body, delay_ms}}]}
+func (s *Server) handleUpdateMockRules(w http.ResponseWriter, r *http.Request) {
+	listener := s.modeListener(w, r, "mock")
+	if listener == nil {
+		return
+	}
+	var req struct {
+		Rules []mock.Rule `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	router, stored, err := compileMockRules(req.Rules)
+	if err != nil {
+		http.Error(w, "Invalid mock rules: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	listener.MockRules = stored
+	if err := s.db.UpdateListener(listener); err != nil {
+		s.Debugf("Failed to update mock rules: %v", err)
+		http.Error(w, "Failed to save mock rules", http.StatusInternalServerError)
+		return
+	}
+	if s.listeners != nil {
+		s.listeners.UpdateMockRules(listener.ID, router)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "rules": router.Rules()})
+}
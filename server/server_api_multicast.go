@@ -8,12 +8,13 @@ import (
 	"time"
 
 	"github.com/NextChapterSoftware/chissl/share/database"
+	"github.com/NextChapterSoftware/chissl/share/policy"
 )
 
-// POST /api/multicast-tunnels (admin)
+// POST /api/multicast-tunnels (multicast:manage)
 func (s *Server) handleCreateMulticastTunnel(w http.ResponseWriter, r *http.Request) {
-	if !s.isUserAdmin(r.Context()) {
-		http.Error(w, "Admin privileges required", http.StatusForbidden)
+	if d := s.authorizeRequest(r, policy.ActionMulticastManage, policy.Resource{}); !d.Allowed {
+		http.Error(w, "Multicast management privileges required", http.StatusForbidden)
 		return
 	}
 	if s.db == nil {
@@ -78,10 +79,10 @@ func (s *Server) handleCreateMulticastTunnel(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(mt)
 }
 
-// GET /api/multicast-tunnels (admin)
+// GET /api/multicast-tunnels (multicast:manage)
 func (s *Server) handleListMulticastTunnels(w http.ResponseWriter, r *http.Request) {
-	if !s.isUserAdmin(r.Context()) {
-		http.Error(w, "Admin privileges required", http.StatusForbidden)
+	if d := s.authorizeRequest(r, policy.ActionMulticastManage, policy.Resource{}); !d.Allowed {
+		http.Error(w, "Multicast management privileges required", http.StatusForbidden)
 		return
 	}
 	if s.db == nil {
@@ -119,8 +120,8 @@ func (s *Server) handleListPublicMulticastTunnels(w http.ResponseWriter, r *http
 
 // PUT /api/multicast-tunnels/{id}
 func (s *Server) handleUpdateMulticastTunnel(w http.ResponseWriter, r *http.Request) {
-	if !s.isUserAdmin(r.Context()) {
-		http.Error(w, "Admin privileges required", http.StatusForbidden)
+	if d := s.authorizeRequest(r, policy.ActionMulticastManage, policy.Resource{}); !d.Allowed {
+		http.Error(w, "Multicast management privileges required", http.StatusForbidden)
 		return
 	}
 	if s.db == nil {
@@ -196,8 +197,8 @@ func (s *Server) handleUpdateMulticastTunnel(w http.ResponseWriter, r *http.Requ
 
 // DELETE /api/multicast-tunnels/{id}
 func (s *Server) handleDeleteMulticastTunnel(w http.ResponseWriter, r *http.Request) {
-	if !s.isUserAdmin(r.Context()) {
-		http.Error(w, "Admin privileges required", http.StatusForbidden)
+	if d := s.authorizeRequest(r, policy.ActionMulticastManage, policy.Resource{}); !d.Allowed {
+		http.Error(w, "Multicast management privileges required", http.StatusForbidden)
 		return
 	}
 	if s.db == nil {
@@ -0,0 +1,337 @@
+package chserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/NextChapterSoftware/chissl/share/database"
+	"github.com/NextChapterSoftware/chissl/share/policy"
+)
+
+// groupResponse is a group with its member usernames
+type groupResponse struct {
+	*database.Group
+	Members []string `json:"members"`
+}
+
+// roleResponse is a role with its decoded rules and bindings
+type roleResponse struct {
+	*database.Role
+	Rules    []policy.Rule           `json:"rules"`
+	Bindings []*database.RoleBinding `json:"bindings"`
+}
+
+// GET /api/groups (admin only)
+func (s *Server) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	groups, err := s.db.ListGroups()
+	if err != nil {
+		http.Error(w, "Failed to list groups", http.StatusInternalServerError)
+		return
+	}
+	out := make([]groupResponse, 0, len(groups))
+	for _, g := range groups {
+		members, err := s.db.ListGroupMembers(g.ID)
+		if err != nil {
+			http.Error(w, "Failed to list group members", http.StatusInternalServerError)
+			return
+		}
+		out = append(out, groupResponse{Group: g, Members: members})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// POST /api/groups and PUT /api/groups/{id} (admin only)
+func (s *Server) handleSaveGroup(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		Name    string   `json:"name"`
+		Members []string `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Group name is required", http.StatusBadRequest)
+		return
+	}
+	for _, username := range req.Members {
+		if _, err := s.db.GetUser(username); err != nil {
+			http.Error(w, fmt.Sprintf("Unknown user: %s", username), http.StatusBadRequest)
+			return
+		}
+	}
+
+	existing, err := s.db.GetGroupByName(req.Name)
+	if err != nil {
+		http.Error(w, "Failed to check group name", http.StatusInternalServerError)
+		return
+	}
+
+	var group *database.Group
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		if existing != nil {
+			http.Error(w, "Group already exists", http.StatusConflict)
+			return
+		}
+		group = &database.Group{Name: req.Name}
+		if err := s.db.CreateGroup(group); err != nil {
+			http.Error(w, "Failed to create group", http.StatusInternalServerError)
+			return
+		}
+		status = http.StatusCreated
+	} else {
+		id := getIDFromPath(r.URL.Path)
+		group, err = s.db.GetGroup(id)
+		if err != nil || group == nil {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		if existing != nil && existing.ID != group.ID {
+			http.Error(w, "Group already exists", http.StatusConflict)
+			return
+		}
+		group.Name = req.Name
+		if err := s.db.UpdateGroup(group); err != nil {
+			http.Error(w, "Failed to update group", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.db.SetGroupMembers(group.ID, req.Members); err != nil {
+		http.Error(w, "Failed to update group members", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordSecurityEvent("group_updated", "info", s.getUsernameFromContext(r.Context()), s.clientIP(r),
+		fmt.Sprintf("Group %s saved with %d members", group.Name, len(req.Members)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(groupResponse{Group: group, Members: req.Members})
+}
+
+// DELETE /api/groups/{id} (admin only)
+func (s *Server) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	group, err := s.db.GetGroup(getIDFromPath(r.URL.Path))
+	if err != nil || group == nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err := s.db.DeleteGroup(group.ID); err != nil {
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+	s.recordSecurityEvent("group_deleted", "info", s.getUsernameFromContext(r.Context()), s.clientIP(r),
+		fmt.Sprintf("Group %s deleted", group.Name))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/roles (admin only)
+func (s *Server) handleListRoles(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	roles, err := s.db.ListRoles()
+	if err != nil {
+		http.Error(w, "Failed to list roles", http.StatusInternalServerError)
+		return
+	}
+	out := make([]roleResponse, 0, len(roles))
+	for _, role := range roles {
+		res, err := s.roleResponse(role)
+		if err != nil {
+			http.Error(w, "Failed to load role bindings", http.StatusInternalServerError)
+			return
+		}
+		out = append(out, res)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) roleResponse(role *database.Role) (roleResponse, error) {
+	res := roleResponse{Role: role, Rules: []policy.Rule{}}
+	if err := json.Unmarshal([]byte(role.Rules), &res.Rules); err != nil {
+		s.Debugf("Role %s has invalid rules: %v", role.Name, err)
+	}
+	bindings, err := s.db.ListRoleBindings(role.ID)
+	if err != nil {
+		return res, err
+	}
+	res.Bindings = bindings
+	return res, nil
+}
+
+// POST /api/roles and PUT /api/roles/{id} (admin only)
+func (s *Server) handleSaveRole(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		Name        string                  `json:"name"`
+		Description string                  `json:"description"`
+		Rules       []policy.Rule           `json:"rules"`
+		Bindings    []*database.RoleBinding `json:"bindings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Role name is required", http.StatusBadRequest)
+		return
+	}
+	for i, rule := range req.Rules {
+		if err := rule.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid rule %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, b := range req.Bindings {
+		switch b.SubjectType {
+		case database.RoleSubjectUser:
+			if strings.TrimSpace(b.Subject) == "" {
+				http.Error(w, "Binding subject is required", http.StatusBadRequest)
+				return
+			}
+		case database.RoleSubjectGroup:
+			// Accept group names for convenience; bindings always store the group ID
+			g, err := s.db.GetGroup(b.Subject)
+			if err == nil && g == nil {
+				g, err = s.db.GetGroupByName(b.Subject)
+			}
+			if err != nil || g == nil {
+				http.Error(w, fmt.Sprintf("Unknown group: %s", b.Subject), http.StatusBadRequest)
+				return
+			}
+			b.Subject = g.ID
+		default:
+			http.Error(w, "Binding type must be 'user' or 'group'", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Rules == nil {
+		req.Rules = []policy.Rule{}
+	}
+	rules, _ := json.Marshal(req.Rules)
+
+	existing, err := s.db.GetRoleByName(req.Name)
+	if err != nil {
+		http.Error(w, "Failed to check role name", http.StatusInternalServerError)
+		return
+	}
+
+	var role *database.Role
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		if existing != nil {
+			http.Error(w, "Role already exists", http.StatusConflict)
+			return
+		}
+		role = &database.Role{Name: req.Name}
+	} else {
+		role, err = s.db.GetRole(getIDFromPath(r.URL.Path))
+		if err != nil || role == nil {
+			http.Error(w, "Role not found", http.StatusNotFound)
+			return
+		}
+		if existing != nil && existing.ID != role.ID {
+			http.Error(w, "Role already exists", http.StatusConflict)
+			return
+		}
+		role.Name = req.Name
+	}
+	role.Rules = string(rules)
+	role.Description = nil
+	if d := strings.TrimSpace(req.Description); d != "" {
+		role.Description = &d
+	}
+
+	if r.Method == http.MethodPost {
+		err = s.db.CreateRole(role)
+		status = http.StatusCreated
+	} else {
+		err = s.db.UpdateRole(role)
+	}
+	if err != nil {
+		http.Error(w, "Failed to save role", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.SetRoleBindings(role.ID, req.Bindings); err != nil {
+		http.Error(w, "Failed to save role bindings", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordSecurityEvent("role_updated", "warn", s.getUsernameFromContext(r.Context()), s.clientIP(r),
+		fmt.Sprintf("Role %s saved with %d rules and %d bindings", role.Name, len(req.Rules), len(req.Bindings)))
+	res, err := s.roleResponse(role)
+	if err != nil {
+		http.Error(w, "Failed to load role bindings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// DELETE /api/roles/{id} (admin only)
+func (s *Server) handleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	role, err := s.db.GetRole(getIDFromPath(r.URL.Path))
+	if err != nil || role == nil {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	}
+	if err := s.db.DeleteRole(role.ID); err != nil {
+		http.Error(w, "Failed to delete role", http.StatusInternalServerError)
+		return
+	}
+	s.recordSecurityEvent("role_deleted", "warn", s.getUsernameFromContext(r.Context()), s.clientIP(r),
+		fmt.Sprintf("Role %s deleted", role.Name))
+	w.WriteHeader(http.StatusNoContent)
+}
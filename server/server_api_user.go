@@ -23,6 +23,7 @@ type UserInfo struct {
 	SSOEnabled     bool   `json:"sso_enabled"`
 	CanEditProfile bool   `json:"can_edit_profile"`
 	AuthMethod     string `json:"auth_method,omitempty"`
+	MFAEnabled     bool   `json:"mfa_enabled"`
 }
 
 // UserToken represents an API token
@@ -76,6 +77,10 @@ func (s *Server) handleGetUserInfo(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if m := s.getUserMFA(username); m != nil {
+		userInfo.MFAEnabled = m.Enabled
+	}
+
 	// Check if user is using SSO by looking at auth sources
 	if s.db != nil {
 		if authSource, err := s.db.GetUserAuthSource(username); err == nil && authSource != nil {
@@ -422,20 +427,6 @@ func (s *Server) isUserAdmin(ctx context.Context) bool {
 		return false
 	}
 
-	// Check --auth admin
-	if s.config != nil && s.config.Auth != "" {
-		au, _ := settings.ParseAuth(s.config.Auth)
-		if username == au {
-			return true
-		}
-	}
-
-	// Check database user
-	if s.db != nil {
-		if dbUser, err := s.db.GetUser(username); err == nil && dbUser != nil {
-			return dbUser.IsAdmin
-		}
-	}
-
-	return false
+	// Built-in admins and users holding a role with the admin action
+	return s.isAdminUser(username)
 }
@@ -0,0 +1,679 @@
+package chserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/server/chaos"
+	"github.com/NextChapterSoftware/chissl/server/mock"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+const (
+	// auditForwardSetting enables forwarding audit entries to security webhooks
+	auditForwardSetting = "audit_forward_webhooks"
+	// auditMaxBody bounds how much of a request or response body is inspected
+	auditMaxBody = 64 << 10
+	// auditPageSize is the batch size used when walking the whole log
+	auditPageSize = 500
+)
+
+// auditedPrefixes lists the path prefixes whose mutating calls are audited
+var auditedPrefixes = []string{"/api/", "/users", "/user", "/authfile", "/scim/v2/"}
+
+// auditKinds maps the leading path segments to the target type of an entry
+var auditKinds = map[string]string{
+	"users":             "user",
+	"user":              "user",
+	"authfile":          "authfile",
+	"listeners":         "listener",
+	"listener":          "listener",
+	"ai-listeners":      "ai_listener",
+	"ai-providers":      "ai_provider",
+	"multicast-tunnels": "multicast_tunnel",
+	"tunnels":           "tunnel",
+	"sessions":          "session",
+	"port-reservations": "port_reservation",
+	"groups":            "group",
+	"roles":             "role",
+	"sso":               "sso_config",
+	"settings":          "setting",
+	"capture":           "capture",
+	"logs":              "logs",
+	"Users":             "scim_user",
+	"Groups":            "scim_group",
+}
+
+// auditResponseKeys limits the response fields kept as the after state of
+// actions whose responses carry captured traffic
+var auditResponseKeys = map[string][]string{
+	"capture_replay": {"conn_id", "replay_of", "code", "duration_ms"},
+}
+
+// auditSensitiveKeys are redacted from stored before/after states
+var auditSensitiveKeys = []string{"password", "pass", "secret", "token", "api_key", "private", "hash", "recovery"}
+
+// auditRecord accumulates the details of one audited request
+type auditRecord struct {
+	entry  database.AuditEntry
+	before interface{}
+}
+
+// auditWriter records the status and a bounded copy of the response body
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if room := auditMaxBody - w.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		w.body.Write(b[:room])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// snapshotWriter collects the output of an internal GET handler call
+type snapshotWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *snapshotWriter) Header() http.Header         { return w.header }
+func (w *snapshotWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *snapshotWriter) WriteHeader(code int)        { w.status = code }
+
+// isAuditedRequest reports whether r is a mutating API call that belongs in the audit log
+func isAuditedRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return false
+	}
+	// Connectivity tests do not change state
+	if strings.HasSuffix(r.URL.Path, "/test") {
+		return false
+	}
+	for _, p := range auditedPrefixes {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditTarget derives the target type and identifier from the request path
+func auditTarget(path string) (kind, id string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case parts[0] == "scim" && len(parts) > 2:
+		parts = parts[2:]
+	case parts[0] == "api" && len(parts) > 1:
+		parts = parts[1:]
+	}
+	kind = auditKinds[parts[0]]
+	if kind == "" {
+		kind = strings.ReplaceAll(parts[0], "-", "_")
+	}
+	switch kind {
+	case "capture":
+		// /api/capture/{tunnels|listeners|multicast}/{id}/{action}
+		if len(parts) > 3 {
+			kind, id = "capture_"+parts[3], parts[2]
+		}
+	case "listener":
+		// /api/listener/{id}[/rules|/chaos]
+		if len(parts) > 2 && parts[2] == "rules" {
+			kind, id = "mock_rules", parts[1]
+		} else if len(parts) > 2 && parts[2] == "chaos" {
+			kind, id = "listener_chaos", parts[1]
+		} else if len(parts) > 1 {
+			id = parts[len(parts)-1]
+		}
+	case "setting", "sso_config":
+		// /api/settings/{name}[/...] and /api/sso/configs/{provider}
+		if len(parts) > 2 && kind == "sso_config" {
+			id = parts[2]
+		} else if len(parts) > 1 && kind == "setting" {
+			id = parts[1]
+		}
+	case "user":
+		if parts[0] == "user" && len(parts) > 1 && parts[1] != "" {
+			// /api/user/{tokens,profile,...} manage the caller's own account
+			if strings.HasPrefix(path, "/api/user/") {
+				kind = "user_" + strings.ReplaceAll(parts[1], "-", "_")
+				if len(parts) > 2 {
+					id = parts[len(parts)-1]
+				}
+				return
+			}
+			id = parts[1]
+		} else if len(parts) > 1 {
+			id = parts[len(parts)-1]
+		}
+	default:
+		if len(parts) > 1 {
+			id = parts[len(parts)-1]
+		}
+	}
+	return
+}
+
+// auditVerb maps an HTTP method to the verb of the audit action
+func auditVerb(method, kind string) string {
+	switch {
+	case kind == "setting":
+		return "update"
+	case method == http.MethodPost:
+		return "create"
+	case method == http.MethodDelete:
+		return "delete"
+	default:
+		return "update"
+	}
+}
+
+// auditActor resolves the caller of an audited request before authentication
+// middleware has run: session cookie, basic auth, then API token
+func (s *Server) auditActor(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, scimPathPrefix) {
+		return "scim"
+	}
+	if username := s.getCurrentUsername(r); username != "" {
+		return username
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && s.db != nil {
+		if ut, err := s.db.ValidateUserToken(token); err == nil && ut != nil {
+			return ut.Username
+		}
+	}
+	return ""
+}
+
+// auditSnapshot returns the current state of a target, or nil if it cannot be read
+func (s *Server) auditSnapshot(ctx context.Context, kind, id string) interface{} {
+	if s.db == nil || id == "" {
+		return nil
+	}
+	switch kind {
+	case "user":
+		if u, err := s.db.GetUser(id); err == nil && u != nil {
+			return u
+		}
+	case "listener":
+		if l, err := s.db.GetListener(id); err == nil && l != nil {
+			return l
+		}
+	case "mock_rules":
+		if l, err := s.db.GetListener(id); err == nil && l != nil {
+			if rules, err := mock.Parse(l.MockRules); err == nil {
+				return rules
+			}
+		}
+	case "listener_chaos":
+		if l, err := s.db.GetListener(id); err == nil && l != nil {
+			if cfg, err := chaos.Parse(l.Chaos); err == nil {
+				return cfg
+			}
+		}
+	case "sso_config":
+		if c, err := s.db.GetSSOConfig(database.SSOProvider(id)); err == nil && c != nil {
+			return c
+		}
+	case "capture_redaction":
+		if s.capture != nil {
+			_, entities := s.capture.Redaction()
+			if rules, ok := entities[id]; ok {
+				return rules
+			}
+		}
+	case "setting":
+		handlers := map[string]http.HandlerFunc{
+			"session":                  s.handleGetSessionSettings,
+			"login-backoff":            s.handleGetLoginBackoffSettings,
+			"ip-rate":                  s.handleGetIPRateSettings,
+			"mfa":                      s.handleGetMFASettings,
+			"reserved-ports-threshold": s.handleGetReservedPortsThreshold,
+			"scim":                     s.handleGetSCIMSettings,
+			"audit":                    s.handleGetAuditSettings,
+			"capture-redaction":        s.handleGetCaptureRedaction,
+			"capture-sinks":            s.handleGetCaptureSinks,
+		}
+		h, ok := handlers[id]
+		if !ok {
+			return nil
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/settings/"+id, nil)
+		if err != nil {
+			return nil
+		}
+		sw := &snapshotWriter{header: http.Header{}}
+		h(sw, req)
+		var v interface{}
+		if (sw.status == 0 || sw.status == http.StatusOK) && json.Unmarshal(sw.body.Bytes(), &v) == nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// beginAudit wraps a mutating request so that it is appended to the audit log
+// once the handler returns. The returned function must be called after the
+// request has been served.
+func (s *Server) beginAudit(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	var reqBody []byte
+	if r.Body != nil {
+		reqBody, _ = io.ReadAll(io.LimitReader(r.Body, auditMaxBody))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(reqBody), r.Body))
+	}
+
+	kind, id := auditTarget(r.URL.Path)
+	if id == "" {
+		// Creations name their target in the request body
+		var body map[string]interface{}
+		if json.Unmarshal(reqBody, &body) == nil {
+			for _, key := range []string{"username", "userName", "provider", "name"} {
+				if v, ok := body[key].(string); ok && v != "" {
+					id = v
+					break
+				}
+			}
+		}
+	}
+	actor := s.auditActor(r)
+	snapCtx := context.WithValue(r.Context(), "username", actor)
+
+	rec := &auditRecord{entry: database.AuditEntry{
+		Actor:      actor,
+		IP:         s.clientIP(r),
+		Action:     kind + "." + auditVerb(r.Method, kind),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		TargetType: kind,
+		Target:     id,
+	}}
+	rec.before = s.auditSnapshot(snapCtx, kind, id)
+
+	aw := &auditWriter{ResponseWriter: w}
+	return aw, r, func() {
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+		// Unauthenticated attempts are covered by security events
+		if aw.status == http.StatusUnauthorized {
+			return
+		}
+		if rec.entry.Actor == "" {
+			rec.entry.Actor = "anonymous"
+		}
+		var resp map[string]interface{}
+		if json.Unmarshal(aw.body.Bytes(), &resp) == nil && rec.entry.Target == "" {
+			if v, ok := resp["id"]; ok {
+				rec.entry.Target = fmt.Sprint(v)
+			}
+		}
+		e := &rec.entry
+		e.Status = aw.status
+		var after interface{}
+		if aw.status < 400 {
+			after = s.auditSnapshot(snapCtx, e.TargetType, e.Target)
+			if after == nil && r.Method != http.MethodDelete && resp != nil {
+				after = resp
+				if keys, ok := auditResponseKeys[e.TargetType]; ok {
+					kept := map[string]interface{}{}
+					for _, k := range keys {
+						if v, ok := resp[k]; ok {
+							kept[k] = v
+						}
+					}
+					after = kept
+				}
+			}
+		}
+		e.Before, e.After, e.Diff = auditStates(rec.before, after)
+		s.appendAudit(e)
+	}
+}
+
+// auditStates renders redacted before/after states and a shallow field diff
+func auditStates(before, after interface{}) (string, string, string) {
+	b, a := auditNormalize(before), auditNormalize(after)
+	enc := func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		out, _ := json.Marshal(v)
+		return string(out)
+	}
+
+	bm, _ := b.(map[string]interface{})
+	am, _ := a.(map[string]interface{})
+	diff := map[string]interface{}{}
+	if bm != nil || am != nil {
+		for k, v := range bm {
+			if nv, ok := am[k]; !ok || enc(nv) != enc(v) {
+				diff[k] = map[string]interface{}{"from": v, "to": am[k]}
+			}
+		}
+		for k, v := range am {
+			if _, ok := bm[k]; !ok {
+				diff[k] = map[string]interface{}{"from": nil, "to": v}
+			}
+		}
+	}
+	d := ""
+	if len(diff) > 0 {
+		d = enc(diff)
+	}
+	return enc(b), enc(a), d
+}
+
+// auditNormalize converts v to generic JSON values with sensitive fields redacted
+func auditNormalize(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil
+	}
+	return auditRedact(out)
+}
+
+func auditRedact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if auditSensitive(k) {
+				if val != nil && val != "" {
+					t[k] = "[redacted]"
+				}
+				continue
+			}
+			t[k] = auditRedact(val)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = auditRedact(t[i])
+		}
+	}
+	return v
+}
+
+func auditSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range auditSensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendAudit stores an entry and optionally forwards it to the security webhooks
+func (s *Server) appendAudit(e *database.AuditEntry) {
+	if s.db == nil {
+		return
+	}
+	s.auditMu.Lock()
+	err := s.db.AppendAuditEntry(e)
+	s.auditMu.Unlock()
+	if err != nil {
+		s.Infof("Failed to append audit entry for %s %s: %v", e.Method, e.Path, err)
+		return
+	}
+	if v, _ := s.db.GetSettingString(auditForwardSetting, "0"); v == "1" {
+		go s.dispatchSecurityEvent(SecurityEvent{
+			Type:     "audit",
+			Severity: "info",
+			Username: e.Actor,
+			IP:       e.IP,
+			At:       e.At,
+			Message:  fmt.Sprintf("%s %s %s (status %d, seq %d)", e.Action, e.TargetType, e.Target, e.Status, e.Seq),
+		})
+	}
+}
+
+// auditFilterFromQuery parses the audit query parameters
+func auditFilterFromQuery(r *http.Request) (database.AuditFilter, error) {
+	q := r.URL.Query()
+	f := database.AuditFilter{
+		Actor:      q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		Target:     q.Get("target"),
+	}
+	for key, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s: expected RFC3339 time", key)
+			}
+			*dst = t
+		}
+	}
+	for key, dst := range map[string]*int{"limit": &f.Limit, "offset": &f.Offset} {
+		if v := q.Get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("invalid %s", key)
+			}
+			*dst = n
+		}
+	}
+	if v := q.Get("after_seq"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid after_seq")
+		}
+		f.AfterSeq = n
+	}
+	if f.Limit > 1000 {
+		f.Limit = 1000
+	}
+	return f, nil
+}
+
+// GET /api/audit (admin only)
+func (s *Server) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	f, err := auditFilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := s.db.ListAuditEntries(f)
+	if err != nil {
+		http.Error(w, "Failed to list audit entries", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []*database.AuditEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// GET /api/audit/export?format=json|csv (admin only)
+func (s *Server) handleExportAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	f, err := auditFilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	var all []*database.AuditEntry
+	f.Ascending, f.Limit, f.Offset = true, auditPageSize, 0
+	for {
+		page, err := s.db.ListAuditEntries(f)
+		if err != nil {
+			http.Error(w, "Failed to export audit entries", http.StatusInternalServerError)
+			return
+		}
+		all = append(all, page...)
+		if len(page) < auditPageSize {
+			break
+		}
+		f.AfterSeq = page[len(page)-1].Seq
+	}
+
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "json" {
+		if all == nil {
+			all = []*database.AuditEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(all)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"seq", "at", "actor", "ip", "action", "method", "path", "target_type", "target", "status", "before", "after", "diff", "prev_hash", "hash"})
+	for _, e := range all {
+		cw.Write([]string{strconv.FormatInt(e.Seq, 10), e.At.UTC().Format(time.RFC3339Nano), e.Actor, e.IP, e.Action, e.Method, e.Path,
+			e.TargetType, e.Target, strconv.Itoa(e.Status), e.Before, e.After, e.Diff, e.PrevHash, e.Hash})
+	}
+	cw.Flush()
+}
+
+// GET /api/audit/verify (admin only) walks the whole hash chain
+func (s *Server) handleVerifyAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var checked int
+	var brokenAt int64
+	prev := ""
+	f := database.AuditFilter{Ascending: true, Limit: auditPageSize}
+	for {
+		page, err := s.db.ListAuditEntries(f)
+		if err != nil {
+			http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+			return
+		}
+		if brokenAt = database.VerifyAuditChain(page, prev); brokenAt != 0 {
+			for _, e := range page {
+				if e.Seq == brokenAt {
+					break
+				}
+				checked++
+			}
+			break
+		}
+		checked += len(page)
+		if len(page) < auditPageSize {
+			break
+		}
+		prev = page[len(page)-1].Hash
+		f.AfterSeq = page[len(page)-1].Seq
+	}
+	res := map[string]interface{}{"valid": brokenAt == 0, "checked": checked}
+	if brokenAt != 0 {
+		res["broken_at"] = brokenAt
+		s.recordSecurityEvent("audit_chain_broken", "critical", s.getUsernameFromContext(r.Context()), s.clientIP(r),
+			fmt.Sprintf("Audit log verification failed at entry %d", brokenAt))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// GET /api/settings/audit (admin only)
+func (s *Server) handleGetAuditSettings(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	forward := false
+	if s.db != nil {
+		v, _ := s.db.GetSettingString(auditForwardSetting, "0")
+		forward = v == "1"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"available": s.db != nil, "forward_webhooks": forward})
+}
+
+// PUT /api/settings/audit {forward_webhooks:bool} (admin only)
+func (s *Server) handleUpdateAuditSettings(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		ForwardWebhooks bool `json:"forward_webhooks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := s.db.SetSettingString(auditForwardSetting, map[bool]string{true: "1", false: "0"}[req.ForwardWebhooks]); err != nil {
+		http.Error(w, "Failed to save audit settings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "forward_webhooks": req.ForwardWebhooks})
+}
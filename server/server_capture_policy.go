@@ -0,0 +1,153 @@
+package chserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/NextChapterSoftware/chissl/server/capture"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// Per-entity capture policies. Policies set through the API live in the
+// capture_policies setting as JSON; policies requested in a client's profile
+// apply to its tunnels while the session lasts, unless one was set here.
+
+const capturePoliciesSetting = "capture_policies"
+
+// loadCapturePolicies applies the persisted capture policies to the capture service
+func (s *Server) loadCapturePolicies() {
+	if s.db == nil || s.capture == nil {
+		return
+	}
+	v, _ := s.db.GetSettingString(capturePoliciesSetting, "")
+	if v == "" {
+		return
+	}
+	var policies map[string]capture.CapturePolicy
+	if err := json.Unmarshal([]byte(v), &policies); err != nil {
+		s.Infof("Ignoring invalid capture policies: %v", err)
+		return
+	}
+	if err := s.capture.SetPolicies(policies); err != nil {
+		s.Infof("Failed to apply capture policies: %v", err)
+	}
+}
+
+// registerSessionCapturePolicies applies the capture policies a client
+// requested for its remotes under their canonical tunnel IDs until ctx is done
+func (s *Server) registerSessionCapturePolicies(ctx context.Context, unameEnc string, c *settings.Config) {
+	if s.capture == nil || len(c.Capture) == 0 {
+		return
+	}
+	var ids []string
+	for _, rmt := range c.Remotes {
+		p, ok := c.Capture[rmt.Encode()]
+		if !ok {
+			continue
+		}
+		if err := p.Validate(); err != nil {
+			s.Infof("Ignoring capture policy for %s: %v", rmt, err)
+			continue
+		}
+		id := "tun-" + unameEnc + "-" + rmt.LocalPort + "-" + rmt.RemotePort
+		s.capture.SetSessionPolicy(id, &p)
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		for _, id := range ids {
+			s.capture.SetSessionPolicy(id, nil)
+		}
+	}()
+}
+
+// GET /api/capture/{tunnels|listeners|multicast}/{id}/policy
+func (s *Server) handleGetCapturePolicy(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	if !s.userHasEntityAccess(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	effective, ok := s.capture.Policy(entityID)
+	set, isSet := s.capture.Policies()[entityID]
+	source := "default"
+	switch {
+	case isSet:
+		source = "server"
+	case ok:
+		source = "client"
+	}
+	out := map[string]interface{}{
+		"entity_id": entityID,
+		"policy":    nil,
+		"effective": effective,
+		"source":    source,
+	}
+	if isSet {
+		out["policy"] = set
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// PUT /api/capture/{tunnels|listeners|multicast}/{id}/policy {mode, sample_rate, max_body_bytes, include_paths, exclude_paths, retention_days}
+// An empty policy removes it.
+func (s *Server) handleUpdateCapturePolicy(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	if !s.userOwnsEntity(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var req capture.CapturePolicy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, "Invalid capture policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	current := s.capture.Policies()
+	policies := make(map[string]capture.CapturePolicy, len(current)+1)
+	for id, p := range current {
+		policies[id] = p
+	}
+	if req.IsZero() {
+		delete(policies, entityID)
+	} else {
+		policies[entityID] = req
+	}
+	if err := s.capture.SetPolicies(policies); err != nil {
+		http.Error(w, "Invalid capture policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.db != nil {
+		b, _ := json.Marshal(policies)
+		if err := s.db.SetSettingString(capturePoliciesSetting, string(b)); err != nil {
+			http.Error(w, "Failed to save capture policy", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "entity_id": entityID, "policy": req})
+}
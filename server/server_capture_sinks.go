@@ -0,0 +1,104 @@
+package chserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/NextChapterSoftware/chissl/server/capture"
+)
+
+// Capture event sinks. Their configuration lives in the capture_sinks
+// setting as JSON. Webhook header values are masked when read back; a masked
+// value sent back keeps the stored one.
+
+const captureSinksSetting = "capture_sinks"
+
+// loadCaptureSinks starts the persisted capture event sinks
+func (s *Server) loadCaptureSinks() {
+	if s.db == nil || s.capture == nil {
+		return
+	}
+	v, _ := s.db.GetSettingString(captureSinksSetting, "")
+	if v == "" {
+		return
+	}
+	var sinks []capture.SinkConfig
+	if err := json.Unmarshal([]byte(v), &sinks); err != nil {
+		s.Infof("Ignoring invalid capture sinks: %v", err)
+		return
+	}
+	if err := s.capture.SetSinks(sinks); err != nil {
+		s.Infof("Failed to start capture sinks: %v", err)
+	}
+}
+
+// GET /api/settings/capture-sinks (admin only)
+func (s *Server) handleGetCaptureSinks(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	configs, stats := s.capture.Sinks()
+	masked := make([]capture.SinkConfig, len(configs))
+	for i, c := range configs {
+		if len(c.Headers) > 0 {
+			h := make(map[string]string, len(c.Headers))
+			for k := range c.Headers {
+				h[k] = capture.RedactedValue
+			}
+			c.Headers = h
+		}
+		masked[i] = c
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sinks": masked, "stats": stats})
+}
+
+// PUT /api/settings/capture-sinks {sinks: [{name, type, url|address|path, entities, types, ...}]} (admin only)
+func (s *Server) handleUpdateCaptureSinks(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		Sinks []capture.SinkConfig `json:"sinks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	current, _ := s.capture.Sinks()
+	for i, c := range req.Sinks {
+		for k, v := range c.Headers {
+			if v != capture.RedactedValue {
+				continue
+			}
+			for _, old := range current {
+				if old.Name == c.Name {
+					req.Sinks[i].Headers[k] = old.Headers[k]
+				}
+			}
+		}
+	}
+	if err := s.capture.SetSinks(req.Sinks); err != nil {
+		http.Error(w, "Invalid capture sinks: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.db != nil {
+		b, _ := json.Marshal(req.Sinks)
+		if err := s.db.SetSettingString(captureSinksSetting, string(b)); err != nil {
+			http.Error(w, "Failed to save capture sinks", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "sinks": len(req.Sinks)})
+}
@@ -0,0 +1,85 @@
+package chserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/server/capture"
+)
+
+const (
+	defaultCaptureWait = 30 * time.Second
+	maxCaptureWait     = 5 * time.Minute
+)
+
+// POST /api/capture/{tunnels|listeners|multicast}/{id}/wait {method, path, headers, json, body_contains, response, status, after_id, since, timeout_ms}
+// Blocks until a matching exchange is captured and returns it, or answers
+// 504 with the entity's latest event ID once the timeout passes.
+func (s *Server) handleWaitCapture(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	if !s.userHasEntityAccess(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		capture.RequestMatch
+		TimeoutMillis int `json:"timeout_ms"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	timeout := defaultCaptureWait
+	if req.TimeoutMillis > 0 {
+		// clamp before converting so huge values cannot overflow
+		timeout = time.Duration(min(req.TimeoutMillis, int(maxCaptureWait/time.Millisecond))) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	x, err := s.capture.WaitFor(ctx, entityID, req.RequestMatch)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			// the client went away
+			return
+		}
+		w.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":         "no matching exchange within " + timeout.String(),
+			"last_event_id": s.capture.LastEventID(entityID),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(x)
+}
+
+// GET /api/capture/{tunnels|listeners|multicast}/{id}/cursor
+// Returns the entity's latest event ID, to wait for exchanges after it.
+func (s *Server) handleGetCaptureCursor(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	if !s.userHasEntityAccess(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"entity_id": entityID, "last_event_id": s.capture.LastEventID(entityID)})
+}
@@ -51,8 +51,8 @@ func (s *Server) isAuthenticated(r *http.Request) bool {
 			return true
 		}
 		if s.db != nil {
-			_, err := s.db.GetUser(username)
-			if err == nil {
+			dbUser, err := s.db.GetUser(username)
+			if err == nil && !dbUser.Disabled {
 				return true
 			}
 		}
@@ -69,7 +69,7 @@ func (s *Server) isAuthenticated(r *http.Request) bool {
 		// Then check database
 		if s.db != nil {
 			dbUser, err := s.db.GetUser(username)
-			if err == nil && dbUser.Password == password {
+			if err == nil && dbUser.Password == password && !dbUser.Disabled {
 				return true
 			}
 		}
@@ -2255,7 +2255,7 @@ function loadSSOConfigs() {
                     var statusBadge = config.enabled ? 'success' : 'secondary';
                     var statusText = config.enabled ? 'Enabled' : 'Disabled';
                     var providerIcon = getProviderIcon(config.provider);
-                    var providerName = config.provider.charAt(0).toUpperCase() + config.provider.slice(1);
+                    var providerName = config.provider === 'oidc' ? 'OpenID Connect' : config.provider.charAt(0).toUpperCase() + config.provider.slice(1);
 
                     html += '<div class="border rounded p-3 mb-3">' +
                         '<div class="d-flex justify-content-between align-items-center">' +
@@ -2286,6 +2286,8 @@ function loadSSOConfigs() {
                             html += 'Client ID: ' + (config.config.client_id || 'Not configured');
                         } else if (config.provider === 'auth0') {
                             html += 'Domain: ' + (config.config.domain || 'Not configured');
+                        } else if (config.provider === 'oidc') {
+                            html += 'Issuer: ' + escapeHtml(config.config.issuer_url || 'Not configured');
                         }
                         html += '</div>';
                     }
@@ -2313,6 +2315,7 @@ function getProviderIcon(provider) {
         case 'auth0': return 'fas fa-shield-alt';
         case 'scim': return 'fas fa-users-cog';
         case 'azure': return 'fab fa-microsoft';
+        case 'oidc': return 'fab fa-openid';
         default: return 'fas fa-cloud';
     }
 }
@@ -2336,6 +2339,7 @@ function showSSOConfigModal() {
         '<option value="okta">Okta</option>' +
         '<option value="scim">Generic SCIM</option>' +
         '<option value="auth0">Auth0</option>' +
+        '<option value="oidc">OpenID Connect</option>' +
         '</select>' +
         '</div>' +
         '<div id="ssoConfigFields"></div>' +
@@ -2426,6 +2430,51 @@ function updateSSOConfigForm() {
             '<label for="auth0ClientSecret">Client Secret *</label>' +
             '<input type="password" class="form-control" id="auth0ClientSecret" placeholder="Your Auth0 Client Secret" required>' +
             '</div>';
+    } else if (provider === 'oidc') {
+        fieldsHtml = '<div class="alert alert-info">' +
+            '<strong>OpenID Connect Setup:</strong><br>' +
+            '1. Register a confidential or public (PKCE) client with your identity provider<br>' +
+            '2. Set the redirect URI to: <code>' + window.location.origin + '/auth/oidc/callback</code><br>' +
+            '3. Enter the issuer URL; endpoints and signing keys are discovered automatically' +
+            '</div>' +
+            '<div class="form-group">' +
+            '<label for="oidcIssuer">Issuer URL *</label>' +
+            '<input type="url" class="form-control" id="oidcIssuer" placeholder="https://idp.example.com/realms/main" required>' +
+            '</div>' +
+            '<div class="form-group">' +
+            '<label for="oidcClientId">Client ID *</label>' +
+            '<input type="text" class="form-control" id="oidcClientId" required>' +
+            '</div>' +
+            '<div class="form-group">' +
+            '<label for="oidcClientSecret">Client Secret</label>' +
+            '<input type="password" class="form-control" id="oidcClientSecret" placeholder="Leave empty for public clients">' +
+            '</div>' +
+            '<div class="form-group">' +
+            '<label for="oidcScopes">Scopes</label>' +
+            '<input type="text" class="form-control" id="oidcScopes" value="openid profile email">' +
+            '</div>' +
+            '<div class="form-row">' +
+            '<div class="form-group col-md-6">' +
+            '<label for="oidcUsernameClaim">Username claim</label>' +
+            '<input type="text" class="form-control" id="oidcUsernameClaim" placeholder="preferred_username">' +
+            '</div>' +
+            '<div class="form-group col-md-6">' +
+            '<label for="oidcGroupsClaim">Groups claim</label>' +
+            '<input type="text" class="form-control" id="oidcGroupsClaim" placeholder="groups">' +
+            '</div>' +
+            '</div>' +
+            '<div class="form-row">' +
+            '<div class="form-group col-md-6">' +
+            '<label for="oidcAdminGroups">Admin groups</label>' +
+            '<input type="text" class="form-control" id="oidcAdminGroups" placeholder="chissl-admins">' +
+            '<small class="form-text text-muted">Comma-separated; members become admins</small>' +
+            '</div>' +
+            '<div class="form-group col-md-6">' +
+            '<label for="oidcAllowedGroups">Allowed groups</label>' +
+            '<input type="text" class="form-control" id="oidcAllowedGroups" placeholder="Anyone">' +
+            '<small class="form-text text-muted">Comma-separated; empty allows every user</small>' +
+            '</div>' +
+            '</div>';
     }
 
     $('#ssoConfigFields').html(fieldsHtml);
@@ -2483,6 +2532,26 @@ function saveSSOConfig() {
             redirect_url: window.location.origin + '/auth/auth0/callback',
             scopes: ['openid', 'profile', 'email']
         };
+    } else if (provider === 'oidc') {
+        var splitList = function(v) {
+            return (v || '').split(',').map(function(s) { return s.trim(); }).filter(function(s) { return s.length > 0; });
+        };
+        if (!$('#oidcIssuer').val() || !$('#oidcClientId').val()) {
+            alert('Please fill in all required fields');
+            return;
+        }
+
+        config = {
+            issuer_url: $('#oidcIssuer').val().trim(),
+            client_id: $('#oidcClientId').val().trim(),
+            client_secret: $('#oidcClientSecret').val(),
+            redirect_url: window.location.origin + '/auth/oidc/callback',
+            scopes: $('#oidcScopes').val().split(' ').filter(s => s.length > 0),
+            username_claim: $('#oidcUsernameClaim').val().trim(),
+            groups_claim: $('#oidcGroupsClaim').val().trim(),
+            admin_groups: splitList($('#oidcAdminGroups').val()),
+            allowed_groups: splitList($('#oidcAllowedGroups').val())
+        };
     }
 
     var requestData = {
@@ -2533,6 +2602,15 @@ function editSSOConfig(provider) {
                         $('#auth0Domain').val(config.config.domain || '');
                         $('#auth0ClientId').val(config.config.client_id || '');
                         $('#auth0ClientSecret').val(config.config.client_secret || '');
+                    } else if (config.provider === 'oidc') {
+                        $('#oidcIssuer').val(config.config.issuer_url || '');
+                        $('#oidcClientId').val(config.config.client_id || '');
+                        $('#oidcClientSecret').val(config.config.client_secret || '');
+                        $('#oidcScopes').val((config.config.scopes || []).join(' '));
+                        $('#oidcUsernameClaim').val(config.config.username_claim || '');
+                        $('#oidcGroupsClaim').val(config.config.groups_claim || '');
+                        $('#oidcAdminGroups').val((config.config.admin_groups || []).join(', '));
+                        $('#oidcAllowedGroups').val((config.config.allowed_groups || []).join(', '));
                     }
                 }, 100);
             });
@@ -3271,8 +3349,37 @@ func (s *Server) serveDashboardLogin(w http.ResponseWriter, r *http.Request) {
             <!-- SSO Login Options -->
             <div id="sso-login-options" class="mb-3"></div>
 
+            <!-- Two-factor code form (shown after password step) -->
+            <div id="mfa-enroll" style="display: none;">
+                <p class="text-muted small">Two-factor authentication is required for your account. Scan the code below with your authenticator app, save your recovery codes, then enter the 6-digit code.</p>
+                <div id="mfa-enroll-qr" class="text-center mb-2"></div>
+                <div class="form-group">
+                    <label class="small">Secret</label>
+                    <input type="text" id="mfa-enroll-secret" class="form-control form-control-sm" readonly>
+                </div>
+                <div class="form-group">
+                    <label class="small">Recovery codes</label>
+                    <pre id="mfa-enroll-recovery" class="border rounded p-2 small"></pre>
+                </div>
+            </div>
+            <form method="post" id="mfa-form" style="display: none;">
+                <div class="input-group mb-3">
+                    <input type="text" name="mfa_code" class="form-control" placeholder="Authentication or recovery code" autocomplete="one-time-code" autofocus>
+                    <div class="input-group-append">
+                        <div class="input-group-text">
+                            <span class="fas fa-shield-alt"></span>
+                        </div>
+                    </div>
+                </div>
+                <div class="row">
+                    <div class="col-12">
+                        <button type="submit" class="btn btn-primary btn-block">Verify</button>
+                    </div>
+                </div>
+            </form>
+
             <!-- Traditional Login Form -->
-            <form method="post">
+            <form method="post" id="password-form">
                 <div class="input-group mb-3">
                     <input type="text" name="username" class="form-control" placeholder="Username" required>
                     <div class="input-group-append">
@@ -3312,12 +3419,43 @@ $(document).ready(function() {
         var retry = parseInt(urlParams.get('retry') || '0', 10);
         var msg = retry > 0 ? ('Too many login attempts. Try again in ' + retry + 's.') : 'Too many login attempts. Please try again later.';
         $('#error-message').text(msg).show();
+    } else if (error === 'invalid_code') {
+        $('#error-message').text('Invalid authentication code').show();
+    } else if (error === 'mfa_expired') {
+        $('#error-message').text('Your sign-in attempt expired. Please sign in again.').show();
+    } else if (error === 'sso_failed') {
+        $('#error-message').text('Single sign-on failed. Contact your administrator if this persists.').show();
+    }
+
+    var mfa = urlParams.get('mfa');
+    if (mfa) {
+        showMFAStep(mfa === 'enroll');
+        return;
     }
 
     // Load SSO login options
     loadSSOLoginOptions();
 });
 
+function showMFAStep(enroll) {
+    $('#password-form').hide();
+    $('.login-box-msg').text('Enter the code from your authenticator app');
+    $('#mfa-form').show();
+    if (!enroll) {
+        return;
+    }
+    $.post('/auth/mfa/enroll')
+        .done(function(data) {
+            $('#mfa-enroll-secret').val(data.secret);
+            $('#mfa-enroll-recovery').text((data.recovery_codes || []).join('\n'));
+            $('#mfa-enroll-qr').html($('<img alt="QR code">').attr('src', data.qr_code));
+            $('#mfa-enroll').show();
+        })
+        .fail(function() {
+            $('#error-message').text('Could not start two-factor enrollment. Please sign in again.').show();
+        });
+}
+
 function loadSSOLoginOptions() {
     $.get('/api/sso/enabled')
         .done(function(configs) {
@@ -3331,7 +3469,7 @@ function loadSSOLoginOptions() {
                     html += '<div class="text-center mb-3">';
                     enabledConfigs.forEach(function(config) {
                         var providerIcon = getProviderIcon(config.provider);
-                        var providerName = config.provider.charAt(0).toUpperCase() + config.provider.slice(1);
+                        var providerName = config.provider === 'oidc' ? 'OpenID Connect' : config.provider.charAt(0).toUpperCase() + config.provider.slice(1);
                         var loginUrl = getSSOLoginUrl(config.provider);
 
                         html += '<a href="' + loginUrl + '" class="btn btn-outline-primary btn-block mb-2">' +
@@ -3354,6 +3492,7 @@ function getProviderIcon(provider) {
         case 'auth0': return 'fas fa-shield-alt';
         case 'scim': return 'fas fa-users-cog';
         case 'azure': return 'fab fa-microsoft';
+        case 'oidc': return 'fab fa-openid';
         default: return 'fas fa-cloud';
     }
 }
@@ -3379,8 +3518,33 @@ function getSSOLoginUrl(provider) {
 	w.Write([]byte(loginHTML))
 }
 
+// setDashboardSession issues the dashboard session cookie for an authenticated user
+func (s *Server) setDashboardSession(w http.ResponseWriter, username string) {
+	s.Debugf("Setting session cookie for user: %s", username)
+	// Compute session TTL (minutes -> seconds), default to 24h if unset/invalid
+	ttlMin := s.config.Security.SessionTTLMinutes
+	if ttlMin <= 0 {
+		ttlMin = 24 * 60
+	}
+	ttlSec := ttlMin * 60
+	// Set session cookie (simplified)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "chissl_session",
+		Value:    username, // In production, use a secure session token
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		MaxAge:   ttlSec,
+	})
+}
+
 // handleDashboardLogin processes login form submission
 func (s *Server) handleDashboardLogin(w http.ResponseWriter, r *http.Request) {
+	// Second step of a two-factor login
+	if r.FormValue("mfa_code") != "" {
+		s.handleDashboardMFA(w, r)
+		return
+	}
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 
@@ -3424,34 +3588,28 @@ func (s *Server) handleDashboardLogin(w http.ResponseWriter, r *http.Request) {
 	// If not found in memory and database is available, check database
 	if !authenticated && s.db != nil {
 		dbUser, err := s.db.GetUser(username)
-		if err == nil && dbUser.Password == password {
+		if err == nil && dbUser.Password == password && !dbUser.Disabled {
 			s.Debugf("Authentication successful via database")
 			authenticated = true
 		}
 	}
 
 	if authenticated {
-		// reset backoff state on success
 		ip := s.clientIP(r)
-		s.resetLoginBackoffFor(username, ip)
 		// record IP attempt
 		s.ipRateRecord(ip)
-		s.Debugf("Setting session cookie for user: %s", username)
-		// Compute session TTL (minutes -> seconds), default to 24h if unset/invalid
-		ttlMin := s.config.Security.SessionTTLMinutes
-		if ttlMin <= 0 {
-			ttlMin = 24 * 60
+		// Second factor: backoff is only reset once the code is verified
+		if m := s.getUserMFA(username); m != nil && m.Enabled {
+			s.startMFAChallenge(w, r, username, ip, false)
+			return
 		}
-		ttlSec := ttlMin * 60
-		// Set session cookie (simplified)
-		http.SetCookie(w, &http.Cookie{
-			Name:     "chissl_session",
-			Value:    username, // In production, use a secure session token
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   false, // Set to true in production with HTTPS
-			MaxAge:   ttlSec,
-		})
+		if s.mfaEnrollmentRequired(username) {
+			s.startMFAChallenge(w, r, username, ip, true)
+			return
+		}
+		// reset backoff state on success
+		s.resetLoginBackoffFor(username, ip)
+		s.setDashboardSession(w, username)
 		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 		return
 	}
@@ -13,6 +13,7 @@ import (
 	chshare "github.com/NextChapterSoftware/chissl/share"
 	"github.com/NextChapterSoftware/chissl/share/cnet"
 	"github.com/NextChapterSoftware/chissl/share/database"
+	"github.com/NextChapterSoftware/chissl/share/policy"
 	"github.com/NextChapterSoftware/chissl/share/settings"
 	"github.com/NextChapterSoftware/chissl/share/tunnel"
 	"golang.org/x/crypto/ssh"
@@ -54,6 +55,13 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Mutating API calls are recorded in the audit log
+	if s.db != nil && isAuditedRequest(r) {
+		var finish func()
+		w, r, finish = s.beginAudit(w, r)
+		defer finish()
+	}
+
 	switch {
 	case strings.HasPrefix(path, "/health"):
 		w.Write([]byte("OK\n"))
@@ -144,6 +152,30 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 				s.userAuthMiddleware(s.handleDownloadCaptureLog)(w, r)
 				return
 			}
+			if strings.HasSuffix(path, "/har") {
+				s.userAuthMiddleware(s.handleExportHAR)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/pcap") {
+				s.userAuthMiddleware(s.handleExportPCAP)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/redaction") {
+				s.userAuthMiddleware(s.handleGetEntityRedaction)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/policy") {
+				s.userAuthMiddleware(s.handleGetCapturePolicy)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/cursor") {
+				s.userAuthMiddleware(s.handleGetCaptureCursor)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/timings") {
+				s.userAuthMiddleware(s.handleCaptureTimings)(w, r)
+				return
+			}
 			if strings.HasSuffix(path, "/recent") {
 				s.userAuthMiddleware(s.handleGetRecentEvents)(w, r)
 				return
@@ -152,11 +184,53 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 				s.userAuthMiddleware(s.handleSSEStream)(w, r)
 				return
 			}
+		case http.MethodPost:
+			if strings.HasSuffix(path, "/replay") {
+				s.userAuthMiddleware(s.handleReplayCapture)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/wait") {
+				s.userAuthMiddleware(s.handleWaitCapture)(w, r)
+				return
+			}
+		case http.MethodPut:
+			if strings.HasSuffix(path, "/redaction") {
+				s.userAuthMiddleware(s.handleUpdateEntityRedaction)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/policy") {
+				s.userAuthMiddleware(s.handleUpdateCapturePolicy)(w, r)
+				return
+			}
 		}
 		return
 	case strings.HasPrefix(path, "/api/capture/listeners/"):
 		switch r.Method {
 		case http.MethodGet:
+			if strings.HasSuffix(path, "/har") {
+				s.userAuthMiddleware(s.handleExportHAR)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/pcap") {
+				s.userAuthMiddleware(s.handleExportPCAP)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/redaction") {
+				s.userAuthMiddleware(s.handleGetEntityRedaction)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/policy") {
+				s.userAuthMiddleware(s.handleGetCapturePolicy)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/cursor") {
+				s.userAuthMiddleware(s.handleGetCaptureCursor)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/timings") {
+				s.userAuthMiddleware(s.handleCaptureTimings)(w, r)
+				return
+			}
 			if strings.HasSuffix(path, "/recent") {
 				s.userAuthMiddleware(s.handleGetRecentEvents)(w, r)
 				return
@@ -165,6 +239,20 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 				s.userAuthMiddleware(s.handleSSEStream)(w, r)
 				return
 			}
+		case http.MethodPost:
+			if strings.HasSuffix(path, "/wait") {
+				s.userAuthMiddleware(s.handleWaitCapture)(w, r)
+				return
+			}
+		case http.MethodPut:
+			if strings.HasSuffix(path, "/redaction") {
+				s.userAuthMiddleware(s.handleUpdateEntityRedaction)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/policy") {
+				s.userAuthMiddleware(s.handleUpdateCapturePolicy)(w, r)
+				return
+			}
 		}
 		return
 	case strings.HasPrefix(path, "/api/listeners"):
@@ -183,19 +271,19 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 			s.userAuthMiddleware(s.handleListPublicMulticastTunnels)(w, r)
 			return
 		}
-		// Admin CRUD endpoints
+		// Management endpoints (handlers require the multicast:manage permission)
 		switch r.Method {
 		case http.MethodGet:
-			s.combinedAuthMiddleware(s.handleListMulticastTunnels)(w, r)
+			s.userAuthMiddleware(s.handleListMulticastTunnels)(w, r)
 			return
 		case http.MethodPost:
-			s.combinedAuthMiddleware(s.handleCreateMulticastTunnel)(w, r)
+			s.userAuthMiddleware(s.handleCreateMulticastTunnel)(w, r)
 			return
 		case http.MethodPut, http.MethodPatch:
-			s.combinedAuthMiddleware(s.handleUpdateMulticastTunnel)(w, r)
+			s.userAuthMiddleware(s.handleUpdateMulticastTunnel)(w, r)
 			return
 		case http.MethodDelete:
-			s.combinedAuthMiddleware(s.handleDeleteMulticastTunnel)(w, r)
+			s.userAuthMiddleware(s.handleDeleteMulticastTunnel)(w, r)
 			return
 		}
 		return
@@ -231,6 +319,41 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	case strings.HasPrefix(path, "/api/listener/"):
+		if strings.HasSuffix(path, "/rules") {
+			switch r.Method {
+			case http.MethodGet:
+				s.userAuthMiddleware(s.handleGetMockRules)(w, r)
+			case http.MethodPut:
+				s.userAuthMiddleware(s.handleUpdateMockRules)(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		if strings.HasSuffix(path, "/chaos") {
+			switch r.Method {
+			case http.MethodGet:
+				s.userAuthMiddleware(s.handleGetChaos)(w, r)
+			case http.MethodPut:
+				s.userAuthMiddleware(s.handleUpdateChaos)(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		if strings.HasSuffix(path, "/fixtures") {
+			switch r.Method {
+			case http.MethodGet:
+				s.userAuthMiddleware(s.handleExportFixtures)(w, r)
+			case http.MethodPost:
+				s.userAuthMiddleware(s.handleImportFixtures)(w, r)
+			case http.MethodDelete:
+				s.userAuthMiddleware(s.handleDeleteFixtures)(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			s.userAuthMiddleware(s.handleGetListener)(w, r)
@@ -246,6 +369,38 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(path, "/api/capture"):
 		switch r.Method {
 		case http.MethodGet:
+			if path == "/api/capture/search" {
+				s.userAuthMiddleware(s.handleSearchCaptures)(w, r)
+				return
+			}
+			if path == "/api/capture/diff" {
+				s.userAuthMiddleware(s.handleDiffCaptures)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/har") {
+				s.userAuthMiddleware(s.handleExportHAR)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/pcap") {
+				s.userAuthMiddleware(s.handleExportPCAP)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/redaction") {
+				s.userAuthMiddleware(s.handleGetEntityRedaction)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/policy") {
+				s.userAuthMiddleware(s.handleGetCapturePolicy)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/cursor") {
+				s.userAuthMiddleware(s.handleGetCaptureCursor)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/timings") {
+				s.userAuthMiddleware(s.handleCaptureTimings)(w, r)
+				return
+			}
 			if strings.Contains(path, "/stream") {
 				s.userAuthMiddleware(s.handleSSEStream)(w, r)
 				return
@@ -254,6 +409,32 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 				s.userAuthMiddleware(s.handleGetRecentEvents)(w, r)
 				return
 			}
+		case http.MethodPost:
+			if strings.HasSuffix(path, "/wait") {
+				s.userAuthMiddleware(s.handleWaitCapture)(w, r)
+				return
+			}
+		case http.MethodPut:
+			if strings.HasSuffix(path, "/redaction") {
+				s.userAuthMiddleware(s.handleUpdateEntityRedaction)(w, r)
+				return
+			}
+			if strings.HasSuffix(path, "/policy") {
+				s.userAuthMiddleware(s.handleUpdateCapturePolicy)(w, r)
+				return
+			}
+		}
+	case strings.HasPrefix(path, "/api/audit"):
+		if r.Method == http.MethodGet {
+			switch {
+			case strings.HasPrefix(path, "/api/audit/export"):
+				s.combinedAuthMiddleware(s.handleExportAudit)(w, r)
+			case strings.HasPrefix(path, "/api/audit/verify"):
+				s.combinedAuthMiddleware(s.handleVerifyAudit)(w, r)
+			default:
+				s.combinedAuthMiddleware(s.handleListAudit)(w, r)
+			}
+			return
 		}
 	case strings.HasPrefix(path, "/api/stats"):
 		switch r.Method {
@@ -295,6 +476,28 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 			s.combinedAuthMiddleware(s.handleGetLogs)(w, r)
 			return
 		}
+	case strings.HasPrefix(path, "/api/user/mfa"):
+		var handlers map[string]http.HandlerFunc
+		switch strings.TrimPrefix(path, "/api/user/mfa") {
+		case "":
+			handlers = map[string]http.HandlerFunc{http.MethodGet: s.handleGetUserMFA, http.MethodDelete: s.handleDisableUserMFA}
+		case "/enroll":
+			handlers = map[string]http.HandlerFunc{http.MethodPost: s.handleEnrollUserMFA}
+		case "/verify":
+			handlers = map[string]http.HandlerFunc{http.MethodPost: s.handleVerifyUserMFA}
+		case "/recovery-codes":
+			handlers = map[string]http.HandlerFunc{http.MethodPost: s.handleRegenerateMFARecoveryCodes}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		h, ok := handlers[r.Method]
+		if !ok {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.userAuthMiddleware(h)(w, r)
+		return
 	case strings.HasPrefix(path, "/api/user/"):
 		switch r.Method {
 		case http.MethodGet:
@@ -383,6 +586,94 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 
+	case strings.HasPrefix(path, "/api/settings/mfa"):
+		if strings.HasPrefix(path, "/api/settings/mfa/users/") && r.Method == http.MethodDelete {
+			s.combinedAuthMiddleware(s.handleResetUserMFA)(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.combinedAuthMiddleware(s.handleGetMFASettings)(w, r)
+			return
+		case http.MethodPut, http.MethodPost:
+			s.combinedAuthMiddleware(s.handleUpdateMFASettings)(w, r)
+			return
+		}
+		return
+
+	case strings.HasPrefix(path, "/api/groups"):
+		switch r.Method {
+		case http.MethodGet:
+			s.combinedAuthMiddleware(s.handleListGroups)(w, r)
+			return
+		case http.MethodPost, http.MethodPut:
+			s.combinedAuthMiddleware(s.handleSaveGroup)(w, r)
+			return
+		case http.MethodDelete:
+			s.combinedAuthMiddleware(s.handleDeleteGroup)(w, r)
+			return
+		}
+		return
+
+	case strings.HasPrefix(path, "/api/roles"):
+		switch r.Method {
+		case http.MethodGet:
+			s.combinedAuthMiddleware(s.handleListRoles)(w, r)
+			return
+		case http.MethodPost, http.MethodPut:
+			s.combinedAuthMiddleware(s.handleSaveRole)(w, r)
+			return
+		case http.MethodDelete:
+			s.combinedAuthMiddleware(s.handleDeleteRole)(w, r)
+			return
+		}
+		return
+
+	case strings.HasPrefix(path, "/api/settings/scim"):
+		if strings.HasPrefix(path, "/api/settings/scim/token") {
+			switch r.Method {
+			case http.MethodPost:
+				s.combinedAuthMiddleware(s.handleGenerateSCIMToken)(w, r)
+				return
+			case http.MethodDelete:
+				s.combinedAuthMiddleware(s.handleRevokeSCIMToken)(w, r)
+				return
+			}
+			return
+		}
+		if r.Method == http.MethodGet {
+			s.combinedAuthMiddleware(s.handleGetSCIMSettings)(w, r)
+			return
+		}
+		return
+
+	case strings.HasPrefix(path, "/api/settings/audit"):
+		switch r.Method {
+		case http.MethodGet:
+			s.combinedAuthMiddleware(s.handleGetAuditSettings)(w, r)
+			return
+		case http.MethodPut, http.MethodPost:
+			s.combinedAuthMiddleware(s.handleUpdateAuditSettings)(w, r)
+			return
+		}
+	case strings.HasPrefix(path, "/api/settings/capture-redaction"):
+		switch r.Method {
+		case http.MethodGet:
+			s.combinedAuthMiddleware(s.handleGetCaptureRedaction)(w, r)
+			return
+		case http.MethodPut, http.MethodPost:
+			s.combinedAuthMiddleware(s.handleUpdateCaptureRedaction)(w, r)
+			return
+		}
+	case strings.HasPrefix(path, "/api/settings/capture-sinks"):
+		switch r.Method {
+		case http.MethodGet:
+			s.combinedAuthMiddleware(s.handleGetCaptureSinks)(w, r)
+			return
+		case http.MethodPut, http.MethodPost:
+			s.combinedAuthMiddleware(s.handleUpdateCaptureSinks)(w, r)
+			return
+		}
 	case strings.HasPrefix(path, "/api/settings/session"):
 		switch r.Method {
 		case http.MethodGet:
@@ -444,7 +735,16 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		return
+	case strings.HasPrefix(path, "/scim/v2/"):
+		// SCIM 2.0 provisioning API, authorized by its own bearer token
+		s.scimAuthMiddleware(s.handleSCIM)(w, r)
+		return
 	case strings.HasPrefix(path, "/auth/"):
+		// Two-factor enrollment during login (authorized by the pending challenge cookie)
+		if path == "/auth/mfa/enroll" {
+			s.handleMFALoginEnroll(w, r)
+			return
+		}
 		// Handle SSO authentication routes
 		if strings.HasPrefix(path, "/auth/scim/") {
 			if path == "/auth/scim/login" {
@@ -454,6 +754,14 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 				s.handleSCIMCallback(w, r)
 				return
 			}
+		} else if strings.HasPrefix(path, "/auth/oidc/") {
+			if path == "/auth/oidc/login" {
+				s.handleOIDCLogin(w, r)
+				return
+			} else if path == "/auth/oidc/callback" {
+				s.handleOIDCCallback(w, r)
+				return
+			}
 		} else if strings.HasPrefix(path, "/auth/auth0/") {
 			if path == "/auth/auth0/login" {
 				s.handleAuth0Login(w, r)
@@ -571,6 +879,15 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 
 	//validate remotes
 	for _, r := range c.Remotes {
+		//subscribing to an enabled multicast tunnel is open to every user
+		multicast := false
+		if s.multicasts != nil {
+			if lp, err := strconv.Atoi(r.LocalPort); err == nil {
+				if am := s.multicasts.getActiveByPort(lp); am != nil && am.Config.Enabled {
+					multicast = true
+				}
+			}
+		}
 		//if user is provided, ensure they have
 		//access to the desired remotes
 		if user != nil {
@@ -580,8 +897,15 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 				return
 			}
 
-			// Check port reservations for the local port
 			if localPort, err := strconv.Atoi(r.LocalPort); err == nil {
+				// Check the role policy for the local port
+				if !multicast {
+					if d := s.authorize(user.Name, policy.ActionTunnelOpen, policy.Resource{Port: localPort}); !d.Allowed {
+						failed(s.Errorf("access denied: %s", d.Reason))
+						return
+					}
+				}
+				// Check port reservations for the local port
 				if available, errMsg := s.isPortAvailableForUser(localPort, user.Name); !available {
 					failed(s.Errorf("port reservation error: %s", errMsg))
 					return
@@ -595,14 +919,7 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 		//confirm reverse tunnel is available
-		allowed := false
-		if s.multicasts != nil {
-			if lp, err := strconv.Atoi(r.LocalPort); err == nil {
-				if am := s.multicasts.getActiveByPort(lp); am != nil && am.Config.Enabled {
-					allowed = true
-				}
-			}
-		}
+		allowed := multicast
 		if !allowed && !r.CanListen() {
 			// initialize capture service if not set
 			if s.config.Dashboard.Enabled && s.capture == nil {
@@ -679,6 +996,7 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 
 	//bind
 	eg, ctx := errgroup.WithContext(req.Context())
+	s.registerSessionCapturePolicies(ctx, unameEnc, c)
 
 	// Periodic DB heartbeat to keep tunnel status fresh while connection is alive
 	if s.db != nil {
@@ -790,6 +1108,7 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 		if len(inboundFiltered) == 0 {
 			return nil
 		}
+		s.registerReplayTargets(ctx, tunnelID, unameEnc, tun, c.Remotes, inboundFiltered)
 		// block on non-multicast remotes
 		return tun.BindRemotes(ctx, inboundFiltered)
 	})
@@ -0,0 +1,584 @@
+package chserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/ccrypto"
+	"github.com/NextChapterSoftware/chissl/share/database"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// mfaChallengeCookie carries the pending second-factor challenge between login steps
+	mfaChallengeCookie = "chissl_mfa"
+	// mfaChallengeTTL bounds how long a password-verified login may wait for its code
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaRecoveryCodeCount is the number of recovery codes issued per enrollment
+	mfaRecoveryCodeCount = 10
+	// mfaIssuer is the issuer shown in authenticator apps
+	mfaIssuer = "chiSSL"
+)
+
+// MFAConfig controls multi-factor authentication policy
+type MFAConfig struct {
+	Required bool `json:"required"`
+}
+
+// mfaChallenge is a password-verified login awaiting a TOTP or recovery code
+type mfaChallenge struct {
+	username string
+	// ip is the client that passed the password step; only it may finish the login
+	ip      string
+	expires time.Time
+	enroll  bool // user must enroll before the session is issued
+}
+
+// MFAEnrollment is returned when a user starts TOTP enrollment
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	// QRCode is the URI rendered as a PNG data URI, generated here so the
+	// secret never leaves the server for a third-party QR service
+	QRCode        string   `json:"qr_code"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// getUserMFA returns the user's enrollment (enabled or pending) if the DB is configured
+func (s *Server) getUserMFA(username string) *database.UserMFA {
+	if s.db == nil || username == "" {
+		return nil
+	}
+	m, err := s.db.GetUserMFA(username)
+	if err != nil {
+		s.Debugf("Failed to load MFA for %s: %v", username, err)
+		return nil
+	}
+	return m
+}
+
+// mfaEnabled reports whether a user has confirmed TOTP enrollment. A password
+// alone does not authenticate such users to the API; they use API tokens.
+func (s *Server) mfaEnabled(username string) bool {
+	m := s.getUserMFA(username)
+	return m != nil && m.Enabled
+}
+
+// mfaEnrollmentRequired reports whether policy forces a DB user without MFA to enroll.
+// CLI (--auth) and authfile users have no DB record and cannot enroll.
+func (s *Server) mfaEnrollmentRequired(username string) bool {
+	if !s.config.Security.MFA.Required || s.db == nil {
+		return false
+	}
+	if _, err := s.db.GetUser(username); err != nil {
+		return false
+	}
+	m := s.getUserMFA(username)
+	return m == nil || !m.Enabled
+}
+
+// verifyMFACode checks a TOTP code (rejecting replays) or consumes a recovery code.
+// Returns the method that matched ("totp" or "recovery").
+func (s *Server) verifyMFACode(m *database.UserMFA, code string) (string, bool) {
+	secret, err := m.GetSecret()
+	if err != nil {
+		s.Debugf("Failed to decrypt MFA secret for %s: %v", m.Username, err)
+		return "", false
+	}
+	if counter, ok := ccrypto.ValidateTOTP(secret, code, time.Now(), 1); ok {
+		if int64(counter) <= m.LastCounter {
+			return "", false
+		}
+		m.LastCounter = int64(counter)
+		if err := s.db.SaveUserMFA(m); err != nil {
+			s.Debugf("Failed to persist MFA counter for %s: %v", m.Username, err)
+		}
+		return "totp", true
+	}
+	if !m.Enabled {
+		// recovery codes are only valid once enrollment is confirmed
+		return "", false
+	}
+	hashes, err := m.GetRecoveryHashes()
+	if err != nil {
+		return "", false
+	}
+	h := ccrypto.HashRecoveryCode(code)
+	for i, candidate := range hashes {
+		if candidate == h {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			if err := m.SetRecoveryHashes(hashes); err != nil {
+				return "", false
+			}
+			if err := s.db.SaveUserMFA(m); err != nil {
+				s.Debugf("Failed to persist recovery code use for %s: %v", m.Username, err)
+				return "", false
+			}
+			return "recovery", true
+		}
+	}
+	return "", false
+}
+
+// beginMFAEnrollment generates a fresh secret and recovery codes and stores them as a pending enrollment
+func (s *Server) beginMFAEnrollment(username string) (*MFAEnrollment, error) {
+	secret, err := ccrypto.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	codes, err := ccrypto.GenerateRecoveryCodes(mfaRecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hashes = append(hashes, ccrypto.HashRecoveryCode(c))
+	}
+	m := &database.UserMFA{Username: username}
+	if err := m.SetSecret(secret); err != nil {
+		return nil, err
+	}
+	if err := m.SetRecoveryHashes(hashes); err != nil {
+		return nil, err
+	}
+	if err := s.db.SaveUserMFA(m); err != nil {
+		return nil, err
+	}
+	uri := ccrypto.TOTPProvisioningURI(mfaIssuer, username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 180)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAEnrollment{
+		Secret:        secret,
+		OTPAuthURI:    uri,
+		QRCode:        "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// confirmMFAEnrollment enables a pending enrollment once the user proves possession of the secret
+func (s *Server) confirmMFAEnrollment(m *database.UserMFA, code string) bool {
+	if _, ok := s.verifyMFACode(m, code); !ok {
+		return false
+	}
+	now := time.Now()
+	m.Enabled = true
+	m.EnabledAt = &now
+	if err := s.db.SaveUserMFA(m); err != nil {
+		s.Debugf("Failed to enable MFA for %s: %v", m.Username, err)
+		return false
+	}
+	s.recordSecurityEvent("mfa_enrolled", "info", m.Username, "", "Two-factor authentication enabled")
+	return true
+}
+
+// newMFAChallenge registers a pending second-factor login and returns its token
+func (s *Server) newMFAChallenge(username, ip string, enroll bool) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	s.mfaMu.Lock()
+	defer s.mfaMu.Unlock()
+	// opportunistic sweep of expired challenges
+	for k, c := range s.mfaChallenges {
+		if now.After(c.expires) {
+			delete(s.mfaChallenges, k)
+		}
+	}
+	s.mfaChallenges[token] = &mfaChallenge{username: username, ip: ip, expires: now.Add(mfaChallengeTTL), enroll: enroll}
+	return token, nil
+}
+
+// mfaChallengeFor returns the pending challenge referenced by the request
+// cookie, if the request comes from the client the challenge was issued to
+func (s *Server) mfaChallengeFor(r *http.Request) (string, *mfaChallenge) {
+	cookie, err := r.Cookie(mfaChallengeCookie)
+	if err != nil || cookie.Value == "" {
+		return "", nil
+	}
+	s.mfaMu.Lock()
+	defer s.mfaMu.Unlock()
+	c := s.mfaChallenges[cookie.Value]
+	if c == nil {
+		return "", nil
+	}
+	if time.Now().After(c.expires) {
+		delete(s.mfaChallenges, cookie.Value)
+		return "", nil
+	}
+	if c.ip != s.clientIP(r) {
+		return "", nil
+	}
+	return cookie.Value, c
+}
+
+// clearMFAChallenge removes a pending challenge and its cookie
+func (s *Server) clearMFAChallenge(w http.ResponseWriter, token string) {
+	s.mfaMu.Lock()
+	delete(s.mfaChallenges, token)
+	s.mfaMu.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaChallengeCookie,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   -1,
+	})
+}
+
+// startMFAChallenge sets the challenge cookie and redirects the browser to the code prompt
+func (s *Server) startMFAChallenge(w http.ResponseWriter, r *http.Request, username, ip string, enroll bool) {
+	token, err := s.newMFAChallenge(username, ip, enroll)
+	if err != nil {
+		http.Error(w, "Failed to start two-factor challenge", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaChallengeCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   int(mfaChallengeTTL.Seconds()),
+	})
+	step := "1"
+	if enroll {
+		step = "enroll"
+	}
+	http.Redirect(w, r, "/dashboard?mfa="+step, http.StatusSeeOther)
+}
+
+// handleDashboardMFA processes the second login step (code submission)
+func (s *Server) handleDashboardMFA(w http.ResponseWriter, r *http.Request) {
+	token, ch := s.mfaChallengeFor(r)
+	if ch == nil {
+		http.Redirect(w, r, "/dashboard?error=mfa_expired", http.StatusSeeOther)
+		return
+	}
+	username := ch.username
+	ip := s.clientIP(r)
+	if locked, retry := s.ipRateCheck(ip); locked {
+		if retry > 0 {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retry.Seconds())))
+		}
+		http.Redirect(w, r, "/dashboard?error=rate_limited&retry="+fmt.Sprintf("%d", int(retry.Seconds())), http.StatusSeeOther)
+		return
+	}
+	// Second-factor attempts share the password backoff bucket
+	locked, retryAfter, delay := s.nextLoginDelayFor(username, ip)
+	if locked {
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds())))
+		}
+		s.clearMFAChallenge(w, token)
+		http.Redirect(w, r, "/dashboard?error=rate_limited&retry="+fmt.Sprintf("%d", int(retryAfter.Seconds())), http.StatusSeeOther)
+		return
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	code := r.FormValue("mfa_code")
+	m := s.getUserMFA(username)
+	ok := false
+	method := ""
+	if m != nil {
+		if ch.enroll && !m.Enabled {
+			ok = s.confirmMFAEnrollment(m, code)
+			method = "enroll"
+		} else if m.Enabled {
+			method, ok = s.verifyMFACode(m, code)
+		}
+	}
+	s.ipRateRecord(ip)
+	if !ok {
+		s.recordLoginFailureFor(username, ip)
+		s.Debugf("Two-factor verification failed for user: %s", username)
+		step := "1"
+		if ch.enroll {
+			step = "enroll"
+		}
+		http.Redirect(w, r, "/dashboard?mfa="+step+"&error=invalid_code", http.StatusSeeOther)
+		return
+	}
+	if method == "recovery" {
+		s.recordSecurityEvent("mfa_recovery_used", "warn", username, ip, "Recovery code used for dashboard login")
+	}
+	s.resetLoginBackoffFor(username, ip)
+	s.clearMFAChallenge(w, token)
+	s.setDashboardSession(w, username)
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// handleMFALoginEnroll starts enrollment for a login challenge that requires it (POST /auth/mfa/enroll)
+func (s *Server) handleMFALoginEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, ch := s.mfaChallengeFor(r)
+	if ch == nil || !ch.enroll || s.db == nil {
+		http.Error(w, "No pending enrollment", http.StatusUnauthorized)
+		return
+	}
+	if m := s.getUserMFA(ch.username); m != nil && m.Enabled {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+	enr, err := s.beginMFAEnrollment(ch.username)
+	if err != nil {
+		s.Debugf("Failed to begin MFA enrollment: %v", err)
+		http.Error(w, "Failed to start enrollment", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enr)
+}
+
+// GET /api/user/mfa
+func (s *Server) handleGetUserMFA(w http.ResponseWriter, r *http.Request) {
+	username := s.getUsernameFromContext(r.Context())
+	if username == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	resp := map[string]any{
+		"enabled":   false,
+		"pending":   false,
+		"required":  s.config.Security.MFA.Required,
+		"available": s.db != nil,
+	}
+	if m := s.getUserMFA(username); m != nil {
+		resp["enabled"] = m.Enabled
+		resp["pending"] = !m.Enabled
+		resp["enabled_at"] = m.EnabledAt
+		if hashes, err := m.GetRecoveryHashes(); err == nil {
+			resp["recovery_codes_remaining"] = len(hashes)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// POST /api/user/mfa/enroll
+func (s *Server) handleEnrollUserMFA(w http.ResponseWriter, r *http.Request) {
+	username := s.getUsernameFromContext(r.Context())
+	if username == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := s.db.GetUser(username); err != nil {
+		http.Error(w, "Two-factor authentication is only available for database users", http.StatusBadRequest)
+		return
+	}
+	if m := s.getUserMFA(username); m != nil && m.Enabled {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+	enr, err := s.beginMFAEnrollment(username)
+	if err != nil {
+		s.Debugf("Failed to begin MFA enrollment: %v", err)
+		http.Error(w, "Failed to start enrollment", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enr)
+}
+
+// mfaCodeRequest is the body for MFA endpoints that require proof of possession
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// POST /api/user/mfa/verify {code}
+func (s *Server) handleVerifyUserMFA(w http.ResponseWriter, r *http.Request) {
+	username := s.getUsernameFromContext(r.Context())
+	if username == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	m := s.getUserMFA(username)
+	if m == nil {
+		http.Error(w, "No pending enrollment", http.StatusNotFound)
+		return
+	}
+	if m.Enabled {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+	if !s.checkMFACode(w, r, username, func() bool { return s.confirmMFAEnrollment(m, req.Code) }) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "enabled": true})
+}
+
+// POST /api/user/mfa/recovery-codes {code} regenerates recovery codes
+func (s *Server) handleRegenerateMFARecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	username := s.getUsernameFromContext(r.Context())
+	if username == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	m := s.getUserMFA(username)
+	if m == nil || !m.Enabled {
+		http.Error(w, "Two-factor authentication not enabled", http.StatusNotFound)
+		return
+	}
+	if !s.checkMFACode(w, r, username, func() bool { _, ok := s.verifyMFACode(m, req.Code); return ok }) {
+		return
+	}
+	codes, err := ccrypto.GenerateRecoveryCodes(mfaRecoveryCodeCount)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hashes = append(hashes, ccrypto.HashRecoveryCode(c))
+	}
+	if err := m.SetRecoveryHashes(hashes); err != nil {
+		http.Error(w, "Failed to store recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.SaveUserMFA(m); err != nil {
+		http.Error(w, "Failed to store recovery codes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"recovery_codes": codes})
+}
+
+// DELETE /api/user/mfa {code} disables MFA for the current user
+func (s *Server) handleDisableUserMFA(w http.ResponseWriter, r *http.Request) {
+	username := s.getUsernameFromContext(r.Context())
+	if username == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	m := s.getUserMFA(username)
+	if m == nil {
+		http.Error(w, "Two-factor authentication not enabled", http.StatusNotFound)
+		return
+	}
+	if m.Enabled {
+		if !s.checkMFACode(w, r, username, func() bool { _, ok := s.verifyMFACode(m, req.Code); return ok }) {
+			return
+		}
+	}
+	if err := s.db.DeleteUserMFA(username); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	s.recordSecurityEvent("mfa_disabled", "warn", username, s.clientIP(r), "Two-factor authentication disabled")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "enabled": false})
+}
+
+// checkMFACode runs verify under the login backoff so a session cannot be
+// used to guess codes. The attempts get a bucket of their own: successful
+// password authentication of the requests must not reset it. It answers the
+// request and returns false when the code is not accepted.
+func (s *Server) checkMFACode(w http.ResponseWriter, r *http.Request, username string, verify func() bool) bool {
+	ip := s.clientIP(r)
+	bucket := "mfa:" + username
+	locked, retryAfter, delay := s.nextLoginDelayFor(bucket, ip)
+	if locked {
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds())))
+		}
+		http.Error(w, "Too many failed attempts. Please try again later.", http.StatusTooManyRequests)
+		return false
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if !verify() {
+		s.recordLoginFailureFor(bucket, ip)
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return false
+	}
+	s.resetLoginBackoffFor(bucket, ip)
+	return true
+}
+
+// GET /api/settings/mfa
+func (s *Server) handleGetMFASettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.config.Security.MFA)
+}
+
+// PUT /api/settings/mfa {required:bool} (admin only)
+func (s *Server) handleUpdateMFASettings(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	var req MFAConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Required && s.db == nil {
+		http.Error(w, "Requiring two-factor authentication needs a database", http.StatusBadRequest)
+		return
+	}
+	s.config.Security.MFA = req
+	if s.db != nil {
+		_ = s.db.SetSettingString("security_mfa_required", map[bool]string{true: "1", false: "0"}[req.Required])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "mfa": req})
+}
+
+// DELETE /api/settings/mfa/users/{username} resets a user's MFA (admin only)
+func (s *Server) handleResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	username := strings.TrimPrefix(r.URL.Path, "/api/settings/mfa/users/")
+	if username == "" || strings.Contains(username, "/") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	if err := s.db.DeleteUserMFA(username); err != nil {
+		http.Error(w, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	s.recordSecurityEvent("mfa_reset", "warn", username, s.clientIP(r), "Two-factor authentication reset by "+s.getUsernameFromContext(r.Context()))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+}
@@ -0,0 +1,91 @@
+package chserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/NextChapterSoftware/chissl/share/policy"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// policySubject resolves a username into the subject evaluated by the policy
+// engine: the built-in admin flag plus groups and roles from the database.
+func (s *Server) policySubject(username string) *policy.Subject {
+	if username == "" {
+		return nil
+	}
+	sub := &policy.Subject{Username: username}
+
+	// --auth admin
+	if s.config != nil && s.config.Auth != "" {
+		au, _ := settings.ParseAuth(s.config.Auth)
+		if username == au {
+			sub.Admin = true
+		}
+	}
+	// Authfile admin
+	if s.users != nil {
+		if u, found := s.users.Get(username); found && u.IsAdmin {
+			sub.Admin = true
+		}
+	}
+	if s.db == nil {
+		return sub
+	}
+	if dbUser, err := s.db.GetUser(username); err == nil && dbUser != nil && dbUser.IsAdmin && !dbUser.Disabled {
+		sub.Admin = true
+	}
+	sub.Groups = s.userGroupIDs(username)
+	roles, err := s.db.ListRolesForUser(username)
+	if err != nil {
+		s.Debugf("Failed to load roles for %s: %v", username, err)
+		return sub
+	}
+	for _, r := range roles {
+		role := policy.Role{Name: r.Name}
+		if err := json.Unmarshal([]byte(r.Rules), &role.Rules); err != nil {
+			s.Debugf("Ignoring role %s with invalid rules: %v", r.Name, err)
+			continue
+		}
+		sub.Roles = append(sub.Roles, role)
+	}
+	return sub
+}
+
+// userGroupIDs returns the IDs of the groups a user belongs to
+func (s *Server) userGroupIDs(username string) []string {
+	if s.db == nil {
+		return nil
+	}
+	groups, err := s.db.ListUserGroups(username)
+	if err != nil {
+		return nil
+	}
+	ids := make([]string, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+	return ids
+}
+
+// isAdminUser reports whether a user has administrative access, either through
+// the built-in admin flag or a role granting the admin action
+func (s *Server) isAdminUser(username string) bool {
+	return s.policy.IsAdmin(s.policySubject(username))
+}
+
+// authorize evaluates an action for a user against the policy engine
+func (s *Server) authorize(username, action string, res policy.Resource) policy.Decision {
+	return s.policy.Authorize(s.policySubject(username), action, res)
+}
+
+// authorizeRequest evaluates an action for the user authenticated on r
+func (s *Server) authorizeRequest(r *http.Request, action string, res policy.Resource) policy.Decision {
+	return s.authorize(s.getCurrentUsername(r), action, res)
+}
+
+// canViewCaptures reports whether the requesting user may see traffic owned by owner
+func (s *Server) canViewCaptures(r *http.Request, owner string) bool {
+	res := policy.Resource{Owner: owner, OwnerGroups: s.userGroupIDs(owner)}
+	return s.authorizeRequest(r, policy.ActionCaptureView, res).Allowed
+}
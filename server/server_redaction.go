@@ -0,0 +1,169 @@
+package chserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/NextChapterSoftware/chissl/server/capture"
+)
+
+// Capture redaction settings. The global rules live in the
+// capture_redaction setting and per-entity rules in
+// capture_redaction_entities, both as JSON.
+
+const (
+	captureRedactionSetting         = "capture_redaction"
+	captureRedactionEntitiesSetting = "capture_redaction_entities"
+)
+
+// loadCaptureRedaction applies the persisted redaction rules to the capture service
+func (s *Server) loadCaptureRedaction() {
+	if s.db == nil || s.capture == nil {
+		return
+	}
+	global := capture.DefaultRedactionSettings()
+	if v, _ := s.db.GetSettingString(captureRedactionSetting, ""); v != "" {
+		if err := json.Unmarshal([]byte(v), &global); err != nil {
+			s.Infof("Ignoring invalid capture redaction settings: %v", err)
+			global = capture.DefaultRedactionSettings()
+		}
+	}
+	var entities map[string]capture.RedactionRules
+	if v, _ := s.db.GetSettingString(captureRedactionEntitiesSetting, ""); v != "" {
+		if err := json.Unmarshal([]byte(v), &entities); err != nil {
+			s.Infof("Ignoring invalid per-entity capture redaction rules: %v", err)
+			entities = nil
+		}
+	}
+	if err := s.capture.SetRedaction(global, entities); err != nil {
+		s.Infof("Failed to apply capture redaction rules: %v", err)
+	}
+}
+
+// saveCaptureRedaction validates, applies and persists the redaction rules
+func (s *Server) saveCaptureRedaction(global capture.RedactionSettings, entities map[string]capture.RedactionRules) (int, error) {
+	if err := s.capture.SetRedaction(global, entities); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if s.db == nil {
+		return http.StatusOK, nil
+	}
+	g, _ := json.Marshal(global)
+	e, _ := json.Marshal(entities)
+	if err := s.db.SetSettingString(captureRedactionSetting, string(g)); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := s.db.SetSettingString(captureRedactionEntitiesSetting, string(e)); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// GET /api/settings/capture-redaction (admin only)
+func (s *Server) handleGetCaptureRedaction(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	global, entities := s.capture.Redaction()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":       global.Enabled,
+		"defaults":      global.Defaults,
+		"rules":         global.Rules,
+		"default_rules": capture.DefaultRedactionRules(),
+		"entities":      entities,
+	})
+}
+
+// PUT /api/settings/capture-redaction {enabled, defaults, rules} (admin only)
+func (s *Server) handleUpdateCaptureRedaction(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var req capture.RedactionSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	_, entities := s.capture.Redaction()
+	if code, err := s.saveCaptureRedaction(req, entities); err != nil {
+		http.Error(w, "Failed to save redaction rules: "+err.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "enabled": req.Enabled, "defaults": req.Defaults, "rules": req.Rules})
+}
+
+// GET /api/capture/{tunnels|listeners|multicast}/{id}/redaction
+func (s *Server) handleGetEntityRedaction(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	if !s.userHasEntityAccess(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	global, entities := s.capture.Redaction()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entity_id":       entityID,
+		"rules":           entities[entityID],
+		"global_enabled":  global.Enabled,
+		"global_defaults": global.Defaults,
+	})
+}
+
+// PUT /api/capture/{tunnels|listeners|multicast}/{id}/redaction {headers, json_paths, patterns, query_params}
+// Entity rules add to the global ones; an empty rule set removes them.
+func (s *Server) handleUpdateEntityRedaction(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	if !s.userOwnsEntity(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var req capture.RedactionRules
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	global, current := s.capture.Redaction()
+	entities := make(map[string]capture.RedactionRules, len(current)+1)
+	for id, rules := range current {
+		entities[id] = rules
+	}
+	if len(req.Headers)+len(req.JSONPaths)+len(req.Patterns)+len(req.QueryParams) == 0 {
+		delete(entities, entityID)
+	} else {
+		entities[entityID] = req
+	}
+	if code, err := s.saveCaptureRedaction(global, entities); err != nil {
+		http.Error(w, "Failed to save redaction rules: "+err.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "entity_id": entityID, "rules": req})
+}
@@ -0,0 +1,274 @@
+package chserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/NextChapterSoftware/chissl/server/capture"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/NextChapterSoftware/chissl/share/tunnel"
+)
+
+// Replay of captured requests. A captured request is re-sent over the live
+// tunnel it was captured on, optionally edited first, and the replayed
+// exchange is captured under the same tunnel as a new connection whose
+// events link back to the original via their "replay_of" meta.
+
+// replayTimeout bounds a replayed request
+const replayTimeout = 30 * time.Second
+
+// replayTarget is a live tunnel remote captured requests can be replayed to
+type replayTarget struct {
+	tun      *tunnel.Tunnel
+	remote   settings.Remote
+	username string
+}
+
+// registerReplayTargets records the bound remotes of a session under their
+// capture IDs until ctx is done. The base session ID is only registered when
+// it is unambiguous.
+func (s *Server) registerReplayTargets(ctx context.Context, sessionID, unameEnc string, tun *tunnel.Tunnel, all settings.Remotes, bound []*settings.Remote) {
+	targets := map[string]replayTarget{}
+	for _, rmt := range bound {
+		t := replayTarget{tun: tun, remote: *rmt, username: tun.Username}
+		for i, r := range all {
+			if r.LocalPort == rmt.LocalPort {
+				targets[fmt.Sprintf("%s-r%d", sessionID, i)] = t
+				break
+			}
+		}
+		lp, _ := strconv.Atoi(rmt.LocalPort)
+		rp, _ := strconv.Atoi(rmt.RemotePort)
+		targets[fmt.Sprintf("tun-%s-%d-%d", unameEnc, lp, rp)] = t
+		if len(bound) == 1 {
+			targets[sessionID] = t
+		}
+	}
+	s.replayMu.Lock()
+	for id, t := range targets {
+		s.replayTargets[id] = t
+	}
+	s.replayMu.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.replayMu.Lock()
+		defer s.replayMu.Unlock()
+		for id := range targets {
+			// A reconnected session may already own the canonical ID
+			if cur, ok := s.replayTargets[id]; ok && cur.tun == tun {
+				delete(s.replayTargets, id)
+			}
+		}
+	}()
+}
+
+// replayRequest selects a captured request and the edits applied before it is re-sent
+type replayRequest struct {
+	ConnID string `json:"conn_id"`
+	Seq    int64  `json:"seq"`
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	// Headers replace captured header values; a null value removes the header
+	Headers map[string]*string `json:"headers,omitempty"`
+	// Body or BodyBase64 replaces the captured body
+	Body       *string `json:"body,omitempty"`
+	BodyBase64 *string `json:"body_base64,omitempty"`
+}
+
+// replayError is a request that cannot be replayed as captured
+type replayError struct {
+	code int
+	msg  string
+}
+
+func (e *replayError) Error() string { return e.msg }
+
+// buildReplayRequest serializes the edited request as HTTP/1.1 for delivery over the tunnel
+func buildReplayRequest(x *capture.Exchange, req replayRequest) ([]byte, error) {
+	head, ok := x.RequestHead()
+	if !ok {
+		return nil, &replayError{http.StatusNotFound, "Captured request not found"}
+	}
+	body := x.RequestBody
+	bodyEdited := req.Body != nil || req.BodyBase64 != nil
+	switch {
+	case req.BodyBase64 != nil:
+		b, err := base64.StdEncoding.DecodeString(*req.BodyBase64)
+		if err != nil {
+			return nil, &replayError{http.StatusBadRequest, "Invalid body_base64"}
+		}
+		body = b
+	case req.Body != nil:
+		body = []byte(*req.Body)
+	case x.Truncated:
+		return nil, &replayError{http.StatusConflict, "Captured request body is truncated; supply a replacement body"}
+	}
+	method, path := head.Method, head.Path
+	if req.Method != "" {
+		method = strings.ToUpper(req.Method)
+	}
+	if req.Path != "" {
+		path = req.Path
+	}
+	header := head.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	for name, v := range req.Headers {
+		if v == nil {
+			header.Del(name)
+		} else {
+			header.Set(name, *v)
+		}
+	}
+
+	// Redacted values cannot be replayed; they must be replaced first
+	var missing []string
+	for _, label := range x.RequestRedactions {
+		kind, name, _ := strings.Cut(label, ":")
+		switch {
+		case kind == "header":
+			if header.Get(name) != capture.RedactedValue {
+				continue
+			}
+		case kind == "query" && req.Path != "":
+			continue
+		case kind != "header" && kind != "query" && bodyEdited:
+			continue
+		}
+		missing = append(missing, label)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, &replayError{http.StatusConflict, "Captured request was redacted; supply replacements for: " + strings.Join(missing, ", ")}
+	}
+
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		return nil, &replayError{http.StatusBadRequest, "Invalid path"}
+	}
+	header.Del("Transfer-Encoding")
+	header.Del("Content-Length")
+	out := &http.Request{
+		Method:        method,
+		URL:           u,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Host:          head.Host,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		// Have the upstream close promptly so one response can be read
+		Close: true,
+	}
+	var buf bytes.Buffer
+	if err := out.Write(&buf); err != nil {
+		return nil, &replayError{http.StatusBadRequest, "Invalid request: " + err.Error()}
+	}
+	return buf.Bytes(), nil
+}
+
+// POST /api/capture/tunnels/{id}/replay {conn_id, seq, method?, path?, headers?, body?, body_base64?}
+func (s *Server) handleReplayCapture(w http.ResponseWriter, r *http.Request) {
+	entityID, entityType := getEntityIDFromPath(r.URL.Path)
+	if entityID == "" || entityType != "tunnel" {
+		http.Error(w, "Invalid tunnel ID", http.StatusBadRequest)
+		return
+	}
+	// Replaying sends traffic through the tunnel, which viewers may not do
+	if !s.userOwnsEntity(r, entityID, entityType) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	if s.capture == nil {
+		http.Error(w, "Capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var req replayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.ConnID == "" || req.Seq <= 0 {
+		http.Error(w, "conn_id and seq are required", http.StatusBadRequest)
+		return
+	}
+	x := capture.FindExchange(s.capture.Events(entityID), req.ConnID, req.Seq)
+	if x == nil || x.Request == nil {
+		http.Error(w, "Captured request not found", http.StatusNotFound)
+		return
+	}
+	payload, err := buildReplayRequest(x, req)
+	if err != nil {
+		if re, ok := err.(*replayError); ok {
+			http.Error(w, re.msg, re.code)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.replayMu.RLock()
+	target, ok := s.replayTargets[entityID]
+	s.replayMu.RUnlock()
+	if !ok {
+		http.Error(w, "Tunnel is not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	connID := fmt.Sprintf("replay-%d", time.Now().UnixNano())
+	replayOf := fmt.Sprintf("%s:%d", req.ConnID, req.Seq)
+	tap := capture.NewReplayTap(s.capture, entityID, target.username, connID, replayOf, 500)
+	tap.OnOpen()
+	_, _ = tap.SrcWriter().Write(payload)
+
+	ctx, cancel := context.WithTimeout(r.Context(), replayTimeout)
+	defer cancel()
+	start := time.Now()
+	raw, err := target.tun.DeliverToRemoteWithResponse(ctx, &target.remote, payload)
+	if err != nil {
+		tap.OnClose(int64(len(payload)), 0)
+		http.Error(w, "Replay failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	_, _ = tap.DstWriter().Write(raw)
+	tap.OnClose(int64(len(payload)), int64(len(raw)))
+
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	if err != nil {
+		http.Error(w, "Invalid upstream response: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	out := map[string]interface{}{
+		"conn_id":     connID,
+		"seq":         1,
+		"replay_of":   replayOf,
+		"status":      res.Status,
+		"code":        res.StatusCode,
+		"header":      res.Header,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}
+	if utf8.Valid(body) {
+		out["body"] = string(body)
+	} else {
+		out["body"] = base64.StdEncoding.EncodeToString(body)
+		out["body_encoding"] = "base64"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,58 @@
+package chserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/NextChapterSoftware/chissl/server/capture"
+)
+
+func TestBuildReplayRequest(t *testing.T) {
+	t.Setenv("CHISEL_CAPTURE_PERSIST", "false")
+	svc := capture.NewService(500, 64*1024)
+	tap := capture.NewReplayTap(svc, "t1", "ann", "c1", "", 500)
+	tap.SrcWriter().Write([]byte("POST /items?page=2 HTTP/1.1\r\nHost: api.local\r\nAuthorization: Bearer secret-token\r\n" +
+		"Content-Type: application/json\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n{\"a\":\r\n2\r\n1}\r\n0\r\n\r\n"))
+	x := capture.FindExchange(svc.GetRecent("t1", 500), "c1", 1)
+	if x == nil {
+		t.Fatalf("captured exchange not found")
+	}
+
+	// The redacted Authorization header must be replaced
+	_, err := buildReplayRequest(x, replayRequest{})
+	if re, ok := err.(*replayError); !ok || re.code != http.StatusConflict || !strings.Contains(re.msg, "header:Authorization") {
+		t.Fatalf("expected redaction conflict, got %v", err)
+	}
+
+	token := "Bearer other"
+	payload, err := buildReplayRequest(x, replayRequest{Method: "put", Headers: map[string]*string{"authorization": &token, "Content-Type": nil}})
+	if err != nil {
+		t.Fatalf("buildReplayRequest: %v", err)
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(payload)))
+	if err != nil {
+		t.Fatalf("invalid replay payload %q: %v", payload, err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if req.Method != "PUT" || req.RequestURI != "/items?page=2" || req.Host != "api.local" || string(body) != `{"a":1}` || req.ContentLength != 7 {
+		t.Fatalf("unexpected replay request %q", payload)
+	}
+	if req.Header.Get("Authorization") != token || req.Header.Get("Content-Type") != "" || len(req.TransferEncoding) != 0 || !req.Close {
+		t.Fatalf("unexpected replay headers: %v", req.Header)
+	}
+
+	// Replayed exchanges link back to the original
+	replay := capture.NewReplayTap(svc, "t1", "ann", "replay-1", "c1:1", 500)
+	replay.OnOpen()
+	replay.SrcWriter().Write(payload)
+	replay.DstWriter().Write([]byte("HTTP/1.1 204 No Content\r\n\r\n"))
+	replay.OnClose(int64(len(payload)), 0)
+	rx := capture.FindExchange(svc.GetRecent("t1", 500), "replay-1", 1)
+	if rx == nil || rx.Response == nil || rx.Request.Meta.(map[string]any)["replay_of"] != "c1:1" {
+		t.Fatalf("expected linked replay exchange, got %+v", rx)
+	}
+}
@@ -71,6 +71,15 @@ func (s *Server) getCurrentUsername(r *http.Request) string {
 	return username
 }
 
+// isUserDisabled reports whether a database user has been deactivated
+func (s *Server) isUserDisabled(username string) bool {
+	if s.db == nil {
+		return false
+	}
+	dbUser, err := s.db.GetUser(username)
+	return err == nil && dbUser.Disabled
+}
+
 // UserAuthMiddleware validates authentication for any user (not just admins)
 func (s *Server) userAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -90,7 +99,7 @@ func (s *Server) userAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			}
 			// Check DB user
 			if s.db != nil {
-				if _, err := s.db.GetUser(username); err == nil {
+				if dbUser, err := s.db.GetUser(username); err == nil && !dbUser.Disabled {
 					ctx := r.Context()
 					ctx = context.WithValue(ctx, "username", username)
 					ctx = context.WithValue(ctx, "authMethod", "session")
@@ -121,7 +130,7 @@ func (s *Server) userAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			// Check API tokens in database
 			if s.db != nil {
 				userToken, err := s.db.ValidateUserToken(token)
-				if err == nil && userToken != nil {
+				if err == nil && userToken != nil && !s.isUserDisabled(userToken.Username) {
 					// API token authentication successful
 					ctx := r.Context()
 					ctx = context.WithValue(ctx, "username", userToken.Username)
@@ -185,7 +194,7 @@ func (s *Server) userAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			// If not found in memory and database is available, check database
 			if !found && s.db != nil {
 				dbUser, err := s.db.GetUser(username)
-				if err == nil && dbUser.Password == password {
+				if err == nil && dbUser.Password == password && !dbUser.Disabled {
 					user = &settings.User{Name: dbUser.Username, Pass: dbUser.Password, IsAdmin: dbUser.IsAdmin}
 					found = true
 				}
@@ -199,6 +208,11 @@ func (s *Server) userAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
+			if s.mfaEnabled(username) {
+				s.ipRateRecord(s.clientIP(r))
+				http.Error(w, "Two-factor authentication is enabled; use an API token", http.StatusUnauthorized)
+				return
+			}
 			// success: reset backoff and continue
 			s.resetLoginBackoffFor(username, s.clientIP(r))
 			// record IP attempt
@@ -235,14 +249,14 @@ func (s *Server) basicAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		// Then DB
 		if s.db != nil {
 			if dbUser, err := s.db.GetUser(username); err == nil {
-				if dbUser != nil && dbUser.Password == password && dbUser.IsAdmin {
+				if dbUser != nil && dbUser.Password == password && !dbUser.Disabled && s.isAdminUser(username) && !s.mfaEnabled(username) {
 					next.ServeHTTP(w, r)
 					return
 				}
 			}
 		}
 		// Finally authfile/in-memory
-		if u, found := s.users.Get(username); found && u.Pass == password && u.IsAdmin {
+		if u, found := s.users.Get(username); found && u.Pass == password && s.isAdminUser(username) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -267,9 +281,9 @@ func (s *Server) combinedAuthMiddleware(next http.HandlerFunc) http.HandlerFunc
 					return
 				}
 			}
-			// DB admin
+			// DB admin (built-in flag or admin role)
 			if s.db != nil {
-				if dbUser, err := s.db.GetUser(username); err == nil && dbUser.IsAdmin {
+				if dbUser, err := s.db.GetUser(username); err == nil && !dbUser.Disabled && s.isAdminUser(username) {
 					ctx := r.Context()
 					ctx = context.WithValue(ctx, "username", username)
 					ctx = context.WithValue(ctx, "authMethod", "session")
@@ -278,7 +292,7 @@ func (s *Server) combinedAuthMiddleware(next http.HandlerFunc) http.HandlerFunc
 				}
 			}
 			// Authfile admin
-			if user, found := s.users.Get(username); found && user.IsAdmin {
+			if user, found := s.users.Get(username); found && s.isAdminUser(username) {
 				ctx := r.Context()
 				ctx = context.WithValue(ctx, "username", username)
 				ctx = context.WithValue(ctx, "user", user)
@@ -315,6 +329,10 @@ func (s *Server) combinedAuthMiddleware(next http.HandlerFunc) http.HandlerFunc
 							return
 						}
 					}
+					if dbUser.Disabled {
+						http.Error(w, "Unauthorized", http.StatusUnauthorized)
+						return
+					}
 
 					// Add user info to context
 					ctx := r.Context()
@@ -367,12 +385,12 @@ func (s *Server) combinedAuthMiddleware(next http.HandlerFunc) http.HandlerFunc
 			// If not found in memory and database is available, check database
 			if !found && s.db != nil {
 				dbUser, err := s.db.GetUser(username)
-				if err == nil && dbUser.Password == password {
+				if err == nil && dbUser.Password == password && !dbUser.Disabled {
 					user = &settings.User{Name: dbUser.Username, Pass: dbUser.Password, IsAdmin: dbUser.IsAdmin}
 					found = true
 				}
 			}
-			if !found || !user.IsAdmin {
+			if !found || !s.isAdminUser(username) {
 				if username != "" {
 					s.recordLoginFailureFor(username, s.clientIP(r))
 				}
@@ -381,6 +399,11 @@ func (s *Server) combinedAuthMiddleware(next http.HandlerFunc) http.HandlerFunc
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
+			if s.mfaEnabled(username) {
+				s.ipRateRecord(s.clientIP(r))
+				http.Error(w, "Two-factor authentication is enabled; use an API token", http.StatusUnauthorized)
+				return
+			}
 			// success: reset backoff and continue
 			s.resetLoginBackoffFor(username, s.clientIP(r))
 			// record IP attempt
@@ -451,6 +474,34 @@ func (s *Server) cleanupUserResources(username string) error {
 		s.Debugf("Failed to cleanup user auth sources: %v", err)
 	}
 
+	// 7. Delete user's MFA enrollment
+	if s.db != nil {
+		if err := s.db.DeleteUserMFA(username); err != nil {
+			s.Debugf("Failed to cleanup user MFA: %v", err)
+		}
+	}
+
+	// 8. Remove user from all groups
+	if s.db != nil {
+		if err := s.db.DeleteUserGroupMemberships(username); err != nil {
+			s.Debugf("Failed to cleanup user group memberships: %v", err)
+		}
+	}
+
+	// 9. Delete user's SCIM mapping
+	if s.db != nil {
+		if err := s.db.DeleteSCIMUser(username); err != nil {
+			s.Debugf("Failed to cleanup SCIM mapping: %v", err)
+		}
+	}
+
+	// 10. Delete user's role bindings
+	if s.db != nil {
+		if err := s.db.DeleteSubjectRoleBindings(database.RoleSubjectUser, username); err != nil {
+			s.Debugf("Failed to cleanup role bindings: %v", err)
+		}
+	}
+
 	s.Infof("Completed cleanup for user: %s", username)
 	return nil
 }
@@ -0,0 +1,1226 @@
+package chserver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/database"
+	"github.com/google/uuid"
+)
+
+// SCIM 2.0 provisioning (RFC 7643 / RFC 7644). Users map onto database.User
+// and groups onto database.Group; requests are authorized by a dedicated
+// bearer token whose SHA-256 is kept in the settings table.
+
+const (
+	scimSchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimSchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimSchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimSchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSchemaSPConfig     = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimSchemaResourceType = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+
+	scimContentType     = "application/scim+json"
+	scimTokenSetting    = "scim_token_hash"
+	scimMaxResults      = 200
+	scimPathPrefix      = "/scim/v2"
+	scimPlaceholderPass = "SCIM_USER_"
+)
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type scimUser struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	UserName    string           `json:"userName"`
+	Name        *scimName        `json:"name,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Emails      []scimMultiValue `json:"emails,omitempty"`
+	Active      *bool            `json:"active,omitempty"`
+	Password    string           `json:"password,omitempty"`
+	Groups      []scimMultiValue `json:"groups,omitempty"`
+	Meta        *scimMeta        `json:"meta,omitempty"`
+}
+
+type scimGroup struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []scimMultiValue `json:"members,omitempty"`
+	Meta        *scimMeta        `json:"meta,omitempty"`
+}
+
+type scimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+type scimPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// scimError is returned to clients in the RFC 7644 §3.12 error format
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string { return e.detail }
+
+func scimErrorf(status int, scimType, format string, args ...interface{}) *scimError {
+	return &scimError{status: status, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+func writeSCIMError(w http.ResponseWriter, err error) {
+	se, ok := err.(*scimError)
+	if !ok {
+		se = &scimError{status: http.StatusInternalServerError, detail: err.Error()}
+	}
+	body := map[string]interface{}{
+		"schemas": []string{scimSchemaError},
+		"status":  strconv.Itoa(se.status),
+		"detail":  se.detail,
+	}
+	if se.scimType != "" {
+		body["scimType"] = se.scimType
+	}
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(se.status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeSCIM(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// hashSCIMToken returns the stored form of a SCIM bearer token
+func hashSCIMToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// scimAuthMiddleware accepts only the dedicated SCIM bearer token
+func (s *Server) scimAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.db == nil {
+			writeSCIMError(w, scimErrorf(http.StatusServiceUnavailable, "", "SCIM requires a database"))
+			return
+		}
+		ip := s.clientIP(r)
+		if locked, retry := s.ipRateCheck(ip); locked {
+			if retry > 0 {
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retry.Seconds())))
+			}
+			writeSCIMError(w, scimErrorf(http.StatusTooManyRequests, "", "Too many requests"))
+			return
+		}
+		expected, err := s.db.GetSettingString(scimTokenSetting, "")
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if err != nil || expected == "" || token == "" ||
+			subtle.ConstantTimeCompare([]byte(hashSCIMToken(token)), []byte(expected)) != 1 {
+			s.ipRateRecord(ip)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			writeSCIMError(w, scimErrorf(http.StatusUnauthorized, "", "Invalid SCIM bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// handleSCIM dispatches /scim/v2/* requests
+func (s *Server) handleSCIM(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, scimPathPrefix), "/")
+	parts := strings.SplitN(rest, "/", 2)
+	id := ""
+	if len(parts) == 2 {
+		id = parts[1]
+	}
+
+	var err error
+	switch parts[0] {
+	case "ServiceProviderConfig":
+		s.handleSCIMServiceProviderConfig(w, r)
+		return
+	case "ResourceTypes":
+		s.handleSCIMResourceTypes(w, r)
+		return
+	case "Users":
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			err = s.handleSCIMListUsers(w, r)
+		case id == "" && r.Method == http.MethodPost:
+			err = s.handleSCIMCreateUser(w, r)
+		case id != "" && r.Method == http.MethodGet:
+			err = s.handleSCIMGetUser(w, r, id)
+		case id != "" && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+			err = s.handleSCIMUpdateUser(w, r, id)
+		case id != "" && r.Method == http.MethodDelete:
+			err = s.handleSCIMDeleteUser(w, r, id)
+		default:
+			err = scimErrorf(http.StatusMethodNotAllowed, "", "Method not allowed")
+		}
+	case "Groups":
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			err = s.handleSCIMListGroups(w, r)
+		case id == "" && r.Method == http.MethodPost:
+			err = s.handleSCIMCreateGroup(w, r)
+		case id != "" && r.Method == http.MethodGet:
+			err = s.handleSCIMGetGroup(w, r, id)
+		case id != "" && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+			err = s.handleSCIMUpdateGroup(w, r, id)
+		case id != "" && r.Method == http.MethodDelete:
+			err = s.handleSCIMDeleteGroup(w, r, id)
+		default:
+			err = scimErrorf(http.StatusMethodNotAllowed, "", "Method not allowed")
+		}
+	default:
+		err = scimErrorf(http.StatusNotFound, "", "Unknown SCIM resource")
+	}
+	if err != nil {
+		writeSCIMError(w, err)
+	}
+}
+
+func (s *Server) handleSCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scimSchemaSPConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": map[string]bool{"supported": true},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Dedicated SCIM token generated in Server Settings",
+			"primary":     true,
+		}},
+	})
+}
+
+func (s *Server) handleSCIMResourceTypes(w http.ResponseWriter, r *http.Request) {
+	base := s.scimBaseURL(r)
+	types := []interface{}{
+		map[string]interface{}{
+			"schemas": []string{scimSchemaResourceType}, "id": "User", "name": "User",
+			"endpoint": "/Users", "schema": scimSchemaUser,
+			"meta": map[string]string{"resourceType": "ResourceType", "location": base + "/ResourceTypes/User"},
+		},
+		map[string]interface{}{
+			"schemas": []string{scimSchemaResourceType}, "id": "Group", "name": "Group",
+			"endpoint": "/Groups", "schema": scimSchemaGroup,
+			"meta": map[string]string{"resourceType": "ResourceType", "location": base + "/ResourceTypes/Group"},
+		},
+	}
+	writeSCIM(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimSchemaListResponse},
+		TotalResults: len(types),
+		StartIndex:   1,
+		ItemsPerPage: len(types),
+		Resources:    types,
+	})
+}
+
+// scimBaseURL returns the absolute URL of the SCIM root for meta.location
+func (s *Server) scimBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + scimPathPrefix
+}
+
+// scimMapping returns the SCIM mapping of a user SCIM manages, or nil. SCIM
+// only sees the accounts it provisioned, which got a mapping when created;
+// local accounts and administrators, by flag or by role, are out of its
+// reach, so the SCIM token cannot be used to take them over.
+func (s *Server) scimMapping(u *database.User) (*database.SCIMUser, error) {
+	if u == nil || s.isAdminUser(u.Username) {
+		return nil, nil
+	}
+	return s.db.GetSCIMUserByUsername(u.Username)
+}
+
+// lookupSCIMUser resolves a SCIM user ID to its mapping and database user,
+// answering 404 for users SCIM does not manage
+func (s *Server) lookupSCIMUser(id string) (*database.SCIMUser, *database.User, error) {
+	m, err := s.db.GetSCIMUser(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m == nil {
+		return nil, nil, scimErrorf(http.StatusNotFound, "", "User %s not found", id)
+	}
+	u, err := s.db.GetUser(m.Username)
+	if err != nil || s.isAdminUser(u.Username) {
+		return nil, nil, scimErrorf(http.StatusNotFound, "", "User %s not found", id)
+	}
+	return m, u, nil
+}
+
+func (s *Server) scimUserResource(r *http.Request, u *database.User, m *database.SCIMUser, withGroups bool) *scimUser {
+	active := !u.Disabled
+	res := &scimUser{
+		Schemas:     []string{scimSchemaUser},
+		ID:          m.ID,
+		UserName:    u.Username,
+		DisplayName: u.DisplayName,
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      u.CreatedAt,
+			LastModified: u.UpdatedAt,
+			Location:     s.scimBaseURL(r) + "/Users/" + m.ID,
+		},
+	}
+	if m.ExternalID != nil {
+		res.ExternalID = *m.ExternalID
+	}
+	if u.DisplayName != "" {
+		res.Name = &scimName{Formatted: u.DisplayName}
+	}
+	if u.Email != "" {
+		res.Emails = []scimMultiValue{{Value: u.Email, Type: "work", Primary: true}}
+	}
+	if withGroups {
+		if groups, err := s.db.ListUserGroups(u.Username); err == nil {
+			for _, g := range groups {
+				if g.Source != database.GroupSourceSCIM {
+					continue // local groups stay hidden, like local users
+				}
+				res.Groups = append(res.Groups, scimMultiValue{
+					Value: g.ID, Display: g.Name, Ref: s.scimBaseURL(r) + "/Groups/" + g.ID,
+				})
+			}
+		}
+	}
+	return res
+}
+
+func (s *Server) scimGroupResource(r *http.Request, g *database.Group, withMembers bool) (*scimGroup, error) {
+	res := &scimGroup{
+		Schemas:     []string{scimSchemaGroup},
+		ID:          g.ID,
+		DisplayName: g.Name,
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt,
+			LastModified: g.UpdatedAt,
+			Location:     s.scimBaseURL(r) + "/Groups/" + g.ID,
+		},
+	}
+	if g.ExternalID != nil {
+		res.ExternalID = *g.ExternalID
+	}
+	if withMembers {
+		members, err := s.db.ListGroupMembers(g.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, username := range members {
+			u, err := s.db.GetUser(username)
+			if err != nil {
+				continue
+			}
+			m, err := s.scimMapping(u)
+			if err != nil {
+				return nil, err
+			}
+			if m == nil {
+				continue // members SCIM does not manage stay hidden
+			}
+			res.Members = append(res.Members, scimMultiValue{
+				Value: m.ID, Display: username, Ref: s.scimBaseURL(r) + "/Users/" + m.ID,
+			})
+		}
+	}
+	return res, nil
+}
+
+// scimUserValues exposes user attributes to the filter evaluator
+func scimUserValues(res *scimUser) func(string) []string {
+	return func(attr string) []string {
+		switch attr {
+		case "id":
+			return []string{res.ID}
+		case "externalid":
+			return []string{res.ExternalID}
+		case "username":
+			return []string{res.UserName}
+		case "displayname":
+			return []string{res.DisplayName}
+		case "name.formatted":
+			if res.Name != nil {
+				return []string{res.Name.Formatted}
+			}
+		case "emails", "emails.value":
+			var out []string
+			for _, e := range res.Emails {
+				out = append(out, e.Value)
+			}
+			return out
+		case "active":
+			return []string{strconv.FormatBool(res.Active == nil || *res.Active)}
+		case "meta.created":
+			return []string{res.Meta.Created.UTC().Format(time.RFC3339)}
+		case "meta.lastmodified":
+			return []string{res.Meta.LastModified.UTC().Format(time.RFC3339)}
+		}
+		return nil
+	}
+}
+
+// scimGroupValues exposes group attributes to the filter evaluator
+func scimGroupValues(res *scimGroup) func(string) []string {
+	return func(attr string) []string {
+		switch attr {
+		case "id":
+			return []string{res.ID}
+		case "externalid":
+			return []string{res.ExternalID}
+		case "displayname":
+			return []string{res.DisplayName}
+		case "members", "members.value":
+			var out []string
+			for _, m := range res.Members {
+				out = append(out, m.Value)
+			}
+			return out
+		case "meta.created":
+			return []string{res.Meta.Created.UTC().Format(time.RFC3339)}
+		case "meta.lastmodified":
+			return []string{res.Meta.LastModified.UTC().Format(time.RFC3339)}
+		}
+		return nil
+	}
+}
+
+// scimPage applies startIndex/count (1-based, RFC 7644 §3.4.2.4) to a result set
+func scimPage(r *http.Request, all []interface{}) scimListResponse {
+	start, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if start < 1 {
+		start = 1
+	}
+	count := scimMaxResults
+	if c := r.URL.Query().Get("count"); c != "" {
+		if n, err := strconv.Atoi(c); err == nil {
+			count = n
+		}
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > scimMaxResults {
+		count = scimMaxResults
+	}
+
+	page := []interface{}{}
+	if start <= len(all) {
+		end := start - 1 + count
+		if end > len(all) {
+			end = len(all)
+		}
+		page = all[start-1 : end]
+	}
+	return scimListResponse{
+		Schemas:      []string{scimSchemaListResponse},
+		TotalResults: len(all),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+// scimExcluded reports whether an attribute was listed in excludedAttributes
+func scimExcluded(r *http.Request, attr string) bool {
+	for _, a := range strings.Split(r.URL.Query().Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(a), attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /scim/v2/Users
+func (s *Server) handleSCIMListUsers(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseSCIMFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		return scimErrorf(http.StatusBadRequest, "invalidFilter", "%v", err)
+	}
+	users, err := s.db.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	var matched []interface{}
+	for _, u := range users {
+		m, err := s.scimMapping(u)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+		res := s.scimUserResource(r, u, m, false)
+		if filter.match(scimUserValues(res)) {
+			if !scimExcluded(r, "groups") {
+				res = s.scimUserResource(r, u, m, true)
+			}
+			matched = append(matched, res)
+		}
+	}
+	writeSCIM(w, http.StatusOK, scimPage(r, matched))
+	return nil
+}
+
+// GET /scim/v2/Users/{id}
+func (s *Server) handleSCIMGetUser(w http.ResponseWriter, r *http.Request, id string) error {
+	m, u, err := s.lookupSCIMUser(id)
+	if err != nil {
+		return err
+	}
+	writeSCIM(w, http.StatusOK, s.scimUserResource(r, u, m, !scimExcluded(r, "groups")))
+	return nil
+}
+
+// POST /scim/v2/Users
+func (s *Server) handleSCIMCreateUser(w http.ResponseWriter, r *http.Request) error {
+	var req scimUser
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Invalid JSON: %v", err)
+	}
+	if strings.TrimSpace(req.UserName) == "" {
+		return scimErrorf(http.StatusBadRequest, "invalidValue", "userName is required")
+	}
+	if _, err := s.db.GetUser(req.UserName); err == nil {
+		return scimErrorf(http.StatusConflict, "uniqueness", "User %s already exists", req.UserName)
+	}
+
+	password := req.Password
+	if password == "" {
+		password = scimPlaceholderPass + randomHex(16) // IdP-managed users sign in via SSO
+	}
+	u := &database.User{
+		Username:    req.UserName,
+		Password:    password,
+		Email:       scimPrimaryEmail(req.Emails),
+		DisplayName: scimDisplayName(&req),
+	}
+	if err := s.db.CreateUser(u); err != nil {
+		return err
+	}
+	m := &database.SCIMUser{ID: uuid.New().String(), Username: u.Username}
+	if req.ExternalID != "" {
+		m.ExternalID = &req.ExternalID
+	}
+	if err := s.db.SaveSCIMUser(m); err != nil {
+		return err
+	}
+	// Record provenance so a later SSO login may claim the account
+	if err := s.db.CreateUserAuthSource(&database.UserAuthSource{
+		Username: u.Username, AuthSource: database.AuthSourceSCIMProvisioned, ExternalID: m.ExternalID,
+	}); err != nil {
+		s.Debugf("Failed to record SCIM auth source for %s: %v", u.Username, err)
+	}
+	if req.Active != nil && !*req.Active {
+		if err := s.db.SetUserDisabled(u.Username, true); err != nil {
+			return err
+		}
+		u.Disabled = true
+	}
+
+	s.Infof("SCIM provisioned user %s", u.Username)
+	writeSCIM(w, http.StatusCreated, s.scimUserResource(r, u, m, false))
+	return nil
+}
+
+// PUT and PATCH /scim/v2/Users/{id}
+func (s *Server) handleSCIMUpdateUser(w http.ResponseWriter, r *http.Request, id string) error {
+	m, u, err := s.lookupSCIMUser(id)
+	if err != nil {
+		return err
+	}
+	res := s.scimUserResource(r, u, m, false)
+
+	if r.Method == http.MethodPut {
+		var req scimUser
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Invalid JSON: %v", err)
+		}
+		if req.Active == nil {
+			t := true
+			req.Active = &t
+		}
+		req.ID = res.ID
+		res = &req
+	} else {
+		var patch scimPatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Invalid JSON: %v", err)
+		}
+		for _, op := range patch.Operations {
+			if err := applySCIMUserPatch(res, op); err != nil {
+				return err
+			}
+		}
+	}
+
+	if res.UserName != "" && res.UserName != u.Username {
+		return scimErrorf(http.StatusBadRequest, "mutability", "userName cannot be changed")
+	}
+
+	u.Email = scimPrimaryEmail(res.Emails)
+	u.DisplayName = scimDisplayName(res)
+	if res.Password != "" {
+		u.Password = res.Password
+	}
+	if err := s.db.UpdateUser(u); err != nil {
+		return err
+	}
+
+	if res.ExternalID == "" {
+		m.ExternalID = nil
+	} else {
+		m.ExternalID = &res.ExternalID
+	}
+	if err := s.db.SaveSCIMUser(m); err != nil {
+		return err
+	}
+
+	active := res.Active == nil || *res.Active
+	if active == u.Disabled {
+		if err := s.db.SetUserDisabled(u.Username, !active); err != nil {
+			return err
+		}
+		u.Disabled = !active
+		if !active {
+			// Deactivation takes effect immediately for connected clients
+			s.closeUserSessions(u.Username)
+			s.recordSecurityEvent("scim_user_deactivated", "info", u.Username, s.clientIP(r), "User deactivated via SCIM")
+		}
+	}
+
+	u, err = s.db.GetUser(u.Username)
+	if err != nil {
+		return err
+	}
+	writeSCIM(w, http.StatusOK, s.scimUserResource(r, u, m, !scimExcluded(r, "groups")))
+	return nil
+}
+
+// DELETE /scim/v2/Users/{id} deprovisions the user and everything they own
+func (s *Server) handleSCIMDeleteUser(w http.ResponseWriter, r *http.Request, id string) error {
+	_, u, err := s.lookupSCIMUser(id)
+	if err != nil {
+		return err
+	}
+	if err := s.cleanupUserResources(u.Username); err != nil {
+		return err
+	}
+	if err := s.db.DeleteUser(u.Username); err != nil {
+		return err
+	}
+	s.Infof("SCIM deprovisioned user %s", u.Username)
+	s.recordSecurityEvent("scim_user_deleted", "info", u.Username, s.clientIP(r), "User deprovisioned via SCIM")
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// applySCIMUserPatch applies one PATCH operation (RFC 7644 §3.5.2) to a user resource.
+// Attributes the server does not store are accepted and ignored.
+func applySCIMUserPatch(res *scimUser, op scimPatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Unsupported operation %q", op.Op)
+	}
+	if op.Path == "" {
+		if kind == "remove" {
+			return scimErrorf(http.StatusBadRequest, "noTarget", "remove requires a path")
+		}
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return scimErrorf(http.StatusBadRequest, "invalidValue", "value must be an object when path is omitted")
+		}
+		for key, value := range values {
+			if err := applySCIMUserPatch(res, scimPatchOperation{Op: kind, Path: key, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parseSCIMPatchPath(op.Path)
+	if err != nil {
+		return scimErrorf(http.StatusBadRequest, "invalidPath", "%v", err)
+	}
+	switch path.attr {
+	case "active":
+		if kind == "remove" {
+			return scimErrorf(http.StatusBadRequest, "mutability", "active cannot be removed")
+		}
+		b, err := scimBool(op.Value)
+		if err != nil {
+			return err
+		}
+		res.Active = &b
+	case "username":
+		if kind == "remove" {
+			return scimErrorf(http.StatusBadRequest, "mutability", "userName cannot be removed")
+		}
+		res.UserName = scimString(op.Value)
+	case "displayname":
+		res.DisplayName = scimRemoveOr(kind, op.Value)
+	case "externalid":
+		res.ExternalID = scimRemoveOr(kind, op.Value)
+	case "password":
+		res.Password = scimRemoveOr(kind, op.Value)
+	case "name":
+		if res.Name == nil {
+			res.Name = &scimName{}
+		}
+		if path.subAttr == "" {
+			if kind == "remove" {
+				res.Name = nil
+				return nil
+			}
+			obj, _ := op.Value.(map[string]interface{})
+			for k, v := range obj {
+				applySCIMName(res.Name, strings.ToLower(k), scimString(v))
+			}
+			return nil
+		}
+		applySCIMName(res.Name, path.subAttr, scimRemoveOr(kind, op.Value))
+	case "emails":
+		return applySCIMEmails(res, kind, path, op.Value)
+	}
+	return nil
+}
+
+func applySCIMName(n *scimName, sub, value string) {
+	switch sub {
+	case "formatted":
+		n.Formatted = value
+	case "givenname":
+		n.GivenName = value
+	case "familyname":
+		n.FamilyName = value
+	}
+}
+
+func applySCIMEmails(res *scimUser, kind string, path *scimPatchPath, value interface{}) error {
+	if path.filterAttr != "" {
+		// emails[type eq "work"].value
+		for i := range res.Emails {
+			if strings.EqualFold(scimEmailAttr(res.Emails[i], path.filterAttr), path.filterValue) {
+				if kind == "remove" {
+					res.Emails = append(res.Emails[:i], res.Emails[i+1:]...)
+				} else {
+					res.Emails[i].Value = scimString(value)
+				}
+				return nil
+			}
+		}
+		if kind != "remove" && path.filterAttr == "type" {
+			res.Emails = append(res.Emails, scimMultiValue{Type: path.filterValue, Value: scimString(value), Primary: len(res.Emails) == 0})
+		}
+		return nil
+	}
+	if kind == "remove" {
+		res.Emails = nil
+		return nil
+	}
+	if path.subAttr == "value" {
+		if len(res.Emails) == 0 {
+			res.Emails = []scimMultiValue{{Type: "work", Primary: true}}
+		}
+		res.Emails[0].Value = scimString(value)
+		return nil
+	}
+	var emails []scimMultiValue
+	raw, _ := json.Marshal(value)
+	if err := json.Unmarshal(raw, &emails); err != nil {
+		return scimErrorf(http.StatusBadRequest, "invalidValue", "emails must be a list")
+	}
+	if kind == "replace" {
+		res.Emails = emails
+	} else {
+		res.Emails = append(emails, res.Emails...)
+	}
+	return nil
+}
+
+func scimEmailAttr(e scimMultiValue, attr string) string {
+	switch attr {
+	case "type":
+		return e.Type
+	case "value":
+		return e.Value
+	case "primary":
+		return strconv.FormatBool(e.Primary)
+	}
+	return ""
+}
+
+// GET /scim/v2/Groups
+func (s *Server) handleSCIMListGroups(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseSCIMFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		return scimErrorf(http.StatusBadRequest, "invalidFilter", "%v", err)
+	}
+	groups, err := s.db.ListGroups()
+	if err != nil {
+		return err
+	}
+
+	// Members are needed to evaluate member filters even if they are excluded from output
+	withMembers := !scimExcluded(r, "members") || strings.Contains(strings.ToLower(r.URL.Query().Get("filter")), "members")
+	var matched []interface{}
+	for _, g := range groups {
+		if g.Source != database.GroupSourceSCIM {
+			continue
+		}
+		res, err := s.scimGroupResource(r, g, withMembers)
+		if err != nil {
+			return err
+		}
+		if filter.match(scimGroupValues(res)) {
+			if scimExcluded(r, "members") {
+				res.Members = nil
+			}
+			matched = append(matched, res)
+		}
+	}
+	writeSCIM(w, http.StatusOK, scimPage(r, matched))
+	return nil
+}
+
+// GET /scim/v2/Groups/{id}
+func (s *Server) handleSCIMGetGroup(w http.ResponseWriter, r *http.Request, id string) error {
+	g, err := s.lookupSCIMGroup(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.scimGroupResource(r, g, !scimExcluded(r, "members"))
+	if err != nil {
+		return err
+	}
+	writeSCIM(w, http.StatusOK, res)
+	return nil
+}
+
+// POST /scim/v2/Groups
+func (s *Server) handleSCIMCreateGroup(w http.ResponseWriter, r *http.Request) error {
+	var req scimGroup
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Invalid JSON: %v", err)
+	}
+	if strings.TrimSpace(req.DisplayName) == "" {
+		return scimErrorf(http.StatusBadRequest, "invalidValue", "displayName is required")
+	}
+	if existing, err := s.db.GetGroupByName(req.DisplayName); err != nil {
+		return err
+	} else if existing != nil {
+		return scimErrorf(http.StatusConflict, "uniqueness", "Group %s already exists", req.DisplayName)
+	}
+	usernames, err := s.scimMemberUsernames(req.Members)
+	if err != nil {
+		return err
+	}
+
+	g := &database.Group{Name: req.DisplayName, Source: database.GroupSourceSCIM}
+	if req.ExternalID != "" {
+		g.ExternalID = &req.ExternalID
+	}
+	if err := s.db.CreateGroup(g); err != nil {
+		return err
+	}
+	if err := s.db.SetGroupMembers(g.ID, usernames); err != nil {
+		return err
+	}
+
+	res, err := s.scimGroupResource(r, g, true)
+	if err != nil {
+		return err
+	}
+	writeSCIM(w, http.StatusCreated, res)
+	return nil
+}
+
+// PUT and PATCH /scim/v2/Groups/{id}
+func (s *Server) handleSCIMUpdateGroup(w http.ResponseWriter, r *http.Request, id string) error {
+	g, err := s.lookupSCIMGroup(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.scimGroupResource(r, g, true)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodPut {
+		var req scimGroup
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Invalid JSON: %v", err)
+		}
+		req.ID = res.ID
+		res = &req
+	} else {
+		var patch scimPatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Invalid JSON: %v", err)
+		}
+		for _, op := range patch.Operations {
+			if err := applySCIMGroupPatch(res, op); err != nil {
+				return err
+			}
+		}
+	}
+
+	if strings.TrimSpace(res.DisplayName) == "" {
+		return scimErrorf(http.StatusBadRequest, "invalidValue", "displayName is required")
+	}
+	if res.DisplayName != g.Name {
+		if existing, err := s.db.GetGroupByName(res.DisplayName); err != nil {
+			return err
+		} else if existing != nil {
+			return scimErrorf(http.StatusConflict, "uniqueness", "Group %s already exists", res.DisplayName)
+		}
+	}
+	usernames, err := s.scimMemberUsernames(res.Members)
+	if err != nil {
+		return err
+	}
+	if usernames, err = s.keepUnmanagedMembers(g.ID, usernames); err != nil {
+		return err
+	}
+
+	g.Name = res.DisplayName
+	g.ExternalID = nil
+	if res.ExternalID != "" {
+		g.ExternalID = &res.ExternalID
+	}
+	if err := s.db.UpdateGroup(g); err != nil {
+		return err
+	}
+	if err := s.db.SetGroupMembers(g.ID, usernames); err != nil {
+		return err
+	}
+
+	out, err := s.scimGroupResource(r, g, !scimExcluded(r, "members"))
+	if err != nil {
+		return err
+	}
+	writeSCIM(w, http.StatusOK, out)
+	return nil
+}
+
+// DELETE /scim/v2/Groups/{id}
+func (s *Server) handleSCIMDeleteGroup(w http.ResponseWriter, r *http.Request, id string) error {
+	g, err := s.lookupSCIMGroup(id)
+	if err != nil {
+		return err
+	}
+	if err := s.db.DeleteGroup(g.ID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// lookupSCIMGroup resolves a SCIM group ID, answering 404 for groups SCIM did
+// not create. Local groups may carry role bindings an administrator made, so
+// SCIM must not rename, delete or add its users to them.
+func (s *Server) lookupSCIMGroup(id string) (*database.Group, error) {
+	g, err := s.db.GetGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil || g.Source != database.GroupSourceSCIM {
+		return nil, scimErrorf(http.StatusNotFound, "", "Group %s not found", id)
+	}
+	return g, nil
+}
+
+// scimMemberUsernames maps member values (SCIM user IDs) to usernames
+func (s *Server) scimMemberUsernames(members []scimMultiValue) ([]string, error) {
+	usernames := make([]string, 0, len(members))
+	for _, member := range members {
+		m, _, err := s.lookupSCIMUser(member.Value)
+		if se, ok := err.(*scimError); ok && se.status == http.StatusNotFound {
+			return nil, scimErrorf(http.StatusBadRequest, "invalidValue", "Unknown member %s", member.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, m.Username)
+	}
+	return usernames, nil
+}
+
+// keepUnmanagedMembers adds the current members of a group that SCIM does not
+// manage to usernames, so replacing the members SCIM sees leaves them alone
+func (s *Server) keepUnmanagedMembers(groupID string, usernames []string) ([]string, error) {
+	current, err := s.db.ListGroupMembers(groupID)
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range current {
+		u, err := s.db.GetUser(username)
+		if err != nil {
+			continue
+		}
+		if m, err := s.scimMapping(u); err != nil {
+			return nil, err
+		} else if m == nil {
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames, nil
+}
+
+// applySCIMGroupPatch applies one PATCH operation to a group resource
+func applySCIMGroupPatch(res *scimGroup, op scimPatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Unsupported operation %q", op.Op)
+	}
+	if op.Path == "" {
+		if kind == "remove" {
+			return scimErrorf(http.StatusBadRequest, "noTarget", "remove requires a path")
+		}
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return scimErrorf(http.StatusBadRequest, "invalidValue", "value must be an object when path is omitted")
+		}
+		for key, value := range values {
+			if err := applySCIMGroupPatch(res, scimPatchOperation{Op: kind, Path: key, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parseSCIMPatchPath(op.Path)
+	if err != nil {
+		return scimErrorf(http.StatusBadRequest, "invalidPath", "%v", err)
+	}
+	switch path.attr {
+	case "displayname":
+		if kind == "remove" {
+			return scimErrorf(http.StatusBadRequest, "mutability", "displayName cannot be removed")
+		}
+		res.DisplayName = scimString(op.Value)
+	case "externalid":
+		res.ExternalID = scimRemoveOr(kind, op.Value)
+	case "members":
+		if path.filterAttr != "" {
+			// members[value eq "id"]
+			if kind != "remove" || path.filterAttr != "value" {
+				return scimErrorf(http.StatusBadRequest, "invalidPath", "unsupported members path %q", op.Path)
+			}
+			res.Members = removeSCIMMembers(res.Members, map[string]bool{path.filterValue: true})
+			return nil
+		}
+		var members []scimMultiValue
+		if op.Value != nil {
+			raw, _ := json.Marshal(op.Value)
+			if err := json.Unmarshal(raw, &members); err != nil {
+				return scimErrorf(http.StatusBadRequest, "invalidValue", "members must be a list")
+			}
+		}
+		switch kind {
+		case "replace":
+			res.Members = members
+		case "add":
+			res.Members = append(res.Members, members...)
+		case "remove":
+			if len(members) == 0 {
+				res.Members = nil
+				return nil
+			}
+			drop := make(map[string]bool, len(members))
+			for _, m := range members {
+				drop[m.Value] = true
+			}
+			res.Members = removeSCIMMembers(res.Members, drop)
+		}
+	default:
+		return scimErrorf(http.StatusBadRequest, "invalidPath", "unsupported attribute %q", op.Path)
+	}
+	return nil
+}
+
+func removeSCIMMembers(members []scimMultiValue, drop map[string]bool) []scimMultiValue {
+	out := members[:0]
+	for _, m := range members {
+		if !drop[m.Value] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func scimPrimaryEmail(emails []scimMultiValue) string {
+	for _, e := range emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+func scimDisplayName(res *scimUser) string {
+	if res.DisplayName != "" {
+		return res.DisplayName
+	}
+	if res.Name != nil {
+		if res.Name.Formatted != "" {
+			return res.Name.Formatted
+		}
+		return strings.TrimSpace(res.Name.GivenName + " " + res.Name.FamilyName)
+	}
+	return ""
+}
+
+func scimString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func scimRemoveOr(kind string, v interface{}) string {
+	if kind == "remove" {
+		return ""
+	}
+	return scimString(v)
+}
+
+// scimBool accepts JSON booleans and the "True"/"False" strings some IdPs send
+func scimBool(v interface{}) (bool, error) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case string:
+		if b, err := strconv.ParseBool(strings.ToLower(t)); err == nil {
+			return b, nil
+		}
+	}
+	return false, scimErrorf(http.StatusBadRequest, "invalidValue", "expected a boolean, got %v", v)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// GET /api/settings/scim (admin only)
+func (s *Server) handleGetSCIMSettings(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	enabled := false
+	if s.db != nil {
+		hash, _ := s.db.GetSettingString(scimTokenSetting, "")
+		enabled = hash != ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"available": s.db != nil,
+		"enabled":   enabled,
+		"endpoint":  s.scimBaseURL(r),
+	})
+}
+
+// POST /api/settings/scim/token generates a new SCIM bearer token (admin only).
+// The token is shown once; only its hash is stored.
+func (s *Server) handleGenerateSCIMToken(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	token := "scim_" + randomHex(32)
+	if err := s.db.SetSettingString(scimTokenSetting, hashSCIMToken(token)); err != nil {
+		http.Error(w, "Failed to store SCIM token", http.StatusInternalServerError)
+		return
+	}
+	s.recordSecurityEvent("scim_token_created", "warn", s.getUsernameFromContext(r.Context()), s.clientIP(r), "SCIM provisioning token generated")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"token": token, "endpoint": s.scimBaseURL(r)})
+}
+
+// DELETE /api/settings/scim/token disables SCIM provisioning (admin only)
+func (s *Server) handleRevokeSCIMToken(w http.ResponseWriter, r *http.Request) {
+	if !s.isUserAdmin(r.Context()) {
+		http.Error(w, "Admin privileges required", http.StatusForbidden)
+		return
+	}
+	if s.db == nil {
+		http.Error(w, "Database not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.db.SetSettingString(scimTokenSetting, ""); err != nil {
+		http.Error(w, "Failed to revoke SCIM token", http.StatusInternalServerError)
+		return
+	}
+	s.recordSecurityEvent("scim_token_revoked", "info", s.getUsernameFromContext(r.Context()), s.clientIP(r), "SCIM provisioning token revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,240 @@
+package chserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scimCondition is a single "attrPath op value" comparison (RFC 7644 §3.4.2.2)
+type scimCondition struct {
+	attr  string // lower-cased attribute path, e.g. "username" or "emails.value"
+	op    string // eq, ne, co, sw, ew, gt, ge, lt, le, pr
+	value string
+}
+
+// scimFilter is a disjunction of conjunctions; "and" binds tighter than "or".
+// Grouping with parentheses, "not" and value-path filters are not supported.
+type scimFilter struct {
+	anyOf [][]scimCondition
+}
+
+// scimCaseExact lists attributes compared case-sensitively (RFC 7643 §3.1, §4.1.1)
+var scimCaseExact = map[string]bool{"id": true, "externalid": true}
+
+var scimOperators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// parseSCIMFilter parses the filter query parameter. An empty filter matches everything.
+func parseSCIMFilter(filter string) (*scimFilter, error) {
+	tokens, err := scimFilterTokens(filter)
+	if err != nil {
+		return nil, err
+	}
+	f := &scimFilter{}
+	if len(tokens) == 0 {
+		return f, nil
+	}
+
+	var current []scimCondition
+	for i := 0; i < len(tokens); {
+		if len(tokens)-i < 2 {
+			return nil, fmt.Errorf("incomplete expression")
+		}
+		attr := tokens[i]
+		if strings.ContainsAny(attr, "[]()") || attr == "not" {
+			return nil, fmt.Errorf("unsupported filter expression %q", attr)
+		}
+		op := strings.ToLower(tokens[i+1])
+		if !scimOperators[op] {
+			return nil, fmt.Errorf("unsupported operator %q", tokens[i+1])
+		}
+		cond := scimCondition{attr: normalizeSCIMAttr(attr), op: op}
+		i += 2
+		if op != "pr" {
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("missing value for %s", attr)
+			}
+			cond.value = tokens[i]
+			i++
+		}
+		current = append(current, cond)
+
+		if i == len(tokens) {
+			break
+		}
+		switch strings.ToLower(tokens[i]) {
+		case "and":
+		case "or":
+			f.anyOf = append(f.anyOf, current)
+			current = nil
+		default:
+			return nil, fmt.Errorf("expected 'and' or 'or', got %q", tokens[i])
+		}
+		i++
+		if i == len(tokens) {
+			return nil, fmt.Errorf("dangling logical operator")
+		}
+	}
+	f.anyOf = append(f.anyOf, current)
+	return f, nil
+}
+
+// scimFilterTokens splits a filter into words and unquoted string literals
+func scimFilterTokens(filter string) ([]string, error) {
+	var tokens []string
+	s := strings.TrimSpace(filter)
+	for len(s) > 0 {
+		if s[0] == ' ' {
+			s = s[1:]
+			continue
+		}
+		if s[0] == '"' {
+			end := 1
+			for ; end < len(s); end++ {
+				if s[end] == '\\' {
+					end++
+					continue
+				}
+				if s[end] == '"' {
+					break
+				}
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			value, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", s[:end+1])
+			}
+			tokens = append(tokens, value)
+			s = s[end+1:]
+			continue
+		}
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			end = len(s)
+		}
+		tokens = append(tokens, s[:end])
+		s = s[end:]
+	}
+	return tokens, nil
+}
+
+// normalizeSCIMAttr lower-cases an attribute path and strips a core schema URN prefix
+func normalizeSCIMAttr(attr string) string {
+	attr = strings.ToLower(attr)
+	for _, urn := range []string{scimSchemaUser, scimSchemaGroup} {
+		prefix := strings.ToLower(urn) + ":"
+		if strings.HasPrefix(attr, prefix) {
+			return strings.TrimPrefix(attr, prefix)
+		}
+	}
+	return attr
+}
+
+// match evaluates the filter; values returns every value of an attribute path
+func (f *scimFilter) match(values func(attr string) []string) bool {
+	if len(f.anyOf) == 0 {
+		return true
+	}
+	for _, all := range f.anyOf {
+		ok := true
+		for _, cond := range all {
+			if !cond.match(values(cond.attr)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c scimCondition) match(values []string) bool {
+	if c.op == "pr" {
+		for _, v := range values {
+			if v != "" {
+				return true
+			}
+		}
+		return false
+	}
+	if c.op == "ne" {
+		// ne holds when no value is equal
+		return !scimCondition{attr: c.attr, op: "eq", value: c.value}.match(values)
+	}
+
+	want := c.value
+	if !scimCaseExact[c.attr] {
+		want = strings.ToLower(want)
+	}
+	for _, v := range values {
+		if !scimCaseExact[c.attr] {
+			v = strings.ToLower(v)
+		}
+		var ok bool
+		switch c.op {
+		case "eq":
+			ok = v == want
+		case "co":
+			ok = strings.Contains(v, want)
+		case "sw":
+			ok = strings.HasPrefix(v, want)
+		case "ew":
+			ok = strings.HasSuffix(v, want)
+		case "gt":
+			ok = v > want
+		case "ge":
+			ok = v >= want
+		case "lt":
+			ok = v < want
+		case "le":
+			ok = v <= want
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scimPatchPath is a parsed PATCH path: attr[filterAttr eq "filterValue"].subAttr
+type scimPatchPath struct {
+	attr        string
+	filterAttr  string
+	filterValue string
+	subAttr     string
+}
+
+// parseSCIMPatchPath supports plain and dotted paths plus a single eq value filter
+func parseSCIMPatchPath(path string) (*scimPatchPath, error) {
+	p := &scimPatchPath{}
+	path = strings.TrimSpace(path)
+	if i := strings.IndexByte(path, '['); i >= 0 {
+		j := strings.IndexByte(path, ']')
+		if j < i {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+		f, err := parseSCIMFilter(path[i+1 : j])
+		if err != nil || len(f.anyOf) != 1 || len(f.anyOf[0]) != 1 || f.anyOf[0][0].op != "eq" {
+			return nil, fmt.Errorf("unsupported path filter in %q", path)
+		}
+		p.filterAttr = f.anyOf[0][0].attr
+		p.filterValue = f.anyOf[0][0].value
+		p.attr = normalizeSCIMAttr(path[:i])
+		p.subAttr = strings.ToLower(strings.TrimPrefix(path[j+1:], "."))
+		return p, nil
+	}
+	path = normalizeSCIMAttr(path)
+	if i := strings.IndexByte(path, '.'); i >= 0 {
+		p.attr, p.subAttr = path[:i], path[i+1:]
+	} else {
+		p.attr = path
+	}
+	return p, nil
+}
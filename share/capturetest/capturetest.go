@@ -0,0 +1,190 @@
+// Package capturetest lets test suites assert on traffic captured by a
+// chissl server: block until a matching request crosses a tunnel, listener
+// or multicast tunnel, then inspect it.
+//
+//	c := capturetest.New("https://chissl.example.com", "ci", token)
+//	mark, _ := c.Mark(ctx, capturetest.Tunnel("tun-123"))
+//	// ... drive the system under test ...
+//	x := c.Expect(t, capturetest.Tunnel("tun-123"), capturetest.Match{
+//		Method: "POST", Path: "/api/orders", JSON: map[string]any{"status": "paid"}, AfterID: &mark,
+//	}, 10*time.Second)
+package capturetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ErrTimeout is returned when no exchange matched within the timeout
+var ErrTimeout = errors.New("capturetest: no matching exchange before the timeout")
+
+// Entity is a capture source on the server
+type Entity struct {
+	Kind string // tunnels, listeners or multicast
+	ID   string
+}
+
+// Tunnel, Listener and Multicast name capture entities
+func Tunnel(id string) Entity    { return Entity{Kind: "tunnels", ID: id} }
+func Listener(id string) Entity  { return Entity{Kind: "listeners", ID: id} }
+func Multicast(id string) Entity { return Entity{Kind: "multicast", ID: id} }
+
+func (e Entity) path(suffix string) string {
+	return "/api/capture/" + e.Kind + "/" + url.PathEscape(e.ID) + "/" + suffix
+}
+
+// Match selects the exchanges to wait for. Empty fields match anything.
+type Match struct {
+	Method string `json:"method,omitempty"`
+	// Path matches the request path without its query ("*" wildcards)
+	Path string `json:"path,omitempty"`
+	// Headers match request header values by name ("*" wildcards)
+	Headers map[string]string `json:"headers,omitempty"`
+	// JSON matches members of a JSON request body by dotted path
+	JSON         map[string]any `json:"json,omitempty"`
+	BodyContains string         `json:"body_contains,omitempty"`
+	// Response waits for the response as well; Status also matches its code
+	Response bool `json:"response,omitempty"`
+	Status   int  `json:"status,omitempty"`
+	// AfterID and Since include exchanges completed before the wait
+	// started: after the event ID (see Mark) or since the time
+	AfterID *uint64    `json:"after_id,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// Head is a captured request or response head
+type Head struct {
+	Method string      `json:"method,omitempty"`
+	Path   string      `json:"path,omitempty"`
+	Host   string      `json:"host,omitempty"`
+	Code   int         `json:"code,omitempty"`
+	Status string      `json:"status,omitempty"`
+	Proto  string      `json:"proto,omitempty"`
+	Header http.Header `json:"header"`
+}
+
+// Exchange is a matched request, with its response when it was awaited
+type Exchange struct {
+	EntityID     string    `json:"entity_id"`
+	ConnID       string    `json:"conn_id"`
+	Seq          int64     `json:"seq"`
+	EventID      uint64    `json:"event_id"`
+	Time         time.Time `json:"time"`
+	Request      *Head     `json:"request"`
+	RequestBody  string    `json:"request_body,omitempty"`
+	Response     *Head     `json:"response,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+	Truncated    bool      `json:"truncated,omitempty"`
+}
+
+// DecodeRequest unmarshals the request body as JSON into v
+func (x *Exchange) DecodeRequest(v any) error {
+	return json.Unmarshal([]byte(x.RequestBody), v)
+}
+
+// DecodeResponse unmarshals the response body as JSON into v
+func (x *Exchange) DecodeResponse(v any) error {
+	return json.Unmarshal([]byte(x.ResponseBody), v)
+}
+
+// Client talks to the capture API of a chissl server
+type Client struct {
+	BaseURL  string
+	Username string
+	Password string
+	// Header is added to every request, e.g. for bearer tokens
+	Header     http.Header
+	HTTPClient *http.Client
+}
+
+// New returns a client authenticating with basic auth
+func New(baseURL, username, password string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Username: username, Password: password}
+}
+
+// Mark returns the entity's latest event ID. Waiting with it as AfterID
+// finds exchanges captured after the mark, even ones completed before the
+// wait starts.
+func (c *Client) Mark(ctx context.Context, e Entity) (uint64, error) {
+	var out struct {
+		LastEventID uint64 `json:"last_event_id"`
+	}
+	if err := c.do(ctx, http.MethodGet, e.path("cursor"), nil, &out); err != nil {
+		return 0, err
+	}
+	return out.LastEventID, nil
+}
+
+// WaitFor blocks until an exchange matching m is captured for the entity,
+// returning ErrTimeout when none is within the timeout
+func (c *Client) WaitFor(ctx context.Context, e Entity, m Match, timeout time.Duration) (*Exchange, error) {
+	body, err := json.Marshal(struct {
+		Match
+		TimeoutMillis int64 `json:"timeout_ms"`
+	}{m, timeout.Milliseconds()})
+	if err != nil {
+		return nil, err
+	}
+	var x Exchange
+	if err := c.do(ctx, http.MethodPost, e.path("wait"), body, &x); err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
+
+// Expect is WaitFor failing t when nothing matches
+func (c *Client) Expect(t testing.TB, e Entity, m Match, timeout time.Duration) *Exchange {
+	t.Helper()
+	x, err := c.WaitFor(context.Background(), e, m, timeout)
+	if err != nil {
+		t.Fatalf("waiting for %s %s on %s/%s: %v", m.Method, m.Path, e.Kind, e.ID, err)
+	}
+	return x
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out any) error {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, rd)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusGatewayTimeout && strings.HasPrefix(res.Header.Get("Content-Type"), "application/json"):
+		// the server's own answer, not a proxy in front of it giving up
+		return ErrTimeout
+	case res.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("capturetest: %s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,59 @@
+package capturetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, _ := r.BasicAuth(); u != "ci" || p != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/capture/tunnels/tun-1/cursor":
+			json.NewEncoder(w).Encode(map[string]any{"entity_id": "tun-1", "last_event_id": 41})
+		case "POST /api/capture/tunnels/tun-1/wait":
+			var req struct {
+				Match
+				TimeoutMillis int64 `json:"timeout_ms"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.AfterID == nil || *req.AfterID != 41 || req.TimeoutMillis != 2000 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusGatewayTimeout)
+				json.NewEncoder(w).Encode(map[string]any{"error": "no matching exchange within 2s", "last_event_id": 41})
+				return
+			}
+			json.NewEncoder(w).Encode(Exchange{EntityID: "tun-1", ConnID: "c1", Request: &Head{Method: req.Method, Path: req.Path},
+				RequestBody: `{"status":"paid"}`})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL+"/", "ci", "secret")
+	ctx := context.Background()
+	mark, err := c.Mark(ctx, Tunnel("tun-1"))
+	if err != nil || mark != 41 {
+		t.Fatalf("Mark = %d, %v", mark, err)
+	}
+	x := c.Expect(t, Tunnel("tun-1"), Match{Method: "POST", Path: "/orders", AfterID: &mark}, 2*time.Second)
+	var body struct{ Status string }
+	if err := x.DecodeRequest(&body); err != nil || body.Status != "paid" || x.Request.Path != "/orders" {
+		t.Fatalf("unexpected exchange %+v", x)
+	}
+	if _, err := c.WaitFor(ctx, Tunnel("tun-1"), Match{}, time.Second); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if _, err := c.Mark(ctx, Listener("other")); err == nil {
+		t.Fatalf("expected an error for an unknown entity")
+	}
+}
@@ -0,0 +1,127 @@
+package ccrypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPPeriod is the RFC 6238 time step used for all codes
+	TOTPPeriod = 30 * time.Second
+	// TOTPDigits is the number of digits in a generated code
+	TOTPDigits = 6
+	// totpSecretLen is the raw secret size (160 bits, as recommended by RFC 4226)
+	totpSecretLen = 20
+)
+
+var b32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random base32 encoded TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	secret, err := randomSecret(totpSecretLen)
+	if err != nil {
+		return "", err
+	}
+	return b32NoPad.EncodeToString(secret), nil
+}
+
+// decodeTOTPSecret accepts base32 secrets with or without padding and spaces
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	s := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	s = strings.TrimRight(s, "=")
+	return b32NoPad.DecodeString(s)
+}
+
+// TOTPCounter returns the time step counter for t
+func TOTPCounter(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(TOTPPeriod/time.Second)
+}
+
+// HOTPCode computes the RFC 4226 code for a base32 secret and counter
+func HOTPCode(secret string, counter uint64) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	// dynamic truncation
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, bin%mod), nil
+}
+
+// TOTPCode computes the current RFC 6238 code for a base32 secret
+func TOTPCode(secret string, t time.Time) (string, error) {
+	return HOTPCode(secret, TOTPCounter(t))
+}
+
+// ValidateTOTP checks code against the secret allowing +/- skew time steps.
+// On success it returns the matched counter so callers can reject replays.
+func ValidateTOTP(secret, code string, t time.Time, skew int) (uint64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	now := TOTPCounter(t)
+	for i := -skew; i <= skew; i++ {
+		c := now + uint64(int64(i))
+		expected, err := HOTPCode(secret, c)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPProvisioningURI builds an otpauth:// URI suitable for rendering as a QR code
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	q.Set("period", fmt.Sprintf("%d", int(TOTPPeriod/time.Second)))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// GenerateRecoveryCodes returns n single-use recovery codes formatted as xxxxx-xxxxx
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b, err := randomSecret(5)
+		if err != nil {
+			return nil, err
+		}
+		h := hex.EncodeToString(b)
+		codes = append(codes, h[:5]+"-"+h[5:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode normalises and hashes a recovery code for storage
+func HashRecoveryCode(code string) string {
+	c := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(c))
+	return hex.EncodeToString(sum[:])
+}
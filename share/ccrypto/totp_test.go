@@ -0,0 +1,68 @@
+package ccrypto
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B vectors (SHA1, truncated to 6 digits)
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		got, err := TOTPCode(secret, time.Unix(c.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("t=%d: got %s, want %s", c.unix, got, c.want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	prev, _ := TOTPCode(secret, now.Add(-TOTPPeriod))
+	if _, ok := ValidateTOTP(secret, prev, now, 1); !ok {
+		t.Fatalf("expected previous step to validate with skew 1")
+	}
+	if _, ok := ValidateTOTP(secret, prev, now, 0); ok {
+		t.Fatalf("expected previous step to fail with skew 0")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now, 1); ok {
+		t.Fatalf("expected short code to fail")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("expected 10 codes, got %d", len(codes))
+	}
+	c := codes[0]
+	if HashRecoveryCode(c) != HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(c, "-", ""))) {
+		t.Fatalf("expected normalised hashes to match")
+	}
+	uri := TOTPProvisioningURI("chiSSL", "alice", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/chiSSL:alice?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Fatalf("unexpected provisioning uri: %s", uri)
+	}
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditEntry is one row of the append-only audit log. Entries form a hash
+// chain: each Hash covers the entry's fields and the previous entry's Hash,
+// so editing or deleting a row breaks verification of every later row.
+type AuditEntry struct {
+	Seq        int64     `db:"seq" json:"seq"`
+	At         time.Time `db:"at" json:"at"`
+	Actor      string    `db:"actor" json:"actor"`
+	IP         string    `db:"ip" json:"ip"`
+	Action     string    `db:"action" json:"action"`
+	Method     string    `db:"method" json:"method"`
+	Path       string    `db:"path" json:"path"`
+	TargetType string    `db:"target_type" json:"target_type"`
+	Target     string    `db:"target" json:"target"`
+	Status     int       `db:"status" json:"status"`
+	Before     string    `db:"before_state" json:"before,omitempty"`
+	After      string    `db:"after_state" json:"after,omitempty"`
+	Diff       string    `db:"diff" json:"diff,omitempty"`
+	PrevHash   string    `db:"prev_hash" json:"prev_hash"`
+	Hash       string    `db:"hash" json:"hash"`
+}
+
+// AuditFilter selects audit entries; zero values are ignored
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	Target     string
+	Since      time.Time
+	Until      time.Time
+	AfterSeq   int64
+	Limit      int
+	Offset     int
+	Ascending  bool
+}
+
+// ComputeHash returns the chain hash of the entry. Every field is length
+// prefixed so that values cannot be shifted between fields.
+func (e *AuditEntry) ComputeHash() string {
+	h := sha256.New()
+	for _, v := range []string{
+		fmt.Sprintf("%d", e.Seq),
+		e.At.UTC().Format(time.RFC3339Nano),
+		e.Actor, e.IP, e.Action, e.Method, e.Path, e.TargetType, e.Target,
+		fmt.Sprintf("%d", e.Status),
+		e.Before, e.After, e.Diff, e.PrevHash,
+	} {
+		fmt.Fprintf(h, "%d:%s\n", len(v), v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+const auditColumns = `seq, at, actor, ip, action, method, path, target_type, target, status, before_state, after_state, diff, prev_hash, hash`
+
+// AppendAuditEntry links the entry to the end of the chain and stores it.
+// Seq, PrevHash and Hash are assigned here; callers must serialize appends.
+func (d *SQLDatabase) AppendAuditEntry(e *AuditEntry) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var last struct {
+		Seq  int64  `db:"seq"`
+		Hash string `db:"hash"`
+	}
+	err = tx.Get(&last, `SELECT seq, hash FROM audit_log ORDER BY seq DESC LIMIT 1`)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	// Store at the precision every backend round-trips so hashes verify after reload
+	e.At = e.At.UTC().Truncate(time.Microsecond)
+	e.Seq = last.Seq + 1
+	e.PrevHash = last.Hash
+	e.Hash = e.ComputeHash()
+
+	query := `INSERT INTO audit_log (` + auditColumns + `)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+	if _, err := tx.Exec(query, e.Seq, e.At, e.Actor, e.IP, e.Action, e.Method, e.Path, e.TargetType, e.Target,
+		e.Status, e.Before, e.After, e.Diff, e.PrevHash, e.Hash); err != nil {
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ListAuditEntries returns entries matching the filter, newest first unless Ascending is set
+func (d *SQLDatabase) ListAuditEntries(f AuditFilter) ([]*AuditEntry, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.Target != "" {
+		add("target = $%d", f.Target)
+	}
+	if !f.Since.IsZero() {
+		add("at >= $%d", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add("at < $%d", f.Until.UTC())
+	}
+	if f.AfterSeq > 0 {
+		add("seq > $%d", f.AfterSeq)
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	if f.Ascending {
+		query += ` ORDER BY seq ASC`
+	} else {
+		query += ` ORDER BY seq DESC`
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	args = append(args, f.Limit, f.Offset)
+	query += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	var entries []*AuditEntry
+	if err := d.db.Select(&entries, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// VerifyAuditChain checks entries in ascending sequence order, starting from
+// prevHash (empty for the first entry in the log). It returns the sequence of
+// the first entry that fails verification, or 0 when the chain is intact.
+func VerifyAuditChain(entries []*AuditEntry, prevHash string) int64 {
+	for _, e := range entries {
+		if e.PrevHash != prevHash || e.ComputeHash() != e.Hash {
+			return e.Seq
+		}
+		prevHash = e.Hash
+	}
+	return 0
+}
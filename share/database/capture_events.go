@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CaptureEventRecord is one persisted traffic capture event. Data holds the
+// JSON encoded event; the capture package owns its schema.
+type CaptureEventRecord struct {
+	ID       int64     `db:"id"`
+	EntityID string    `db:"entity_id"`
+	ConnID   string    `db:"conn_id"`
+	At       time.Time `db:"at"`
+	Data     string    `db:"data"`
+}
+
+// AppendCaptureEvents stores a batch of capture events in one transaction
+func (d *SQLDatabase) AppendCaptureEvents(records []*CaptureEventRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	query := `INSERT INTO capture_events (entity_id, conn_id, at, data) VALUES (?,?,?,?)`
+	if d.config.Type == "postgres" {
+		query = `INSERT INTO capture_events (entity_id, conn_id, at, data) VALUES ($1,$2,$3,$4)`
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare capture insert: %w", err)
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		if _, err := stmt.Exec(r.EntityID, r.ConnID, r.At.UTC(), r.Data); err != nil {
+			return fmt.Errorf("failed to insert capture event: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit capture events: %w", err)
+	}
+	return nil
+}
+
+// ListCaptureEntities returns the IDs of entities with stored capture events
+func (d *SQLDatabase) ListCaptureEntities() ([]string, error) {
+	var ids []string
+	err := d.db.Select(&ids, `SELECT DISTINCT entity_id FROM capture_events ORDER BY entity_id`)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListCaptureConnections returns the connection IDs stored for an entity
+func (d *SQLDatabase) ListCaptureConnections(entityID string) ([]string, error) {
+	var ids []string
+	var err error
+	if d.config.Type == "postgres" {
+		err = d.db.Select(&ids, `SELECT DISTINCT conn_id FROM capture_events WHERE entity_id = $1 ORDER BY conn_id`, entityID)
+	} else {
+		err = d.db.Select(&ids, `SELECT DISTINCT conn_id FROM capture_events WHERE entity_id = ? ORDER BY conn_id`, entityID)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListCaptureEvents returns an entity's capture events in the order they were appended
+func (d *SQLDatabase) ListCaptureEvents(entityID string) ([]*CaptureEventRecord, error) {
+	var rows []*CaptureEventRecord
+	var err error
+	if d.config.Type == "postgres" {
+		err = d.db.Select(&rows, `SELECT id, entity_id, conn_id, at, data FROM capture_events WHERE entity_id = $1 ORDER BY id`, entityID)
+	} else {
+		err = d.db.Select(&rows, `SELECT id, entity_id, conn_id, at, data FROM capture_events WHERE entity_id = ? ORDER BY id`, entityID)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// DeleteCaptureEventsBefore removes an entity's capture events older than
+// cutoff. Times are stored in UTC so that SQLite's text timestamps compare
+// in order.
+func (d *SQLDatabase) DeleteCaptureEventsBefore(entityID string, cutoff time.Time) (int64, error) {
+	var res sql.Result
+	var err error
+	if d.config.Type == "postgres" {
+		res, err = d.db.Exec(`DELETE FROM capture_events WHERE entity_id = $1 AND at < $2`, entityID, cutoff.UTC())
+	} else {
+		res, err = d.db.Exec(`DELETE FROM capture_events WHERE entity_id = ? AND at < ?`, entityID, cutoff.UTC())
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete capture events: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
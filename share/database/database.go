@@ -33,6 +33,7 @@ type Database interface {
 	UpdateUser(user *User) error
 	DeleteUser(username string) error
 	ListUsers() ([]*User, error)
+	SetUserDisabled(username string, disabled bool) error
 	CreateSession(session *Session) error
 	GetSession(sessionID string) (*Session, error)
 	DeleteSession(sessionID string) error
@@ -68,6 +69,11 @@ type Database interface {
 	AddListenerBytes(listenerID string, sent, recv int64) error
 	AddListenerConnections(listenerID string, delta int) error
 	MarkStaleListenersClosed(age time.Duration) error
+	// Fixtures of record and replay listeners
+	AddListenerFixtures(records []*ListenerFixtureRecord) error
+	ListListenerFixtures(listenerID string) ([]*ListenerFixtureRecord, error)
+	DeleteListenerFixtures(listenerID string) (int64, error)
+	TrimListenerFixtures(listenerID, matchKey string, perKey, total int) (int64, error)
 
 	// Multicast tunnels management
 	CreateMulticastTunnel(mt *MulticastTunnel) error
@@ -107,6 +113,7 @@ type Database interface {
 	CheckUserListenerLimit(username string) (bool, error)
 	GetSettingInt(key string, defaultValue int) (int, error)
 	GetSettingBool(key string, defaultValue bool) (bool, error)
+	GetSettingString(key string, defaultValue string) (string, error)
 	SetSettingString(key string, value string) error
 
 	// Security webhooks
@@ -134,6 +141,54 @@ type Database interface {
 	ListUserAuthSourcesByUsername(username string) ([]*UserAuthSource, error)
 	DeleteUserAuthSource(id int) error
 
+	// User MFA (TOTP) enrollment
+	GetUserMFA(username string) (*UserMFA, error)
+	SaveUserMFA(m *UserMFA) error
+	DeleteUserMFA(username string) error
+
+	// Groups and membership
+	CreateGroup(group *Group) error
+	GetGroup(id string) (*Group, error)
+	GetGroupByName(name string) (*Group, error)
+	UpdateGroup(group *Group) error
+	DeleteGroup(id string) error
+	ListGroups() ([]*Group, error)
+	ListGroupMembers(groupID string) ([]string, error)
+	SetGroupMembers(groupID string, usernames []string) error
+	AddGroupMember(groupID, username string) error
+	RemoveGroupMember(groupID, username string) error
+	ListUserGroups(username string) ([]*Group, error)
+	DeleteUserGroupMemberships(username string) error
+
+	// Roles and role bindings
+	CreateRole(role *Role) error
+	GetRole(id string) (*Role, error)
+	GetRoleByName(name string) (*Role, error)
+	UpdateRole(role *Role) error
+	DeleteRole(id string) error
+	ListRoles() ([]*Role, error)
+	ListRoleBindings(roleID string) ([]*RoleBinding, error)
+	SetRoleBindings(roleID string, bindings []*RoleBinding) error
+	ListRolesForUser(username string) ([]*Role, error)
+	DeleteSubjectRoleBindings(subjectType, subject string) error
+
+	// Audit log
+	AppendAuditEntry(e *AuditEntry) error
+	ListAuditEntries(f AuditFilter) ([]*AuditEntry, error)
+
+	// Traffic capture storage
+	AppendCaptureEvents(records []*CaptureEventRecord) error
+	ListCaptureEntities() ([]string, error)
+	ListCaptureConnections(entityID string) ([]string, error)
+	ListCaptureEvents(entityID string) ([]*CaptureEventRecord, error)
+	DeleteCaptureEventsBefore(entityID string, cutoff time.Time) (int64, error)
+
+	// SCIM resource identifiers
+	GetSCIMUser(id string) (*SCIMUser, error)
+	GetSCIMUserByUsername(username string) (*SCIMUser, error)
+	SaveSCIMUser(u *SCIMUser) error
+	DeleteSCIMUser(username string) error
+
 	// User preferences management
 	GetUserPreference(username, key string) (*UserPreference, error)
 	SetUserPreference(username, key, value string) error
@@ -173,6 +228,7 @@ type User struct {
 	DisplayName string    `db:"display_name" json:"display_name,omitempty"`
 	IsAdmin     bool      `db:"is_admin" json:"is_admin"`
 	Addresses   string    `db:"addresses" json:"addresses"` // JSON array of regex patterns
+	Disabled    bool      `db:"disabled" json:"disabled"`   // set by SCIM deprovisioning; blocks all authentication
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
 }
@@ -242,11 +298,15 @@ type Listener struct {
 	Name        string    `db:"name" json:"name"` // human-friendly name
 	Username    string    `db:"username" json:"username"`
 	Port        int       `db:"port" json:"port"`
-	Mode        string    `db:"mode" json:"mode"`                       // "sink" or "proxy"
-	TargetURL   string    `db:"target_url" json:"target_url,omitempty"` // for proxy mode
-	Response    string    `db:"response" json:"response,omitempty"`     // for sink mode
-	UseTLS      bool      `db:"use_tls" json:"use_tls"`                 // whether to use TLS
-	Status      string    `db:"status" json:"status"`                   // "open", "closed", "error"
+	Mode        string    `db:"mode" json:"mode"`                           // "sink" or "proxy"
+	TargetURL   string    `db:"target_url" json:"target_url,omitempty"`     // for proxy mode
+	Response    string    `db:"response" json:"response,omitempty"`         // for sink mode
+	MockRules   string    `db:"mock_rules" json:"-"`                        // JSON rules for mock mode
+	OpenAPISpec string    `db:"openapi_spec" json:"openapi_spec,omitempty"` // OpenAPI document for openapi mode
+	MatchKeys   string    `db:"match_keys" json:"match_keys,omitempty"`     // fixture match keys for replay mode, comma separated
+	Chaos       string    `db:"chaos" json:"-"`                             // JSON fault injection config for proxy mode
+	UseTLS      bool      `db:"use_tls" json:"use_tls"`                     // whether to use TLS
+	Status      string    `db:"status" json:"status"`                       // "open", "closed", "error"
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
 	BytesSent   int64     `db:"bytes_sent" json:"bytes_sent"`
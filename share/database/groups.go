@@ -0,0 +1,227 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GroupSourceSCIM marks groups created through the SCIM provisioning API
+const GroupSourceSCIM = "scim"
+
+// Group is a named set of users. Groups are provisioned by SCIM or created
+// locally and are the unit that roles are granted to. Source records which;
+// it is empty for local groups.
+type Group struct {
+	ID         string    `db:"id" json:"id"`
+	Name       string    `db:"name" json:"name"`
+	ExternalID *string   `db:"external_id" json:"external_id,omitempty"`
+	Source     string    `db:"source" json:"source,omitempty"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// CreateGroup creates a new group, assigning an ID if none is set
+func (d *SQLDatabase) CreateGroup(group *Group) error {
+	if group.ID == "" {
+		group.ID = uuid.New().String()
+	}
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+
+	query := `INSERT INTO user_groups (id, name, external_id, source, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := d.db.Exec(query, group.ID, group.Name, group.ExternalID, group.Source, group.CreatedAt, group.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create group: %w", err)
+	}
+
+	return nil
+}
+
+// GetGroup retrieves a group by ID, returning nil when it does not exist
+func (d *SQLDatabase) GetGroup(id string) (*Group, error) {
+	group := &Group{}
+	query := `SELECT id, name, external_id, COALESCE(source, '') AS source, created_at, updated_at FROM user_groups WHERE id = $1`
+
+	err := d.db.Get(group, query, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+
+	return group, nil
+}
+
+// GetGroupByName retrieves a group by name, returning nil when it does not exist
+func (d *SQLDatabase) GetGroupByName(name string) (*Group, error) {
+	group := &Group{}
+	query := `SELECT id, name, external_id, COALESCE(source, '') AS source, created_at, updated_at FROM user_groups WHERE name = $1`
+
+	err := d.db.Get(group, query, name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+
+	return group, nil
+}
+
+// UpdateGroup updates a group's name and external ID
+func (d *SQLDatabase) UpdateGroup(group *Group) error {
+	group.UpdatedAt = time.Now()
+
+	query := `UPDATE user_groups SET name = $1, external_id = $2, updated_at = $3 WHERE id = $4`
+
+	result, err := d.db.Exec(query, group.Name, group.ExternalID, group.UpdatedAt, group.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("group not found: %s", group.ID)
+	}
+
+	return nil
+}
+
+// DeleteGroup deletes a group, its memberships and its role bindings
+func (d *SQLDatabase) DeleteGroup(id string) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_group_members WHERE group_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete group members: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM role_bindings WHERE subject_type = $1 AND subject = $2`, RoleSubjectGroup, id); err != nil {
+		return fmt.Errorf("failed to delete group role bindings: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_groups WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ListGroups returns all groups ordered by name
+func (d *SQLDatabase) ListGroups() ([]*Group, error) {
+	var groups []*Group
+	query := `SELECT id, name, external_id, COALESCE(source, '') AS source, created_at, updated_at FROM user_groups ORDER BY name`
+
+	err := d.db.Select(&groups, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+// ListGroupMembers returns the usernames belonging to a group
+func (d *SQLDatabase) ListGroupMembers(groupID string) ([]string, error) {
+	var members []string
+	query := `SELECT username FROM user_group_members WHERE group_id = $1 ORDER BY username`
+
+	err := d.db.Select(&members, query, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group members: %w", err)
+	}
+
+	return members, nil
+}
+
+// SetGroupMembers replaces the membership of a group
+func (d *SQLDatabase) SetGroupMembers(groupID string, usernames []string) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_group_members WHERE group_id = $1`, groupID); err != nil {
+		return fmt.Errorf("failed to clear group members: %w", err)
+	}
+	seen := make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		if _, err := tx.Exec(`INSERT INTO user_group_members (group_id, username, created_at) VALUES ($1, $2, $3)`,
+			groupID, username, time.Now()); err != nil {
+			return fmt.Errorf("failed to add group member: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddGroupMember adds a user to a group; adding an existing member is a no-op
+func (d *SQLDatabase) AddGroupMember(groupID, username string) error {
+	var count int
+	if err := d.db.Get(&count, `SELECT COUNT(*) FROM user_group_members WHERE group_id = $1 AND username = $2`,
+		groupID, username); err != nil {
+		return fmt.Errorf("failed to check group member: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	query := `INSERT INTO user_group_members (group_id, username, created_at) VALUES ($1, $2, $3)`
+	if _, err := d.db.Exec(query, groupID, username, time.Now()); err != nil {
+		return fmt.Errorf("failed to add group member: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveGroupMember removes a user from a group
+func (d *SQLDatabase) RemoveGroupMember(groupID, username string) error {
+	query := `DELETE FROM user_group_members WHERE group_id = $1 AND username = $2`
+
+	if _, err := d.db.Exec(query, groupID, username); err != nil {
+		return fmt.Errorf("failed to remove group member: %w", err)
+	}
+
+	return nil
+}
+
+// ListUserGroups returns the groups a user belongs to
+func (d *SQLDatabase) ListUserGroups(username string) ([]*Group, error) {
+	var groups []*Group
+	query := `SELECT g.id, g.name, g.external_id, COALESCE(g.source, '') AS source, g.created_at, g.updated_at
+			  FROM user_groups g JOIN user_group_members m ON m.group_id = g.id
+			  WHERE m.username = $1 ORDER BY g.name`
+
+	err := d.db.Select(&groups, query, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+// DeleteUserGroupMemberships removes a user from every group
+func (d *SQLDatabase) DeleteUserGroupMemberships(username string) error {
+	query := `DELETE FROM user_group_members WHERE username = $1`
+
+	if _, err := d.db.Exec(query, username); err != nil {
+		return fmt.Errorf("failed to delete user group memberships: %w", err)
+	}
+
+	return nil
+}
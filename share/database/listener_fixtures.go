@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ListenerFixtureRecord is one exchange stored by a record listener or
+// imported into a replay listener. Data holds the JSON encoded fixture; the
+// fixture package owns its schema. MatchKey is the fixture's key under the
+// listener's match keys when it was recorded; imported fixtures leave it empty.
+type ListenerFixtureRecord struct {
+	ID         int64     `db:"id"`
+	ListenerID string    `db:"listener_id"`
+	At         time.Time `db:"at"`
+	Data       string    `db:"data"`
+	MatchKey   string    `db:"match_key"`
+}
+
+// AddListenerFixtures stores a batch of fixtures in one transaction
+func (d *SQLDatabase) AddListenerFixtures(records []*ListenerFixtureRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	query := `INSERT INTO listener_fixtures (listener_id, at, data, match_key) VALUES (?,?,?,?)`
+	if d.config.Type == "postgres" {
+		query = `INSERT INTO listener_fixtures (listener_id, at, data, match_key) VALUES ($1,$2,$3,$4)`
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare fixture insert: %w", err)
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		if _, err := stmt.Exec(r.ListenerID, r.At.UTC(), r.Data, r.MatchKey); err != nil {
+			return fmt.Errorf("failed to insert fixture: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit fixtures: %w", err)
+	}
+	return nil
+}
+
+// ListListenerFixtures returns a listener's fixtures in the order they were added
+func (d *SQLDatabase) ListListenerFixtures(listenerID string) ([]*ListenerFixtureRecord, error) {
+	var rows []*ListenerFixtureRecord
+	var err error
+	if d.config.Type == "postgres" {
+		err = d.db.Select(&rows, `SELECT id, listener_id, at, data, COALESCE(match_key, '') AS match_key FROM listener_fixtures WHERE listener_id = $1 ORDER BY id`, listenerID)
+	} else {
+		err = d.db.Select(&rows, `SELECT id, listener_id, at, data, COALESCE(match_key, '') AS match_key FROM listener_fixtures WHERE listener_id = ? ORDER BY id`, listenerID)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// DeleteListenerFixtures removes all fixtures of a listener
+func (d *SQLDatabase) DeleteListenerFixtures(listenerID string) (int64, error) {
+	var res sql.Result
+	var err error
+	if d.config.Type == "postgres" {
+		res, err = d.db.Exec(`DELETE FROM listener_fixtures WHERE listener_id = $1`, listenerID)
+	} else {
+		res, err = d.db.Exec(`DELETE FROM listener_fixtures WHERE listener_id = ?`, listenerID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete fixtures: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// TrimListenerFixtures keeps the latest perKey fixtures with the given match
+// key and the latest total fixtures of the listener, deleting older ones.
+// A limit of zero or less is not applied.
+func (d *SQLDatabase) TrimListenerFixtures(listenerID, matchKey string, perKey, total int) (int64, error) {
+	var deleted int64
+	if perKey > 0 {
+		query := `DELETE FROM listener_fixtures WHERE listener_id = ? AND match_key = ? AND id NOT IN (
+			SELECT id FROM listener_fixtures WHERE listener_id = ? AND match_key = ? ORDER BY id DESC LIMIT ?)`
+		if d.config.Type == "postgres" {
+			query = `DELETE FROM listener_fixtures WHERE listener_id = $1 AND match_key = $2 AND id NOT IN (
+			SELECT id FROM listener_fixtures WHERE listener_id = $3 AND match_key = $4 ORDER BY id DESC LIMIT $5)`
+		}
+		res, err := d.db.Exec(query, listenerID, matchKey, listenerID, matchKey, perKey)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to trim fixtures: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	if total > 0 {
+		query := `DELETE FROM listener_fixtures WHERE listener_id = ? AND id NOT IN (
+			SELECT id FROM listener_fixtures WHERE listener_id = ? ORDER BY id DESC LIMIT ?)`
+		if d.config.Type == "postgres" {
+			query = `DELETE FROM listener_fixtures WHERE listener_id = $1 AND id NOT IN (
+			SELECT id FROM listener_fixtures WHERE listener_id = $2 ORDER BY id DESC LIMIT $3)`
+		}
+		res, err := d.db.Exec(query, listenerID, listenerID, total)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to trim fixtures: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
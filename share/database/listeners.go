@@ -10,11 +10,11 @@ func (d *SQLDatabase) CreateListener(listener *Listener) error {
 	listener.CreatedAt = time.Now()
 	listener.UpdatedAt = time.Now()
 
-	query := `INSERT INTO listeners (id, name, username, port, mode, target_url, response, use_tls, status, created_at, updated_at, bytes_sent, bytes_recv, connections)
-			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+	query := `INSERT INTO listeners (id, name, username, port, mode, target_url, response, mock_rules, openapi_spec, match_keys, chaos, use_tls, status, created_at, updated_at, bytes_sent, bytes_recv, connections)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`
 
 	_, err := d.db.Exec(query, listener.ID, listener.Name, listener.Username, listener.Port, listener.Mode,
-		listener.TargetURL, listener.Response, listener.UseTLS, listener.Status, listener.CreatedAt, listener.UpdatedAt,
+		listener.TargetURL, listener.Response, listener.MockRules, listener.OpenAPISpec, listener.MatchKeys, listener.Chaos, listener.UseTLS, listener.Status, listener.CreatedAt, listener.UpdatedAt,
 		listener.BytesSent, listener.BytesRecv, listener.Connections)
 
 	if err != nil {
@@ -30,10 +30,12 @@ func (d *SQLDatabase) UpdateListener(listener *Listener) error {
 
 	query := `UPDATE listeners SET name = COALESCE($1, name), status = COALESCE($2, status), updated_at = $3, bytes_sent = COALESCE($4, bytes_sent),
 			  bytes_recv = COALESCE($5, bytes_recv), connections = COALESCE($6, connections),
-			  target_url = COALESCE($7, target_url), response = COALESCE($8, response), use_tls = COALESCE($9, use_tls) WHERE id = $10`
+			  target_url = COALESCE($7, target_url), response = COALESCE($8, response), use_tls = COALESCE($9, use_tls),
+			  mock_rules = COALESCE($10, mock_rules), openapi_spec = COALESCE($11, openapi_spec),
+			  match_keys = COALESCE($12, match_keys), chaos = COALESCE($13, chaos) WHERE id = $14`
 
 	result, err := d.db.Exec(query, listener.Name, listener.Status, listener.UpdatedAt, listener.BytesSent,
-		listener.BytesRecv, listener.Connections, listener.TargetURL, listener.Response, listener.UseTLS, listener.ID)
+		listener.BytesRecv, listener.Connections, listener.TargetURL, listener.Response, listener.UseTLS, listener.MockRules, listener.OpenAPISpec, listener.MatchKeys, listener.Chaos, listener.ID)
 
 	if err != nil {
 		return fmt.Errorf("failed to update listener: %w", err)
@@ -75,7 +77,7 @@ func (d *SQLDatabase) DeleteListener(listenerID string) error {
 // GetListener retrieves a listener by ID
 func (d *SQLDatabase) GetListener(listenerID string) (*Listener, error) {
 	var listener Listener
-	query := `SELECT id, name, username, port, mode, target_url, response, use_tls, status, created_at, updated_at, bytes_sent, bytes_recv, connections
+	query := `SELECT id, name, username, port, mode, target_url, response, COALESCE(mock_rules, '') AS mock_rules, COALESCE(openapi_spec, '') AS openapi_spec, COALESCE(match_keys, '') AS match_keys, COALESCE(chaos, '') AS chaos, use_tls, status, created_at, updated_at, bytes_sent, bytes_recv, connections
 			  FROM listeners WHERE id = $1`
 
 	err := d.db.Get(&listener, query, listenerID)
@@ -89,7 +91,7 @@ func (d *SQLDatabase) GetListener(listenerID string) (*Listener, error) {
 // ListListeners retrieves all listeners
 func (d *SQLDatabase) ListListeners() ([]*Listener, error) {
 	var listeners []*Listener
-	query := `SELECT id, name, username, port, mode, target_url, response, use_tls, status, created_at, updated_at, bytes_sent, bytes_recv, connections
+	query := `SELECT id, name, username, port, mode, target_url, response, COALESCE(mock_rules, '') AS mock_rules, COALESCE(openapi_spec, '') AS openapi_spec, COALESCE(match_keys, '') AS match_keys, COALESCE(chaos, '') AS chaos, use_tls, status, created_at, updated_at, bytes_sent, bytes_recv, connections
 			  FROM listeners ORDER BY created_at DESC`
 
 	err := d.db.Select(&listeners, query)
@@ -103,7 +105,7 @@ func (d *SQLDatabase) ListListeners() ([]*Listener, error) {
 // ListActiveListeners retrieves all active listeners
 func (d *SQLDatabase) ListActiveListeners() ([]*Listener, error) {
 	var listeners []*Listener
-	query := `SELECT id, username, port, mode, target_url, response, use_tls, status, created_at, updated_at, bytes_sent, bytes_recv, connections
+	query := `SELECT id, username, port, mode, target_url, response, COALESCE(mock_rules, '') AS mock_rules, COALESCE(openapi_spec, '') AS openapi_spec, COALESCE(match_keys, '') AS match_keys, COALESCE(chaos, '') AS chaos, use_tls, status, created_at, updated_at, bytes_sent, bytes_recv, connections
 			  FROM listeners WHERE status = 'open' ORDER BY created_at DESC`
 
 	err := d.db.Select(&listeners, query)
@@ -134,6 +134,14 @@ func (d *SQLDatabase) getSQLiteMigrations() []string {
 		`ALTER TABLE listeners ADD COLUMN use_tls BOOLEAN DEFAULT 1`,
 		// Add name column to existing listeners table if it doesn't exist (SQLite)
 		`ALTER TABLE listeners ADD COLUMN name TEXT DEFAULT ''`,
+		// Add mock_rules column for rule-based mock listeners (SQLite)
+		`ALTER TABLE listeners ADD COLUMN mock_rules TEXT DEFAULT ''`,
+		// Add openapi_spec column for OpenAPI mock listeners (SQLite)
+		`ALTER TABLE listeners ADD COLUMN openapi_spec TEXT DEFAULT ''`,
+		// Add match_keys column for replay listeners (SQLite)
+		`ALTER TABLE listeners ADD COLUMN match_keys TEXT DEFAULT ''`,
+		// Add chaos column for fault injection on proxy listeners (SQLite)
+		`ALTER TABLE listeners ADD COLUMN chaos TEXT DEFAULT ''`,
 
 		// Create user_tokens table
 		`CREATE TABLE IF NOT EXISTS user_tokens (
@@ -316,6 +324,122 @@ func (d *SQLDatabase) getSQLiteMigrations() []string {
 		`CREATE INDEX IF NOT EXISTS idx_ai_response_versions_listener_id ON ai_response_versions(ai_listener_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_ai_response_versions_version ON ai_response_versions(ai_listener_id, version_number)`,
 		`CREATE INDEX IF NOT EXISTS idx_ai_response_versions_active ON ai_response_versions(ai_listener_id, is_active)`,
+
+		// Create user MFA (TOTP) table
+		`CREATE TABLE IF NOT EXISTS user_mfa (
+			username TEXT PRIMARY KEY,
+			secret TEXT NOT NULL,
+			recovery_codes TEXT NOT NULL DEFAULT '',
+			enabled BOOLEAN DEFAULT FALSE,
+			last_counter INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			enabled_at DATETIME,
+			FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+		)`,
+
+		// Users can be disabled (SCIM active=false) without deleting them
+		`ALTER TABLE users ADD COLUMN disabled BOOLEAN DEFAULT 0`,
+
+		// Create groups tables
+		`CREATE TABLE IF NOT EXISTS user_groups (
+			id TEXT PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			external_id TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_group_members (
+			group_id TEXT NOT NULL,
+			username TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (group_id, username),
+			FOREIGN KEY (group_id) REFERENCES user_groups(id) ON DELETE CASCADE,
+			FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_group_members_username ON user_group_members(username)`,
+		// Groups record whether SCIM created them; SCIM only manages its own
+		`ALTER TABLE user_groups ADD COLUMN source TEXT DEFAULT ''`,
+
+		// Create SCIM user identifiers table
+		`CREATE TABLE IF NOT EXISTS scim_users (
+			id TEXT PRIMARY KEY,
+			username TEXT UNIQUE NOT NULL,
+			external_id TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+		)`,
+
+		// Create roles and role bindings tables
+		`CREATE TABLE IF NOT EXISTS roles (
+			id TEXT PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			description TEXT,
+			rules TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS role_bindings (
+			role_id TEXT NOT NULL,
+			subject_type TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (role_id, subject_type, subject),
+			FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_role_bindings_subject ON role_bindings(subject_type, subject)`,
+
+		// Create append-only audit log table
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			seq INTEGER PRIMARY KEY,
+			at DATETIME NOT NULL,
+			actor TEXT NOT NULL,
+			ip TEXT NOT NULL,
+			action TEXT NOT NULL,
+			method TEXT NOT NULL,
+			path TEXT NOT NULL,
+			target_type TEXT NOT NULL,
+			target TEXT NOT NULL,
+			status INTEGER NOT NULL,
+			before_state TEXT NOT NULL,
+			after_state TEXT NOT NULL,
+			diff TEXT NOT NULL,
+			prev_hash TEXT NOT NULL,
+			hash TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target)`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END`,
+
+		// Create traffic capture events table
+		`CREATE TABLE IF NOT EXISTS capture_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entity_id TEXT NOT NULL,
+			conn_id TEXT NOT NULL,
+			at DATETIME NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_capture_events_entity ON capture_events(entity_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_capture_events_at ON capture_events(at)`,
+
+		// Create fixtures table for record and replay listeners
+		`CREATE TABLE IF NOT EXISTS listener_fixtures (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			listener_id TEXT NOT NULL,
+			at DATETIME NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_listener_fixtures_listener ON listener_fixtures(listener_id, id)`,
+		// Match key of recorded fixtures, used to keep only the latest per key
+		`ALTER TABLE listener_fixtures ADD COLUMN match_key TEXT DEFAULT ''`,
 	}
 }
 
@@ -442,6 +566,14 @@ func (d *SQLDatabase) getPostgresMigrations() []string {
 		`ALTER TABLE listeners ADD COLUMN IF NOT EXISTS use_tls BOOLEAN DEFAULT TRUE`,
 		// Add name column to existing listeners table if it doesn't exist (PostgreSQL)
 		`ALTER TABLE listeners ADD COLUMN IF NOT EXISTS name VARCHAR(255) DEFAULT ''`,
+		// Add mock_rules column for rule-based mock listeners (PostgreSQL)
+		`ALTER TABLE listeners ADD COLUMN IF NOT EXISTS mock_rules TEXT DEFAULT ''`,
+		// Add openapi_spec column for OpenAPI mock listeners (PostgreSQL)
+		`ALTER TABLE listeners ADD COLUMN IF NOT EXISTS openapi_spec TEXT DEFAULT ''`,
+		// Add match_keys column for replay listeners (PostgreSQL)
+		`ALTER TABLE listeners ADD COLUMN IF NOT EXISTS match_keys TEXT DEFAULT ''`,
+		// Add chaos column for fault injection on proxy listeners (PostgreSQL)
+		`ALTER TABLE listeners ADD COLUMN IF NOT EXISTS chaos TEXT DEFAULT ''`,
 
 		// Create settings table for configuration (PostgreSQL)
 		`CREATE TABLE IF NOT EXISTS settings (
@@ -603,5 +735,119 @@ func (d *SQLDatabase) getPostgresMigrations() []string {
 		`CREATE INDEX IF NOT EXISTS idx_ai_response_versions_listener_id ON ai_response_versions(ai_listener_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_ai_response_versions_version ON ai_response_versions(ai_listener_id, version_number)`,
 		`CREATE INDEX IF NOT EXISTS idx_ai_response_versions_active ON ai_response_versions(ai_listener_id, is_active)`,
+
+		// Create user MFA (TOTP) table (PostgreSQL)
+		`CREATE TABLE IF NOT EXISTS user_mfa (
+			username VARCHAR(255) PRIMARY KEY,
+			secret TEXT NOT NULL,
+			recovery_codes TEXT NOT NULL DEFAULT '',
+			enabled BOOLEAN DEFAULT FALSE,
+			last_counter BIGINT DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			enabled_at TIMESTAMP,
+			FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+		)`,
+
+		// Users can be disabled (SCIM active=false) without deleting them
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN DEFAULT FALSE`,
+
+		// Create groups tables (PostgreSQL)
+		`CREATE TABLE IF NOT EXISTS user_groups (
+			id VARCHAR(255) PRIMARY KEY,
+			name VARCHAR(255) UNIQUE NOT NULL,
+			external_id VARCHAR(255),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_group_members (
+			group_id VARCHAR(255) NOT NULL,
+			username VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (group_id, username),
+			FOREIGN KEY (group_id) REFERENCES user_groups(id) ON DELETE CASCADE,
+			FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_group_members_username ON user_group_members(username)`,
+		`ALTER TABLE user_groups ADD COLUMN IF NOT EXISTS source VARCHAR(32) DEFAULT ''`,
+
+		// Create SCIM user identifiers table (PostgreSQL)
+		`CREATE TABLE IF NOT EXISTS scim_users (
+			id VARCHAR(255) PRIMARY KEY,
+			username VARCHAR(255) UNIQUE NOT NULL,
+			external_id VARCHAR(255),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+		)`,
+
+		// Create roles and role bindings tables (PostgreSQL)
+		`CREATE TABLE IF NOT EXISTS roles (
+			id VARCHAR(255) PRIMARY KEY,
+			name VARCHAR(255) UNIQUE NOT NULL,
+			description TEXT,
+			rules TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS role_bindings (
+			role_id VARCHAR(255) NOT NULL,
+			subject_type VARCHAR(20) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (role_id, subject_type, subject),
+			FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_role_bindings_subject ON role_bindings(subject_type, subject)`,
+
+		// Create append-only audit log table (PostgreSQL)
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			seq BIGINT PRIMARY KEY,
+			at TIMESTAMPTZ NOT NULL,
+			actor VARCHAR(255) NOT NULL,
+			ip VARCHAR(64) NOT NULL,
+			action VARCHAR(255) NOT NULL,
+			method VARCHAR(10) NOT NULL,
+			path TEXT NOT NULL,
+			target_type VARCHAR(64) NOT NULL,
+			target VARCHAR(255) NOT NULL,
+			status INTEGER NOT NULL,
+			before_state TEXT NOT NULL,
+			after_state TEXT NOT NULL,
+			diff TEXT NOT NULL,
+			prev_hash VARCHAR(64) NOT NULL,
+			hash VARCHAR(64) NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target)`,
+		`CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_log is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log`,
+		`CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+		FOR EACH ROW EXECUTE FUNCTION audit_log_append_only()`,
+
+		// Create traffic capture events table (PostgreSQL)
+		`CREATE TABLE IF NOT EXISTS capture_events (
+			id BIGSERIAL PRIMARY KEY,
+			entity_id VARCHAR(255) NOT NULL,
+			conn_id VARCHAR(255) NOT NULL,
+			at TIMESTAMPTZ NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_capture_events_entity ON capture_events(entity_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_capture_events_at ON capture_events(at)`,
+
+		// Create fixtures table for record and replay listeners (PostgreSQL)
+		`CREATE TABLE IF NOT EXISTS listener_fixtures (
+			id BIGSERIAL PRIMARY KEY,
+			listener_id VARCHAR(255) NOT NULL,
+			at TIMESTAMPTZ NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_listener_fixtures_listener ON listener_fixtures(listener_id, id)`,
+		`ALTER TABLE listener_fixtures ADD COLUMN IF NOT EXISTS match_key TEXT DEFAULT ''`,
 	}
 }
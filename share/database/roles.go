@@ -0,0 +1,208 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Role binding subject types
+const (
+	RoleSubjectUser  = "user"
+	RoleSubjectGroup = "group"
+)
+
+// Role is a named set of permission rules. Rules are stored as the JSON
+// encoding of []policy.Rule and interpreted by the policy engine.
+type Role struct {
+	ID          string    `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description *string   `db:"description" json:"description,omitempty"`
+	Rules       string    `db:"rules" json:"-"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// RoleBinding grants a role to a user (by username) or a group (by group ID)
+type RoleBinding struct {
+	RoleID      string    `db:"role_id" json:"role_id"`
+	SubjectType string    `db:"subject_type" json:"type"`
+	Subject     string    `db:"subject" json:"subject"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// CreateRole creates a new role, assigning an ID if none is set
+func (d *SQLDatabase) CreateRole(role *Role) error {
+	if role.ID == "" {
+		role.ID = uuid.New().String()
+	}
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = role.CreatedAt
+
+	query := `INSERT INTO roles (id, name, description, rules, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := d.db.Exec(query, role.ID, role.Name, role.Description, role.Rules, role.CreatedAt, role.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+
+	return nil
+}
+
+// GetRole retrieves a role by ID, returning nil when it does not exist
+func (d *SQLDatabase) GetRole(id string) (*Role, error) {
+	role := &Role{}
+	query := `SELECT id, name, description, rules, created_at, updated_at FROM roles WHERE id = $1`
+
+	err := d.db.Get(role, query, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+
+	return role, nil
+}
+
+// GetRoleByName retrieves a role by name, returning nil when it does not exist
+func (d *SQLDatabase) GetRoleByName(name string) (*Role, error) {
+	role := &Role{}
+	query := `SELECT id, name, description, rules, created_at, updated_at FROM roles WHERE name = $1`
+
+	err := d.db.Get(role, query, name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+
+	return role, nil
+}
+
+// UpdateRole updates a role's name, description and rules
+func (d *SQLDatabase) UpdateRole(role *Role) error {
+	role.UpdatedAt = time.Now()
+
+	query := `UPDATE roles SET name = $1, description = $2, rules = $3, updated_at = $4 WHERE id = $5`
+
+	result, err := d.db.Exec(query, role.Name, role.Description, role.Rules, role.UpdatedAt, role.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("role not found: %s", role.ID)
+	}
+
+	return nil
+}
+
+// DeleteRole deletes a role and its bindings
+func (d *SQLDatabase) DeleteRole(id string) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM role_bindings WHERE role_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete role bindings: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM roles WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ListRoles returns all roles ordered by name
+func (d *SQLDatabase) ListRoles() ([]*Role, error) {
+	var roles []*Role
+	query := `SELECT id, name, description, rules, created_at, updated_at FROM roles ORDER BY name`
+
+	err := d.db.Select(&roles, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// ListRoleBindings returns the subjects a role is granted to
+func (d *SQLDatabase) ListRoleBindings(roleID string) ([]*RoleBinding, error) {
+	var bindings []*RoleBinding
+	query := `SELECT role_id, subject_type, subject, created_at FROM role_bindings
+			  WHERE role_id = $1 ORDER BY subject_type, subject`
+
+	err := d.db.Select(&bindings, query, roleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list role bindings: %w", err)
+	}
+
+	return bindings, nil
+}
+
+// SetRoleBindings replaces the bindings of a role
+func (d *SQLDatabase) SetRoleBindings(roleID string, bindings []*RoleBinding) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM role_bindings WHERE role_id = $1`, roleID); err != nil {
+		return fmt.Errorf("failed to clear role bindings: %w", err)
+	}
+	seen := make(map[string]bool, len(bindings))
+	for _, b := range bindings {
+		key := b.SubjectType + "\x00" + b.Subject
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if _, err := tx.Exec(`INSERT INTO role_bindings (role_id, subject_type, subject, created_at) VALUES ($1, $2, $3, $4)`,
+			roleID, b.SubjectType, b.Subject, time.Now()); err != nil {
+			return fmt.Errorf("failed to add role binding: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListRolesForUser returns the roles bound to a user directly or through their groups
+func (d *SQLDatabase) ListRolesForUser(username string) ([]*Role, error) {
+	var roles []*Role
+	query := `SELECT DISTINCT r.id, r.name, r.description, r.rules, r.created_at, r.updated_at
+			  FROM roles r JOIN role_bindings b ON b.role_id = r.id
+			  WHERE (b.subject_type = $1 AND b.subject = $2)
+			     OR (b.subject_type = $3 AND b.subject IN (SELECT group_id FROM user_group_members WHERE username = $4))
+			  ORDER BY r.name`
+
+	err := d.db.Select(&roles, query, RoleSubjectUser, username, RoleSubjectGroup, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// DeleteSubjectRoleBindings removes every binding for a user or group
+func (d *SQLDatabase) DeleteSubjectRoleBindings(subjectType, subject string) error {
+	query := `DELETE FROM role_bindings WHERE subject_type = $1 AND subject = $2`
+
+	if _, err := d.db.Exec(query, subjectType, subject); err != nil {
+		return fmt.Errorf("failed to delete role bindings: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AuthSourceSCIMProvisioned marks users created through the SCIM provisioning API
+const AuthSourceSCIMProvisioned = "scim_provisioned"
+
+// SCIMUser maps a stable SCIM resource ID to a local username
+type SCIMUser struct {
+	ID         string    `db:"id" json:"id"`
+	Username   string    `db:"username" json:"username"`
+	ExternalID *string   `db:"external_id" json:"external_id,omitempty"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// GetSCIMUser retrieves a SCIM mapping by resource ID, returning nil when absent
+func (d *SQLDatabase) GetSCIMUser(id string) (*SCIMUser, error) {
+	u := &SCIMUser{}
+	query := `SELECT id, username, external_id, created_at, updated_at FROM scim_users WHERE id = $1`
+
+	err := d.db.Get(u, query, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get SCIM user: %w", err)
+	}
+
+	return u, nil
+}
+
+// GetSCIMUserByUsername retrieves a SCIM mapping by username, returning nil when absent
+func (d *SQLDatabase) GetSCIMUserByUsername(username string) (*SCIMUser, error) {
+	u := &SCIMUser{}
+	query := `SELECT id, username, external_id, created_at, updated_at FROM scim_users WHERE username = $1`
+
+	err := d.db.Get(u, query, username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get SCIM user: %w", err)
+	}
+
+	return u, nil
+}
+
+// SaveSCIMUser creates or updates a SCIM mapping
+func (d *SQLDatabase) SaveSCIMUser(u *SCIMUser) error {
+	now := time.Now()
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = now
+	}
+	u.UpdatedAt = now
+
+	updateQuery := `UPDATE scim_users SET username = $1, external_id = $2, updated_at = $3 WHERE id = $4`
+
+	result, err := d.db.Exec(updateQuery, u.Username, u.ExternalID, u.UpdatedAt, u.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update SCIM user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		insertQuery := `INSERT INTO scim_users (id, username, external_id, created_at, updated_at)
+						VALUES ($1, $2, $3, $4, $5)`
+
+		_, err = d.db.Exec(insertQuery, u.ID, u.Username, u.ExternalID, u.CreatedAt, u.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create SCIM user: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteSCIMUser removes the SCIM mapping for a username
+func (d *SQLDatabase) DeleteSCIMUser(username string) error {
+	query := `DELETE FROM scim_users WHERE username = $1`
+
+	_, err := d.db.Exec(query, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete SCIM user: %w", err)
+	}
+
+	return nil
+}
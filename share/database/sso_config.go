@@ -15,6 +15,7 @@ const (
 	SSOProviderAuth0 SSOProvider = "auth0"
 	SSOProviderOkta  SSOProvider = "okta"
 	SSOProviderAzure SSOProvider = "azure"
+	SSOProviderOIDC  SSOProvider = "oidc"
 )
 
 // SSOConfig represents SSO configuration stored in database
@@ -51,6 +52,22 @@ type Auth0Config struct {
 	Scopes       []string `json:"scopes"`
 }
 
+// OIDCConfig represents a generic OpenID Connect provider. Endpoints and
+// signing keys are taken from the issuer's discovery document.
+type OIDCConfig struct {
+	IssuerURL     string   `json:"issuer_url"`
+	ClientID      string   `json:"client_id"`
+	ClientSecret  string   `json:"client_secret,omitempty"`
+	RedirectURL   string   `json:"redirect_url"`
+	Scopes        []string `json:"scopes"`
+	UsernameClaim string   `json:"username_claim,omitempty"` // default "preferred_username"
+	EmailClaim    string   `json:"email_claim,omitempty"`    // default "email"
+	NameClaim     string   `json:"name_claim,omitempty"`     // default "name"
+	GroupsClaim   string   `json:"groups_claim,omitempty"`   // default "groups"; dotted paths allowed
+	AdminGroups   []string `json:"admin_groups,omitempty"`   // members are granted admin on every login
+	AllowedGroups []string `json:"allowed_groups,omitempty"` // when set, only members may sign in
+}
+
 // UserAuthSource represents how a user was authenticated
 type UserAuthSource struct {
 	ID           int       `db:"id" json:"id"`
@@ -124,6 +141,12 @@ func (d *SQLDatabase) GetSSOConfig(provider SSOProvider) (*SSOConfig, error) {
 			return nil, fmt.Errorf("failed to unmarshal Auth0 config: %w", err)
 		}
 		config.Config = auth0Config
+	case SSOProviderOIDC:
+		var oidcConfig OIDCConfig
+		if err := json.Unmarshal([]byte(config.ConfigJSON), &oidcConfig); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal OIDC config: %w", err)
+		}
+		config.Config = oidcConfig
 	}
 
 	return config, nil
@@ -160,6 +183,11 @@ func (d *SQLDatabase) ListSSOConfigs() ([]*SSOConfig, error) {
 			if err := json.Unmarshal([]byte(config.ConfigJSON), &auth0Config); err == nil {
 				config.Config = auth0Config
 			}
+		case SSOProviderOIDC:
+			var oidcConfig OIDCConfig
+			if err := json.Unmarshal([]byte(config.ConfigJSON), &oidcConfig); err == nil {
+				config.Config = oidcConfig
+			}
 		}
 
 		configs = append(configs, config)
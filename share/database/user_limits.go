@@ -199,6 +199,19 @@ func (d *SQLDatabase) GetSettingBool(key string, defaultValue bool) (bool, error
 	}
 }
 
+// GetSettingString is a helper to get string settings with default
+func (d *SQLDatabase) GetSettingString(key string, defaultValue string) (string, error) {
+	var value string
+	query := `SELECT value FROM settings WHERE key = $1`
+	if err := d.db.Get(&value, query, key); err != nil {
+		if err == sql.ErrNoRows {
+			return defaultValue, nil
+		}
+		return defaultValue, err
+	}
+	return value, nil
+}
+
 // SetSettingString upserts a string setting value
 func (d *SQLDatabase) SetSettingString(key string, value string) error {
 	query := `INSERT INTO settings (key, value, updated_at) VALUES ($1, $2, $3)
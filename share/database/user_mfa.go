@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// UserMFA holds a user's TOTP enrollment. Secret and RecoveryCodes are stored encrypted.
+type UserMFA struct {
+	Username      string     `db:"username" json:"username"`
+	Secret        string     `db:"secret" json:"-"`
+	RecoveryCodes string     `db:"recovery_codes" json:"-"` // encrypted JSON array of hashed codes
+	Enabled       bool       `db:"enabled" json:"enabled"`
+	LastCounter   int64      `db:"last_counter" json:"-"` // last accepted TOTP step, to reject replays
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at" json:"updated_at"`
+	EnabledAt     *time.Time `db:"enabled_at" json:"enabled_at,omitempty"`
+}
+
+// SetSecret encrypts and stores the TOTP secret
+func (m *UserMFA) SetSecret(secret string) error {
+	enc, err := EncryptAPIKey(secret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt MFA secret: %w", err)
+	}
+	m.Secret = enc
+	return nil
+}
+
+// GetSecret decrypts the stored TOTP secret
+func (m *UserMFA) GetSecret() (string, error) {
+	return DecryptAPIKey(m.Secret)
+}
+
+// SetRecoveryHashes encrypts and stores the hashed recovery codes
+func (m *UserMFA) SetRecoveryHashes(hashes []string) error {
+	if hashes == nil {
+		hashes = []string{}
+	}
+	b, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	enc, err := EncryptAPIKey(string(b))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt recovery codes: %w", err)
+	}
+	m.RecoveryCodes = enc
+	return nil
+}
+
+// GetRecoveryHashes decrypts the stored hashed recovery codes
+func (m *UserMFA) GetRecoveryHashes() ([]string, error) {
+	if m.RecoveryCodes == "" {
+		return []string{}, nil
+	}
+	plain, err := DecryptAPIKey(m.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	if err := json.Unmarshal([]byte(plain), &hashes); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// GetUserMFA returns the MFA enrollment for a user, or nil if none exists
+func (d *SQLDatabase) GetUserMFA(username string) (*UserMFA, error) {
+	m := &UserMFA{}
+	query := `SELECT username, secret, recovery_codes, enabled, last_counter, created_at, updated_at, enabled_at
+			  FROM user_mfa WHERE username = $1`
+
+	err := d.db.Get(m, query, username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user MFA: %w", err)
+	}
+
+	return m, nil
+}
+
+// SaveUserMFA creates or replaces the MFA enrollment for a user
+func (d *SQLDatabase) SaveUserMFA(m *UserMFA) error {
+	now := time.Now()
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = now
+	}
+	m.UpdatedAt = now
+
+	updateQuery := `UPDATE user_mfa SET secret = $1, recovery_codes = $2, enabled = $3, last_counter = $4,
+					updated_at = $5, enabled_at = $6 WHERE username = $7`
+
+	result, err := d.db.Exec(updateQuery, m.Secret, m.RecoveryCodes, m.Enabled, m.LastCounter,
+		m.UpdatedAt, m.EnabledAt, m.Username)
+	if err != nil {
+		return fmt.Errorf("failed to update user MFA: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		insertQuery := `INSERT INTO user_mfa (username, secret, recovery_codes, enabled, last_counter, created_at, updated_at, enabled_at)
+						VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+		_, err = d.db.Exec(insertQuery, m.Username, m.Secret, m.RecoveryCodes, m.Enabled, m.LastCounter,
+			m.CreatedAt, m.UpdatedAt, m.EnabledAt)
+		if err != nil {
+			return fmt.Errorf("failed to create user MFA: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteUserMFA removes a user's MFA enrollment
+func (d *SQLDatabase) DeleteUserMFA(username string) error {
+	query := `DELETE FROM user_mfa WHERE username = $1`
+
+	_, err := d.db.Exec(query, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete user MFA: %w", err)
+	}
+
+	return nil
+}
@@ -9,7 +9,7 @@ import (
 // GetUser retrieves a user by username
 func (d *SQLDatabase) GetUser(username string) (*User, error) {
 	user := &User{}
-	query := `SELECT id, username, password, email, display_name, is_admin, addresses, created_at, updated_at
+	query := `SELECT id, username, password, email, display_name, is_admin, addresses, disabled, created_at, updated_at
 			  FROM users WHERE username = $1`
 
 	err := d.db.Get(user, query, username)
@@ -86,10 +86,32 @@ func (d *SQLDatabase) DeleteUser(username string) error {
 	return nil
 }
 
+// SetUserDisabled enables or disables a user without touching other fields.
+// Disabled users keep their data but can no longer authenticate.
+func (d *SQLDatabase) SetUserDisabled(username string, disabled bool) error {
+	query := `UPDATE users SET disabled = $1, updated_at = $2 WHERE username = $3`
+
+	result, err := d.db.Exec(query, disabled, time.Now(), username)
+	if err != nil {
+		return fmt.Errorf("failed to update user status: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found: %s", username)
+	}
+
+	return nil
+}
+
 // ListUsers retrieves all users
 func (d *SQLDatabase) ListUsers() ([]*User, error) {
 	var users []*User
-	query := `SELECT id, username, password, email, display_name, is_admin, addresses, created_at, updated_at
+	query := `SELECT id, username, password, email, display_name, is_admin, addresses, disabled, created_at, updated_at
 			  FROM users ORDER BY username`
 
 	err := d.db.Select(&users, query)
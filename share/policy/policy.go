@@ -0,0 +1,263 @@
+// Package policy evaluates role-based authorization decisions.
+//
+// A Subject (a user plus the groups and roles resolved for them) is checked
+// against an action and, where relevant, the resource it targets. Admins are
+// allowed everything; other users are allowed an action when any of their
+// roles has a matching rule. Users without any role get DefaultRole, which
+// mirrors the permissions regular users had before roles were introduced.
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Actions that can be authorized
+const (
+	ActionAdmin           = "admin"            // full administrative access
+	ActionTunnelOpen      = "tunnel:open"      // open a reverse tunnel on a server port
+	ActionListenerCreate  = "listener:create"  // create a listener of a given mode on a port
+	ActionCaptureView     = "capture:view"     // view captured traffic owned by a user
+	ActionMulticastManage = "multicast:manage" // create, update and delete multicast tunnels
+)
+
+// Capture visibility scopes for ActionCaptureView
+const (
+	ScopeOwn   = "own"   // captures of the subject's own tunnels and listeners
+	ScopeGroup = "group" // captures owned by anyone sharing a group with the subject
+	ScopeAll   = "all"   // all captures
+)
+
+var knownActions = map[string]bool{
+	ActionAdmin:           true,
+	ActionTunnelOpen:      true,
+	ActionListenerCreate:  true,
+	ActionCaptureView:     true,
+	ActionMulticastManage: true,
+}
+
+// Rule grants one action, optionally narrowed by ports, listener modes or scope
+type Rule struct {
+	Action string   `json:"action"`
+	Ports  string   `json:"ports,omitempty"` // e.g. "8000-8999,9090"; empty means any port
+	Modes  []string `json:"modes,omitempty"` // listener modes; empty means any mode
+	Scope  string   `json:"scope,omitempty"` // capture scope; empty means own
+}
+
+// Role is a named set of rules
+type Role struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// Subject is the principal being authorized
+type Subject struct {
+	Username string
+	Admin    bool     // built-in admin flag (--auth, authfile or database)
+	Groups   []string // IDs of the groups the user belongs to
+	Roles    []Role   // roles bound to the user directly or through a group
+}
+
+// Resource describes the target of an action
+type Resource struct {
+	Owner       string   // owning username (capture:view)
+	OwnerGroups []string // group IDs of the owner (capture:view with group scope)
+	Port        int      // server port (tunnel:open, listener:create)
+	Mode        string   // listener mode (listener:create)
+}
+
+// Decision is the outcome of an authorization check
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// DefaultRole applies to non-admin users without any role binding
+var DefaultRole = Role{
+	Name: "default",
+	Rules: []Rule{
+		{Action: ActionTunnelOpen},
+		{Action: ActionListenerCreate},
+		{Action: ActionCaptureView, Scope: ScopeOwn},
+	},
+}
+
+// Engine evaluates authorization decisions
+type Engine struct {
+	// Default is used for subjects that have no roles
+	Default Role
+}
+
+// NewEngine returns an engine using DefaultRole for unbound users
+func NewEngine() *Engine {
+	return &Engine{Default: DefaultRole}
+}
+
+// IsAdmin reports whether the subject has administrative access
+func (e *Engine) IsAdmin(sub *Subject) bool {
+	if sub == nil {
+		return false
+	}
+	if sub.Admin {
+		return true
+	}
+	for _, role := range sub.Roles {
+		for _, rule := range role.Rules {
+			if rule.Action == ActionAdmin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorize decides whether the subject may perform action on res
+func (e *Engine) Authorize(sub *Subject, action string, res Resource) Decision {
+	if sub == nil {
+		return Decision{Reason: "not authenticated"}
+	}
+	if e.IsAdmin(sub) {
+		return Decision{Allowed: true}
+	}
+	if action == ActionCaptureView && res.Owner == sub.Username {
+		// Users can always see traffic of their own tunnels and listeners
+		return Decision{Allowed: true}
+	}
+
+	roles := sub.Roles
+	if len(roles) == 0 {
+		roles = []Role{e.Default}
+	}
+	for _, role := range roles {
+		for _, rule := range role.Rules {
+			if rule.Action == action && e.ruleMatches(sub, rule, res) {
+				return Decision{Allowed: true}
+			}
+		}
+	}
+	return Decision{Reason: denialReason(sub, action, res)}
+}
+
+func (e *Engine) ruleMatches(sub *Subject, rule Rule, res Resource) bool {
+	switch rule.Action {
+	case ActionTunnelOpen:
+		return portAllowed(rule.Ports, res.Port)
+	case ActionListenerCreate:
+		return portAllowed(rule.Ports, res.Port) && modeAllowed(rule.Modes, res.Mode)
+	case ActionCaptureView:
+		switch rule.Scope {
+		case ScopeAll:
+			return true
+		case ScopeGroup:
+			return res.Owner == sub.Username || sharesGroup(sub.Groups, res.OwnerGroups)
+		default:
+			return res.Owner == sub.Username
+		}
+	case ActionMulticastManage:
+		return true
+	}
+	return false
+}
+
+func denialReason(sub *Subject, action string, res Resource) string {
+	switch action {
+	case ActionTunnelOpen:
+		return fmt.Sprintf("user %s may not open tunnels on port %d", sub.Username, res.Port)
+	case ActionListenerCreate:
+		return fmt.Sprintf("user %s may not create %s listeners on port %d", sub.Username, res.Mode, res.Port)
+	case ActionCaptureView:
+		return fmt.Sprintf("user %s may not view captures of %s", sub.Username, res.Owner)
+	case ActionMulticastManage:
+		return fmt.Sprintf("user %s may not manage multicast tunnels", sub.Username)
+	}
+	return fmt.Sprintf("user %s is not permitted to %s", sub.Username, action)
+}
+
+func portAllowed(spec string, port int) bool {
+	if strings.TrimSpace(spec) == "" {
+		return true
+	}
+	ranges, err := ParsePortRanges(spec)
+	if err != nil {
+		return false
+	}
+	for _, r := range ranges {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func modeAllowed(modes []string, mode string) bool {
+	if len(modes) == 0 {
+		return true
+	}
+	for _, m := range modes {
+		if strings.EqualFold(m, mode) {
+			return true
+		}
+	}
+	return false
+}
+
+func sharesGroup(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParsePortRanges parses a comma-separated list of ports and inclusive ranges
+func ParsePortRanges(spec string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			lo, hi = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		}
+		start, err1 := strconv.Atoi(lo)
+		end, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
+}
+
+// Validate checks that a rule is well formed
+func (r Rule) Validate() error {
+	if !knownActions[r.Action] {
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	if r.Ports != "" {
+		if r.Action != ActionTunnelOpen && r.Action != ActionListenerCreate {
+			return fmt.Errorf("ports do not apply to %s", r.Action)
+		}
+		if _, err := ParsePortRanges(r.Ports); err != nil {
+			return err
+		}
+	}
+	if len(r.Modes) > 0 && r.Action != ActionListenerCreate {
+		return fmt.Errorf("modes do not apply to %s", r.Action)
+	}
+	if r.Scope != "" {
+		if r.Action != ActionCaptureView {
+			return fmt.Errorf("scope does not apply to %s", r.Action)
+		}
+		if r.Scope != ScopeOwn && r.Scope != ScopeGroup && r.Scope != ScopeAll {
+			return fmt.Errorf("unknown scope %q", r.Scope)
+		}
+	}
+	return nil
+}
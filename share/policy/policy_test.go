@@ -0,0 +1,77 @@
+package policy
+
+import "testing"
+
+func TestAuthorize(t *testing.T) {
+	e := NewEngine()
+	ops := Role{Name: "ops", Rules: []Rule{
+		{Action: ActionTunnelOpen, Ports: "8000-8999, 9100"},
+		{Action: ActionListenerCreate, Ports: "8000-8999", Modes: []string{"sink"}},
+		{Action: ActionCaptureView, Scope: ScopeGroup},
+	}}
+
+	tests := []struct {
+		name    string
+		sub     *Subject
+		action  string
+		res     Resource
+		allowed bool
+	}{
+		{"nil subject", nil, ActionTunnelOpen, Resource{Port: 80}, false},
+		{"admin flag", &Subject{Username: "root", Admin: true}, ActionMulticastManage, Resource{}, true},
+		{"admin role", &Subject{Username: "a", Roles: []Role{{Rules: []Rule{{Action: ActionAdmin}}}}}, ActionCaptureView, Resource{Owner: "b"}, true},
+		{"default tunnel", &Subject{Username: "u"}, ActionTunnelOpen, Resource{Port: 22}, true},
+		{"default listener", &Subject{Username: "u"}, ActionListenerCreate, Resource{Port: 22, Mode: "proxy"}, true},
+		{"default own capture", &Subject{Username: "u"}, ActionCaptureView, Resource{Owner: "u"}, true},
+		{"default other capture", &Subject{Username: "u"}, ActionCaptureView, Resource{Owner: "v"}, false},
+		{"default multicast", &Subject{Username: "u"}, ActionMulticastManage, Resource{}, false},
+		{"default admin", &Subject{Username: "u"}, ActionAdmin, Resource{}, false},
+		{"role port in range", &Subject{Username: "u", Roles: []Role{ops}}, ActionTunnelOpen, Resource{Port: 8500}, true},
+		{"role single port", &Subject{Username: "u", Roles: []Role{ops}}, ActionTunnelOpen, Resource{Port: 9100}, true},
+		{"role port out of range", &Subject{Username: "u", Roles: []Role{ops}}, ActionTunnelOpen, Resource{Port: 9000}, false},
+		{"role mode allowed", &Subject{Username: "u", Roles: []Role{ops}}, ActionListenerCreate, Resource{Port: 8001, Mode: "SINK"}, true},
+		{"role mode denied", &Subject{Username: "u", Roles: []Role{ops}}, ActionListenerCreate, Resource{Port: 8001, Mode: "proxy"}, false},
+		{"group capture", &Subject{Username: "u", Groups: []string{"g1"}, Roles: []Role{ops}}, ActionCaptureView, Resource{Owner: "v", OwnerGroups: []string{"g1"}}, true},
+		{"group capture other group", &Subject{Username: "u", Groups: []string{"g1"}, Roles: []Role{ops}}, ActionCaptureView, Resource{Owner: "v", OwnerGroups: []string{"g2"}}, false},
+		{"own capture with roles", &Subject{Username: "u", Roles: []Role{{Name: "empty"}}}, ActionCaptureView, Resource{Owner: "u"}, true},
+		{"roles replace default", &Subject{Username: "u", Roles: []Role{{Name: "empty"}}}, ActionTunnelOpen, Resource{Port: 22}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := e.Authorize(tt.sub, tt.action, tt.res)
+			if d.Allowed != tt.allowed {
+				t.Fatalf("Authorize() = %v (%s), want %v", d.Allowed, d.Reason, tt.allowed)
+			}
+			if !d.Allowed && d.Reason == "" {
+				t.Fatalf("denial without reason")
+			}
+		})
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	valid := []Rule{
+		{Action: ActionAdmin},
+		{Action: ActionTunnelOpen, Ports: "1-1024,8080"},
+		{Action: ActionListenerCreate, Modes: []string{"sink", "ai-mock"}},
+		{Action: ActionCaptureView, Scope: ScopeAll},
+	}
+	for _, r := range valid {
+		if err := r.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v", r, err)
+		}
+	}
+	invalid := []Rule{
+		{Action: "tunnels:delete"},
+		{Action: ActionTunnelOpen, Ports: "9000-8000"},
+		{Action: ActionTunnelOpen, Ports: "0-10"},
+		{Action: ActionCaptureView, Ports: "80"},
+		{Action: ActionTunnelOpen, Modes: []string{"sink"}},
+		{Action: ActionCaptureView, Scope: "everyone"},
+	}
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", r)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Capture modes of a CapturePolicy
+const (
+	CaptureFull    = "full"
+	CaptureHeaders = "headers"
+	CaptureOff     = "off"
+)
+
+// CapturePolicy controls traffic capture for one tunnel, listener or
+// multicast tunnel. Zero values fall back to the server defaults: full
+// capture of every connection, the server's body limit and retention.
+type CapturePolicy struct {
+	// Mode is "full", "headers" (message bodies are not kept) or "off"
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// SampleRate is the fraction of connections captured, in (0, 1]
+	SampleRate float64 `json:"sample_rate,omitempty" yaml:"sample-rate,omitempty"`
+	// MaxBodyBytes caps the bytes kept of each message body
+	MaxBodyBytes int `json:"max_body_bytes,omitempty" yaml:"max-body-bytes,omitempty"`
+	// IncludePaths and ExcludePaths select HTTP exchanges by request path,
+	// where "*" matches any run of characters
+	IncludePaths []string `json:"include_paths,omitempty" yaml:"include-paths,omitempty"`
+	ExcludePaths []string `json:"exclude_paths,omitempty" yaml:"exclude-paths,omitempty"`
+	// RetentionDays overrides how long persisted captures are kept
+	RetentionDays int `json:"retention_days,omitempty" yaml:"retention-days,omitempty"`
+}
+
+// Validate checks the policy's values
+func (p CapturePolicy) Validate() error {
+	switch p.Mode {
+	case "", CaptureFull, CaptureHeaders, CaptureOff:
+	default:
+		return fmt.Errorf("invalid capture mode %q", p.Mode)
+	}
+	if p.SampleRate < 0 || p.SampleRate > 1 {
+		return errors.New("sample_rate must be between 0 and 1")
+	}
+	if p.MaxBodyBytes < 0 {
+		return errors.New("max_body_bytes must not be negative")
+	}
+	if p.RetentionDays < 0 {
+		return errors.New("retention_days must not be negative")
+	}
+	for _, pat := range append(append([]string(nil), p.IncludePaths...), p.ExcludePaths...) {
+		if pat == "" {
+			return errors.New("path patterns must not be empty")
+		}
+	}
+	return nil
+}
+
+// IsZero reports whether the policy sets nothing
+func (p CapturePolicy) IsZero() bool {
+	return p.Mode == "" && p.SampleRate == 0 && p.MaxBodyBytes == 0 &&
+		len(p.IncludePaths) == 0 && len(p.ExcludePaths) == 0 && p.RetentionDays == 0
+}
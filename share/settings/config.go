@@ -8,6 +8,9 @@ import (
 type Config struct {
 	Version string
 	Remotes
+	// Capture holds capture policies requested by the client, keyed by the
+	// encoded remote (Remote.Encode)
+	Capture map[string]CapturePolicy `json:",omitempty"`
 }
 
 func DecodeConfig(b []byte) (*Config, error) {
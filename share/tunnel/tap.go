@@ -2,6 +2,7 @@ package tunnel
 
 import (
 	"io"
+	"time"
 
 	"github.com/NextChapterSoftware/chissl/share/settings"
 )
@@ -12,6 +13,10 @@ type Meta struct {
 	Username string
 	Remote   settings.Remote
 	ConnID   string
+	// Peer is the address of the connecting client, when known
+	Peer string
+	// Accepted is when the connection was accepted, when known
+	Accepted time.Time
 }
 
 // Tap receives lifecycle and byte-stream callbacks for a single connection.
@@ -22,6 +27,12 @@ type Tap interface {
 	OnClose(sent int64, received int64)
 }
 
+// ChannelTap is implemented by taps that record when the SSH channel
+// carrying their connection was opened.
+type ChannelTap interface {
+	OnChannelOpen()
+}
+
 // TapFactory creates a Tap for a given connection meta. It can
 // return nil to disable capture for that connection.
 type TapFactory func(meta Meta) Tap
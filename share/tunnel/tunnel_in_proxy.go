@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/NextChapterSoftware/chissl/share/cio"
 	"github.com/NextChapterSoftware/chissl/share/settings"
@@ -140,6 +141,7 @@ func (p *Proxy) runHTTPS(ctx context.Context) error {
 
 func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
 	defer src.Close()
+	accepted := time.Now()
 
 	p.mu.Lock()
 	p.count++
@@ -156,7 +158,10 @@ func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
 	// Prepare optional tap early so we can record failures too
 	var tap Tap
 	if t, ok := p.sshTun.(*Tunnel); ok && t.Config.TapFactory != nil {
-		meta := Meta{Username: t.Config.Username, Remote: *p.remote, ConnID: fmt.Sprintf("%d", cid)}
+		meta := Meta{Username: t.Config.Username, Remote: *p.remote, ConnID: fmt.Sprintf("%d", cid), Accepted: accepted}
+		if c, ok := src.(net.Conn); ok {
+			meta.Peer = c.RemoteAddr().String()
+		}
 		tap = t.Config.TapFactory(meta)
 		if tap != nil {
 			tap.OnOpen()
@@ -173,6 +178,9 @@ func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
 		return
 	}
 	go ssh.DiscardRequests(reqs)
+	if ct, ok := tap.(ChannelTap); ok {
+		ct.OnChannelOpen()
+	}
 	// Pipe with tee if tap present
 	var sent, received int64
 	if tap != nil {
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+func TestAuditLog(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	for _, u := range []*database.User{
+		{Username: "admin", Password: "adminpass", IsAdmin: true},
+		{Username: "carol", Password: "carolpass"},
+	} {
+		if err := db.CreateUser(u); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	h := srv.HTTPHandler()
+
+	do := func(method, path string, body interface{}, username, password string) *httptest.ResponseRecorder {
+		req := createAuthenticatedRequest(t, method, path, body, username, password)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Mutating calls are recorded
+	if rr := do(http.MethodPost, "/api/users", map[string]interface{}{"username": "erin", "password": "erinpass"}, "admin", "adminpass"); rr.Code >= 300 {
+		t.Fatalf("expected user creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPut, "/api/users/erin", map[string]interface{}{"username": "erin", "is_admin": true}, "admin", "adminpass"); rr.Code >= 300 {
+		t.Fatalf("expected user update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPut, "/api/settings/audit", map[string]interface{}{"forward_webhooks": true}, "admin", "adminpass"); rr.Code != http.StatusOK {
+		t.Fatalf("expected audit settings update, got %d", rr.Code)
+	}
+	// Calls rejected by authentication are left to security events
+	if rr := do(http.MethodDelete, "/api/users/admin", nil, "carol", "carolpass"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected non-admin delete to be rejected, got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodGet, "/api/audit", nil, "carol", "carolpass"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected audit API to require admin, got %d", rr.Code)
+	}
+	rr := do(http.MethodGet, "/api/audit?target=erin", nil, "admin", "adminpass")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected audit listing, got %d", rr.Code)
+	}
+	var entries []database.AuditEntry
+	if err := json.Unmarshal(rr.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("invalid audit response: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != "user.update" || entries[1].Action != "user.create" {
+		t.Fatalf("unexpected audit entries: %+v", entries)
+	}
+	update := entries[0]
+	if update.Actor != "admin" || update.TargetType != "user" || !strings.Contains(update.Diff, "is_admin") {
+		t.Fatalf("expected update entry with actor and diff, got %+v", update)
+	}
+	if strings.Contains(update.Before, "erinpass") || strings.Contains(update.After, "erinpass") {
+		t.Fatalf("expected password to be redacted, got before=%s after=%s", update.Before, update.After)
+	}
+
+	rr = do(http.MethodGet, "/api/audit?target_type=setting", nil, "admin", "adminpass")
+	entries = nil
+	json.Unmarshal(rr.Body.Bytes(), &entries)
+	if len(entries) != 1 || !strings.Contains(entries[0].Diff, "forward_webhooks") {
+		t.Fatalf("expected settings change with diff, got %+v", entries)
+	}
+
+	// The chain verifies and is exportable
+	rr = do(http.MethodGet, "/api/audit/verify", nil, "admin", "adminpass")
+	var verify struct {
+		Valid   bool `json:"valid"`
+		Checked int  `json:"checked"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &verify)
+	if !verify.Valid || verify.Checked != 3 {
+		t.Fatalf("expected valid chain of 3 entries, got %+v", verify)
+	}
+	rr = do(http.MethodGet, "/api/audit/export?format=csv", nil, "admin", "adminpass")
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "\n") != 4 {
+		t.Fatalf("expected CSV export with header and 3 rows, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Edited entries break verification
+	all, err := db.ListAuditEntries(database.AuditFilter{Ascending: true})
+	if err != nil {
+		t.Fatalf("Failed to list audit entries: %v", err)
+	}
+	if broken := database.VerifyAuditChain(all, ""); broken != 0 {
+		t.Fatalf("expected stored chain to verify, broke at %d", broken)
+	}
+	all[1].Actor = "mallory"
+	if broken := database.VerifyAuditChain(all, ""); broken != all[1].Seq {
+		t.Fatalf("expected tampering to be detected at %d, got %d", all[1].Seq, broken)
+	}
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+// Users allowed to view the captures of a listener may not change how it is
+// captured; only its owner and admins may.
+func TestCaptureSettingsRequireOwnership(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	for _, u := range []*database.User{
+		{Username: "admin", Password: "adminpass", IsAdmin: true},
+		{Username: "carol", Password: "carolpass"},
+		{Username: "erin", Password: "erinpass"},
+	} {
+		if err := db.CreateUser(u); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	if err := db.CreateTunnel(&database.Tunnel{ID: "tun-carol", Username: "carol", LocalPort: 3000, RemotePort: 3000, Status: "open"}); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+	if err := db.CreateListener(&database.Listener{ID: "listener-carol", Name: "carol", Username: "carol", Port: 18080, Mode: "sink", Status: "closed"}); err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+
+	t.Setenv("CHISEL_CAPTURE_DIR", filepath.Join(tempDir, "capture"))
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig, Dashboard: chserver.DashboardConfig{Enabled: true}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	h := srv.HTTPHandler()
+
+	do := func(method, path string, body interface{}, username, password string) int {
+		req := createAuthenticatedRequest(t, method, path, body, username, password)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// erin may view everyone's captures
+	if code := do(http.MethodPost, "/api/roles", map[string]interface{}{
+		"name":     "viewer",
+		"rules":    []map[string]interface{}{{"action": "capture:view", "scope": "all"}},
+		"bindings": []map[string]string{{"type": "user", "subject": "erin"}},
+	}, "admin", "adminpass"); code != http.StatusCreated {
+		t.Fatalf("expected role creation, got %d", code)
+	}
+
+	policy := "/api/capture/listeners/listener-carol/policy"
+	headersOnly := map[string]interface{}{"mode": "headers"}
+	if code := do(http.MethodGet, policy, nil, "erin", "erinpass"); code != http.StatusOK {
+		t.Fatalf("expected a viewer to read the capture policy, got %d", code)
+	}
+	if code := do(http.MethodPut, policy, headersOnly, "erin", "erinpass"); code != http.StatusForbidden {
+		t.Fatalf("expected a viewer not to change the capture policy, got %d", code)
+	}
+	if code := do(http.MethodPut, policy, headersOnly, "carol", "carolpass"); code != http.StatusOK {
+		t.Fatalf("expected the owner to change the capture policy, got %d", code)
+	}
+	if code := do(http.MethodPut, policy, map[string]interface{}{}, "admin", "adminpass"); code != http.StatusOK {
+		t.Fatalf("expected an admin to change the capture policy, got %d", code)
+	}
+
+	redaction := "/api/capture/listeners/listener-carol/redaction"
+	rules := map[string]interface{}{"headers": []string{"X-Api-Key"}}
+	if code := do(http.MethodGet, redaction, nil, "erin", "erinpass"); code != http.StatusOK {
+		t.Fatalf("expected a viewer to read the redaction rules, got %d", code)
+	}
+	if code := do(http.MethodPut, redaction, map[string]interface{}{}, "erin", "erinpass"); code != http.StatusForbidden {
+		t.Fatalf("expected a viewer not to change the redaction rules, got %d", code)
+	}
+	if code := do(http.MethodPut, redaction, rules, "carol", "carolpass"); code != http.StatusOK {
+		t.Fatalf("expected the owner to change the redaction rules, got %d", code)
+	}
+
+	// Replays go through the owner's tunnel and are audited against it
+	replay := "/api/capture/tunnels/tun-carol/replay"
+	exchange := map[string]interface{}{"conn_id": "conn-1", "seq": 1}
+	if code := do(http.MethodPost, replay, exchange, "erin", "erinpass"); code != http.StatusForbidden {
+		t.Fatalf("expected a viewer not to replay captured requests, got %d", code)
+	}
+	if code := do(http.MethodPost, replay, exchange, "carol", "carolpass"); code != http.StatusNotFound {
+		t.Fatalf("expected the owner's replay to look up the exchange, got %d", code)
+	}
+	entries, err := db.ListAuditEntries(database.AuditFilter{Action: "capture_replay.create", Target: "tun-carol", Ascending: true})
+	if err != nil || len(entries) != 2 || entries[0].Actor != "erin" || entries[0].Status != http.StatusForbidden || entries[1].Actor != "carol" {
+		t.Fatalf("expected both replay attempts to be audited, got %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+func TestCaptureWaitTimeout(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := db.CreateUser(&database.User{Username: "carol", Password: "carolpass"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := db.CreateTunnel(&database.Tunnel{ID: "tun-carol", Username: "carol", LocalPort: 3000, RemotePort: 3000, Status: "open"}); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+
+	t.Setenv("CHISEL_CAPTURE_DIR", filepath.Join(tempDir, "capture"))
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig, Dashboard: chserver.DashboardConfig{Enabled: true}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+
+	req := createAuthenticatedRequest(t, http.MethodPost, "/api/capture/tunnels/tun-carol/wait", map[string]interface{}{"path": "/never", "timeout_ms": 50}, "carol", "carolpass")
+	rr := httptest.NewRecorder()
+	srv.HTTPHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504 after the timeout, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Error       string `json:"error"`
+		LastEventID *int64 `json:"last_event_id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil || out.Error == "" || out.LastEventID == nil {
+		t.Fatalf("expected the error and cursor in the body, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+func TestChaosProxyListener(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := db.CreateUser(&database.User{Username: "admin", Password: "adminpass", IsAdmin: true}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	t.Setenv("CHISEL_CAPTURE_DIR", filepath.Join(tempDir, "capture"))
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig, Dashboard: chserver.DashboardConfig{Enabled: true}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	h := srv.HTTPHandler()
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req := createAuthenticatedRequest(t, method, path, body, "admin", "adminpass")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	faults := map[string]interface{}{"enabled": false, "rules": []map[string]interface{}{
+		{"name": "outage", "method": "GET", "path": "/orders/{id}", "error_status": 503},
+	}}
+	rr := do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": port, "mode": "proxy", "target_url": upstream.URL, "chaos": faults})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected proxy listener creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listener database.Listener
+	json.NewDecoder(rr.Body).Decode(&listener)
+
+	get := func(path string) (int, string) {
+		var res *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if res, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", port, path)); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("proxy listener unreachable: %v", err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+
+	if code, body := get("/orders/1"); code != http.StatusOK || body != "upstream /orders/1" {
+		t.Fatalf("expected disabled chaos to pass requests through, got %d %s", code, body)
+	}
+
+	// Switching chaos on applies to the running listener
+	faults["enabled"] = true
+	if rr := do(http.MethodPut, "/api/listener/"+listener.ID+"/chaos", faults); rr.Code != http.StatusOK {
+		t.Fatalf("expected chaos update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if code, _ := get("/orders/1"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the injected outage, got %d", code)
+	}
+	if code, _ := get("/customers/1"); code != http.StatusOK {
+		t.Fatalf("expected other paths to pass through, got %d", code)
+	}
+
+	rr = do(http.MethodGet, "/api/listener/"+listener.ID+"/chaos", nil)
+	var stored struct {
+		Enabled bool `json:"enabled"`
+		Rules   []struct {
+			Name string `json:"name"`
+		} `json:"rules"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&stored); err != nil || !stored.Enabled || len(stored.Rules) != 1 || stored.Rules[0].Name != "outage" {
+		t.Fatalf("unexpected stored chaos %+v, %v", stored, err)
+	}
+
+	if rr := do(http.MethodPut, "/api/listener/"+listener.ID+"/chaos", map[string]interface{}{"enabled": true, "rules": []map[string]interface{}{{"probability": 2}}}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid rule to be rejected, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, "/api/listener/"+listener.ID+"/chaos", map[string]interface{}{"enabled": false, "rules": faults["rules"]}); rr.Code != http.StatusOK {
+		t.Fatalf("expected chaos to be switched off, got %d", rr.Code)
+	}
+	if code, _ := get("/orders/1"); code != http.StatusOK {
+		t.Fatalf("expected the outage to end, got %d", code)
+	}
+}
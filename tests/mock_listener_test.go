@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+func TestMockListener(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := db.CreateUser(&database.User{Username: "admin", Password: "adminpass", IsAdmin: true}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	t.Setenv("CHISEL_CAPTURE_DIR", filepath.Join(tempDir, "capture"))
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig, Dashboard: chserver.DashboardConfig{Enabled: true}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	h := srv.HTTPHandler()
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req := createAuthenticatedRequest(t, method, path, body, "admin", "adminpass")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	rule := func(path, body string) map[string]interface{} {
+		return map[string]interface{}{"method": "GET", "path": path, "response": map[string]interface{}{"status": 200, "body": body}}
+	}
+	if rr := do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": port, "mode": "mock", "rules": []interface{}{
+		map[string]interface{}{"path": "bad"},
+	}}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid rules to be rejected, got %d", rr.Code)
+	}
+	rr := do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": port, "mode": "mock", "use_tls": false, "rules": []interface{}{
+		rule("/users/{id}", `{"id": {{json .Params.id}}}`),
+	}})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected mock listener creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listener database.Listener
+	json.NewDecoder(rr.Body).Decode(&listener)
+
+	get := func(path string) (int, string) {
+		var res *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if res, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", port, path)); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("mock listener unreachable: %v", err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	if code, body := get("/users/7"); code != http.StatusOK || body != `{"id": "7"}` {
+		t.Fatalf("unexpected mock response %d %s", code, body)
+	}
+
+	// Updated rules apply to the running listener
+	rulesPath := "/api/listener/" + listener.ID + "/rules"
+	if rr := do(http.MethodPut, rulesPath, map[string]interface{}{"rules": []interface{}{rule("/users/{id}", "user {{.Params.id}}")}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected rules update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if code, body := get("/users/8"); code != http.StatusOK || body != "user 8" {
+		t.Fatalf("expected the updated rule, got %d %s", code, body)
+	}
+	if code, _ := get("/orders"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a matching rule, got %d", code)
+	}
+	rr = do(http.MethodGet, rulesPath, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"path":"/users/{id}"`) {
+		t.Fatalf("unexpected stored rules %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPut, rulesPath, map[string]interface{}{"rules": []interface{}{map[string]interface{}{"response": map[string]interface{}{"body": "{{"}}}}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid template to be rejected, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,234 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/database"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// stubIdP is a minimal OpenID Connect provider serving discovery, JWKS and a
+// token endpoint that enforces PKCE.
+type stubIdP struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	signKey   *rsa.PrivateKey // key used to sign ID tokens (differs from key to simulate forgery)
+	clientID  string
+	claims    jwt.MapClaims
+	mu        sync.Mutex
+	nonce     string
+	challenge string
+}
+
+func newStubIdP(t *testing.T, clientID string) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &stubIdP{key: key, signKey: key, clientID: clientID}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		if r.FormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":   idp.URL,
+			"aud":   idp.clientID,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(5 * time.Minute).Unix(),
+			"nonce": idp.nonce,
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, _ := token.SignedString(idp.signKey)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": signed})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// login drives the browser side of the flow and returns the callback response
+func (idp *stubIdP) login(t *testing.T, h http.Handler) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/oidc/login", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("login: expected 302, got %d (%s)", rr.Code, rr.Body.String())
+	}
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(loc.String(), idp.URL+"/authorize") {
+		t.Fatalf("login: unexpected redirect %q", rr.Header().Get("Location"))
+	}
+	q := loc.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("login: PKCE parameters missing: %v", q)
+	}
+	idp.mu.Lock()
+	idp.nonce = q.Get("nonce")
+	idp.challenge = q.Get("code_challenge")
+	idp.mu.Unlock()
+
+	req := httptest.NewRequest("GET", "/auth/oidc/callback?code=good-code&state="+url.QueryEscape(q.Get("state")), nil)
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	cb := httptest.NewRecorder()
+	h.ServeHTTP(cb, req)
+	return cb
+}
+
+func sessionCookie(rr *httptest.ResponseRecorder) string {
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "chissl_session" && c.MaxAge >= 0 {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// TestOIDCLoginFlow exercises the generic OIDC provider against a stub IdP
+func TestOIDCLoginFlow(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	idp := newStubIdP(t, "chissl")
+	if err := db.CreateSSOConfig(&database.SSOConfig{
+		Provider: database.SSOProviderOIDC,
+		Enabled:  true,
+		Config: database.OIDCConfig{
+			IssuerURL:     idp.URL,
+			ClientID:      "chissl",
+			RedirectURL:   "http://localhost/auth/oidc/callback",
+			GroupsClaim:   "realm_access.roles",
+			AdminGroups:   []string{"ops"},
+			AllowedGroups: []string{"ops", "dev"},
+		},
+	}); err != nil {
+		t.Fatalf("Failed to save SSO config: %v", err)
+	}
+
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	h := srv.HTTPHandler()
+
+	// First login provisions the user and maps the group to admin
+	idp.claims = jwt.MapClaims{
+		"sub":                "subject-1",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"realm_access":       map[string]interface{}{"roles": []string{"ops"}},
+	}
+	rr := idp.login(t, h)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/dashboard" || sessionCookie(rr) != "alice" {
+		t.Fatalf("expected dashboard session for alice, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	user, err := db.GetUser("alice")
+	if err != nil || !user.IsAdmin || user.Email != "alice@example.com" {
+		t.Fatalf("expected provisioned admin user, got %+v (%v)", user, err)
+	}
+	source, err := db.GetUserAuthSource("alice")
+	if err != nil || source == nil || source.AuthSource != "oidc" || source.ExternalID == nil || *source.ExternalID != "subject-1" {
+		t.Fatalf("expected oidc auth source, got %+v (%v)", source, err)
+	}
+
+	// Group removal revokes admin on the next login
+	idp.claims["realm_access"] = map[string]interface{}{"roles": []string{"dev"}}
+	if rr := idp.login(t, h); sessionCookie(rr) != "alice" {
+		t.Fatalf("expected second login to succeed, got %q", rr.Header().Get("Location"))
+	}
+	if user, _ := db.GetUser("alice"); user == nil || user.IsAdmin {
+		t.Fatalf("expected admin to be revoked, got %+v", user)
+	}
+
+	// Users outside the allowed groups are rejected
+	idp.claims = jwt.MapClaims{"sub": "subject-2", "preferred_username": "mallory", "realm_access": map[string]interface{}{"roles": []string{"guest"}}}
+	if rr := idp.login(t, h); sessionCookie(rr) != "" || !strings.Contains(rr.Header().Get("Location"), "error=sso_failed") {
+		t.Fatalf("expected group policy rejection, got %q", rr.Header().Get("Location"))
+	}
+
+	// A different subject cannot take over an existing username
+	idp.claims = jwt.MapClaims{"sub": "subject-3", "preferred_username": "alice", "realm_access": map[string]interface{}{"roles": []string{"ops"}}}
+	if rr := idp.login(t, h); sessionCookie(rr) != "" {
+		t.Fatalf("expected account takeover to be rejected")
+	}
+
+	// SCIM-provisioned accounts are linked by their externalId, not the username claim
+	carlID := "subject-carl"
+	if err := db.CreateUser(&database.User{Username: "carl", Password: "carlpass"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := db.SaveSCIMUser(&database.SCIMUser{ID: "scim-carl", Username: "carl", ExternalID: &carlID}); err != nil {
+		t.Fatalf("Failed to save SCIM user: %v", err)
+	}
+	if err := db.CreateUserAuthSource(&database.UserAuthSource{Username: "carl", AuthSource: database.AuthSourceSCIMProvisioned, ExternalID: &carlID}); err != nil {
+		t.Fatalf("Failed to save auth source: %v", err)
+	}
+	idp.claims = jwt.MapClaims{"sub": "subject-4", "preferred_username": "carl", "realm_access": map[string]interface{}{"roles": []string{"dev"}}}
+	if rr := idp.login(t, h); sessionCookie(rr) != "" {
+		t.Fatalf("expected a matching username claim alone not to claim a SCIM account")
+	}
+	idp.claims["sub"] = carlID
+	if rr := idp.login(t, h); sessionCookie(rr) != "carl" {
+		t.Fatalf("expected the SCIM account to be linked by subject, got %q", rr.Header().Get("Location"))
+	}
+
+	// Tokens not signed by the published key are rejected
+	forged, _ := rsa.GenerateKey(rand.Reader, 2048)
+	idp.signKey = forged
+	idp.claims = jwt.MapClaims{"sub": "subject-1", "preferred_username": "alice", "realm_access": map[string]interface{}{"roles": []string{"ops"}}}
+	if rr := idp.login(t, h); sessionCookie(rr) != "" {
+		t.Fatalf("expected forged id_token to be rejected")
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+const ordersSpec = `openapi: 3.0.3
+info: {title: Orders, version: "1"}
+paths:
+  /orders/{id}:
+    parameters:
+      - {name: id, in: path, required: true, schema: {type: integer}}
+    get:
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  id: {type: integer}
+                  status: {type: string, enum: [open, paid, shipped]}
+                  total: {type: number, minimum: 0}
+                  placed_at: {type: string, format: date-time}
+        "404":
+          content:
+            application/json: {example: {error: no such order}}
+`
+
+func TestOpenAPIListener(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := db.CreateUser(&database.User{Username: "admin", Password: "adminpass", IsAdmin: true}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	t.Setenv("CHISEL_CAPTURE_DIR", filepath.Join(tempDir, "capture"))
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig, Dashboard: chserver.DashboardConfig{Enabled: true}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	h := srv.HTTPHandler()
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req := createAuthenticatedRequest(t, method, path, body, "admin", "adminpass")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	if rr := do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": port, "mode": "openapi", "openapi_spec": "swagger: '2.0'"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid spec to be rejected, got %d", rr.Code)
+	}
+	rr := do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": port, "mode": "openapi", "openapi_spec": ordersSpec})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected openapi listener creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listener database.Listener
+	json.NewDecoder(rr.Body).Decode(&listener)
+
+	get := func(path string, header http.Header) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d%s", port, path), nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		var res *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if res, err = http.DefaultClient.Do(req); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("openapi listener unreachable: %v", err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+
+	// Responses without examples are synthesized, the same way every time
+	code, first := get("/orders/12", nil)
+	var order struct {
+		ID       *float64 `json:"id"`
+		Status   string   `json:"status"`
+		Total    *float64 `json:"total"`
+		PlacedAt string   `json:"placed_at"`
+	}
+	if err := json.Unmarshal([]byte(first), &order); code != http.StatusOK || err != nil || order.ID == nil || order.Total == nil || *order.Total < 0 ||
+		!strings.Contains("open paid shipped", order.Status) || order.Status == "" {
+		t.Fatalf("unexpected synthesized response %d %s", code, first)
+	}
+	if _, err := time.Parse(time.RFC3339, order.PlacedAt); err != nil {
+		t.Fatalf("expected a date-time, got %q", order.PlacedAt)
+	}
+	if _, again := get("/orders/12", nil); again != first {
+		t.Fatalf("expected a deterministic response, got %s then %s", first, again)
+	}
+	if code, body := get("/orders/12", http.Header{"Prefer": {"code=404"}}); code != http.StatusNotFound || body != `{"error":"no such order"}` {
+		t.Fatalf("unexpected preferred response %d %s", code, body)
+	}
+	if code, body := get("/orders/abc", nil); code != http.StatusBadRequest || !strings.Contains(body, "path parameter id must be of type integer") {
+		t.Fatalf("expected a validation error, got %d %s", code, body)
+	}
+
+	// A new spec applies to the running listener
+	updated := strings.Replace(ordersSpec, "/orders/{id}", "/invoices/{id}", 1)
+	if rr := do(http.MethodPut, "/api/listener/"+listener.ID, map[string]interface{}{"openapi_spec": "openapi: 3.0.0"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid spec update to be rejected, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, "/api/listener/"+listener.ID, map[string]interface{}{"openapi_spec": updated}); rr.Code != http.StatusOK {
+		t.Fatalf("expected spec update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if code, _ := get("/invoices/3", nil); code != http.StatusOK {
+		t.Fatalf("expected the updated spec, got %d", code)
+	}
+	if code, _ := get("/orders/3", nil); code != http.StatusNotFound {
+		t.Fatalf("expected the old path to be gone, got %d", code)
+	}
+}
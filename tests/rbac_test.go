@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+func TestRoleBasedAccessControl(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	for _, u := range []*database.User{
+		{Username: "admin", Password: "adminpass", IsAdmin: true},
+		{Username: "carol", Password: "carolpass"},
+		{Username: "dave", Password: "davepass"},
+	} {
+		if err := db.CreateUser(u); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	h := srv.HTTPHandler()
+
+	do := func(method, path string, body interface{}, username, password string) int {
+		req := createAuthenticatedRequest(t, method, path, body, username, password)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Without roles, regular users keep the default permissions
+	if code := do(http.MethodPost, "/api/multicast-tunnels", map[string]interface{}{"port": 19001}, "carol", "carolpass"); code != http.StatusForbidden {
+		t.Fatalf("expected multicast management to be denied by default, got %d", code)
+	}
+	if code := do(http.MethodGet, "/api/roles", nil, "carol", "carolpass"); code != http.StatusUnauthorized {
+		t.Fatalf("expected role admin API to be denied, got %d", code)
+	}
+
+	// Group "platform" gets a role limited to sink listeners on 18000-18999 plus multicast management
+	if code := do(http.MethodPost, "/api/groups", map[string]interface{}{"name": "platform", "members": []string{"carol"}}, "admin", "adminpass"); code != http.StatusCreated {
+		t.Fatalf("expected group creation, got %d", code)
+	}
+	if code := do(http.MethodPost, "/api/roles", map[string]interface{}{
+		"name": "platform-engineer",
+		"rules": []map[string]interface{}{
+			{"action": "listener:create", "ports": "18000-18999", "modes": []string{"sink"}},
+			{"action": "multicast:manage"},
+		},
+		"bindings": []map[string]string{{"type": "group", "subject": "platform"}},
+	}, "admin", "adminpass"); code != http.StatusCreated {
+		t.Fatalf("expected role creation, got %d", code)
+	}
+	if code := do(http.MethodPost, "/api/roles", map[string]interface{}{
+		"name":  "broken",
+		"rules": []map[string]interface{}{{"action": "tunnel:open", "ports": "70000"}},
+	}, "admin", "adminpass"); code != http.StatusBadRequest {
+		t.Fatalf("expected invalid rule to be rejected, got %d", code)
+	}
+
+	if code := do(http.MethodGet, "/api/multicast-tunnels", nil, "carol", "carolpass"); code != http.StatusOK {
+		t.Fatalf("expected multicast management via group role, got %d", code)
+	}
+	if code := do(http.MethodGet, "/api/multicast-tunnels", nil, "dave", "davepass"); code != http.StatusForbidden {
+		t.Fatalf("expected multicast management denied outside the group, got %d", code)
+	}
+	if code := do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": 17000, "mode": "sink"}, "carol", "carolpass"); code != http.StatusForbidden {
+		t.Fatalf("expected listener outside role port range to be denied, got %d", code)
+	}
+	if code := do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": 18001, "mode": "proxy", "target_url": "http://127.0.0.1:1"}, "carol", "carolpass"); code != http.StatusForbidden {
+		t.Fatalf("expected proxy listener to be denied by role, got %d", code)
+	}
+
+	// A role granting the admin action opens admin APIs
+	if code := do(http.MethodPost, "/api/roles", map[string]interface{}{
+		"name":     "auditor",
+		"rules":    []map[string]interface{}{{"action": "admin"}},
+		"bindings": []map[string]string{{"type": "user", "subject": "dave"}},
+	}, "admin", "adminpass"); code != http.StatusCreated {
+		t.Fatalf("expected admin role creation, got %d", code)
+	}
+	if code := do(http.MethodGet, "/api/roles", nil, "dave", "davepass"); code != http.StatusOK {
+		t.Fatalf("expected admin role to grant admin API access, got %d", code)
+	}
+
+	// Deleting the user removes their bindings
+	if code := do(http.MethodDelete, "/user/dave", nil, "admin", "adminpass"); code != http.StatusAccepted {
+		t.Fatalf("expected user deletion, got %d", code)
+	}
+	role, _ := db.GetRoleByName("auditor")
+	if bindings, _ := db.ListRoleBindings(role.ID); len(bindings) != 0 {
+		t.Fatalf("expected bindings to be removed with the user, got %d", len(bindings))
+	}
+}
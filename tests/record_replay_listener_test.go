@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/server/fixture"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+func TestRecordReplayListener(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := db.CreateUser(&database.User{Username: "admin", Password: "adminpass", IsAdmin: true}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	t.Setenv("CHISEL_CAPTURE_DIR", filepath.Join(tempDir, "capture"))
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig, Dashboard: chserver.DashboardConfig{Enabled: true}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	h := srv.HTTPHandler()
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req := createAuthenticatedRequest(t, method, path, body, "admin", "adminpass")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	freePort := func() int {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		return ln.Addr().(*net.TCPAddr).Port
+	}
+	send := func(method string, port int, path, body string) (int, string) {
+		var res *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			req, _ := http.NewRequest(method, fmt.Sprintf("http://127.0.0.1:%d%s", port, path), strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer upstream-secret")
+			if res, err = http.DefaultClient.Do(req); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("listener unreachable: %v", err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call":%d,"path":%q,"body":%q}`, calls, r.URL.RequestURI(), b)
+	}))
+	defer upstream.Close()
+
+	if rr := do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": freePort(), "mode": "record"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected a record listener without target to be rejected, got %d", rr.Code)
+	}
+	recPort := freePort()
+	rr := do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": recPort, "mode": "record", "target_url": upstream.URL})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected record listener creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var recorder database.Listener
+	json.NewDecoder(rr.Body).Decode(&recorder)
+
+	// Record two calls to one endpoint and one with a body
+	recorded := map[string]string{}
+	for _, c := range [][3]string{{"GET", "/orders?page=1&size=2", ""}, {"GET", "/orders?page=1&size=2", ""}, {"POST", "/orders", `{"sku":"a1"}`}} {
+		code, body := send(c[0], recPort, c[1], c[2])
+		if code != http.StatusCreated {
+			t.Fatalf("expected the upstream response, got %d %s", code, body)
+		}
+		recorded[c[0]+c[1]] = body
+	}
+
+	var doc fixture.Document
+	for i := 0; i < 50 && len(doc.Fixtures) < 3; i++ {
+		rr = do(http.MethodGet, "/api/listener/"+recorder.ID+"/fixtures", nil)
+		doc = fixture.Document{}
+		json.NewDecoder(rr.Body).Decode(&doc)
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(doc.Fixtures) != 3 {
+		t.Fatalf("expected 3 recorded fixtures, got %d", len(doc.Fixtures))
+	}
+	if doc.Fixtures[0].Request.Header.Get("Authorization") != "" {
+		t.Fatal("expected credentials to be left out of fixtures")
+	}
+
+	// Replay them without the upstream
+	repPort := freePort()
+	rr = do(http.MethodPost, "/api/listeners", map[string]interface{}{"port": repPort, "mode": "replay", "match_keys": []string{"method", "path", "query", "body"}})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected replay listener creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var replayer database.Listener
+	json.NewDecoder(rr.Body).Decode(&replayer)
+	if rr := do(http.MethodPost, "/api/listener/"+replayer.ID+"/fixtures", map[string]interface{}{"fixtures": []map[string]interface{}{{"method": "GET", "path": "nope", "status": 200}}}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid fixture to be rejected, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, "/api/listener/"+replayer.ID+"/fixtures?replace=true", doc); rr.Code != http.StatusOK {
+		t.Fatalf("expected fixture import, got %d: %s", rr.Code, rr.Body.String())
+	}
+	upstream.Close()
+
+	if code, body := send("GET", repPort, "/orders?size=2&page=1", ""); code != http.StatusCreated || !strings.Contains(body, `"call":1`) {
+		t.Fatalf("unexpected first replay %d %s", code, body)
+	}
+	if _, body := send("GET", repPort, "/orders?page=1&size=2", ""); !strings.Contains(body, `"call":2`) {
+		t.Fatalf("expected the second recorded response, got %s", body)
+	}
+	if _, body := send("POST", repPort, "/orders", `{ "sku": "a1" }`); body != recorded["POST/orders"] {
+		t.Fatalf("unexpected replayed body %s", body)
+	}
+	if code, _ := send("POST", repPort, "/orders", `{"sku":"b2"}`); code != http.StatusNotFound {
+		t.Fatalf("expected another body not to match, got %d", code)
+	}
+
+	// Dropping the body key applies to the running listener
+	if rr := do(http.MethodPut, "/api/listener/"+replayer.ID, map[string]interface{}{"match_keys": []string{"method", "path"}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected match key update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if code, _ := send("POST", repPort, "/orders", `{"sku":"b2"}`); code != http.StatusCreated {
+		t.Fatalf("expected the body to be ignored, got %d", code)
+	}
+	if rr := do(http.MethodDelete, "/api/listener/"+replayer.ID+"/fixtures", nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected fixture deletion, got %d", rr.Code)
+	}
+	if code, _ := send("POST", repPort, "/orders", `{"sku":"a1"}`); code != http.StatusNotFound {
+		t.Fatalf("expected no fixtures after deletion, got %d", code)
+	}
+}
+
+func TestListenerFixtureLimits(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	// Recording keeps the latest per key and the latest overall
+	var records []*database.ListenerFixtureRecord
+	for i := 0; i < 5; i++ {
+		records = append(records, &database.ListenerFixtureRecord{ListenerID: "l1", At: time.Now(), Data: fmt.Sprint("a", i), MatchKey: "GET /a"})
+	}
+	records = append(records, &database.ListenerFixtureRecord{ListenerID: "l1", At: time.Now(), Data: "b0", MatchKey: "GET /b"})
+	records = append(records, &database.ListenerFixtureRecord{ListenerID: "l2", At: time.Now(), Data: "c0", MatchKey: "GET /a"})
+	if err := db.AddListenerFixtures(records); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.TrimListenerFixtures("l1", "GET /a", 2, 0); err != nil || n != 3 {
+		t.Fatalf("expected 3 fixtures trimmed, got %d, %v", n, err)
+	}
+	if n, err := db.TrimListenerFixtures("l1", "GET /a", 2, 2); err != nil || n != 1 {
+		t.Fatalf("expected the oldest fixture trimmed, got %d, %v", n, err)
+	}
+	var kept []string
+	rows, _ := db.ListListenerFixtures("l1")
+	for _, r := range rows {
+		kept = append(kept, r.Data+"="+r.MatchKey)
+	}
+	if strings.Join(kept, ",") != "a4=GET /a,b0=GET /b" {
+		t.Fatalf("unexpected fixtures kept: %v", kept)
+	}
+	if rows, _ := db.ListListenerFixtures("l2"); len(rows) != 1 {
+		t.Fatalf("expected other listeners to be left alone, got %d fixtures", len(rows))
+	}
+
+	if err := db.CreateUser(&database.User{Username: "admin", Password: "adminpass", IsAdmin: true}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := db.CreateListener(&database.Listener{ID: "replay-1", Name: "replay", Username: "admin", Port: 18081, Mode: "replay", Status: "closed"}); err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Setenv("CHISEL_CAPTURE_DIR", filepath.Join(tempDir, "capture"))
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig, Dashboard: chserver.DashboardConfig{Enabled: true}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+
+	// Imports are limited too
+	doc := fixture.Document{}
+	for i := 0; i < 1001; i++ {
+		doc.Fixtures = append(doc.Fixtures, &fixture.Fixture{Method: "GET", Path: fmt.Sprint("/f", i), Status: 200})
+	}
+	req := createAuthenticatedRequest(t, http.MethodPost, "/api/listener/replay-1/fixtures", doc, "admin", "adminpass")
+	rr := httptest.NewRecorder()
+	srv.HTTPHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "at most 1000") {
+		t.Fatalf("expected too many fixtures to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,262 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/database"
+)
+
+func scimRequest(t *testing.T, h http.Handler, token, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/scim+json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSCIMProvisioning(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := db.CreateUser(&database.User{Username: "admin", Password: "adminpass", IsAdmin: true}); err != nil {
+		t.Fatalf("Failed to create admin: %v", err)
+	}
+
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	h := srv.HTTPHandler()
+
+	// SCIM is disabled until an admin generates a token
+	if rr := scimRequest(t, h, "anything", http.MethodGet, "/scim/v2/Users", nil); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a configured token, got %d", rr.Code)
+	}
+	req := createAuthenticatedRequest(t, http.MethodPost, "/api/settings/scim/token", nil, "admin", "adminpass")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	var tokenRes struct {
+		Token string `json:"token"`
+	}
+	if rr.Code != http.StatusOK || json.NewDecoder(rr.Body).Decode(&tokenRes) != nil || tokenRes.Token == "" {
+		t.Fatalf("expected token from admin endpoint, got %d", rr.Code)
+	}
+	token := tokenRes.Token
+	if rr := scimRequest(t, h, "wrong", http.MethodGet, "/scim/v2/Users", nil); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for wrong token, got %d", rr.Code)
+	}
+
+	// Create a user
+	rr = scimRequest(t, h, token, http.MethodPost, "/scim/v2/Users", map[string]interface{}{
+		"schemas":    []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"userName":   "bob",
+		"externalId": "ext-bob",
+		"password":   "bobpass",
+		"name":       map[string]string{"givenName": "Bob", "familyName": "Builder"},
+		"emails":     []map[string]interface{}{{"value": "bob@example.com", "primary": true}},
+		"active":     true,
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating user, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"displayName"`
+	}
+	json.NewDecoder(rr.Body).Decode(&created)
+	if created.ID == "" || created.DisplayName != "Bob Builder" {
+		t.Fatalf("unexpected created user %+v", created)
+	}
+	if rr := scimRequest(t, h, token, http.MethodPost, "/scim/v2/Users", map[string]string{"userName": "bob"}); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate user, got %d", rr.Code)
+	}
+
+	// Filter by userName, as IdPs do before creating
+	rr = scimRequest(t, h, token, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "BOB"`), nil)
+	var list struct {
+		TotalResults int `json:"totalResults"`
+	}
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || list.TotalResults != 1 {
+		t.Fatalf("expected one filtered user, got %d (%d)", list.TotalResults, rr.Code)
+	}
+	// Local accounts, administrators above all, are out of SCIM's reach
+	rr = scimRequest(t, h, token, http.MethodGet, "/scim/v2/Users", nil)
+	json.NewDecoder(rr.Body).Decode(&list)
+	if list.TotalResults != 1 {
+		t.Fatalf("expected only the provisioned user to be listed, got %d", list.TotalResults)
+	}
+	if m, _ := db.GetSCIMUserByUsername("admin"); m != nil {
+		t.Fatalf("expected listing not to adopt the admin account, got %+v", m)
+	}
+	// Even a mapping left from before does not expose an admin
+	db.SaveSCIMUser(&database.SCIMUser{ID: "legacy-admin", Username: "admin"})
+	if rr := scimRequest(t, h, token, http.MethodPatch, "/scim/v2/Users/legacy-admin", map[string]interface{}{
+		"Operations": []map[string]interface{}{{"op": "replace", "path": "password", "value": "owned"}},
+	}); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 changing an admin through SCIM, got %d", rr.Code)
+	}
+	if rr := scimRequest(t, h, token, http.MethodDelete, "/scim/v2/Users/legacy-admin", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 deleting an admin through SCIM, got %d", rr.Code)
+	}
+	// So are users made administrators by a role rather than the flag
+	rr = scimRequest(t, h, token, http.MethodPost, "/scim/v2/Users", map[string]interface{}{"userName": "dana", "password": "danapass"})
+	var dana struct {
+		ID string `json:"id"`
+	}
+	if rr.Code != http.StatusCreated || json.NewDecoder(rr.Body).Decode(&dana) != nil {
+		t.Fatalf("expected 201 creating dana, got %d", rr.Code)
+	}
+	req = createAuthenticatedRequest(t, http.MethodPost, "/api/roles", map[string]interface{}{
+		"name":     "operators",
+		"rules":    []map[string]interface{}{{"action": "admin"}},
+		"bindings": []map[string]string{{"type": "user", "subject": "dana"}},
+	}, "admin", "adminpass")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected role creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := scimRequest(t, h, token, http.MethodGet, "/scim/v2/Users/"+dana.ID, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 reading a role-bound admin through SCIM, got %d", rr.Code)
+	}
+	if rr := scimRequest(t, h, token, http.MethodPatch, "/scim/v2/Users/"+dana.ID, map[string]interface{}{
+		"Operations": []map[string]interface{}{{"op": "replace", "path": "password", "value": "owned"}},
+	}); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 changing a role-bound admin through SCIM, got %d", rr.Code)
+	}
+	if u, err := db.GetUser("dana"); err != nil || u.Password != "danapass" {
+		t.Fatalf("expected dana's password to be unchanged")
+	}
+	rr = scimRequest(t, h, token, http.MethodGet, "/scim/v2/Users", nil)
+	json.NewDecoder(rr.Body).Decode(&list)
+	if list.TotalResults != 1 {
+		t.Fatalf("expected a role-bound admin not to be listed, got %d users", list.TotalResults)
+	}
+	if rr := scimRequest(t, h, token, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName eq`), nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for malformed filter, got %d", rr.Code)
+	}
+
+	// The provisioned password works until the user is deactivated
+	basic := func() int {
+		req := createAuthenticatedRequest(t, http.MethodGet, "/api/tunnels", nil, "bob", "bobpass")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := basic(); code != http.StatusOK {
+		t.Fatalf("expected provisioned user to authenticate, got %d", code)
+	}
+	rr = scimRequest(t, h, token, http.MethodPatch, "/scim/v2/Users/"+created.ID, map[string]interface{}{
+		"schemas":    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{{"op": "Replace", "path": "active", "value": "False"}},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 deactivating user, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if code := basic(); code != http.StatusUnauthorized {
+		t.Fatalf("expected deactivated user to be rejected, got %d", code)
+	}
+
+	// Groups reference members by SCIM id
+	rr = scimRequest(t, h, token, http.MethodPost, "/scim/v2/Groups", map[string]interface{}{
+		"displayName": "engineering",
+		"members":     []map[string]string{{"value": created.ID}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating group, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var group struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(rr.Body).Decode(&group)
+	if groups, _ := db.ListUserGroups("bob"); len(groups) != 1 || groups[0].Name != "engineering" {
+		t.Fatalf("expected bob in engineering, got %+v", groups)
+	}
+	rr = scimRequest(t, h, token, http.MethodPatch, "/scim/v2/Groups/"+group.ID, map[string]interface{}{
+		"Operations": []map[string]interface{}{{"op": "remove", "path": `members[value eq "` + created.ID + `"]`}},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 removing member, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if members, _ := db.ListGroupMembers(group.ID); len(members) != 0 {
+		t.Fatalf("expected empty group, got %v", members)
+	}
+	if rr := scimRequest(t, h, token, http.MethodPost, "/scim/v2/Groups", map[string]interface{}{
+		"displayName": "ghosts", "members": []map[string]string{{"value": "missing"}},
+	}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown member, got %d", rr.Code)
+	}
+
+	// Groups created locally, which may carry admin role bindings, are out of SCIM's reach
+	operators := &database.Group{Name: "operators"}
+	if err := db.CreateGroup(operators); err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	var groupList struct {
+		TotalResults int `json:"totalResults"`
+	}
+	rr = scimRequest(t, h, token, http.MethodGet, "/scim/v2/Groups", nil)
+	json.NewDecoder(rr.Body).Decode(&groupList)
+	if groupList.TotalResults != 1 {
+		t.Fatalf("expected only the provisioned group to be listed, got %d", groupList.TotalResults)
+	}
+	addBob := map[string]interface{}{
+		"Operations": []map[string]interface{}{{"op": "add", "path": "members", "value": []map[string]string{{"value": created.ID}}}},
+	}
+	for _, c := range []struct {
+		method string
+		body   interface{}
+	}{
+		{http.MethodGet, nil},
+		{http.MethodPatch, addBob},
+		{http.MethodPut, map[string]interface{}{"displayName": "renamed"}},
+		{http.MethodDelete, nil},
+	} {
+		if rr := scimRequest(t, h, token, c.method, "/scim/v2/Groups/"+operators.ID, c.body); rr.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for %s on a local group, got %d", c.method, rr.Code)
+		}
+	}
+	if g, _ := db.GetGroup(operators.ID); g == nil || g.Name != "operators" {
+		t.Fatalf("expected the local group to be left alone, got %+v", g)
+	}
+	if members, _ := db.ListGroupMembers(operators.ID); len(members) != 0 {
+		t.Fatalf("expected no members added to the local group, got %v", members)
+	}
+
+	// Delete deprovisions the user
+	if rr := scimRequest(t, h, token, http.MethodDelete, "/scim/v2/Users/"+created.ID, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 deleting user, got %d", rr.Code)
+	}
+	if _, err := db.GetUser("bob"); err == nil {
+		t.Fatalf("expected bob to be deleted")
+	}
+	if rr := scimRequest(t, h, token, http.MethodGet, "/scim/v2/Users/"+created.ID, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", rr.Code)
+	}
+}
@@ -204,6 +204,25 @@ func TestUserAuthMiddleware(t *testing.T) {
 		}
 	})
 
+	// Test password-only access with two-factor authentication enabled (should be denied)
+	t.Run("MFA User Password Should Be Denied", func(t *testing.T) {
+		if err := db.SaveUserMFA(&database.UserMFA{Username: "testuser", Enabled: true}); err != nil {
+			t.Fatalf("Failed to enable MFA: %v", err)
+		}
+		defer db.DeleteUserMFA("testuser")
+		req := createAuthenticatedRequest(t, "GET", "/test", nil, "testuser", "testpass")
+		rr := httptest.NewRecorder()
+
+		// Use the user auth middleware to protect the handler
+		protectedHandler := srv.UserAuthMiddleware(testHandler)
+		protectedHandler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for password auth of MFA user, got %d. Response: %s",
+				rr.Code, rr.Body.String())
+		}
+	})
+
 	// Test unauthenticated access (should be denied)
 	t.Run("Unauthenticated User Should Be Denied", func(t *testing.T) {
 		req := createUnauthenticatedRequest(t, "GET", "/test", nil)
@@ -856,3 +875,85 @@ func TestAdminWriteAPIsDeniedToRegular(t *testing.T) {
 		t.Errorf("DELETE /user/user: expected 401/403 for regular, got %d (body: %s)", rr.Code, rr.Body.String())
 	}
 }
+
+// TestMFARoutes tests that unknown two-factor routes and methods are rejected
+func TestMFARoutes(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := db.CreateUser(&database.User{Username: "mfauser", Password: "mfapass"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/api/user/mfa", http.StatusOK},
+		{http.MethodPost, "/api/user/mfa", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/user/mfa/enroll", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/user/mfa/unknown", http.StatusNotFound},
+	} {
+		req := createAuthenticatedRequest(t, tc.method, tc.path, nil, "mfauser", "mfapass")
+		rr := httptest.NewRecorder()
+		srv.HTTPHandler().ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("Expected %d for %s %s, got %d", tc.code, tc.method, tc.path, rr.Code)
+		}
+	}
+}
+
+// TestMFACodeBackoff tests that codes checked by the two-factor settings
+// endpoints share the login backoff
+func TestMFACodeBackoff(t *testing.T) {
+	tempDir := t.TempDir()
+	dbConfig := &database.DatabaseConfig{Type: "sqlite", FilePath: filepath.Join(tempDir, "test.db")}
+	db := database.NewDatabase(dbConfig)
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := db.CreateUser(&database.User{Username: "mfauser", Password: "mfapass"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	srv, err := chserver.NewServer(&chserver.Config{Database: dbConfig, Security: chserver.SecurityConfig{
+		LoginBackoff: chserver.LoginBackoffConfig{BaseDelayMS: 1, MaxDelayMS: 1, HardLockFailures: 3},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	do := func(method, path string, body interface{}) int {
+		req := createAuthenticatedRequest(t, method, path, body, "mfauser", "mfapass")
+		rr := httptest.NewRecorder()
+		srv.HTTPHandler().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := do(http.MethodPost, "/api/user/mfa/enroll", nil); code != http.StatusOK {
+		t.Fatalf("Expected enrollment to start, got %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := do(http.MethodPost, "/api/user/mfa/verify", map[string]string{"code": "000000"}); code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for a wrong code, got %d", code)
+		}
+	}
+	if code := do(http.MethodPost, "/api/user/mfa/verify", map[string]string{"code": "000000"}); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 once the code was guessed too often, got %d", code)
+	}
+}